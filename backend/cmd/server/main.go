@@ -33,7 +33,7 @@ func main() {
 	log.Println("----------------------------------------")
 
 	// Load Configuration
-	cfg := config.Load()
+	config.Load()
 
 	// Initialize Router
 	r := gin.New()
@@ -57,7 +57,10 @@ func main() {
 	}
 
 	// Setup Routes
-	api.SetupRoutes(r, cfg, frontendFS)
+	api.SetupRoutes(r, frontendFS)
+
+	// Resume background jobs left unfinished by a previous run
+	api.StartJobs()
 
 	// Start Browser (in goroutine to not block)
 	go func() {
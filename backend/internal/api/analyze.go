@@ -2,22 +2,21 @@ package api
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
-	"math/rand"
-	"path/filepath"
-	"time"
-
-	// Added for time.Sleep
-	"tts-book/backend/internal/audio"
+	"tts-book/backend/internal/casting"
 	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/jobs"
 	"tts-book/backend/internal/llm"
-	"tts-book/backend/internal/tts"
 
 	"github.com/gin-gonic/gin"
 	"github.com/mozillazg/go-pinyin"
@@ -94,204 +93,87 @@ func cleanTypesetting(typesetting, originalText string) string {
 	return cleaned
 }
 
-// GenerateAudio orchestrates the TTS generation
-func GenerateAudio(c *gin.Context) {
-	chapterID := c.Param("chapterID")
-
-	Store.Mu.RLock()
-	segments, ok := Store.Analysis[chapterID]
-	bookID := Store.BookID
-	// mapping := Store.VoiceMapping // Copy if needed
-	Store.Mu.RUnlock()
-
-	if !ok || len(segments) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Chapter not analyzed yet"})
-		return
+// applyReadings writes the pinyin of ruby-annotated words into the
+// typesetting of every segment containing them. Words given different
+// readings in the same chapter are left to the LLM, as are readings that
+// are not pinyin (zhuyin, kana).
+func applyReadings(results []llm.AnalysisResult, readings []epub.Reading) {
+	replacements := make(map[string]string)
+	for _, r := range readings {
+		syllables, ok := pinyinSyllables(r.Reading)
+		if !ok || len(syllables) != utf8.RuneCountInString(r.Text) || strings.IndexFunc(r.Text, func(c rune) bool { return !unicode.Is(unicode.Han, c) }) >= 0 {
+			continue
+		}
+		value := strings.Join(syllables, " ")
+		if prev, seen := replacements[r.Text]; seen && prev != value {
+			replacements[r.Text] = "" // Ambiguous
+			continue
+		}
+		replacements[r.Text] = value
 	}
 
-	// Start async generation
-	go func() {
-		BroadcastProgress(chapterID, 0, "Initializing TTS...")
-
-		// Initialize TTS Client
-		cfg := config.Get()
-		ttsClient := tts.NewClient(cfg)
-
-		// Prepare temp dir
-		tempDir := fmt.Sprintf("data/temp/%s", chapterID)
-		os.MkdirAll(tempDir, 0755)
-
-		outDir := fmt.Sprintf("data/out/%s", bookID)
-		os.MkdirAll(outDir, 0755) // Ensure output dir exists
-
-		total := len(segments)
-		var filePaths []string
-
-		for i, seg := range segments {
-			// Determine voice/emotion from mapping
-			Store.Mu.RLock()
-			mapping, hasMapping := Store.VoiceMapping[seg.Speaker]
-			Store.Mu.RUnlock()
-
-			voice := ""
-			emotion := seg.Emotion
-
-			if hasMapping {
-				voice = mapping.VoiceID // Use full path for internal use
-
-				// Determine emotion based on UseLLMEmotion setting
-
-				// Determine emotion based on UseLLMEmotion setting
-				// Default to true if nil
-				useLLM := true
-				if mapping.UseLLMEmotion != nil {
-					useLLM = *mapping.UseLLMEmotion
-				}
-
-				if useLLM {
-
-					// Use emotion from LLM analysis (seg.Emotion)
-
-					if seg.Emotion != "" {
-
-						emotion = seg.Emotion
-
-					}
-
-				} else {
-
-					// Use default emotion from mapping
-
-					if mapping.Emotion != "" {
-
-						emotion = mapping.Emotion
-
-					}
-
-				}
-
-			}
-
-			if emotion == "" {
-
-				emotion = "calm"
-
-			}
-
-			if emotion == "" {
-				emotion = "calm"
-			}
-
-			// Generate
-			// Generate
-			runes := []rune(seg.Text)
-			display := string(runes)
-			if len(runes) > 10 {
-				display = string(runes[:10])
-			}
-			BroadcastProgress(chapterID, int((float64(i)/float64(total))*100), fmt.Sprintf("Generating (%d/%d): %s...", i+1, total, display))
-
-			textToSpeak := strings.TrimSpace(seg.Typesetting)
-			if textToSpeak == "" {
-				textToSpeak = strings.TrimSpace(seg.Text)
-			}
-			if textToSpeak == "" {
-				log.Printf("[TTS] Skipping empty segment %d", i)
-				continue
+	for k := range results {
+		typesetting := results[k].Typesetting
+		if typesetting == "" {
+			typesetting = results[k].Text
+		}
+		changed := false
+		for word, value := range replacements {
+			if value != "" && strings.Contains(typesetting, word) {
+				typesetting = strings.ReplaceAll(typesetting, word, value)
+				changed = true
 			}
+		}
+		if changed {
+			results[k].Typesetting = typesetting
+		}
+	}
+}
 
-			// Check for length and split if necessary
-			maxChars := 20 // Hardcoded limit for now
-			chunks := SplitTextForTTS(textToSpeak, maxChars)
-
-			var segmentAudioData []byte
-
-			if len(chunks) == 1 {
-				// Original logic for single chunk
-				log.Printf("[TTS] Generating segment %d with text: %s", i, textToSpeak)
-				audioData, err := ttsClient.Generate(textToSpeak, voice, emotion)
-				if err != nil {
-					log.Printf("[TTS] Error generating segment %d: %v", i, err)
-					BroadcastProgress(chapterID, 0, fmt.Sprintf("Error: %v", err))
-					return
-				}
-				segmentAudioData = audioData
-			} else {
-				// Split logic
-				log.Printf("[TTS] Segment %d is long (%d chars), split into %d chunks", i, len([]rune(textToSpeak)), len(chunks))
-				var chunkFiles []string
-
-				for j, chunk := range chunks {
-					BroadcastProgress(chapterID, int((float64(i)/float64(total))*100), fmt.Sprintf("Generating (%d/%d): Part %d/%d...", i+1, total, j+1, len(chunks)))
-					log.Printf("[TTS] Generating segment %d chunk %d: %s", i, j, chunk)
-
-					chunkAudio, err := ttsClient.Generate(chunk, voice, emotion)
-					if err != nil {
-						log.Printf("[TTS] Error generating segment %d chunk %d: %v", i, j, err)
-						BroadcastProgress(chapterID, 0, fmt.Sprintf("Error in chunk: %v", err))
-						return
-					}
-
-					// Save chunk to temp
-					chunkPath := fmt.Sprintf("%s/%d_part_%d.wav", tempDir, i, j)
-					if err := os.WriteFile(chunkPath, chunkAudio, 0644); err != nil {
-						log.Printf("[TTS] Failed to write chunk file: %v", err)
-						return
-					}
-					chunkFiles = append(chunkFiles, chunkPath)
-				}
-
-				// Merge chunks into one segment
-				// Using 0ms silence for intra-segment merge, as splits might be comma-based.
-				// If we want sentence pauses, we'd need smarter splitting or rely on TTS natural pause.
-				mergedPath := fmt.Sprintf("%s/%d_merged.wav", tempDir, i)
-				if err := audio.MergeAndNormalize(chunkFiles, mergedPath, 0, false); err != nil {
-					log.Printf("[TTS] Failed to merge chunks for segment %d: %v", i, err)
-					return
-				}
+// toneMarks maps accented pinyin vowels to the plain vowel and tone number
+var toneMarks = map[rune]struct {
+	vowel rune
+	tone  byte
+}{
+	'ā': {'a', '1'}, 'á': {'a', '2'}, 'ǎ': {'a', '3'}, 'à': {'a', '4'},
+	'ē': {'e', '1'}, 'é': {'e', '2'}, 'ě': {'e', '3'}, 'è': {'e', '4'},
+	'ī': {'i', '1'}, 'í': {'i', '2'}, 'ǐ': {'i', '3'}, 'ì': {'i', '4'},
+	'ō': {'o', '1'}, 'ó': {'o', '2'}, 'ǒ': {'o', '3'}, 'ò': {'o', '4'},
+	'ū': {'u', '1'}, 'ú': {'u', '2'}, 'ǔ': {'u', '3'}, 'ù': {'u', '4'},
+	'ǖ': {'v', '1'}, 'ǘ': {'v', '2'}, 'ǚ': {'v', '3'}, 'ǜ': {'v', '4'}, 'ü': {'v', '5'},
+}
 
-				// Read back the merged data to be consistent with main flow
-				// (Though efficiently we could just rename it to the final destination, but existing logic appends to filePaths)
-				data, err := os.ReadFile(mergedPath)
-				if err != nil {
-					log.Printf("[TTS] Failed to read merged segment %d: %v", i, err)
-					return
+// pinyinSyllables converts a pinyin reading, with tone marks or numbers,
+// to the upper-case numbered form used in typesetting ("zhòng" -> "ZHONG4").
+func pinyinSyllables(reading string) ([]string, bool) {
+	var syllables []string
+	for _, word := range strings.FieldsFunc(reading, func(r rune) bool { return unicode.IsSpace(r) || r == '\'' || r == '·' }) {
+		var sb strings.Builder
+		tone := byte('5')
+		for _, r := range strings.ToLower(word) {
+			switch {
+			case r >= 'a' && r <= 'z':
+				sb.WriteRune(r)
+			case r >= '1' && r <= '5':
+				tone = byte(r)
+			default:
+				m, ok := toneMarks[r]
+				if !ok {
+					return nil, false
 				}
-				segmentAudioData = data
-
-				// Cleanup chunk files
-				for _, f := range chunkFiles {
-					os.Remove(f)
+				sb.WriteRune(m.vowel)
+				if m.tone != '5' {
+					tone = m.tone
 				}
-				os.Remove(mergedPath) // Will be rewritten below as final segment file
-			}
-
-			// Save temp file (final segment)
-			filePath := fmt.Sprintf("%s/%d.wav", tempDir, i)
-			if err := os.WriteFile(filePath, segmentAudioData, 0644); err != nil {
-				log.Printf("[TTS] Failed to write temp file: %v", err)
-				return
 			}
-			filePaths = append(filePaths, filePath)
 		}
-
-		// Merge
-		BroadcastProgress(chapterID, 95, "Merging Audio Files...")
-		outPath := fmt.Sprintf("%s/%s.wav", outDir, chapterID)
-
-		if err := audio.MergeAndNormalize(filePaths, outPath, cfg.MergeSilence, cfg.NormalizeAudio); err != nil {
-			log.Printf("[TTS] Merge failed: %v", err)
-			BroadcastProgress(chapterID, 0, fmt.Sprintf("Merge Error: %v", err))
-			return
+		syllable := strings.ToUpper(sb.String()) + string(tone)
+		if len(pinyinWhitelist) > 0 && !pinyinWhitelist[syllable] {
+			return nil, false
 		}
-
-		// Cleanup temp
-		os.RemoveAll(tempDir)
-
-		BroadcastProgress(chapterID, 100, "Generation Complete!")
-	}()
-
-	c.JSON(http.StatusOK, gin.H{"status": "started", "chapterId": chapterID})
+		syllables = append(syllables, syllable)
+	}
+	return syllables, len(syllables) > 0
 }
 
 func min(a, b int) int {
@@ -305,10 +187,17 @@ func AnalyzeChapter(c *gin.Context) {
 	chapterID := c.Param("chapterID")
 	force := c.Query("force") == "true"
 
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+
 	// Check if already analyzed (and not forcing re-analysis)
-	Store.Mu.RLock()
-	existing, exists := Store.Analysis[chapterID]
-	Store.Mu.RUnlock()
+	book.Mu.RLock()
+	existing, exists := book.Analysis[chapterID]
+	chapters := book.Chapters
+	bookID := book.BookID
+	book.Mu.RUnlock()
 
 	if exists && len(existing) > 0 && !force {
 		log.Printf("[Analyze] Returning cached analysis for chapter %s", chapterID)
@@ -320,18 +209,18 @@ func AnalyzeChapter(c *gin.Context) {
 		return
 	}
 
-	// Retrieve chapter content from memory store
-	// For MVP, we iterate LoadedChapters["current"] to find ID
-	chapters, ok := LoadedChapters["current"]
-	if !ok {
+	// Retrieve chapter content from the book's project
+	if len(chapters) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No book loaded"})
 		return
 	}
 
 	var textToAnalyze string
+	var readings []epub.Reading
 	for _, ch := range chapters {
 		if ch.ID == chapterID {
 			textToAnalyze = ch.Content
+			readings = ch.Readings
 			break
 		}
 	}
@@ -350,32 +239,49 @@ func AnalyzeChapter(c *gin.Context) {
 
 	client := llm.NewClient(cfg)
 
-	// Chunking text to prevent LLM context issues (losing narrators)
-	// Limit based on config
-	limit := cfg.LLMChunkSize
+	// Analysis runs inline with the request, so a disconnecting client aborts it.
+	allResults, err := analyzeText(c.Request.Context(), client, bookID, chapterID, textToAnalyze, readings, cfg.LLMChunkSize)
+	if err != nil {
+		// One failed chunk fails the whole chapter so the user can retry,
+		// rather than silently storing partial results.
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	log.Printf("[Analyze] Success. Found total %d segments.\n", len(allResults))
+
+	if err := saveAnalysis(book, chapterID, allResults); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chapterId": chapterID,
+		"results":   allResults,
+	})
+}
+
+// analyzeText splits a chapter into LLM-sized chunks and analyzes them in order.
+// Chunking prevents the LLM from losing context (e.g. dropping narrators) on long chapters.
+// Readings the book gives for its words override the LLM's pinyin.
+func analyzeText(ctx context.Context, client *llm.Client, bookID, chapterID, text string, readings []epub.Reading, limit int) ([]llm.AnalysisResult, error) {
 	if limit <= 0 {
 		limit = 1000
 	}
-	chunks := SplitText(textToAnalyze, limit)
+	chunks := SplitText(text, limit)
 	log.Printf("[Analyze] Split Chapter %s into %d chunks (limit: %d)\n", chapterID, len(chunks), limit)
 
 	var allResults []llm.AnalysisResult
 
 	for i, chunk := range chunks {
-		log.Printf("[Analyze] Processing chunk %d/%d (len: %d)\n", i+1, len(chunks), len(chunk))
+		log.Printf("[Analyze] Processing chapter %s chunk %d/%d (len: %d)\n", chapterID, i+1, len(chunks), len(chunk))
 
-		results, err := client.AnalyzeTextStream(chunk, func(token string) {
-			BroadcastLLMOutput(chapterID, token)
+		results, err := client.AnalyzeTextStream(ctx, chunk, func(token string) {
+			BroadcastLLMOutput(bookID, chapterID, token)
 		})
 		if err != nil {
-			log.Printf("[Analyze] Chunk %d failed: %v\n", i+1, err)
-			// Continue with best effort? Or fail?
-			// Let's log and likely continue to get partial results, but appending nothing for this chunk.
-			// Or better, error out to let user know something went wrong.
-			// Given "prevent this behavior", reliability is key. But one chunk fail shouldn't necessarily kill everything if others worked.
-			// However, to be safe, let's treat it as a task failure for now so user can retry.
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Analysis failed at chunk %d: %v", i+1, err)})
-			return
+			log.Printf("[Analyze] Chapter %s chunk %d failed: %v\n", chapterID, i+1, err)
+			return nil, fmt.Errorf("analysis failed at chunk %d: %v", i+1, err)
 		}
 
 		for k := range results {
@@ -383,227 +289,172 @@ func AnalyzeChapter(c *gin.Context) {
 				results[k].Typesetting = cleanTypesetting(results[k].Typesetting, results[k].Text)
 			}
 		}
+		applyReadings(results, readings)
 
 		allResults = append(allResults, results...)
 	}
 
-	log.Printf("[Analyze] Success. Found total %d segments.\n", len(allResults))
+	return allResults, nil
+}
 
-	// Save results to Store
-	// Auto-assign voices to new characters
-	voiceDir := cfg.VoiceDir
-	if voiceDir == "" {
-		voiceDir = "voices" // Fallback if config is empty
-	}
-	availableVoices, err := GetVoicesFromDir(voiceDir)
+// saveAnalysis stores a chapter's analysis results in the book, resolving
+// speaker aliases to their character, registers its speakers and casts
+// voices for characters that don't have one yet. If saving fails the book
+// is left as it was.
+func saveAnalysis(book *ProjectStore, chapterID string, results []llm.AnalysisResult) error {
+	voices, err := castingVoices()
 	if err != nil {
-		log.Printf("[Analyze] Warning: Could not list voices from %s: %v", voiceDir, err)
-	}
-
-	// Shuffle voices for random assignment
-	if len(availableVoices) > 0 {
-		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-		rnd.Shuffle(len(availableVoices), func(i, j int) {
-			availableVoices[i], availableVoices[j] = availableVoices[j], availableVoices[i]
-		})
+		log.Printf("[Analyze] Warning: %v", err)
 	}
 
-	Store.Mu.Lock()
-	Store.Analysis[chapterID] = allResults
-
-	nextVoiceIdx := 0
-	for _, r := range allResults {
-		if r.Speaker != "" {
-			Store.DetectedCharacters[r.Speaker] = true
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
 
-			// Assign voice if character doesn't have one OR has one with empty VoiceID
-			config, exists := Store.VoiceMapping[r.Speaker]
-			if (!exists || config.VoiceID == "") && len(availableVoices) > 0 {
-				// Pick next voice
-				voicePath := availableVoices[nextVoiceIdx%len(availableVoices)]
-				nextVoiceIdx++
-
-				Store.VoiceMapping[r.Speaker] = VoiceConfig{
-					VoiceID:       voicePath,
-					Emotion:       "calm",
-					UseLLMEmotion: boolPtr(true), // Default to using LLM emotions
-				}
-				log.Printf("[Analyze] Auto-assigned voice %s to character %s", filepath.Base(voicePath), r.Speaker)
-			}
+	before := book.snapshot()
+	resolve := book.speakerResolver()
+	for i := range results {
+		if results[i].Speaker != "" {
+			results[i].Speaker = resolve(results[i].Speaker)
+			book.DetectedCharacters[results[i].Speaker] = true
 		}
 	}
-	Store.Mu.Unlock()
+	book.Analysis[chapterID] = results
+
+	// Cast characters without a voice; everyone else keeps theirs
+	book.applyCasting(casting.Cast(book.castingCharacters(), voices, casting.FillMissing))
 
 	// Persist
-	if err := Store.Save(); err != nil {
-		log.Printf("[Analyze] Warning: Failed to save store: %v", err)
+	if _, err := book.saveEdit(before, "analyze", fmt.Sprintf("Analyzed chapter %s", chapterID)); err != nil {
+		book.revert(before)
+		return fmt.Errorf("failed to save analysis: %v", err)
 	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"chapterId": chapterID,
-		"results":   allResults,
-	})
+	return nil
 }
 
-// AnalyzeAllChapters analyzes all chapters sequentially
+// AnalyzeAllChapters queues a background job that analyzes all chapters sequentially
 func AnalyzeAllChapters(c *gin.Context) {
 	force := c.Query("force") == "true"
 
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+
 	// Get all chapters
-	chapters, ok := LoadedChapters["current"]
-	if !ok || len(chapters) == 0 {
+	book.Mu.RLock()
+	chapters := book.Chapters
+	bookID := book.BookID
+	book.Mu.RUnlock()
+	if len(chapters) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No book loaded"})
 		return
 	}
 
-	// Start async batch analysis
-	go func() {
-		total := len(chapters)
-		successCount := 0
-		failedChapters := []string{}
-
-		for i, chapter := range chapters {
-			chapterID := chapter.ID
-			chapterTitle := chapter.Title
-			if chapterTitle == "" {
-				chapterTitle = fmt.Sprintf("Chapter %s", chapterID)
-			}
-
-			// Broadcast overall progress
-			overallPercent := int((float64(i) / float64(total)) * 100)
-			BroadcastProgress("batch", overallPercent, fmt.Sprintf("Analyzing %s (%d/%d)...", chapterTitle, i+1, total))
-
-			// Check if already analyzed (and not forcing re-analysis)
-			Store.Mu.RLock()
-			existing, exists := Store.Analysis[chapterID]
-			Store.Mu.RUnlock()
-
-			if exists && len(existing) > 0 && !force {
-				log.Printf("[AnalyzeAll] Skipping already analyzed chapter %s", chapterID)
-				successCount++
-				continue
-			}
-
-			// Analyze this chapter
-			textToAnalyze := chapter.Content
-			if textToAnalyze == "" {
-				log.Printf("[AnalyzeAll] Chapter %s is empty, skipping", chapterID)
-				failedChapters = append(failedChapters, chapterTitle)
-				continue
-			}
-
-			// Initialize LLM Client
-			cfg := config.Get()
-			if cfg.LLMAPIKey == "" {
-				log.Printf("[AnalyzeAll] LLM API Key is missing")
-				BroadcastProgress("batch", 0, "Error: LLM API Key is missing")
-				return
-			}
-
-			client := llm.NewClient(cfg)
-
-			// Chunking text
-			limit := cfg.LLMChunkSize
-			if limit <= 0 {
-				limit = 1000
-			}
-			chunks := SplitText(textToAnalyze, limit)
-			log.Printf("[AnalyzeAll] Chapter %s split into %d chunks\n", chapterID, len(chunks))
-
-			var allResults []llm.AnalysisResult
-			chunkFailed := false
-
-			for j, chunk := range chunks {
-				log.Printf("[AnalyzeAll] Processing chapter %s chunk %d/%d\n", chapterID, j+1, len(chunks))
-
-				results, err := client.AnalyzeTextStream(chunk, func(token string) {
-					BroadcastLLMOutput(chapterID, token)
-				})
-				if err != nil {
-					log.Printf("[AnalyzeAll] Chapter %s chunk %d failed: %v\n", chapterID, j+1, err)
-					failedChapters = append(failedChapters, chapterTitle)
-					chunkFailed = true
-					break
-				}
-
-				for k := range results {
-					if results[k].Typesetting != "" {
-						results[k].Typesetting = cleanTypesetting(results[k].Typesetting, results[k].Text)
-					}
-				}
+	if config.Get().LLMAPIKey == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "LLM API Key is missing in config"})
+		return
+	}
 
-				allResults = append(allResults, results...)
-			}
+	job, ok := submitJob(c, jobAnalyzeAll, "analyze-all:"+bookID, bookID, map[string]string{"force": fmt.Sprint(force)})
+	if !ok {
+		return
+	}
 
-			if chunkFailed {
-				continue
-			}
+	c.JSON(http.StatusOK, gin.H{"status": "started", "totalChapters": len(chapters), "jobId": job.ID})
+}
 
-			log.Printf("[AnalyzeAll] Chapter %s analyzed successfully. Found %d segments.\n", chapterID, len(allResults))
+// runAnalyzeAll is the job runner behind AnalyzeAllChapters.
+func runAnalyzeAll(ctx context.Context, job *jobs.Job) error {
+	book, err := jobBook(job)
+	if err != nil {
+		return err
+	}
+	force := job.Param("force") == "true"
+	book.Mu.RLock()
+	chapters := book.Chapters
+	book.Mu.RUnlock()
 
-			// Auto-assign voices to new characters
-			voiceDir := cfg.VoiceDir
-			if voiceDir == "" {
-				voiceDir = "voices"
-			}
-			availableVoices, err := GetVoicesFromDir(voiceDir)
-			if err != nil {
-				log.Printf("[AnalyzeAll] Warning: Could not list voices from %s: %v", voiceDir, err)
-			}
+	total := len(chapters)
+	successCount := 0
+	matterCount := 0
+	failedChapters := []string{}
 
-			// Shuffle voices for random assignment
-			if len(availableVoices) > 0 {
-				rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
-				rnd.Shuffle(len(availableVoices), func(i, j int) {
-					availableVoices[i], availableVoices[j] = availableVoices[j], availableVoices[i]
-				})
-			}
+	cfg := config.Get()
+	if cfg.LLMAPIKey == "" {
+		reportProgress(job, "batch", 0, "Error: LLM API Key is missing")
+		return fmt.Errorf("LLM API Key is missing")
+	}
+	client := llm.NewClient(cfg)
 
-			Store.Mu.Lock()
-			Store.Analysis[chapterID] = allResults
+	for i, chapter := range chapters {
+		if err := job.Checkpoint(ctx); err != nil {
+			reportProgress(job, "batch", 0, "Analysis cancelled")
+			return err
+		}
 
-			nextVoiceIdx := 0
-			for _, r := range allResults {
-				if r.Speaker != "" {
-					Store.DetectedCharacters[r.Speaker] = true
+		chapterID := chapter.ID
+		chapterTitle := chapter.Title
+		if chapterTitle == "" {
+			chapterTitle = fmt.Sprintf("Chapter %s", chapterID)
+		}
 
-					// Assign voice if character doesn't have one OR has one with empty VoiceID
-					config, exists := Store.VoiceMapping[r.Speaker]
-					if (!exists || config.VoiceID == "") && len(availableVoices) > 0 {
-						// Pick next voice
-						voicePath := availableVoices[nextVoiceIdx%len(availableVoices)]
-						nextVoiceIdx++
+		// Broadcast overall progress
+		overallPercent := int((float64(i) / float64(total)) * 100)
+		reportProgress(job, "batch", overallPercent, fmt.Sprintf("Analyzing %s (%d/%d)...", chapterTitle, i+1, total))
 
-						Store.VoiceMapping[r.Speaker] = VoiceConfig{
-							VoiceID: voicePath,
+		// Front and back matter can still be analyzed one chapter at a time
+		if chapter.Matter != "" {
+			log.Printf("[AnalyzeAll] Skipping %s matter chapter %s", chapter.Matter, chapterID)
+			matterCount++
+			continue
+		}
 
-							Emotion: "calm",
+		// Check if already analyzed (and not forcing re-analysis)
+		book.Mu.RLock()
+		existing, exists := book.Analysis[chapterID]
+		book.Mu.RUnlock()
 
-							UseLLMEmotion: boolPtr(true), // Default to using LLM emotions
-						}
+		if exists && len(existing) > 0 && !force {
+			log.Printf("[AnalyzeAll] Skipping already analyzed chapter %s", chapterID)
+			successCount++
+			continue
+		}
 
-					}
-				}
-			}
-			Store.Mu.Unlock()
+		if chapter.Content == "" {
+			log.Printf("[AnalyzeAll] Chapter %s is empty, skipping", chapterID)
+			failedChapters = append(failedChapters, chapterTitle)
+			continue
+		}
 
-			// Persist after each chapter
-			if err := Store.Save(); err != nil {
-				log.Printf("[AnalyzeAll] Warning: Failed to save store: %v", err)
+		allResults, err := analyzeText(ctx, client, job.BookID, chapterID, chapter.Content, chapter.Readings, cfg.LLMChunkSize)
+		if err != nil {
+			if ctx.Err() != nil {
+				reportProgress(job, "batch", 0, "Analysis cancelled")
+				return ctx.Err()
 			}
-
-			successCount++
+			failedChapters = append(failedChapters, chapterTitle)
+			continue
 		}
 
-		// Broadcast completion
-		if len(failedChapters) > 0 {
-			BroadcastProgress("batch", 100, fmt.Sprintf("Completed with errors. %d/%d chapters analyzed. Failed: %s", successCount, total, failedChapters))
-		} else {
-			BroadcastProgress("batch", 100, fmt.Sprintf("All chapters analyzed successfully! (%d/%d)", successCount, total))
+		log.Printf("[AnalyzeAll] Chapter %s analyzed successfully. Found %d segments.\n", chapterID, len(allResults))
+
+		// Persists after each chapter so a cancelled run keeps finished chapters
+		if err := saveAnalysis(book, chapterID, allResults); err != nil {
+			log.Printf("[AnalyzeAll] Chapter %s: %v", chapterID, err)
+			failedChapters = append(failedChapters, chapterTitle)
+			continue
 		}
-	}()
+		successCount++
+	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "started", "totalChapters": len(chapters)})
+	// Broadcast completion
+	total -= matterCount
+	if len(failedChapters) > 0 {
+		reportProgress(job, "batch", 100, fmt.Sprintf("Completed with errors. %d/%d chapters analyzed. Failed: %s", successCount, total, failedChapters))
+	} else {
+		reportProgress(job, "batch", 100, fmt.Sprintf("All chapters analyzed successfully! (%d/%d)", successCount, total))
+	}
+	return nil
 }
 
 // SplitText splits a large string into chunks strictly less than limit.
@@ -754,222 +605,3 @@ func SplitTextForTTS(text string, maxChars int) []string {
 
 	return chunks
 }
-
-// GenerateAllAudio generates audio for all chapters sequentially, skipping chapters that already have audio files.
-func GenerateAllAudio(c *gin.Context) {
-	// Get all chapters
-	chapters, ok := LoadedChapters["current"]
-	if !ok || len(chapters) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No book loaded"})
-		return
-	}
-
-	Store.Mu.RLock()
-	bookID := Store.BookID
-	Store.Mu.RUnlock()
-
-	// Start async batch generation
-	go func() {
-		total := len(chapters)
-		successCount := 0
-		skippedCount := 0
-		failedChapters := []string{}
-
-		cfg := config.Get()
-		ttsClient := tts.NewClient(cfg)
-
-		for i, chapter := range chapters {
-			chapterID := chapter.ID
-			chapterTitle := chapter.Title
-			if chapterTitle == "" {
-				chapterTitle = fmt.Sprintf("Chapter %s", chapterID)
-			}
-
-			// Broadcast overall progress
-			overallPercent := int((float64(i) / float64(total)) * 100)
-			BroadcastProgress("batch-generate", overallPercent, fmt.Sprintf("Processing %s (%d/%d)...", chapterTitle, i+1, total))
-
-			// Check if audio already exists
-			outDir := fmt.Sprintf("data/out/%s", bookID)
-			audioPath := fmt.Sprintf("%s/%s.wav", outDir, chapterID)
-			if _, err := os.Stat(audioPath); err == nil {
-				log.Printf("[GenerateAll] Audio already exists for chapter %s, skipping", chapterID)
-				skippedCount++
-				continue
-			}
-
-			// Check if chapter has analysis data
-			Store.Mu.RLock()
-			segments, hasAnalysis := Store.Analysis[chapterID]
-			Store.Mu.RUnlock()
-
-			if !hasAnalysis || len(segments) == 0 {
-				log.Printf("[GenerateAll] Chapter %s has no analysis data, skipping", chapterID)
-				failedChapters = append(failedChapters, chapterTitle+" (no analysis)")
-				continue
-			}
-
-			// Generate audio for this chapter (inline logic from GenerateAudio)
-			tempDir := fmt.Sprintf("data/temp/%s", chapterID)
-			os.MkdirAll(tempDir, 0755)
-			os.MkdirAll(outDir, 0755)
-
-			segTotal := len(segments)
-			var filePaths []string
-			chapterFailed := false
-
-			for j, seg := range segments {
-				Store.Mu.RLock()
-				mapping, hasMapping := Store.VoiceMapping[seg.Speaker]
-				Store.Mu.RUnlock()
-
-				voice := ""
-				emotion := seg.Emotion
-
-				if hasMapping {
-					voice = mapping.VoiceID
-
-					useLLM := true
-					if mapping.UseLLMEmotion != nil {
-						useLLM = *mapping.UseLLMEmotion
-					}
-
-					if useLLM {
-						if seg.Emotion != "" {
-							emotion = seg.Emotion
-						}
-					} else {
-						if mapping.Emotion != "" {
-							emotion = mapping.Emotion
-						}
-					}
-				}
-
-				if emotion == "" {
-					emotion = "calm"
-				}
-
-				// Progress for this segment within the chapter
-				segPercent := int((float64(j) / float64(segTotal)) * 100)
-				runes := []rune(seg.Text)
-				display := string(runes)
-				if len(runes) > 10 {
-					display = string(runes[:10])
-				}
-				BroadcastProgress("batch-generate", overallPercent, fmt.Sprintf("%s (%d/%d) Seg %d/%d: %s...", chapterTitle, i+1, total, j+1, segTotal, display))
-
-				textToSpeak := strings.TrimSpace(seg.Typesetting)
-				if textToSpeak == "" {
-					textToSpeak = strings.TrimSpace(seg.Text)
-				}
-				if textToSpeak == "" {
-					log.Printf("[GenerateAll] Skipping empty segment %d in chapter %s", j, chapterID)
-					continue
-				}
-
-				maxChars := 100
-				chunks := SplitTextForTTS(textToSpeak, maxChars)
-
-				var segmentAudioData []byte
-
-				if len(chunks) == 1 {
-					log.Printf("[GenerateAll] Chapter %s segment %d: %s", chapterID, j, textToSpeak)
-					audioData, err := ttsClient.Generate(textToSpeak, voice, emotion)
-					if err != nil {
-						log.Printf("[GenerateAll] Error generating chapter %s segment %d: %v", chapterID, j, err)
-						failedChapters = append(failedChapters, chapterTitle)
-						chapterFailed = true
-						break
-					}
-					segmentAudioData = audioData
-				} else {
-					log.Printf("[GenerateAll] Chapter %s segment %d is long, split into %d chunks", chapterID, j, len(chunks))
-					var chunkFiles []string
-
-					for k, chunk := range chunks {
-						BroadcastProgress("batch-generate", overallPercent+segPercent/total, fmt.Sprintf("%s Seg %d/%d Part %d/%d", chapterTitle, j+1, segTotal, k+1, len(chunks)))
-						chunkAudio, err := ttsClient.Generate(chunk, voice, emotion)
-						if err != nil {
-							log.Printf("[GenerateAll] Error chapter %s seg %d chunk %d: %v", chapterID, j, k, err)
-							failedChapters = append(failedChapters, chapterTitle)
-							chapterFailed = true
-							break
-						}
-
-						chunkPath := fmt.Sprintf("%s/%d_part_%d.wav", tempDir, j, k)
-						if err := os.WriteFile(chunkPath, chunkAudio, 0644); err != nil {
-							log.Printf("[GenerateAll] Failed to write chunk: %v", err)
-							chapterFailed = true
-							break
-						}
-						chunkFiles = append(chunkFiles, chunkPath)
-					}
-
-					if chapterFailed {
-						break
-					}
-
-					mergedPath := fmt.Sprintf("%s/%d_merged.wav", tempDir, j)
-					if err := audio.MergeAndNormalize(chunkFiles, mergedPath, 0, false); err != nil {
-						log.Printf("[GenerateAll] Failed to merge chunks: %v", err)
-						failedChapters = append(failedChapters, chapterTitle)
-						chapterFailed = true
-						break
-					}
-
-					data, err := os.ReadFile(mergedPath)
-					if err != nil {
-						log.Printf("[GenerateAll] Failed to read merged: %v", err)
-						chapterFailed = true
-						break
-					}
-					segmentAudioData = data
-
-					for _, f := range chunkFiles {
-						os.Remove(f)
-					}
-					os.Remove(mergedPath)
-				}
-
-				if chapterFailed {
-					break
-				}
-
-				filePath := fmt.Sprintf("%s/%d.wav", tempDir, j)
-				if err := os.WriteFile(filePath, segmentAudioData, 0644); err != nil {
-					log.Printf("[GenerateAll] Failed to write temp: %v", err)
-					chapterFailed = true
-					break
-				}
-				filePaths = append(filePaths, filePath)
-			}
-
-			if chapterFailed {
-				os.RemoveAll(tempDir)
-				continue
-			}
-
-			// Merge all segments for this chapter
-			outPath := fmt.Sprintf("%s/%s.wav", outDir, chapterID)
-			if err := audio.MergeAndNormalize(filePaths, outPath, cfg.MergeSilence, cfg.NormalizeAudio); err != nil {
-				log.Printf("[GenerateAll] Merge failed for chapter %s: %v", chapterID, err)
-				failedChapters = append(failedChapters, chapterTitle)
-				os.RemoveAll(tempDir)
-				continue
-			}
-
-			os.RemoveAll(tempDir)
-			successCount++
-			log.Printf("[GenerateAll] Chapter %s completed successfully", chapterID)
-		}
-
-		// Broadcast completion
-		if len(failedChapters) > 0 {
-			BroadcastProgress("batch-generate", 100, fmt.Sprintf("Done. %d generated, %d skipped, %d failed: %v", successCount, skippedCount, len(failedChapters), failedChapters))
-		} else {
-			BroadcastProgress("batch-generate", 100, fmt.Sprintf("All done! %d generated, %d skipped.", successCount, skippedCount))
-		}
-	}()
-
-	c.JSON(http.StatusOK, gin.H{"status": "started", "totalChapters": len(chapters)})
-}
@@ -2,10 +2,8 @@ package api_test
 
 import (
 	"encoding/json"
-	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 
 	"tts-book/backend/internal/api"
@@ -27,7 +25,7 @@ func TestAnalyzeChapter_MockMode(t *testing.T) {
 	// 2. Setup Gin
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	api.SetupRoutes(r, cfg, nil)
+	api.SetupRoutes(r, nil)
 
 	// 3. Preload a dummy chapter into memory store
 	chapterID := "ch_test"
@@ -38,20 +36,14 @@ func TestAnalyzeChapter_MockMode(t *testing.T) {
 			Content: "This is a mock narration segment. This is a mock dialogue segment. Another mock narration.",
 		},
 	}
-	// Manually inject into internal store var (exported for test or we just use Upload mechanism...
-	// but Upload requires file. Let's direct inject to LoadedChapters if accessible.
-	// Wait, api.LoadedChapters is exported? Yes from my grep earlier.)
-	api.LoadedChapters["current"] = dummyChapters
-
+	// Inject directly into the current book, since Upload requires a file.
 	// SETUP: Mock Book ID to test per-book persistence
 	mockBookID := "mock_book_123"
 	api.Store.Mu.Lock()
 	api.Store.BookID = mockBookID
+	api.Store.Chapters = dummyChapters
 	api.Store.Mu.Unlock()
-	defer func() {
-		// Cleanup specific file
-		os.Remove(fmt.Sprintf("data/%s.json", mockBookID))
-	}()
+	defer api.Books.Delete(mockBookID)
 
 	// Perform Request
 	w := httptest.NewRecorder()
@@ -95,11 +87,12 @@ func TestAnalyzeChapter_MockMode(t *testing.T) {
 		t.Errorf("Store has %d items, response has %d", len(stored), len(response.Results))
 	}
 
-	// Verify JSON file created for this book
-	expectedFile := fmt.Sprintf("data/%s.json", mockBookID)
-	if _, err := os.Stat(expectedFile); os.IsNotExist(err) {
-		t.Errorf("Expected persistence file %s to be created, but it was not found", expectedFile)
-	} else {
-		t.Logf("Verified persistence file created: %s", expectedFile)
+	// Verify the analysis was persisted for this book
+	var saved api.ProjectStore
+	if err := saved.Load(mockBookID); err != nil {
+		t.Fatalf("Expected book %s to be saved, but loading it failed: %v", mockBookID, err)
+	}
+	if len(saved.Analysis[chapterID]) != len(stored) {
+		t.Errorf("Saved project has %d segments, store has %d", len(saved.Analysis[chapterID]), len(stored))
 	}
 }
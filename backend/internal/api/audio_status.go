@@ -5,6 +5,9 @@ import (
 	"net/http"
 	"os"
 
+	"tts-book/backend/internal/audio"
+	"tts-book/backend/internal/config"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -12,13 +15,15 @@ import (
 func GetAudioStatus(c *gin.Context) {
 	chapterID := c.Param("chapterID")
 
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+
 	// Check if audio file exists
-	Store.Mu.RLock()
-	bookID := Store.BookID
-	Store.Mu.RUnlock()
+	bookID := book.id()
 
-	audioPath := fmt.Sprintf("data/out/%s/%s.wav", bookID, chapterID)
-	_, err := os.Stat(audioPath)
+	audioPath, format, err := findChapterAudio(bookID, chapterID)
 
 	if os.IsNotExist(err) {
 		c.JSON(http.StatusOK, gin.H{
@@ -38,6 +43,44 @@ func GetAudioStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"exists":    true,
 		"chapterId": chapterID,
-		"url":       fmt.Sprintf("/output/%s/%s.wav", bookID, chapterID),
+		"url":       outputURL(audioPath),
+		"format":    format.Name,
+		"mimeType":  format.MIME,
 	})
 }
+
+// chapterAudioPath is where a chapter's audio is written in format f.
+func chapterAudioPath(bookID, chapterID string, f audio.Format) string {
+	return fmt.Sprintf("data/out/%s/%s%s", bookID, chapterID, f.Ext)
+}
+
+// findChapterAudio locates a chapter's audio, preferring the configured
+// output format over files left from earlier format settings. The error
+// satisfies os.IsNotExist when no audio exists.
+func findChapterAudio(bookID, chapterID string) (string, audio.Format, error) {
+	formats := audio.Formats()
+	if preferred, err := audio.LookupFormat(config.Get().OutputFormat); err == nil {
+		formats = append([]audio.Format{preferred}, formats...)
+	}
+	for _, f := range formats {
+		path := chapterAudioPath(bookID, chapterID, f)
+		_, err := os.Stat(path)
+		if err == nil {
+			return path, f, nil
+		}
+		if !os.IsNotExist(err) {
+			return "", audio.Format{}, err
+		}
+	}
+	return "", audio.Format{}, os.ErrNotExist
+}
+
+// removeStaleAudio deletes a chapter's audio in formats other than keep, so
+// switching formats never leaves two diverging copies behind.
+func removeStaleAudio(bookID, chapterID string, keep audio.Format) {
+	for _, f := range audio.Formats() {
+		if f.Name != keep.Name {
+			os.Remove(chapterAudioPath(bookID, chapterID, f))
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/importer"
+	"tts-book/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// coverExtensions are the cover image types that are kept, by media type
+var coverExtensions = map[string]string{
+	"image/jpeg": ".jpg", "image/png": ".png", "image/gif": ".gif", "image/webp": ".webp",
+}
+
+// readBookInfo reads the metadata and cover of a book file. The cover is
+// saved next to the book's generated audio; coverPath is empty if the book
+// has none.
+func readBookInfo(bookID, bookPath string) (meta *epub.Metadata, coverPath string) {
+	meta, err := importer.Metadata(bookPath)
+	if err != nil {
+		log.Printf("[Book] Warning: Failed to read metadata of %s: %v", bookPath, err)
+		meta = &epub.Metadata{Title: strings.TrimSuffix(filepath.Base(bookPath), filepath.Ext(bookPath))}
+	}
+
+	data, mediaType, err := importer.Cover(bookPath)
+	if err != nil {
+		return meta, ""
+	}
+	ext, ok := coverExtensions[mediaType]
+	if !ok {
+		log.Printf("[Book] Warning: Unsupported cover type %s", mediaType)
+		return meta, ""
+	}
+	coverPath = fmt.Sprintf("data/out/%s/cover%s", bookID, ext)
+	if err := os.MkdirAll(filepath.Dir(coverPath), 0755); err != nil {
+		log.Printf("[Book] Warning: Failed to save cover: %v", err)
+		return meta, ""
+	}
+	if err := os.WriteFile(coverPath, data, 0644); err != nil {
+		log.Printf("[Book] Warning: Failed to save cover: %v", err)
+		return meta, ""
+	}
+	return meta, coverPath
+}
+
+// outputURL is the URL a file under data/out is served at, or empty for no file
+func outputURL(path string) string {
+	if path == "" {
+		return ""
+	}
+	return "/output/" + strings.TrimPrefix(filepath.ToSlash(path), "data/out/")
+}
+
+// GetBook returns the book's metadata, cover URL, chapter count and the
+// files generated for it
+func GetBook(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.RLock()
+	defer book.Mu.RUnlock()
+
+	if book.BookID == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No book loaded"})
+		return
+	}
+
+	artefacts := []storage.Artefact{}
+	if db, err := projects(); err == nil {
+		if stored, err := db.Artefacts(book.BookID); err == nil {
+			artefacts = stored
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"bookId":    book.BookID,
+		"name":      book.summary().Name,
+		"bookPath":  book.CurrentBookPath,
+		"metadata":  book.Metadata,
+		"coverUrl":  outputURL(book.CoverPath),
+		"chapters":  len(book.Chapters),
+		"artefacts": artefacts,
+	})
+}
@@ -0,0 +1,560 @@
+package api
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+	"tts-book/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bundleVersion is the project bundle format written by ExportBundle
+const bundleVersion = 1
+
+// bundleProject is project.json in a project bundle. A bundle is a zip
+// archive holding it, the source book under book/, the voice samples the
+// characters use under voices/ and optionally the generated audio under
+// audio/. Paths in project.json are paths in the archive.
+type bundleProject struct {
+	Version    int                             `json:"version"`
+	BookID     string                          `json:"bookId"`
+	Name       string                          `json:"name,omitempty"`
+	BookFile   string                          `json:"bookFile"`
+	Metadata   *epub.Metadata                  `json:"metadata,omitempty"`
+	Chapters   []epub.Chapter                  `json:"chapters"`
+	Analysis   map[string][]llm.AnalysisResult `json:"analysis"`
+	Characters []string                        `json:"characters"`
+	Profiles   map[string]CharacterProfile     `json:"profiles,omitempty"`
+	Voices     map[string]VoiceConfig          `json:"voices"`
+	Audio      []string                        `json:"audio,omitempty"`
+	Artefacts  []storage.Artefact              `json:"artefacts,omitempty"`
+}
+
+// ExportBundle returns the book as a project bundle that ImportBundle can
+// restore on another machine. ?audio=true includes the generated audio.
+func ExportBundle(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	withAudio := c.Query("audio") == "true"
+
+	book.Mu.RLock()
+	p := &bundleProject{
+		Version:    bundleVersion,
+		BookID:     book.BookID,
+		Name:       book.Name,
+		Metadata:   book.Metadata,
+		Chapters:   slices.Clone(book.Chapters),
+		Analysis:   make(map[string][]llm.AnalysisResult, len(book.Analysis)),
+		Characters: make([]string, 0, len(book.DetectedCharacters)),
+		Voices:     make(map[string]VoiceConfig, len(book.VoiceMapping)),
+		Profiles:   maps.Clone(book.Profiles),
+	}
+	// Copies, as jobs and edits may change the book while the bundle is written
+	for chapterID, segments := range book.Analysis {
+		p.Analysis[chapterID] = slices.Clone(segments)
+	}
+	for name, ok := range book.DetectedCharacters {
+		if ok {
+			p.Characters = append(p.Characters, name)
+		}
+	}
+	for name, v := range book.VoiceMapping {
+		p.Voices[name] = v
+	}
+	bookPath := book.CurrentBookPath
+	book.Mu.RUnlock()
+	sort.Strings(p.Characters)
+
+	if p.BookID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No book loaded"})
+		return
+	}
+	if _, err := os.Stat(bookPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Source book is missing: %v", err)})
+		return
+	}
+
+	// Written to a temp file first, so a failure is still reported as an error
+	tmp, err := os.CreateTemp("", "tts-book-export-*.zip")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.Remove(tmp.Name())
+
+	err = writeBundle(tmp, p, bookPath, withAudio)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to export project: %v", err)})
+		return
+	}
+
+	c.FileAttachment(tmp.Name(), p.BookID+".zip")
+}
+
+// writeBundle writes the project and the files it references as a bundle.
+// Voice paths in p are rewritten to their place in the bundle.
+func writeBundle(w io.Writer, p *bundleProject, bookPath string, withAudio bool) error {
+	zw := zip.NewWriter(w)
+
+	p.BookFile = "book/" + filepath.Base(bookPath)
+	if err := addZipFile(zw, p.BookFile, bookPath); err != nil {
+		return err
+	}
+
+	// Characters sharing a sample share the entry; different samples with
+	// the same file name are told apart by a suffix
+	entries := make(map[string]string) // Local path -> entry
+	used := make(map[string]bool)
+	names := make([]string, 0, len(p.Voices))
+	for name := range p.Voices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v := p.Voices[name]
+		if v.VoiceID == "" {
+			continue
+		}
+		entry, ok := entries[v.VoiceID]
+		if !ok {
+			if _, err := os.Stat(v.VoiceID); err != nil {
+				log.Printf("[Bundle] Warning: Voice %s of %s not found, keeping its path", v.VoiceID, name)
+				continue
+			}
+			entry = uniqueEntry("voices", filepath.Base(v.VoiceID), used)
+			if err := addZipFile(zw, entry, v.VoiceID); err != nil {
+				return err
+			}
+			entries[v.VoiceID] = entry
+		}
+		v.VoiceID = entry
+		p.Voices[name] = v
+	}
+
+	if withAudio {
+		outDir := filepath.Join("data", "out", p.BookID)
+		files, err := os.ReadDir(outDir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, f := range files {
+			// The cover is extracted again from the book on import
+			if f.IsDir() || strings.HasPrefix(f.Name(), "cover.") {
+				continue
+			}
+			entry := "audio/" + f.Name()
+			if err := addZipFile(zw, entry, filepath.Join(outDir, f.Name())); err != nil {
+				return err
+			}
+			p.Audio = append(p.Audio, entry)
+		}
+
+		db, err := projects()
+		if err != nil {
+			return err
+		}
+		artefacts, err := db.Artefacts(p.BookID)
+		if err != nil {
+			return err
+		}
+		for _, a := range artefacts {
+			a.Path = "audio/" + filepath.Base(a.Path)
+			p.Artefacts = append(p.Artefacts, a)
+		}
+	}
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	fw, err := zw.Create("project.json")
+	if err != nil {
+		return err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func addZipFile(zw *zip.Writer, entry, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w, err := zw.Create(entry)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// uniqueEntry returns dir/name, or dir/name-2... if that is taken
+func uniqueEntry(dir, name string, used map[string]bool) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	entry := dir + "/" + name
+	for i := 2; used[entry]; i++ {
+		entry = fmt.Sprintf("%s/%s-%d%s", dir, base, i, ext)
+	}
+	used[entry] = true
+	return entry
+}
+
+// ImportBundle restores a project bundle made by ExportBundle and opens the
+// book. Voice samples are copied into the voice directory, reusing samples
+// already there, and the characters relinked to them. A book that already
+// exists is only replaced with ?overwrite=true, and only once the bundle
+// has been restored in full.
+func ImportBundle(c *gin.Context) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	overwrite := c.Query("overwrite") == "true"
+
+	tmp, err := os.CreateTemp("", "tts-book-import-*.zip")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := c.SaveUploadedFile(file, tmp.Name()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+
+	zr, err := zip.OpenReader(tmp.Name())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not a project bundle"})
+		return
+	}
+	defer zr.Close()
+
+	p, err := readBundleProject(&zr.Reader)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// No job may start on the book while it is replaced
+	unlock, err := Jobs.LockBook(p.BookID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "The book has unfinished jobs"})
+		return
+	}
+	defer unlock()
+
+	_, err = Books.Get(p.BookID)
+	if err != nil && !errors.Is(err, ErrBookNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	exists := err == nil
+	if exists && !overwrite {
+		c.JSON(http.StatusConflict, gin.H{"error": "The book is already in the library. Retry with overwrite=true to replace it."})
+		return
+	}
+
+	book, relinked, cleared, err := restoreBundle(&zr.Reader, p)
+	if err != nil {
+		if !exists {
+			// Nothing else uses the files extracted so far
+			os.RemoveAll(filepath.Join("uploads", p.BookID))
+			os.RemoveAll(filepath.Join("data", "out", p.BookID))
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to import project: %v", err)})
+		return
+	}
+
+	book.Mu.RLock()
+	defer book.Mu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Import successful",
+		"bookId":   book.BookID,
+		"chapters": book.Chapters,
+		"bookPath": book.CurrentBookPath,
+		"metadata": book.Metadata,
+		"voices":   relinked,
+		"cleared":  cleared,
+	})
+}
+
+// readBundleProject reads and checks project.json. Every path it names
+// must be a plain file in the folder of its kind, so nothing is written
+// outside the places the bundle is restored to.
+func readBundleProject(zr *zip.Reader) (*bundleProject, error) {
+	data, err := readZipFile(zr, "project.json")
+	if err != nil {
+		return nil, errors.New("Not a project bundle: project.json is missing")
+	}
+	var p bundleProject
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("Invalid project.json: %v", err)
+	}
+	if p.Version > bundleVersion {
+		return nil, fmt.Errorf("The bundle was made by a newer version (format %d)", p.Version)
+	}
+	if !validBookID.MatchString(p.BookID) {
+		return nil, errors.New("Invalid book ID in bundle")
+	}
+
+	entries := []string{p.BookFile}
+	entries = append(entries, p.Audio...)
+	for _, v := range p.Voices {
+		if strings.HasPrefix(v.VoiceID, "voices/") {
+			entries = append(entries, v.VoiceID)
+		}
+	}
+	for _, entry := range entries {
+		dir, name := path.Split(entry)
+		if (dir != "book/" && dir != "voices/" && dir != "audio/") || name == "" || name == "." || name == ".." {
+			return nil, fmt.Errorf("Invalid path %q in bundle", entry)
+		}
+		if _, err := fs.Stat(zr, entry); err != nil {
+			return nil, fmt.Errorf("The bundle is missing %s", entry)
+		}
+	}
+	return &p, nil
+}
+
+// localVoice reports whether a voice ID names a file rather than a voice of
+// the engine. A bundle's own samples are under voices/; any other file
+// would be whatever is at that path on this machine.
+func localVoice(id string) bool {
+	return filepath.IsAbs(id) || strings.ContainsAny(id, `/\`) || id == "." || id == ".."
+}
+
+// restoreBundle writes the bundle's files into place and saves the project,
+// replacing a saved book with the same ID in one transaction. Audio is
+// staged until the project is saved, so a failure leaves an existing book
+// as it was. It returns the voice each bundled sample was relinked to and
+// the characters whose voice was a local file outside the bundle, which
+// are cleared.
+func restoreBundle(zr *zip.Reader, p *bundleProject) (*ProjectStore, map[string]string, []string, error) {
+	// The book ID is the file's hash, so a file already here is the same
+	bookPath := filepath.Join("uploads", p.BookID, path.Base(p.BookFile))
+	if err := extractZipFile(zr, p.BookFile, bookPath); err != nil {
+		return nil, nil, nil, err
+	}
+
+	voiceDir := config.Get().VoiceLibrary()[0]
+	relinked := make(map[string]string)
+	voices := make(map[string]VoiceConfig, len(p.Voices))
+	var cleared []string
+	for name, v := range p.Voices {
+		switch {
+		case strings.HasPrefix(v.VoiceID, "voices/"):
+			local, ok := relinked[v.VoiceID]
+			if !ok {
+				var err error
+				if local, err = relinkVoice(zr, v.VoiceID, voiceDir); err != nil {
+					return nil, nil, nil, err
+				}
+				relinked[v.VoiceID] = local
+			}
+			v.VoiceID = local
+		case localVoice(v.VoiceID):
+			log.Printf("[Bundle] Clearing the voice of %s: %s is not in the bundle", name, v.VoiceID)
+			v.VoiceID = ""
+			cleared = append(cleared, name)
+		}
+		voices[name] = v
+	}
+	sort.Strings(cleared)
+
+	outDir := filepath.Join("data", "out", p.BookID)
+	staging := outDir + ".import"
+	os.RemoveAll(staging)
+	defer os.RemoveAll(staging)
+	for _, entry := range p.Audio {
+		if err := extractZipFile(zr, entry, filepath.Join(staging, path.Base(entry))); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	book := newProjectStore()
+	book.BookID = p.BookID
+	book.Name = p.Name
+	book.CurrentBookPath = bookPath
+	book.Chapters = p.Chapters
+	if p.Analysis != nil {
+		book.Analysis = p.Analysis
+	}
+	for _, name := range p.Characters {
+		book.DetectedCharacters[name] = true
+	}
+	for name, profile := range p.Profiles {
+		book.setProfile(name, profile)
+	}
+	book.VoiceMapping = voices
+	book.Metadata, book.CoverPath = readBookInfo(p.BookID, bookPath)
+	if p.Metadata != nil {
+		book.Metadata = p.Metadata
+	}
+
+	// Not shared yet, so no lock is needed
+	err := book.commit(func(tx storage.Tx) error {
+		if err := tx.Clear(); err != nil {
+			return err
+		}
+		if err := book.putAll(tx); err != nil {
+			return err
+		}
+		for _, a := range p.Artefacts {
+			a.Path = filepath.Join(outDir, path.Base(a.Path))
+			if err := tx.PutArtefact(a); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The project is saved; swap the old audio for the bundle's
+	if err := replaceOutput(outDir, staging); err != nil {
+		return nil, nil, nil, err
+	}
+	book, err = Books.reopen(p.BookID)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return book, relinked, cleared, nil
+}
+
+// replaceOutput moves the files in staging into dir, removing what dir held
+// before except the cover, which comes from the book file
+func replaceOutput(dir, staging string) error {
+	old, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, f := range old {
+		if !strings.HasPrefix(f.Name(), "cover.") {
+			os.RemoveAll(filepath.Join(dir, f.Name()))
+		}
+	}
+
+	staged, err := os.ReadDir(staging)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range staged {
+		if err := os.Rename(filepath.Join(staging, f.Name()), filepath.Join(dir, f.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relinkVoice copies a bundled voice sample into the voice directory and
+// returns its absolute path. A sample with the same name and content that
+// is already there is used as is; one with different content is kept and
+// the bundled sample gets a suffixed name.
+func relinkVoice(zr *zip.Reader, entry, voiceDir string) (string, error) {
+	data, err := readZipFile(zr, entry)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(voiceDir, 0755); err != nil {
+		return "", err
+	}
+
+	name := path.Base(entry)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	dst := filepath.Join(voiceDir, name)
+	for i := 2; ; i++ {
+		existing, err := os.ReadFile(dst)
+		if os.IsNotExist(err) {
+			if err := os.WriteFile(dst, data, 0644); err != nil {
+				return "", err
+			}
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if bytes.Equal(existing, data) {
+			break
+		}
+		dst = filepath.Join(voiceDir, fmt.Sprintf("%s-%d%s", base, i, ext))
+	}
+
+	// Absolute, like uploaded voices, so the TTS server can use it locally
+	if abs, err := filepath.Abs(dst); err == nil {
+		dst = abs
+	}
+	return dst, nil
+}
+
+func readZipFile(zr *zip.Reader, name string) ([]byte, error) {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func extractZipFile(zr *zip.Reader, name, dst string) error {
+	src, err := zr.Open(name)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	// Through a temp file, so a failed copy leaves a file at dst as it was
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	if _, err := io.Copy(out, src); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
@@ -0,0 +1,203 @@
+package api_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBundle(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	mockBookID := "mock_book_bundle"
+	bookPath := filepath.Join("uploads", mockBookID, "book.txt")
+	os.MkdirAll(filepath.Dir(bookPath), 0755)
+	os.WriteFile(bookPath, []byte("你好！"), 0644)
+	outDir := filepath.Join("data", "out", mockBookID)
+	os.MkdirAll(outDir, 0755)
+	os.WriteFile(filepath.Join(outDir, "ch1.wav"), []byte("audio"), 0644)
+
+	// A voice sample outside the voice directory, referenced by absolute path
+	voice := filepath.Join(t.TempDir(), "mock_bundle_alice.wav")
+	os.WriteFile(voice, []byte("alice"), 0644)
+	relinked := filepath.Join("voices", "mock_bundle_alice.wav")
+
+	api.Store.Mu.Lock()
+	api.Store.BookID = mockBookID
+	api.Store.CurrentBookPath = bookPath
+	api.Store.Chapters = []epub.Chapter{{ID: "ch1", Title: "Bundle", Content: "你好！"}}
+	api.Store.Analysis["ch1"] = []llm.AnalysisResult{{Text: "你好！", Speaker: "Alice", Emotion: "happy"}}
+	api.Store.DetectedCharacters["Alice"] = true
+	api.Store.VoiceMapping["Alice"] = api.VoiceConfig{VoiceID: voice, Emotion: "happy"}
+	api.Store.VoiceMapping["Bob"] = api.VoiceConfig{VoiceID: "remote-voice"}
+	if err := api.Store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	api.Store.Mu.Unlock()
+	defer api.Books.Delete(mockBookID)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/api/books/"+mockBookID+"/export?audio=true", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("export: status %d, body %s", w.Code, w.Body.String())
+	}
+	bundle := w.Body.Bytes()
+
+	zr, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := make(map[string]bool)
+	for _, f := range zr.File {
+		entries[f.Name] = true
+	}
+	for _, name := range []string{"project.json", "book/book.txt", "voices/mock_bundle_alice.wav", "audio/ch1.wav"} {
+		if !entries[name] {
+			t.Errorf("bundle has no %s; entries %v", name, entries)
+		}
+	}
+	f, _ := zr.Open("project.json")
+	var project struct {
+		Voices map[string]api.VoiceConfig `json:"voices"`
+	}
+	json.NewDecoder(f).Decode(&project)
+	f.Close()
+	if project.Voices["Alice"].VoiceID != "voices/mock_bundle_alice.wav" || project.Voices["Bob"].VoiceID != "remote-voice" {
+		t.Errorf("bundled voices = %+v", project.Voices)
+	}
+
+	importBundle := func(url string, bundle []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", mockBookID+".zip")
+		io.Copy(fw, bytes.NewReader(bundle))
+		mw.Close()
+		req, _ := http.NewRequest("POST", url, &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// The book is still here, so it's only replaced when asked to
+	if w := importBundle("/api/import", bundle); w.Code != http.StatusConflict {
+		t.Fatalf("import over existing book: status %d, want 409", w.Code)
+	}
+
+	// A broken bundle is rejected before anything of the book is replaced
+	broken := rewriteBundle(t, zr, func(name string, data []byte) []byte {
+		if name == "audio/ch1.wav" {
+			return nil
+		}
+		return data
+	})
+	if w := importBundle("/api/import?overwrite=true", broken); w.Code != http.StatusBadRequest {
+		t.Errorf("import of broken bundle: status %d, want 400", w.Code)
+	}
+	if data, err := os.ReadFile(filepath.Join(outDir, "ch1.wav")); err != nil || string(data) != "audio" {
+		t.Errorf("audio after broken import = %q, %v", data, err)
+	}
+
+	if w := importBundle("/api/import?overwrite=true", bundle); w.Code != http.StatusOK {
+		t.Fatalf("import: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	book, err := api.Books.Get(mockBookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	book.Mu.RLock()
+	alice, bob := book.VoiceMapping["Alice"], book.VoiceMapping["Bob"]
+	segments := book.Analysis["ch1"]
+	restoredPath := book.CurrentBookPath
+	book.Mu.RUnlock()
+
+	abs, _ := filepath.Abs(relinked)
+	if alice.VoiceID != abs || alice.Emotion != "happy" || bob.VoiceID != "remote-voice" {
+		t.Errorf("relinked voices = %+v, %+v; want Alice at %s", alice, bob, abs)
+	}
+	if data, err := os.ReadFile(relinked); err != nil || string(data) != "alice" {
+		t.Errorf("relinked voice sample = %q, %v", data, err)
+	}
+	if len(segments) != 1 || segments[0].Speaker != "Alice" {
+		t.Errorf("restored analysis = %+v", segments)
+	}
+	if data, err := os.ReadFile(restoredPath); err != nil || string(data) != "你好！" {
+		t.Errorf("restored book %s = %q, %v", restoredPath, data, err)
+	}
+	if _, err := os.Stat(filepath.Join(outDir, "ch1.wav")); err != nil {
+		t.Errorf("restored audio: %v", err)
+	}
+
+	// A voice pointing at a local file that isn't in the bundle is cleared
+	local := rewriteBundle(t, zr, func(name string, data []byte) []byte {
+		if name == "project.json" {
+			return bytes.Replace(data, []byte(`"remote-voice"`), []byte(`"/etc/passwd"`), 1)
+		}
+		return data
+	})
+	w = importBundle("/api/import?overwrite=true", local)
+	var resp struct {
+		Cleared []string `json:"cleared"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || len(resp.Cleared) != 1 || resp.Cleared[0] != "Bob" {
+		t.Fatalf("import with local voice: status %d, body %s", w.Code, w.Body.String())
+	}
+	book, _ = api.Books.Get(mockBookID)
+	book.Mu.RLock()
+	bob = book.VoiceMapping["Bob"]
+	book.Mu.RUnlock()
+	if bob.VoiceID != "" {
+		t.Errorf("Bob's voice = %q, want it cleared", bob.VoiceID)
+	}
+}
+
+// rewriteBundle copies a bundle, passing each entry through fn. Entries fn
+// returns nil for are left out.
+func rewriteBundle(t *testing.T, zr *zip.Reader, fn func(name string, data []byte) []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data = fn(f.Name, data); data == nil {
+			continue
+		}
+		w, err := zw.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
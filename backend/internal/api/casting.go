@@ -0,0 +1,164 @@
+package api
+
+import (
+	"cmp"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"tts-book/backend/internal/casting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CastVoicesRequest struct {
+	// Characters whose proposed voice to apply; empty applies them all
+	Characters []string `json:"characters"`
+}
+
+// GetCasting previews the voices casting would give the book's characters.
+// ?mode is "missing" (characters without a voice), "distinct" (the default:
+// also frequent speakers sharing a voice) or "all".
+func GetCasting(c *gin.Context) {
+	mode, err := casting.ParseMode(c.Query("mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	voices, err := castingVoices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.RLock()
+	defer book.Mu.RUnlock()
+
+	changes := casting.Cast(book.castingCharacters(), voices, mode)
+	c.JSON(http.StatusOK, gin.H{"changes": orEmpty(changes), "voices": len(voices)})
+}
+
+// CastVoices applies the voices GetCasting proposes, or some of them, as
+// one revision that can be undone
+func CastVoices(c *gin.Context) {
+	mode, err := casting.ParseMode(c.Query("mode"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var req CastVoicesRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	voices, err := castingVoices()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
+
+	changes := casting.Cast(book.castingCharacters(), voices, mode)
+	if len(req.Characters) > 0 {
+		changes = slices.DeleteFunc(changes, func(ch casting.Change) bool {
+			return !slices.Contains(req.Characters, ch.Character)
+		})
+	}
+
+	before := book.snapshot()
+	book.applyCasting(changes)
+	rev, err := book.saveEdit(before, "cast-voices", fmt.Sprintf("Cast voices of %d characters", len(changes)))
+	if err != nil {
+		book.revert(before)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"changes": orEmpty(changes), "revision": rev})
+}
+
+// castingVoices returns the voices casting picks from. Voices whose
+// sidecar doesn't give their gender or age are guessed from their name.
+func castingVoices() ([]casting.Voice, error) {
+	list, err := voiceLibrary().List()
+	if err != nil {
+		return nil, fmt.Errorf("could not list voices: %v", err)
+	}
+	voices := make([]casting.Voice, len(list))
+	for i, v := range list {
+		guess := casting.GuessVoice(v.Path)
+		voices[i] = casting.Voice{ID: v.Path, Gender: cmp.Or(v.Gender, guess.Gender), Age: cmp.Or(v.Age, guess.Age)}
+	}
+	return voices, nil
+}
+
+// castingCharacters returns the book's characters with their profile,
+// line count and current voice. Caller must hold s.Mu.
+func (s *ProjectStore) castingCharacters() []casting.Character {
+	lines := make(map[string]int)
+	for _, segments := range s.Analysis {
+		for _, seg := range segments {
+			lines[seg.Speaker]++
+		}
+	}
+	chars := make([]casting.Character, 0, len(s.DetectedCharacters))
+	for name, ok := range s.DetectedCharacters {
+		if !ok {
+			continue
+		}
+		// Library voices are absolute paths; mappings may hold relative ones
+		voice := s.VoiceMapping[name].VoiceID
+		if _, err := os.Stat(voice); voice != "" && err == nil {
+			if abs, err := filepath.Abs(voice); err == nil {
+				voice = abs
+			}
+		}
+		profile := s.Profiles[name]
+		chars = append(chars, casting.Character{
+			Name:   name,
+			Gender: profile.Gender,
+			Age:    profile.Age,
+			Lines:  lines[name],
+			Voice:  voice,
+		})
+	}
+	return chars
+}
+
+// applyCasting gives characters their new voices, keeping the rest of
+// their voice settings. Caller must hold s.Mu for writing.
+func (s *ProjectStore) applyCasting(changes []casting.Change) {
+	for _, ch := range changes {
+		config, exists := s.VoiceMapping[ch.Character]
+		if !exists {
+			config = VoiceConfig{
+				Emotion:       "calm",
+				UseLLMEmotion: boolPtr(true), // Default to using LLM emotions
+			}
+		}
+		config.VoiceID = ch.To
+		s.VoiceMapping[ch.Character] = config
+		log.Printf("[Casting] Cast voice %s for character %s (%s)", filepath.Base(ch.To), ch.Character, ch.Reason)
+	}
+}
+
+func orEmpty(changes []casting.Change) []casting.Change {
+	if changes == nil {
+		return []casting.Change{}
+	}
+	return changes
+}
@@ -0,0 +1,102 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/casting"
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCasting(t *testing.T) {
+	cfg := config.Get()
+	voiceDir := t.TempDir()
+	for _, name := range []string{"female.wav", "girl.wav", "male.wav", "old_man.wav"} {
+		os.WriteFile(filepath.Join(voiceDir, name), []byte("voice"), 0644)
+	}
+	oldVoiceDirs := cfg.VoiceDirs
+	cfg.VoiceDirs = []string{voiceDir}
+	defer func() { cfg.VoiceDirs = oldVoiceDirs }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	female := filepath.Join(voiceDir, "female.wav")
+	girl := filepath.Join(voiceDir, "girl.wav")
+	male := filepath.Join(voiceDir, "male.wav")
+
+	// The two main characters share a voice, and the girl has none
+	mockBookID := "mock_book_casting"
+	api.Store.Mu.Lock()
+	api.Store.BookID = mockBookID
+	api.Store.Analysis["ch1"] = []llm.AnalysisResult{
+		{Text: "a", Speaker: "Alice"}, {Text: "b", Speaker: "Alice"}, {Text: "c", Speaker: "Alice"},
+		{Text: "d", Speaker: "Bob"}, {Text: "e", Speaker: "Bob"},
+		{Text: "f", Speaker: "Girl"},
+	}
+	for _, name := range []string{"Alice", "Bob", "Girl"} {
+		api.Store.DetectedCharacters[name] = true
+	}
+	api.Store.Profiles["Alice"] = api.CharacterProfile{Name: "Alice", Gender: "female"}
+	api.Store.Profiles["Bob"] = api.CharacterProfile{Name: "Bob", Gender: "male", Age: "young"}
+	api.Store.Profiles["Girl"] = api.CharacterProfile{Name: "Girl", Gender: "female", Age: "child"}
+	api.Store.VoiceMapping["Alice"] = api.VoiceConfig{VoiceID: female, Emotion: "happy"}
+	api.Store.VoiceMapping["Bob"] = api.VoiceConfig{VoiceID: female}
+	api.Store.Mu.Unlock()
+	defer api.Books.Delete(mockBookID)
+
+	do := func(method, path string, body any) []casting.Change {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d, body %s", method, path, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Changes []casting.Change `json:"changes"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Changes
+	}
+	voiceOf := func(name string) api.VoiceConfig {
+		api.Store.Mu.RLock()
+		defer api.Store.Mu.RUnlock()
+		return api.Store.VoiceMapping[name]
+	}
+
+	// The preview changes nothing
+	changes := do("GET", "/api/casting", nil)
+	if len(changes) != 2 || changes[0].Character != "Bob" || changes[0].To != male || changes[0].Reason != "shared" ||
+		changes[1].Character != "Girl" || changes[1].To != girl {
+		t.Fatalf("preview = %+v", changes)
+	}
+	if voiceOf("Bob").VoiceID != female {
+		t.Errorf("preview changed Bob's voice to %s", voiceOf("Bob").VoiceID)
+	}
+
+	// Apply only Bob's new voice
+	do("POST", "/api/casting", gin.H{"characters": []string{"Bob"}})
+	if voiceOf("Bob").VoiceID != male || voiceOf("Girl").VoiceID != "" || voiceOf("Alice").Emotion != "happy" {
+		t.Errorf("after casting: Bob %+v, Girl %+v, Alice %+v", voiceOf("Bob"), voiceOf("Girl"), voiceOf("Alice"))
+	}
+
+	// Casting again proposes only what is left
+	if changes := do("GET", "/api/casting", nil); len(changes) != 1 || changes[0].Character != "Girl" {
+		t.Errorf("second preview = %+v", changes)
+	}
+}
@@ -1,8 +1,11 @@
 package api
 
 import (
-	"log"
+	"fmt"
 	"net/http"
+	"regexp"
+	"slices"
+	"strings"
 	"tts-book/backend/internal/llm"
 
 	"github.com/gin-gonic/gin"
@@ -18,6 +21,16 @@ type UpdateCharacterRequest struct {
 	VoiceConfig VoiceConfig `json:"voiceConfig"`
 }
 
+var validGenders = map[string]bool{"": true, "male": true, "female": true}
+
+var validAges = map[string]bool{
+	"": true, "child": true, "teen": true, "young": true,
+	"adult": true, "middle-aged": true, "elderly": true,
+}
+
+// The analysis names speakers it inferred from context as "她(蒙扎)"
+var inferredSpeaker = regexp.MustCompile(`^[^(（]*[(（]\s*(.+?)\s*[)）]$`)
+
 // MergeCharacters merges multiple source characters into a single target character
 func MergeCharacters(c *gin.Context) {
 	var req MergeRequest
@@ -31,12 +44,18 @@ func MergeCharacters(c *gin.Context) {
 		return
 	}
 
-	Store.Mu.Lock()
-	defer Store.Mu.Unlock()
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
+
+	before := book.snapshot()
 
 	// 1. Update Analysis Results
 	count := 0
-	for chapterID, segments := range Store.Analysis {
+	for chapterID, segments := range book.Analysis {
 		updatedSegments := make([]llm.AnalysisResult, len(segments)) // Create new slice to avoid mutating whilst iterating if we were doing that, but here we replace
 		copy(updatedSegments, segments)
 
@@ -52,29 +71,55 @@ func MergeCharacters(c *gin.Context) {
 			}
 		}
 		if changed {
-			Store.Analysis[chapterID] = updatedSegments
+			book.Analysis[chapterID] = updatedSegments
 		}
 	}
 
 	// 2. Update DetectedCharacters & VoiceMapping
 	// Ensure target exists
-	Store.DetectedCharacters[req.Target] = true
+	book.DetectedCharacters[req.Target] = true
+
+	// Keep the merged names as aliases, so later analysis results using
+	// them are credited to the target
+	profile := book.Profiles[req.Target]
+	for _, src := range req.Sources {
+		if src == req.Target {
+			continue
+		}
+		profile.Aliases = appendAlias(profile.Aliases, req.Target, src)
+		from := book.Profiles[src]
+		for _, alias := range from.Aliases {
+			profile.Aliases = appendAlias(profile.Aliases, req.Target, alias)
+		}
+		if profile.Gender == "" {
+			profile.Gender = from.Gender
+		}
+		if profile.Age == "" {
+			profile.Age = from.Age
+		}
+		if profile.Description == "" {
+			profile.Description = from.Description
+		}
+		delete(book.Profiles, src)
+	}
+	book.setProfile(req.Target, profile)
 
 	// Preserve target voice config if it exists, otherwise try to take from one of the sources?
 	// For now, we assume user keeps target's config or sets it later.
 	// We just delete sources.
 
 	for _, src := range req.Sources {
-		delete(Store.DetectedCharacters, src)
+		delete(book.DetectedCharacters, src)
 		// We could delete voice mapping, but maybe keep it for reference?
 		// No, clean it up.
-		delete(Store.VoiceMapping, src)
+		delete(book.VoiceMapping, src)
 	}
 
 	// Persist
-	if err := Store.Save(); err != nil {
-		log.Printf("Failed to save store after merge: %v", err)
-		c.JSON(http.StatusOK, gin.H{"message": "Merged in memory, but save failed", "count": count})
+	summary := fmt.Sprintf("Merged %s into %s", strings.Join(req.Sources, ", "), req.Target)
+	if _, err := book.saveEdit(before, "merge-characters", summary); err != nil {
+		book.revert(before)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -93,19 +138,140 @@ func UpdateCharacter(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Character Name is required"})
 		return
 	}
+	params, err := checkParams(req.VoiceConfig.Params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.VoiceConfig.Params = params
 
-	Store.Mu.Lock()
-	defer Store.Mu.Unlock()
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
 
-	Store.VoiceMapping[req.Name] = req.VoiceConfig
+	before := book.snapshot()
+	book.VoiceMapping[req.Name] = req.VoiceConfig
 
 	// Also ensure character is in Detected list just in case
-	Store.DetectedCharacters[req.Name] = true
+	book.DetectedCharacters[req.Name] = true
 
 	// Persist
-	if err := Store.Save(); err != nil {
-		log.Printf("Failed to save store after update: %v", err)
+	if _, err := book.saveEdit(before, "update-character", fmt.Sprintf("Updated character %s", req.Name)); err != nil {
+		book.revert(before)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Character updated"})
 }
+
+// UpdateProfile sets the aliases and attributes of a character
+func UpdateProfile(c *gin.Context) {
+	var req CharacterProfile
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !validGenders[req.Gender] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid gender %q", req.Gender)})
+		return
+	}
+	if !validAges[req.Age] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid age %q", req.Age)})
+		return
+	}
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
+
+	if !book.DetectedCharacters[req.Name] {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Character not found"})
+		return
+	}
+
+	var aliases []string
+	for _, alias := range req.Aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || alias == req.Name {
+			continue
+		}
+		// A name that is a character of its own has segments and maybe a
+		// voice; merging decides what happens to those
+		if book.DetectedCharacters[alias] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is a character; merge it instead", alias)})
+			return
+		}
+		for name, other := range book.Profiles {
+			if name != req.Name && slices.Contains(other.Aliases, alias) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s is already an alias of %s", alias, name)})
+				return
+			}
+		}
+		aliases = appendAlias(aliases, req.Name, alias)
+	}
+	req.Aliases = aliases
+
+	before := book.snapshot()
+	book.setProfile(req.Name, req)
+
+	if _, err := book.saveEdit(before, "update-profile", fmt.Sprintf("Updated profile of %s", req.Name)); err != nil {
+		book.revert(before)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Profile updated", "profile": req})
+}
+
+// setProfile stores a character's profile, dropping it if it holds nothing
+// but the name. Caller must hold s.Mu for writing.
+func (s *ProjectStore) setProfile(name string, p CharacterProfile) {
+	p.Name = name
+	if isBareProfile(p) {
+		delete(s.Profiles, name)
+	} else {
+		s.Profiles[name] = p
+	}
+}
+
+func isBareProfile(p CharacterProfile) bool {
+	return len(p.Aliases) == 0 && p.Gender == "" && p.Age == "" && p.Description == ""
+}
+
+func appendAlias(aliases []string, name, alias string) []string {
+	if alias == name || slices.Contains(aliases, alias) {
+		return aliases
+	}
+	return append(aliases, alias)
+}
+
+// speakerResolver returns a function mapping the speaker names analysis
+// results use to the character they belong to: aliases resolve to their
+// character, and "她(蒙扎)" to 蒙扎 (or whoever 蒙扎 is an alias of).
+// Caller must hold s.Mu.
+func (s *ProjectStore) speakerResolver() func(string) string {
+	aliases := make(map[string]string)
+	for name, p := range s.Profiles {
+		for _, alias := range p.Aliases {
+			aliases[alias] = name
+		}
+	}
+	var resolve func(string) string
+	resolve = func(speaker string) string {
+		speaker = strings.TrimSpace(speaker)
+		if name, ok := aliases[speaker]; ok {
+			return name
+		}
+		if m := inferredSpeaker.FindStringSubmatch(speaker); m != nil {
+			return resolve(m[1])
+		}
+		return speaker
+	}
+	return resolve
+}
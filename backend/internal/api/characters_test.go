@@ -0,0 +1,109 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestCharacterProfiles(t *testing.T) {
+	cfg := config.Get()
+	cfg.MockLLM = true
+	cfg.LLMAPIKey = "dummy_key"
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	mockBookID := "mock_book_profiles"
+	api.Store.Mu.Lock()
+	api.Store.BookID = mockBookID
+	api.Store.Chapters = []epub.Chapter{
+		{ID: "ch1", Title: "第一章", Content: "“你好。”“走吧。”"},
+		{ID: "ch2", Title: "第二章", Content: "This is a mock narration segment. This is a mock dialogue segment. Another mock narration."},
+	}
+	api.Store.Analysis["ch1"] = []llm.AnalysisResult{
+		{Text: "“你好。”", Speaker: "蒙扎", Emotion: "calm"},
+		{Text: "“走吧。”", Speaker: "她(蒙扎)", Emotion: "calm"},
+	}
+	api.Store.DetectedCharacters["蒙扎"] = true
+	api.Store.DetectedCharacters["她(蒙扎)"] = true
+	api.Store.Mu.Unlock()
+	defer api.Books.Delete(mockBookID)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Merging keeps the merged name as an alias
+	if w := do("POST", "/api/characters/merge", gin.H{"target": "蒙扎", "sources": []string{"她(蒙扎)"}}); w.Code != http.StatusOK {
+		t.Fatalf("merge: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	// An alias can't be a character of its own
+	profile := gin.H{"name": "蒙扎", "aliases": []string{"Hero", "她(蒙扎)", "蒙莎·穆卡托"}, "gender": "female", "age": "adult", "description": "雇佣兵"}
+	if w := do("POST", "/api/characters/profile", gin.H{"name": "蒙扎", "aliases": []string{"蒙扎"}, "age": "ancient"}); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid age: status %d, want 400", w.Code)
+	}
+	if w := do("POST", "/api/characters/profile", profile); w.Code != http.StatusOK {
+		t.Fatalf("update profile: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	// New results naming an alias are credited to the character
+	if w := do("POST", "/api/analyze/ch2", nil); w.Code != http.StatusOK {
+		t.Fatalf("analyze: status %d, body %s", w.Code, w.Body.String())
+	}
+	api.Store.Mu.RLock()
+	speaker := api.Store.Analysis["ch2"][1].Speaker
+	hero := api.Store.DetectedCharacters["Hero"]
+	api.Store.Mu.RUnlock()
+	if speaker != "蒙扎" || hero {
+		t.Errorf("alias speaker resolved to %q, Hero detected %v", speaker, hero)
+	}
+
+	w := do("GET", "/api/characters", nil)
+	var resp struct {
+		Characters []api.CharacterDetail `json:"characters"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	var got *api.CharacterDetail
+	for i := range resp.Characters {
+		if resp.Characters[i].Name == "蒙扎" {
+			got = &resp.Characters[i]
+		}
+	}
+	if got == nil {
+		t.Fatalf("characters = %+v", resp.Characters)
+	}
+	if !reflect.DeepEqual(got.Aliases, []string{"Hero", "她(蒙扎)", "蒙莎·穆卡托"}) || got.Gender != "female" || got.Age != "adult" ||
+		got.Description != "雇佣兵" || got.FirstAppearance != "ch1" || got.Lines != 3 {
+		t.Errorf("character = %+v", *got)
+	}
+
+	// Profiles are saved
+	var saved api.ProjectStore
+	if err := saved.Load(mockBookID); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Profiles["蒙扎"].Gender != "female" || len(saved.Profiles["蒙扎"].Aliases) != 3 {
+		t.Errorf("saved profile = %+v", saved.Profiles["蒙扎"])
+	}
+}
@@ -0,0 +1,329 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"tts-book/backend/internal/audio"
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/jobs"
+	"tts-book/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// m4bPath is where the audiobook export of a book is written
+func m4bPath(bookID string) string {
+	return fmt.Sprintf("data/out/%s/audiobook.m4b", bookID)
+}
+
+// ExportM4B queues a job that stitches every generated chapter into one M4B
+// audiobook with chapter markers. Optional ?bitrate= sets the AAC kbps.
+func ExportM4B(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	bookID := book.id()
+
+	if bookID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No book loaded"})
+		return
+	}
+	params := map[string]string{}
+	if raw := c.Query("bitrate"); raw != "" {
+		if _, err := strconv.Atoi(raw); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bitrate"})
+			return
+		}
+		params["bitrate"] = raw
+	}
+
+	job, ok := submitJob(c, jobExportM4B, "export-m4b:"+bookID, bookID, params)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "started", "jobId": job.ID})
+}
+
+// GetM4BStatus reports whether an audiobook export exists for the book
+func GetM4BStatus(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	bookID := book.id()
+
+	info, err := os.Stat(m4bPath(bookID))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"exists": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"exists":    true,
+		"url":       fmt.Sprintf("/output/%s/audiobook.m4b", bookID),
+		"mimeType":  "audio/mp4",
+		"size":      info.Size(),
+		"updatedAt": info.ModTime(),
+	})
+}
+
+// runExportM4B is the job runner behind ExportM4B.
+func runExportM4B(ctx context.Context, job *jobs.Job) error {
+	const channel = "export"
+	fail := func(err error) error {
+		reportProgress(job, channel, 0, fmt.Sprintf("Error: %v", err))
+		return err
+	}
+
+	book, err := jobBook(job)
+	if err != nil {
+		return fail(err)
+	}
+	bookID := job.BookID
+
+	book.Mu.RLock()
+	chapters := book.Chapters
+	bookMeta := book.Metadata
+	coverPath := book.CoverPath
+	book.Mu.RUnlock()
+
+	// Collect generated chapters in book order
+	var inputs []audio.BookChapter
+	var missing []string
+	for _, ch := range chapters {
+		path, _, err := findChapterAudio(bookID, ch.ID)
+		if err != nil {
+			missing = append(missing, ch.ID)
+			continue
+		}
+		title := ch.Title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %s", ch.ID)
+		}
+		inputs = append(inputs, audio.BookChapter{Title: title, Path: path})
+	}
+	if len(inputs) == 0 {
+		return fail(fmt.Errorf("no generated chapters to export"))
+	}
+	if len(missing) > 0 {
+		log.Printf("[Export] Skipping %d chapters without audio: %v", len(missing), missing)
+	}
+
+	meta := audio.BookMetadata{}
+	if m := bookMeta; m != nil {
+		meta.Title, meta.Authors, meta.Narrators, meta.Language = m.Title, m.Authors, m.Narrators, m.Language
+		meta.Date, meta.Description, meta.Series, meta.SeriesIndex = m.Date, m.Description, m.Series, m.SeriesIndex
+	}
+	// Only JPEG and PNG can be embedded in MP4
+	if ext := filepath.Ext(coverPath); ext == ".jpg" || ext == ".png" {
+		meta.CoverPath = coverPath
+	}
+
+	if err := job.Checkpoint(ctx); err != nil {
+		return err
+	}
+
+	cfg := config.Get()
+	kbps, _ := strconv.Atoi(job.Param("bitrate"))
+	reportProgress(job, channel, 1, fmt.Sprintf("Encoding %d chapters...", len(inputs)))
+
+	lastPercent := 1
+	err = audio.ExportM4B(ctx, cfg.EncoderPath, inputs, m4bPath(bookID), meta, kbps, func(done, total time.Duration) {
+		if total <= 0 {
+			return
+		}
+		percent := int(done * 99 / total)
+		if percent > lastPercent && percent < 100 {
+			lastPercent = percent
+			reportProgress(job, channel, percent, fmt.Sprintf("Encoding %d chapters...", len(inputs)))
+		}
+	})
+	if ctx.Err() != nil {
+		reportProgress(job, channel, 0, "Export cancelled")
+		return ctx.Err()
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	msg := fmt.Sprintf("Exported %d chapters", len(inputs))
+	if len(missing) > 0 {
+		msg += fmt.Sprintf(" (%d without audio skipped)", len(missing))
+	}
+	recordArtefact(bookID, storage.Artefact{Kind: storage.ArtefactM4B, Path: m4bPath(bookID)})
+	reportProgress(job, channel, 100, msg)
+	return nil
+}
+
+// epubPath is where the read-aloud EPUB export of a book is written
+func epubPath(bookID string) string {
+	return fmt.Sprintf("data/out/%s/readaloud.epub", bookID)
+}
+
+// ExportEPUB queues a job that repackages the source EPUB with the generated
+// audio as EPUB 3 media overlays, so reading systems highlight the text as it
+// is read.
+func ExportEPUB(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	bookID := book.id()
+
+	if bookID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No book loaded"})
+		return
+	}
+
+	job, ok := submitJob(c, jobExportEPUB, "export-epub:"+bookID, bookID, nil)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "started", "jobId": job.ID})
+}
+
+// GetEPUBStatus reports whether a read-aloud EPUB exists for the book
+func GetEPUBStatus(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	bookID := book.id()
+
+	info, err := os.Stat(epubPath(bookID))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"exists": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"exists":    true,
+		"url":       fmt.Sprintf("/output/%s/readaloud.epub", bookID),
+		"mimeType":  "application/epub+zip",
+		"size":      info.Size(),
+		"updatedAt": info.ModTime(),
+	})
+}
+
+// runExportEPUB is the job runner behind ExportEPUB. Only chapters with both
+// audio and timing data get an overlay; the rest are copied unchanged.
+func runExportEPUB(ctx context.Context, job *jobs.Job) error {
+	const channel = "export"
+	fail := func(err error) error {
+		reportProgress(job, channel, 0, fmt.Sprintf("Error: %v", err))
+		return err
+	}
+
+	book, err := jobBook(job)
+	if err != nil {
+		return fail(err)
+	}
+	bookID := job.BookID
+
+	book.Mu.RLock()
+	bookPath := book.CurrentBookPath
+	chapters := book.Chapters
+	book.Mu.RUnlock()
+
+	if !strings.EqualFold(filepath.Ext(bookPath), ".epub") {
+		return fail(fmt.Errorf("read-aloud export needs an EPUB source"))
+	}
+
+	// Chapters saved before hrefs were recorded lack them, so re-read the
+	// book for those, once
+	reportProgress(job, channel, 0, "Reading book...")
+	var fresh []epub.Chapter
+	hrefs := func(ch epub.Chapter) ([]string, error) {
+		if len(ch.Hrefs) > 0 {
+			return ch.Hrefs, nil
+		}
+		if fresh == nil {
+			reader, err := epub.NewReader(bookPath)
+			if err != nil {
+				return nil, err
+			}
+			if fresh, err = reader.GetChapters(); err != nil {
+				return nil, fmt.Errorf("failed to read book: %v", err)
+			}
+		}
+		return matchingHrefs(ch, fresh)
+	}
+
+	var overlays []epub.OverlayChapter
+	var missing []string
+	for _, ch := range chapters {
+		path, format, err := findChapterAudio(bookID, ch.ID)
+		if err != nil {
+			missing = append(missing, ch.ID)
+			continue
+		}
+		ct, err := readTimings(bookID, ch.ID)
+		if err != nil {
+			missing = append(missing, ch.ID)
+			continue
+		}
+		chHrefs, err := hrefs(ch)
+		if err != nil {
+			return fail(err)
+		}
+		clips := make([]epub.Clip, len(ct.Segments))
+		for i, s := range ct.Segments {
+			clips[i] = epub.Clip{
+				Text:  s.Text,
+				Start: time.Duration(s.StartMs) * time.Millisecond,
+				End:   time.Duration(s.EndMs) * time.Millisecond,
+			}
+		}
+		overlays = append(overlays, epub.OverlayChapter{
+			ID:        ch.ID,
+			Hrefs:     chHrefs,
+			Title:     ch.Title,
+			AudioPath: path,
+			AudioType: format.MIME,
+			Clips:     clips,
+		})
+	}
+	if len(overlays) == 0 {
+		return fail(fmt.Errorf("no generated chapters to export"))
+	}
+	if len(missing) > 0 {
+		log.Printf("[Export] Skipping %d chapters without audio or timing: %v", len(missing), missing)
+	}
+
+	if err := job.Checkpoint(ctx); err != nil {
+		return err
+	}
+
+	reportProgress(job, channel, 10, fmt.Sprintf("Packaging %d chapters...", len(overlays)))
+	if err := epub.WriteMediaOverlays(bookPath, epubPath(bookID), overlays); err != nil {
+		return fail(err)
+	}
+
+	msg := fmt.Sprintf("Exported %d chapters", len(overlays))
+	if len(missing) > 0 {
+		msg += fmt.Sprintf(" (%d without audio skipped)", len(missing))
+	}
+	recordArtefact(bookID, storage.Artefact{Kind: storage.ArtefactEPUB, Path: epubPath(bookID)})
+	reportProgress(job, channel, 100, msg)
+	return nil
+}
+
+// matchingHrefs returns the hrefs of the freshly read chapter that is ch:
+// same ID, title and text. Books imported under an earlier chapter scheme
+// may give the ID to other text, and the overlay would then point at it.
+func matchingHrefs(ch epub.Chapter, fresh []epub.Chapter) ([]string, error) {
+	for _, f := range fresh {
+		if f.ID == ch.ID && f.Title == ch.Title && stripSpace(f.Content) == stripSpace(ch.Content) {
+			return f.Hrefs, nil
+		}
+	}
+	return nil, fmt.Errorf("chapter %s (%s) no longer matches the book file; re-upload the book to record where its text comes from", ch.ID, ch.Title)
+}
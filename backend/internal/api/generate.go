@@ -0,0 +1,614 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"tts-book/backend/internal/audio"
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/jobs"
+	"tts-book/backend/internal/llm"
+	"tts-book/backend/internal/storage"
+	"tts-book/backend/internal/tts"
+	"tts-book/backend/internal/voices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// chapterLocks serialises generation per chapter so two jobs never write
+// into the same data/temp/<bookID>/<chapterID> directory at once.
+var chapterLocks sync.Map // bookID/chapterID -> chan struct{}
+
+// lockChapter waits until no other job is generating the chapter.
+// The returned function releases the lock.
+func lockChapter(ctx context.Context, bookID, chapterID string) (func(), error) {
+	v, _ := chapterLocks.LoadOrStore(bookID+"/"+chapterID, make(chan struct{}, 1))
+	sem := v.(chan struct{})
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// GenerateAudio queues a job that runs TTS generation for one chapter
+func GenerateAudio(c *gin.Context) {
+	chapterID := c.Param("chapterID")
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+
+	book.Mu.RLock()
+	segments, ok := book.Analysis[chapterID]
+	bookID := book.BookID
+	book.Mu.RUnlock()
+
+	if !ok || len(segments) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Chapter not analyzed yet"})
+		return
+	}
+
+	job, ok := submitJob(c, jobGenerateChapter, "generate:"+bookID+":"+chapterID, bookID, map[string]string{"chapterId": chapterID})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "started", "chapterId": chapterID, "jobId": job.ID})
+}
+
+// runGenerateChapter is the job runner behind GenerateAudio.
+func runGenerateChapter(ctx context.Context, job *jobs.Job) error {
+	chapterID := job.Param("chapterId")
+	book, err := jobBook(job)
+	if err != nil {
+		reportProgress(job, chapterID, 0, fmt.Sprintf("Error: %v", err))
+		return err
+	}
+
+	reportProgress(job, chapterID, 0, "Initializing TTS...")
+
+	cfg := config.Get()
+	pool := tts.SharedPool(cfg)
+
+	err = generateChapter(ctx, job, pool, book, chapterID, func(percent int, msg string) {
+		reportProgress(job, chapterID, percent, msg)
+	})
+	if ctx.Err() != nil {
+		reportProgress(job, chapterID, 0, "Generation cancelled")
+		return ctx.Err()
+	}
+	if err != nil {
+		reportProgress(job, chapterID, 0, fmt.Sprintf("Error: %v", err))
+		return err
+	}
+
+	reportProgress(job, chapterID, 100, "Generation Complete!")
+	return nil
+}
+
+// resolveVoice picks the reference voice, emotion and generation settings
+// for a segment from the speaker's voice mapping in the book. Settings are
+// the project's, overridden by the character's, overridden by the segment's.
+func resolveVoice(book *ProjectStore, seg llm.AnalysisResult) (voice, emotion string, params config.GenerationParams) {
+	book.Mu.RLock()
+	mapping, hasMapping := book.VoiceMapping[seg.Speaker]
+	book.Mu.RUnlock()
+
+	params = config.DefaultGenerationParams().Merge(&config.Get().TTSParams).Merge(mapping.Params).Merge(seg.Params)
+	emotion = seg.Emotion
+
+	if hasMapping {
+		voice = voices.Reference(mapping.VoiceID) // Full path for internal use; the prepared clip if there is one
+
+		// Determine emotion based on UseLLMEmotion setting
+		// Default to true if nil
+		useLLM := true
+		if mapping.UseLLMEmotion != nil {
+			useLLM = *mapping.UseLLMEmotion
+		}
+
+		if useLLM {
+			// Use emotion from LLM analysis (seg.Emotion)
+			if seg.Emotion != "" {
+				emotion = seg.Emotion
+			}
+		} else {
+			// Use default emotion from mapping
+			if mapping.Emotion != "" {
+				emotion = mapping.Emotion
+			}
+		}
+	}
+
+	if emotion == "" {
+		emotion = "calm"
+	}
+	return voice, emotion, params
+}
+
+// checkParams validates generation settings a character or segment
+// overrides. Overrides that set nothing are dropped.
+func checkParams(params *config.GenerationParams) (*config.GenerationParams, error) {
+	if params == nil || params.IsZero() {
+		return nil, nil
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// segmentTask is a segment that has something to speak, with its cache key.
+type segmentTask struct {
+	index int
+	req   tts.Request
+	key   string
+}
+
+// planSegments resolves voice, emotion and text for every segment and
+// computes its cache key. Segments with nothing to speak are dropped.
+func planSegments(pool *tts.Pool, book *ProjectStore, segments []llm.AnalysisResult) ([]segmentTask, error) {
+	var tasks []segmentTask
+	for i, seg := range segments {
+		textToSpeak := strings.TrimSpace(seg.Typesetting)
+		if textToSpeak == "" {
+			textToSpeak = strings.TrimSpace(seg.Text)
+		}
+		if textToSpeak == "" {
+			log.Printf("[TTS] Skipping empty segment %d", i)
+			continue
+		}
+
+		voice, emotion, params := resolveVoice(book, seg)
+		req := tts.Request{Text: textToSpeak, Voice: voice, Emotion: emotion, Params: params}
+		key, err := pool.CacheKey(req)
+		if err != nil {
+			return nil, fmt.Errorf("segment %d: %v", i, err)
+		}
+		tasks = append(tasks, segmentTask{index: i, req: req, key: key})
+	}
+	return tasks, nil
+}
+
+// chapterManifest is written next to a chapter's audio and records the
+// segment cache keys it was merged from, so batch generation can tell
+// whether the output is still up to date.
+type chapterManifest struct {
+	Keys     []string `json:"keys"`
+	Encoding string   `json:"encoding,omitempty"` // Format and bitrate, see audio.Format.Label. Empty means WAV
+}
+
+func manifestPath(bookID, chapterID string) string {
+	return fmt.Sprintf("data/out/%s/%s.manifest.json", bookID, chapterID)
+}
+
+func writeManifest(bookID, chapterID string, m chapterManifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(manifestPath(bookID, chapterID), data, 0644)
+}
+
+// chapterUpToDate reports whether a chapter's existing output was merged from
+// exactly the given segments and encoded with the given settings. Output
+// without a manifest predates the segment cache or lost its manifest, so
+// nothing says what it was made from; it is stale unless keepLegacy is set.
+// Output without timing data is re-merged from the cache to produce it.
+func chapterUpToDate(bookID, chapterID string, tasks []segmentTask, encoding string, keepLegacy bool) bool {
+	data, err := os.ReadFile(manifestPath(bookID, chapterID))
+	if os.IsNotExist(err) {
+		return keepLegacy
+	}
+	var m chapterManifest
+	if err != nil || json.Unmarshal(data, &m) != nil || len(m.Keys) != len(tasks) {
+		return false
+	}
+	if m.Encoding == "" {
+		m.Encoding = "wav"
+	}
+	if m.Encoding != encoding {
+		return false
+	}
+	if _, err := os.Stat(timingPath(bookID, chapterID)); err != nil {
+		return false
+	}
+	for i, t := range tasks {
+		if m.Keys[i] != t.key {
+			return false
+		}
+	}
+	return true
+}
+
+// generateChapter synthesises the analysed segments of a chapter and merges
+// them into data/out/<bookID>/<chapterID>.wav, encoded afterwards when
+// cfg.OutputFormat is a compressed format. Segment audio is cached under
+// data/cache/<bookID> by content key, so only segments whose text, voice,
+// emotion or TTS parameters changed are sent to the TTS server. Uncached
+// segments are synthesised by as many workers as the pool has slots; the
+// pool is shared with other jobs, so they may wait for a turn. Segments
+// longer than ttsMaxChars are split for TTS and stitched back together.
+// progress receives the percentage of segments done and a status line.
+func generateChapter(ctx context.Context, job *jobs.Job, pool *tts.Pool, book *ProjectStore, chapterID string, progress func(percent int, msg string)) error {
+	book.Mu.RLock()
+	bookID := book.BookID
+	book.Mu.RUnlock()
+
+	unlock, err := lockChapter(ctx, bookID, chapterID)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	book.Mu.RLock()
+	segments := book.Analysis[chapterID]
+	book.Mu.RUnlock()
+
+	if len(segments) == 0 {
+		return fmt.Errorf("chapter %s not analyzed yet", chapterID)
+	}
+
+	tasks, err := planSegments(pool, book, segments)
+	if err != nil {
+		return err
+	}
+	if len(tasks) == 0 {
+		return fmt.Errorf("chapter %s has no text to speak", chapterID)
+	}
+
+	cfg := config.Get()
+	cache := tts.NewSegmentCache(fmt.Sprintf("data/cache/%s", bookID))
+
+	// Fail before synthesis rather than after if the output can't be encoded
+	format, err := audio.LookupFormat(cfg.OutputFormat)
+	if err != nil {
+		return err
+	}
+	if format.NeedsEncoder() {
+		if err := audio.CheckEncoder(cfg.EncoderPath); err != nil {
+			return err
+		}
+	}
+
+	// Temp dir holds the chunks of split segments while they are generated
+	tempDir := fmt.Sprintf("data/temp/%s/%s", bookID, chapterID)
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return fmt.Errorf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	outDir := fmt.Sprintf("data/out/%s", bookID)
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output dir: %v", err)
+	}
+
+	// Cached segments are used as-is; the rest are queued for the workers.
+	// filePaths is indexed by task so merge order matches segment order
+	// regardless of which worker finishes first.
+	filePaths := make([]string, len(tasks))
+	keys := make([]string, len(tasks))
+	var pending []int
+	for i, t := range tasks {
+		keys[i] = t.key
+		if cache.Has(t.key) {
+			filePaths[i] = cache.Path(t.key)
+		} else {
+			pending = append(pending, i)
+		}
+	}
+	cached := len(tasks) - len(pending)
+
+	workers := pool.Capacity()
+	if workers > len(pending) {
+		workers = len(pending)
+	}
+
+	// Progress counts finished segments (cached ones included), so it stays
+	// monotonic however the workers interleave.
+	var progressMu sync.Mutex
+	done := cached
+	report := func(t segmentTask, finished bool, status string) {
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		if finished {
+			done++
+		}
+		runes := []rune(segments[t.index].Text)
+		display := string(runes)
+		if len(runes) > 10 {
+			display = string(runes[:10])
+		}
+		percent := done * 95 / len(tasks)
+		progress(percent, fmt.Sprintf("%s (%d/%d, segment %d): %s...", status, done, len(tasks), t.index+1, display))
+	}
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan int)
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				t := tasks[i]
+				report(t, false, "Generating")
+
+				segmentAudioData, err := synthesizeSegment(workCtx, pool, tempDir, t.index, t.req, ttsMaxChars, func(part, parts int) {
+					report(t, false, fmt.Sprintf("Generating part %d/%d", part+1, parts))
+				})
+				if err != nil {
+					fail(fmt.Errorf("segment %d: %v", t.index, err))
+					return
+				}
+
+				filePath, err := cache.Put(t.key, segmentAudioData)
+				if err != nil {
+					fail(fmt.Errorf("failed to cache segment %d: %v", t.index, err))
+					return
+				}
+				filePaths[i] = filePath
+				report(t, true, "Generated")
+			}
+		}()
+	}
+
+	// Feed the queue, pausing between segments when the job is paused
+	for _, i := range pending {
+		if err := job.Checkpoint(workCtx); err != nil {
+			fail(err)
+			break
+		}
+		select {
+		case queue <- i:
+		case <-workCtx.Done():
+		}
+		if workCtx.Err() != nil {
+			break
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if firstErr != nil {
+		return firstErr
+	}
+
+	log.Printf("[TTS] Chapter %s: %d/%d segments reused from cache", chapterID, cached, len(tasks))
+
+	// Merge
+	progress(95, "Merging Audio Files...")
+	outPath := chapterAudioPath(bookID, chapterID, format)
+	mergePath := outPath
+	if format.NeedsEncoder() {
+		mergePath = fmt.Sprintf("%s/merged.wav", tempDir)
+	}
+
+	timings, err := audio.MergeAndNormalize(filePaths, mergePath, cfg.MergeSilence, cfg.NormalizeAudio)
+	if err != nil {
+		return fmt.Errorf("merge failed: %v", err)
+	}
+	if format.NeedsEncoder() {
+		progress(97, fmt.Sprintf("Encoding %s...", strings.ToUpper(format.Name)))
+		if err := audio.Encode(ctx, cfg.EncoderPath, mergePath, outPath, format, cfg.OutputBitrate); err != nil {
+			return err
+		}
+	}
+	removeStaleAudio(bookID, chapterID, format)
+
+	if err := writeTimings(bookID, chapterID, segments, tasks, timings); err != nil {
+		log.Printf("[TTS] Warning: Failed to write timings for chapter %s: %v", chapterID, err)
+	} else {
+		recordArtefact(bookID, storage.Artefact{ChapterID: chapterID, Kind: storage.ArtefactTimings, Path: timingPath(bookID, chapterID)})
+	}
+
+	manifest := chapterManifest{Keys: keys, Encoding: format.Label(cfg.OutputBitrate)}
+	if err := writeManifest(bookID, chapterID, manifest); err != nil {
+		log.Printf("[TTS] Warning: Failed to write manifest for chapter %s: %v", chapterID, err)
+	}
+	recordArtefact(bookID, storage.Artefact{ChapterID: chapterID, Kind: storage.ArtefactAudio, Path: outPath, Info: manifest.Encoding})
+
+	log.Printf("[TTS] Chapter %s completed successfully", chapterID)
+	return nil
+}
+
+// ttsMaxChars is the longest text sent to the TTS engine at once. It is the
+// same however a chapter's generation is started, as cached segment audio
+// is reused across both.
+const ttsMaxChars = 100
+
+// synthesizeSegment generates audio for a single segment. Text longer than
+// maxChars is split into chunks which are generated separately and merged
+// without silence, since splits may fall on commas.
+func synthesizeSegment(ctx context.Context, pool *tts.Pool, tempDir string, index int, req tts.Request, maxChars int, onPart func(part, parts int)) ([]byte, error) {
+	chunks := SplitTextForTTS(req.Text, maxChars)
+
+	if len(chunks) == 1 {
+		log.Printf("[TTS] Generating segment %d with text: %s", index, req.Text)
+		return pool.Generate(ctx, req)
+	}
+
+	log.Printf("[TTS] Segment %d is long (%d chars), split into %d chunks", index, len([]rune(req.Text)), len(chunks))
+
+	var chunkFiles []string
+	defer func() {
+		for _, f := range chunkFiles {
+			os.Remove(f)
+		}
+	}()
+
+	for j, chunk := range chunks {
+		onPart(j, len(chunks))
+		log.Printf("[TTS] Generating segment %d chunk %d: %s", index, j, chunk)
+
+		part := req
+		part.Text = chunk
+		chunkAudio, err := pool.Generate(ctx, part)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d: %v", j, err)
+		}
+
+		chunkPath := fmt.Sprintf("%s/%d_part_%d.wav", tempDir, index, j)
+		if err := os.WriteFile(chunkPath, chunkAudio, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write chunk file: %v", err)
+		}
+		chunkFiles = append(chunkFiles, chunkPath)
+	}
+
+	mergedPath := fmt.Sprintf("%s/%d_merged.wav", tempDir, index)
+	defer os.Remove(mergedPath)
+	if _, err := audio.MergeAndNormalize(chunkFiles, mergedPath, 0, false); err != nil {
+		return nil, fmt.Errorf("failed to merge chunks: %v", err)
+	}
+
+	return os.ReadFile(mergedPath)
+}
+
+// GenerateAllAudio queues a job that generates audio for all chapters sequentially,
+// skipping chapters whose audio is up to date with their analysis and voices.
+// With keepLegacy=true, audio generated before manifests existed is kept too.
+func GenerateAllAudio(c *gin.Context) {
+	keepLegacy := c.Query("keepLegacy") == "true"
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+
+	// Get all chapters
+	book.Mu.RLock()
+	chapters := book.Chapters
+	bookID := book.BookID
+	book.Mu.RUnlock()
+	if len(chapters) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No book loaded"})
+		return
+	}
+
+	job, ok := submitJob(c, jobGenerateAll, "generate-all:"+bookID, bookID, map[string]string{"keepLegacy": fmt.Sprint(keepLegacy)})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "started", "totalChapters": len(chapters), "jobId": job.ID})
+}
+
+// runGenerateAll is the job runner behind GenerateAllAudio.
+func runGenerateAll(ctx context.Context, job *jobs.Job) error {
+	book, err := jobBook(job)
+	if err != nil {
+		reportProgress(job, "batch-generate", 0, fmt.Sprintf("Error: %v", err))
+		return err
+	}
+	book.Mu.RLock()
+	chapters := book.Chapters
+	book.Mu.RUnlock()
+	bookID := job.BookID
+	keepLegacy := job.Param("keepLegacy") == "true"
+
+	total := len(chapters)
+	successCount := 0
+	skippedCount := 0
+	failedChapters := []string{}
+
+	cfg := config.Get()
+	pool := tts.SharedPool(cfg)
+	format, err := audio.LookupFormat(cfg.OutputFormat)
+	if err != nil {
+		reportProgress(job, "batch-generate", 0, fmt.Sprintf("Error: %v", err))
+		return err
+	}
+	encoding := format.Label(cfg.OutputBitrate)
+
+	for i, chapter := range chapters {
+		if err := job.Checkpoint(ctx); err != nil {
+			reportProgress(job, "batch-generate", 0, "Generation cancelled")
+			return err
+		}
+
+		chapterID := chapter.ID
+		chapterTitle := chapter.Title
+		if chapterTitle == "" {
+			chapterTitle = fmt.Sprintf("Chapter %s", chapterID)
+		}
+
+		// Broadcast overall progress
+		overallPercent := int((float64(i) / float64(total)) * 100)
+		reportProgress(job, "batch-generate", overallPercent, fmt.Sprintf("Processing %s (%d/%d)...", chapterTitle, i+1, total))
+
+		// Check if chapter has analysis data
+		book.Mu.RLock()
+		segments, hasAnalysis := book.Analysis[chapterID]
+		book.Mu.RUnlock()
+
+		if (!hasAnalysis || len(segments) == 0) && chapter.Matter != "" {
+			log.Printf("[GenerateAll] Skipping unanalyzed %s matter chapter %s", chapter.Matter, chapterID)
+			skippedCount++
+			continue
+		}
+		if !hasAnalysis || len(segments) == 0 {
+			log.Printf("[GenerateAll] Chapter %s has no analysis data, skipping", chapterID)
+			failedChapters = append(failedChapters, chapterTitle+" (no analysis)")
+			continue
+		}
+
+		// Skip if audio exists and every segment is unchanged since it was merged.
+		// Otherwise regenerate; unchanged segments come from the segment cache.
+		audioPath := chapterAudioPath(bookID, chapterID, format)
+		if _, err := os.Stat(audioPath); err == nil {
+			tasks, err := planSegments(pool, book, segments)
+			if err == nil && chapterUpToDate(bookID, chapterID, tasks, encoding, keepLegacy) {
+				log.Printf("[GenerateAll] Audio for chapter %s is up to date, skipping", chapterID)
+				skippedCount++
+				continue
+			}
+		}
+
+		err := generateChapter(ctx, job, pool, book, chapterID, func(percent int, msg string) {
+			reportProgress(job, "batch-generate", overallPercent+percent/total, fmt.Sprintf("%s (%d/%d) %s", chapterTitle, i+1, total, msg))
+		})
+		if ctx.Err() != nil {
+			reportProgress(job, "batch-generate", 0, "Generation cancelled")
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("[GenerateAll] Chapter %s failed: %v", chapterID, err)
+			failedChapters = append(failedChapters, chapterTitle)
+			continue
+		}
+
+		successCount++
+	}
+
+	// Broadcast completion
+	if len(failedChapters) > 0 {
+		reportProgress(job, "batch-generate", 100, fmt.Sprintf("Done. %d generated, %d skipped, %d failed: %v", successCount, skippedCount, len(failedChapters), failedChapters))
+	} else {
+		reportProgress(job, "batch-generate", 100, fmt.Sprintf("All done! %d generated, %d skipped.", successCount, skippedCount))
+	}
+	return nil
+}
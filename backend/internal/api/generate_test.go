@@ -0,0 +1,115 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/jobs"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateAudio_MockTTS(t *testing.T) {
+	// Audio, cache and job state are written under data/ in the working dir
+	t.Chdir(t.TempDir())
+
+	cfg := config.Get()
+	oldMockTTS := cfg.MockTTS
+	cfg.MockTTS = true
+	t.Cleanup(func() { cfg.MockTTS = oldMockTTS })
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	chapterID := "ch_gen"
+	mockBookID := "mock_book_generate"
+
+	current := api.Store
+	book, err := api.Books.Create(mockBookID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		api.Books.Delete(mockBookID)
+		api.Store = current
+	})
+
+	book.Mu.Lock()
+	book.Chapters = []epub.Chapter{{ID: chapterID, Title: "Gen", Content: "他笑了。你好！"}}
+	book.Analysis[chapterID] = []llm.AnalysisResult{
+		{Text: "他笑了。", Speaker: "Narrator", Emotion: "calm"},
+		{Text: "你好！", Speaker: "Alice", Emotion: "happy"},
+	}
+	book.Mu.Unlock()
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/api/generate/"+chapterID, nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("generate: status %d, body %s", w.Code, w.Body.String())
+	}
+	var started struct {
+		JobID string `json:"jobId"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &started)
+
+	// Wait for the job to finish
+	var job jobs.Job
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/jobs/"+started.JobID, nil)
+		r.ServeHTTP(w, req)
+		json.Unmarshal(w.Body.Bytes(), &job)
+		if job.State.Finished() {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if job.State != jobs.StateDone {
+		t.Fatalf("job ended in state %s: %s", job.State, job.Error)
+	}
+
+	out, err := os.ReadFile("data/out/" + mockBookID + "/" + chapterID + ".wav")
+	if err != nil {
+		t.Fatalf("merged chapter audio missing: %v", err)
+	}
+	if len(out) < 44 || string(out[:4]) != "RIFF" {
+		t.Errorf("merged output is not a WAV file (%d bytes)", len(out))
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/audio-status/"+chapterID, nil)
+	r.ServeHTTP(w, req)
+	var status struct {
+		Exists   bool   `json:"exists"`
+		URL      string `json:"url"`
+		MIMEType string `json:"mimeType"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &status)
+	if !status.Exists || status.URL != "/output/"+mockBookID+"/"+chapterID+".wav" || status.MIMEType != "audio/wav" {
+		t.Errorf("unexpected audio status %+v", status)
+	}
+
+	// Subtitles line up with the merged segments
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/subtitles/"+chapterID+"?format=vtt", nil)
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("subtitles: status %d, body %s", w.Code, w.Body.String())
+	}
+	if body := w.Body.String(); !strings.HasPrefix(body, "WEBVTT") ||
+		!strings.Contains(body, "00:00:00.000 --> ") || !strings.Contains(body, "<v Alice>你好！") {
+		t.Errorf("unexpected subtitles:\n%s", body)
+	}
+}
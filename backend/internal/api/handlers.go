@@ -1,59 +1,114 @@
 package api
 
 import (
+	"context"
 	"net/http"
+	"time"
+	"tts-book/backend/internal/audio"
 	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/importer"
 	"tts-book/backend/internal/llm"
+	"tts-book/backend/internal/tts"
 
 	"github.com/gin-gonic/gin"
 )
 
-func GetConfig(cfg *config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		c.JSON(http.StatusOK, cfg)
-	}
+func GetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Get())
 }
 
-func UpdateConfig(cfg *config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var newCfg config.Config
-		if err := c.ShouldBindJSON(&newCfg); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		// Update values
-		cfg.LLMAPIKey = newCfg.LLMAPIKey
-		cfg.LLMBaseURL = newCfg.LLMBaseURL
-		cfg.LLMModel = newCfg.LLMModel
-		cfg.IndexTTSUrl = newCfg.IndexTTSUrl
-		cfg.VoiceDir = newCfg.VoiceDir
-		cfg.LLMChunkSize = newCfg.LLMChunkSize
-		cfg.LLMMinInterval = newCfg.LLMMinInterval
-		cfg.MockLLM = newCfg.MockLLM
-		cfg.LLMProvider = newCfg.LLMProvider
-		cfg.MergeSilence = newCfg.MergeSilence
-
-		// Save
-		if err := cfg.Save(); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save config"})
-			return
-		}
-
-		c.JSON(http.StatusOK, cfg)
+// UpdateConfig saves new settings. They replace the configuration whole, so
+// jobs that are running finish with the settings they started with.
+func UpdateConfig(c *gin.Context) {
+	var newCfg config.Config
+	if err := c.ShouldBindJSON(&newCfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := tts.CheckConfig(&newCfg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := audio.LookupFormat(newCfg.OutputFormat); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	if _, err := importer.CompilePatterns(newCfg.ChapterPatterns); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := newCfg.TTSParams.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Update values
+	cfg := new(config.Config)
+	*cfg = *config.Get()
+	cfg.LLMAPIKey = newCfg.LLMAPIKey
+	cfg.LLMBaseURL = newCfg.LLMBaseURL
+	cfg.LLMModel = newCfg.LLMModel
+	cfg.IndexTTSUrl = newCfg.IndexTTSUrl
+	cfg.VoiceDir = newCfg.VoiceDir
+	cfg.VoiceDirs = newCfg.VoiceDirs
+	cfg.VoiceSampleRate = newCfg.VoiceSampleRate
+	cfg.LLMChunkSize = newCfg.LLMChunkSize
+	cfg.LLMMinInterval = newCfg.LLMMinInterval
+	cfg.MockLLM = newCfg.MockLLM
+	cfg.MockTTS = newCfg.MockTTS
+	cfg.LLMProvider = newCfg.LLMProvider
+	cfg.MergeSilence = newCfg.MergeSilence
+	cfg.OutputFormat = newCfg.OutputFormat
+	cfg.OutputBitrate = newCfg.OutputBitrate
+	cfg.EncoderPath = newCfg.EncoderPath
+	cfg.TTSConcurrency = newCfg.TTSConcurrency
+	cfg.TTSEndpoints = newCfg.TTSEndpoints
+	cfg.TTSUploadTTL = newCfg.TTSUploadTTL
+	cfg.TTSParams = newCfg.TTSParams
+	cfg.TTSEngine = newCfg.TTSEngine
+	cfg.TTSOpenAI = newCfg.TTSOpenAI
+	cfg.TTSHTTP = newCfg.TTSHTTP
+	cfg.ChapterPatterns = newCfg.ChapterPatterns
+	cfg.FootnotesAtEnd = newCfg.FootnotesAtEnd
+
+	// Save
+	if err := cfg.Save(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save config"})
+		return
+	}
+	config.Set(cfg)
+
+	c.JSON(http.StatusOK, cfg)
 }
 
-func ListLLMModels(cfg *config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		// Create a temporary client with current config to fetch models
-		// For now, use stored config.
-		client := llm.NewClient(cfg)
-		models, err := client.ListModels()
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list models: " + err.Error()})
-			return
-		}
-		c.JSON(http.StatusOK, gin.H{"models": models})
+func ListLLMModels(c *gin.Context) {
+	// Create a temporary client with current config to fetch models
+	// For now, use stored config.
+	client := llm.NewClient(config.Get())
+	models, err := client.ListModels()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list models: " + err.Error()})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+// GetTTSEngine reports the configured engine's capabilities and the health
+// of each of its endpoints.
+func GetTTSEngine(c *gin.Context) {
+	pool := tts.SharedPool(config.Get())
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	c.JSON(http.StatusOK, gin.H{
+		"capabilities": pool.Capabilities(),
+		"endpoints":    pool.Health(ctx),
+	})
+}
+
+// GetUploadCacheStats reports how often reference voices were reused on
+// Index-TTS servers instead of being uploaded again
+func GetUploadCacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, tts.UploadCacheStats())
 }
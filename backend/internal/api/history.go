@@ -0,0 +1,457 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+
+	"tts-book/backend/internal/llm"
+	"tts-book/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errNothingToUndo = errors.New("nothing to undo")
+	errNothingToRedo = errors.New("nothing to redo")
+)
+
+// snapshot copies the parts of the project edits change: segments,
+// characters, voices and profiles. Caller must hold s.Mu.
+func (s *ProjectStore) snapshot() *storage.Snapshot {
+	snap := &storage.Snapshot{
+		Segments:   make(map[string][]llm.AnalysisResult, len(s.Analysis)),
+		Characters: make(map[string]bool, len(s.DetectedCharacters)),
+		Voices:     make(map[string]*VoiceConfig, len(s.VoiceMapping)),
+		Profiles:   make(map[string]*CharacterProfile, len(s.Profiles)),
+	}
+	for chapterID, segments := range s.Analysis {
+		snap.Segments[chapterID] = slices.Clone(segments)
+	}
+	for name, ok := range s.DetectedCharacters {
+		if ok {
+			snap.Characters[name] = true
+		}
+	}
+	for name, v := range s.VoiceMapping {
+		snap.Voices[name] = &v
+	}
+	for name, p := range s.Profiles {
+		p.Aliases = slices.Clone(p.Aliases)
+		snap.Profiles[name] = &p
+	}
+	return snap
+}
+
+// diffSnapshots reduces two full snapshots to the parts that differ
+func diffSnapshots(before, after *storage.Snapshot) (b, a *storage.Snapshot) {
+	b, a = &storage.Snapshot{}, &storage.Snapshot{}
+
+	for _, chapterID := range unionKeys(before.Segments, after.Segments) {
+		old, cur := before.Segments[chapterID], after.Segments[chapterID]
+		if reflect.DeepEqual(old, cur) {
+			continue
+		}
+		setKey(&b.Segments, chapterID, old)
+		setKey(&a.Segments, chapterID, cur)
+	}
+	for _, name := range unionKeys(before.Characters, after.Characters) {
+		if before.Characters[name] != after.Characters[name] {
+			setKey(&b.Characters, name, before.Characters[name])
+			setKey(&a.Characters, name, after.Characters[name])
+		}
+	}
+	for _, name := range unionKeys(before.Voices, after.Voices) {
+		if !reflect.DeepEqual(before.Voices[name], after.Voices[name]) {
+			setKey(&b.Voices, name, before.Voices[name])
+			setKey(&a.Voices, name, after.Voices[name])
+		}
+	}
+	for _, name := range unionKeys(before.Profiles, after.Profiles) {
+		if !reflect.DeepEqual(before.Profiles[name], after.Profiles[name]) {
+			setKey(&b.Profiles, name, before.Profiles[name])
+			setKey(&a.Profiles, name, after.Profiles[name])
+		}
+	}
+	return b, a
+}
+
+func unionKeys[V any](x, y map[string]V) []string {
+	keys := make([]string, 0, len(x)+len(y))
+	for k := range x {
+		keys = append(keys, k)
+	}
+	for k := range y {
+		if _, ok := x[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func setKey[V any](m *map[string]V, k string, v V) {
+	if *m == nil {
+		*m = make(map[string]V)
+	}
+	(*m)[k] = v
+}
+
+// applySnapshot writes the parts held in a snapshot back into the project.
+// Caller must hold s.Mu for writing.
+func (s *ProjectStore) applySnapshot(snap *storage.Snapshot) {
+	for chapterID, segments := range snap.Segments {
+		if segments == nil {
+			delete(s.Analysis, chapterID)
+		} else {
+			s.Analysis[chapterID] = slices.Clone(segments)
+		}
+	}
+	for name, ok := range snap.Characters {
+		if ok {
+			s.DetectedCharacters[name] = true
+		} else {
+			delete(s.DetectedCharacters, name)
+		}
+	}
+	for name, v := range snap.Voices {
+		if v == nil {
+			delete(s.VoiceMapping, name)
+		} else {
+			s.VoiceMapping[name] = *v
+		}
+	}
+	for name, p := range snap.Profiles {
+		if p == nil {
+			delete(s.Profiles, name)
+		} else {
+			profile := *p
+			profile.Aliases = slices.Clone(p.Aliases)
+			s.Profiles[name] = profile
+		}
+	}
+}
+
+// revert puts the project back to a full snapshot after an edit failed to
+// save. Caller must hold s.Mu for writing.
+func (s *ProjectStore) revert(snap *storage.Snapshot) {
+	s.Analysis = make(map[string][]llm.AnalysisResult, len(snap.Segments))
+	s.DetectedCharacters = make(map[string]bool, len(snap.Characters))
+	s.VoiceMapping = make(map[string]VoiceConfig, len(snap.Voices))
+	s.Profiles = make(map[string]CharacterProfile, len(snap.Profiles))
+	s.applySnapshot(snap)
+}
+
+// putSnapshot saves the parts of the project a snapshot touches.
+// Caller must hold s.Mu.
+func (s *ProjectStore) putSnapshot(tx storage.Tx, snap *storage.Snapshot) error {
+	for chapterID := range snap.Segments {
+		if err := tx.PutSegments(chapterID, s.Analysis[chapterID]); err != nil {
+			return err
+		}
+	}
+	if len(snap.Characters) > 0 || len(snap.Voices) > 0 || len(snap.Profiles) > 0 {
+		return s.putCharacters(tx)
+	}
+	return nil
+}
+
+// saveEdit saves what changed since before was taken and records it as a
+// revision that can be undone. Edits that change nothing aren't recorded.
+// Caller must hold s.Mu for writing.
+func (s *ProjectStore) saveEdit(before *storage.Snapshot, action, summary string) (*storage.Revision, error) {
+	b, a := diffSnapshots(before, s.snapshot())
+	if len(b.Segments) == 0 && len(b.Characters) == 0 && len(b.Voices) == 0 && len(b.Profiles) == 0 {
+		return nil, nil
+	}
+
+	rev := &storage.Revision{
+		Action:   action,
+		Summary:  summary,
+		Chapters: unionKeys(a.Segments, nil),
+		Before:   b,
+		After:    a,
+	}
+	err := s.commit(func(tx storage.Tx) error {
+		if err := s.putSnapshot(tx, a); err != nil {
+			return err
+		}
+		return tx.AddRevision(rev)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rev, nil
+}
+
+// undo reverts the most recent revision that isn't undone.
+// Caller must hold s.Mu for writing.
+func (s *ProjectStore) undo() (*storage.Revision, error) {
+	return s.step(true)
+}
+
+// redo re-applies the earliest undone revision.
+// Caller must hold s.Mu for writing.
+func (s *ProjectStore) redo() (*storage.Revision, error) {
+	return s.step(false)
+}
+
+func (s *ProjectStore) step(undo bool) (*storage.Revision, error) {
+	db, err := projects()
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := db.Revisions(s.BookID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Undone revisions are always the newest ones
+	var target *storage.Revision
+	for i := range revisions {
+		r := &revisions[i]
+		if undo && !r.Undone {
+			target = r
+		}
+		if !undo && r.Undone {
+			target = r
+			break
+		}
+	}
+	if target == nil {
+		if undo {
+			return nil, errNothingToUndo
+		}
+		return nil, errNothingToRedo
+	}
+
+	rev, err := db.Revision(s.BookID, target.ID)
+	if err != nil {
+		return nil, err
+	}
+	from, to := rev.After, rev.Before
+	if !undo {
+		from, to = to, from
+	}
+
+	s.applySnapshot(to)
+	err = s.commit(func(tx storage.Tx) error {
+		if err := s.putSnapshot(tx, to); err != nil {
+			return err
+		}
+		return tx.SetUndone(rev.ID, undo)
+	})
+	if err != nil {
+		s.applySnapshot(from)
+		return nil, err
+	}
+	rev.Undone = undo
+	return rev, nil
+}
+
+// chapterAt returns a chapter's segments as they were right after a
+// revision; nil if the chapter wasn't analyzed then
+func chapterAt(bookID, chapterID string, revisionID int64, current []llm.AnalysisResult) ([]llm.AnalysisResult, error) {
+	db, err := projects()
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := db.Revisions(bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	// History is linear, so the chapter is as the last revision up to the
+	// given one left it, or as the first later revision found it. Undone
+	// revisions no longer took place.
+	var after, before *storage.Revision
+	found := false
+	for i := range revisions {
+		r := &revisions[i]
+		if r.ID == revisionID {
+			found = true
+		}
+		if r.Undone || !slices.Contains(r.Chapters, chapterID) {
+			continue
+		}
+		if r.ID <= revisionID {
+			after = r
+		} else if before == nil {
+			before = r
+		}
+	}
+	if !found {
+		return nil, ErrRevisionNotFound
+	}
+
+	switch {
+	case after != nil:
+		rev, err := db.Revision(bookID, after.ID)
+		if err != nil {
+			return nil, err
+		}
+		return rev.After.Segments[chapterID], nil
+	case before != nil:
+		rev, err := db.Revision(bookID, before.ID)
+		if err != nil {
+			return nil, err
+		}
+		return rev.Before.Segments[chapterID], nil
+	default:
+		return current, nil
+	}
+}
+
+var ErrRevisionNotFound = errors.New("revision not found")
+
+// GetHistory lists the book's revisions, newest first. Snapshots are left
+// out; GetRevision returns them.
+func GetHistory(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	db, err := projects()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	revisions, err := db.Revisions(book.id())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	canUndo, canRedo := false, false
+	for _, r := range revisions {
+		canUndo = canUndo || !r.Undone
+		canRedo = canRedo || r.Undone
+	}
+	slices.Reverse(revisions)
+	c.JSON(http.StatusOK, gin.H{
+		"revisions": revisions,
+		"canUndo":   canUndo,
+		"canRedo":   canRedo,
+	})
+}
+
+// GetRevision returns one revision with the parts it changed, before and after
+func GetRevision(c *gin.Context) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("revisionID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision ID"})
+		return
+	}
+	db, err := projects()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	rev, err := db.Revision(book.id(), id)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, rev)
+}
+
+// Undo reverts the book's most recent edit
+func Undo(c *gin.Context) {
+	stepHistory(c, true)
+}
+
+// Redo re-applies the book's most recently undone edit
+func Redo(c *gin.Context) {
+	stepHistory(c, false)
+}
+
+func stepHistory(c *gin.Context, undo bool) {
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
+
+	var rev *storage.Revision
+	var err error
+	if undo {
+		rev, err = book.undo()
+	} else {
+		rev, err = book.redo()
+	}
+	if errors.Is(err, errNothingToUndo) || errors.Is(err, errNothingToRedo) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revision": rev})
+}
+
+// RestoreChapter sets a chapter's analysis back to how it was right after
+// an earlier revision. The restore is itself a revision, so it can be undone.
+func RestoreChapter(c *gin.Context) {
+	chapterID := c.Param("chapterID")
+	id, err := strconv.ParseInt(c.Param("revisionID"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid revision ID"})
+		return
+	}
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
+
+	segments, err := chapterAt(book.BookID, chapterID, id, book.Analysis[chapterID])
+	if errors.Is(err, ErrRevisionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Revision not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	before := book.snapshot()
+	if segments == nil {
+		delete(book.Analysis, chapterID)
+	} else {
+		book.Analysis[chapterID] = slices.Clone(segments)
+	}
+	for _, seg := range segments {
+		if seg.Speaker != "" {
+			book.DetectedCharacters[seg.Speaker] = true
+		}
+	}
+
+	rev, err := book.saveEdit(before, "restore-chapter", fmt.Sprintf("Restored chapter %s to revision %d", chapterID, id))
+	if err != nil {
+		book.revert(before)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"chapterId": chapterID,
+		"results":   segments,
+		"revision":  rev,
+	})
+}
@@ -0,0 +1,140 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+	"tts-book/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestHistory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	chapterID := "ch_history"
+	mockBookID := "mock_book_history"
+
+	api.Store.Mu.Lock()
+	api.Store.BookID = mockBookID
+	api.Store.Chapters = []epub.Chapter{{ID: chapterID, Title: "History", Content: "他笑了。你好！"}}
+	api.Store.Analysis[chapterID] = []llm.AnalysisResult{
+		{Text: "他笑了。", Speaker: "Narrator", Emotion: "calm"},
+		{Text: "你好！", Speaker: "Alise", Emotion: "happy"},
+	}
+	api.Store.DetectedCharacters["Narrator"] = true
+	api.Store.DetectedCharacters["Alise"] = true
+	api.Store.DetectedCharacters["Alice"] = true
+	api.Store.VoiceMapping["Alise"] = api.VoiceConfig{VoiceID: "alise.wav"}
+	api.Store.Mu.Unlock()
+	defer api.Books.Delete(mockBookID)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status %d, body %s", method, path, w.Code, w.Body.String())
+		}
+		return w
+	}
+	speaker := func() string {
+		api.Store.Mu.RLock()
+		defer api.Store.Mu.RUnlock()
+		return api.Store.Analysis[chapterID][1].Speaker
+	}
+	history := func() (revisions []storage.Revision, canUndo, canRedo bool) {
+		w := do("GET", "/api/history", nil)
+		var resp struct {
+			Revisions []storage.Revision `json:"revisions"`
+			CanUndo   bool               `json:"canUndo"`
+			CanRedo   bool               `json:"canRedo"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Revisions, resp.CanUndo, resp.CanRedo
+	}
+
+	// Two edits: fix the emotion, then merge the misspelt speaker
+	do("PUT", "/api/analysis/"+chapterID+"/segments/1", gin.H{"emotion": "surprised"})
+	do("POST", "/api/characters/merge", gin.H{"target": "Alice", "sources": []string{"Alise"}})
+	if speaker() != "Alice" {
+		t.Fatalf("speaker after merge = %q", speaker())
+	}
+
+	revisions, canUndo, canRedo := history()
+	if len(revisions) != 2 || revisions[0].Action != "merge-characters" || !canUndo || canRedo {
+		t.Fatalf("history after edits = %+v (undo %v, redo %v)", revisions, canUndo, canRedo)
+	}
+	merge, edit := revisions[0], revisions[1]
+
+	// The revision records the changed parts before and after
+	w := do("GET", "/api/history/"+strconv.FormatInt(merge.ID, 10), nil)
+	var rev storage.Revision
+	json.Unmarshal(w.Body.Bytes(), &rev)
+	if rev.Before.Segments[chapterID][1].Speaker != "Alise" || rev.After.Segments[chapterID][1].Speaker != "Alice" ||
+		rev.Before.Voices["Alise"] == nil || rev.After.Voices["Alise"] != nil {
+		t.Errorf("merge revision = %+v", rev)
+	}
+
+	// Undo brings the merged character and its voice back
+	do("POST", "/api/history/undo", nil)
+	api.Store.Mu.RLock()
+	voice, hasVoice := api.Store.VoiceMapping["Alise"]
+	detected := api.Store.DetectedCharacters["Alise"]
+	api.Store.Mu.RUnlock()
+	if speaker() != "Alise" || !hasVoice || voice.VoiceID != "alise.wav" || !detected {
+		t.Errorf("after undo: speaker %q, voice %+v, detected %v", speaker(), voice, detected)
+	}
+	if _, _, canRedo := history(); !canRedo {
+		t.Error("undone revision can't be redone")
+	}
+
+	do("POST", "/api/history/redo", nil)
+	if speaker() != "Alice" {
+		t.Errorf("speaker after redo = %q", speaker())
+	}
+
+	// Restore the chapter to before the merge; the restore can be undone too
+	do("POST", "/api/analysis/"+chapterID+"/restore/"+strconv.FormatInt(edit.ID, 10), nil)
+	api.Store.Mu.RLock()
+	restored := api.Store.Analysis[chapterID][1]
+	api.Store.Mu.RUnlock()
+	if restored.Speaker != "Alise" || restored.Emotion != "surprised" {
+		t.Errorf("restored segment = %+v", restored)
+	}
+	do("POST", "/api/history/undo", nil)
+	if speaker() != "Alice" {
+		t.Errorf("speaker after undoing restore = %q", speaker())
+	}
+
+	// The undone restore no longer counts as history: restoring to it leaves
+	// the chapter as the merge left it
+	revisions, _, _ = history()
+	do("POST", "/api/analysis/"+chapterID+"/restore/"+strconv.FormatInt(revisions[0].ID, 10), nil)
+	if speaker() != "Alice" {
+		t.Errorf("speaker after restoring to an undone revision = %q", speaker())
+	}
+
+	// Undo and redo are saved, not just applied in memory
+	var saved api.ProjectStore
+	if err := saved.Load(mockBookID); err != nil {
+		t.Fatal(err)
+	}
+	if saved.Analysis[chapterID][1].Speaker != "Alice" || saved.Analysis[chapterID][1].Emotion != "surprised" {
+		t.Errorf("saved segment = %+v", saved.Analysis[chapterID][1])
+	}
+}
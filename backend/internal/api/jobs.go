@@ -0,0 +1,140 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"tts-book/backend/internal/jobs"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Jobs runs analysis and generation in the background. Job state is
+// persisted to data/jobs.json so unfinished runs resume after a restart.
+var Jobs = jobs.NewManager("data/jobs.json", 2)
+
+// Job kinds
+const (
+	jobGenerateChapter = "generate"
+	jobGenerateAll     = "generate-all"
+	jobAnalyzeAll      = "analyze-all"
+	jobExportM4B       = "export-m4b"
+	jobExportEPUB      = "export-epub"
+)
+
+func init() {
+	Jobs.Register(jobGenerateChapter, runGenerateChapter)
+	Jobs.Register(jobGenerateAll, runGenerateAll)
+	Jobs.Register(jobAnalyzeAll, runAnalyzeAll)
+	Jobs.Register(jobExportM4B, runExportM4B)
+	Jobs.Register(jobExportEPUB, runExportEPUB)
+}
+
+// StartJobs resumes jobs left unfinished by a previous run.
+// It should be called once at startup, after routes are set up.
+func StartJobs() {
+	if err := Jobs.Load(); err != nil {
+		log.Printf("[Jobs] Warning: Failed to load persisted jobs: %v", err)
+	}
+}
+
+// submitJob queues a job and writes an error response if that fails.
+// Duplicate submissions for the same target are rejected with 409.
+func submitJob(c *gin.Context, kind, key, bookID string, params map[string]string) (*jobs.Job, bool) {
+	job, err := Jobs.Submit(kind, key, bookID, params)
+	if errors.Is(err, jobs.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A job for this target is already running", "jobId": job.ID})
+		return nil, false
+	}
+	if errors.Is(err, jobs.ErrBookLocked) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The book is being deleted or replaced"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return job, true
+}
+
+// reportProgress records progress on the job and broadcasts it to the UI.
+func reportProgress(job *jobs.Job, channel string, percent int, msg string) {
+	job.Report(percent, msg)
+	GlobalHub.broadcast <- ProgressMessage{
+		Type:       "progress",
+		Percentage: percent,
+		Message:    msg,
+		ChapterID:  channel,
+		JobID:      job.ID,
+		BookID:     job.BookID,
+	}
+}
+
+// jobBook returns the project of the job's book, loading it from disk for
+// jobs resumed after a restart. Every book has its own project, so opening
+// another book while the job runs doesn't affect it.
+func jobBook(job *jobs.Job) (*ProjectStore, error) {
+	book, err := Books.Get(job.BookID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load book %s: %v", job.BookID, err)
+	}
+	return book, nil
+}
+
+// ListJobs returns all known jobs, newest first. Under /books/:bookID only
+// the book's jobs are listed.
+func ListJobs(c *gin.Context) {
+	list := Jobs.List()
+	if bookID := c.Param("bookID"); bookID != "" {
+		filtered := []*jobs.Job{}
+		for _, job := range list {
+			if job.BookID == bookID {
+				filtered = append(filtered, job)
+			}
+		}
+		list = filtered
+	}
+	c.JSON(http.StatusOK, gin.H{"jobs": list})
+}
+
+// GetJob returns a single job
+func GetJob(c *gin.Context) {
+	job, err := Jobs.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob cancels a queued, running or paused job
+func CancelJob(c *gin.Context) {
+	jobAction(c, Jobs.Cancel)
+}
+
+// PauseJob pauses a job at its next checkpoint
+func PauseJob(c *gin.Context) {
+	jobAction(c, Jobs.Pause)
+}
+
+// ResumeJob resumes a paused job
+func ResumeJob(c *gin.Context) {
+	jobAction(c, Jobs.Resume)
+}
+
+func jobAction(c *gin.Context, action func(id string) error) {
+	id := c.Param("id")
+	if err := action(id); err != nil {
+		status := http.StatusConflict
+		if errors.Is(err, jobs.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	job, _ := Jobs.Get(id)
+	c.JSON(http.StatusOK, job)
+}
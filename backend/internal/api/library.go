@@ -0,0 +1,326 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"tts-book/backend/internal/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Library holds every book project in the project database. Projects are loaded on
+// first use and kept in memory, so a job keeps working on its own book
+// whichever book the UI has open.
+type Library struct {
+	mu    sync.Mutex
+	books map[string]*ProjectStore
+}
+
+// Books is the library of all projects. Store is the current book, the one
+// the unscoped /api routes work on.
+var Books = &Library{books: make(map[string]*ProjectStore)}
+
+var ErrBookNotFound = storage.ErrNotFound
+
+// Book IDs are file hashes; anything else could escape data/
+var validBookID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// BookSummary describes a book in the library listing
+type BookSummary struct {
+	BookID    string    `json:"bookId"`
+	Name      string    `json:"name"`
+	Title     string    `json:"title"`
+	Authors   []string  `json:"authors,omitempty"`
+	CoverURL  string    `json:"coverUrl,omitempty"`
+	Chapters  int       `json:"chapters"`
+	Analyzed  int       `json:"analyzed"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Current   bool      `json:"current"`
+}
+
+// Current returns the most recently opened book
+func (l *Library) Current() *ProjectStore {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return Store
+}
+
+// Get returns a saved book, loading it from disk if it isn't in memory yet
+func (l *Library) Get(bookID string) (*ProjectStore, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.getLocked(bookID, false)
+}
+
+// Open makes a saved book the current one
+func (l *Library) Open(bookID string) (*ProjectStore, error) {
+	return l.open(bookID, false)
+}
+
+// Create makes a book the current one, starting an empty project for it if
+// it has never been saved
+func (l *Library) Create(bookID string) (*ProjectStore, error) {
+	return l.open(bookID, true)
+}
+
+func (l *Library) open(bookID string, create bool) (*ProjectStore, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, err := l.getLocked(bookID, create)
+	if err != nil {
+		return nil, err
+	}
+	// Keep the previous book in memory: a job may still be working on it
+	if id := Store.id(); id != "" && l.books[id] == nil {
+		l.books[id] = Store
+	}
+	l.books[bookID] = p
+	Store = p
+	return p, nil
+}
+
+func (l *Library) getLocked(bookID string, create bool) (*ProjectStore, error) {
+	if !validBookID.MatchString(bookID) {
+		return nil, ErrBookNotFound
+	}
+	if Store.id() == bookID {
+		return Store, nil
+	}
+	if p, ok := l.books[bookID]; ok {
+		return p, nil
+	}
+
+	p := newProjectStore()
+	err := p.Load(bookID)
+	if errors.Is(err, ErrBookNotFound) && create {
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	l.books[bookID] = p
+	return p, nil
+}
+
+// List returns every saved book, most recently changed first
+func (l *Library) List() ([]BookSummary, error) {
+	db, err := projects()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := db.ListBooks()
+	if err != nil {
+		return nil, err
+	}
+
+	current := Books.Current().id()
+	books := make([]BookSummary, len(stored))
+	for i, b := range stored {
+		books[i] = newBookSummary(b)
+		books[i].Current = b.ID == current
+	}
+	return books, nil
+}
+
+// reopen makes a book the current one, loading it again from disk after it
+// has been replaced there
+func (l *Library) reopen(bookID string) (*ProjectStore, error) {
+	l.mu.Lock()
+	delete(l.books, bookID)
+	if Store.id() == bookID {
+		Store = newProjectStore()
+	}
+	l.mu.Unlock()
+	return l.Open(bookID)
+}
+
+// Rename sets the name a book is listed under
+func (l *Library) Rename(bookID, name string) error {
+	p, err := l.Get(bookID)
+	if err != nil {
+		return err
+	}
+	p.Mu.Lock()
+	defer p.Mu.Unlock()
+	p.Name = name
+	return p.saveBook()
+}
+
+// Delete removes a book's project, generated audio, segment cache and
+// uploaded file. If it was the current book, no book is current afterwards.
+func (l *Library) Delete(bookID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	p, err := l.getLocked(bookID, false)
+	if err != nil {
+		return err
+	}
+	delete(l.books, bookID)
+	if Store == p {
+		Store = newProjectStore()
+	}
+
+	p.Mu.RLock()
+	bookPath := p.CurrentBookPath
+	p.Mu.RUnlock()
+
+	db, err := projects()
+	if err != nil {
+		return err
+	}
+	// Books that were opened but never saved aren't in the database
+	if err := db.Delete(bookID); err != nil && !errors.Is(err, ErrBookNotFound) {
+		return err
+	}
+	os.RemoveAll(filepath.Join("data", "out", bookID))
+	os.RemoveAll(filepath.Join("data", "cache", bookID))
+	os.RemoveAll(filepath.Join("data", "temp", bookID))
+	// Books uploaded before the library share one folder; leave those files
+	if uploadDir := filepath.Join("uploads", bookID); filepath.Dir(bookPath) == uploadDir {
+		os.RemoveAll(uploadDir)
+	}
+	return nil
+}
+
+// newBookSummary describes a stored book for the library listing
+func newBookSummary(b storage.BookInfo) BookSummary {
+	summary := BookSummary{
+		BookID:    b.ID,
+		Name:      b.Name,
+		Chapters:  b.Chapters,
+		Analyzed:  b.Analyzed,
+		CoverURL:  outputURL(b.CoverPath),
+		UpdatedAt: b.UpdatedAt,
+	}
+	if b.Metadata != nil {
+		summary.Title = b.Metadata.Title
+		summary.Authors = b.Metadata.Authors
+	}
+	if summary.Title == "" {
+		summary.Title = strings.TrimSuffix(filepath.Base(b.Path), filepath.Ext(b.Path))
+	}
+	if summary.Name == "" {
+		summary.Name = summary.Title
+	}
+	return summary
+}
+
+// summary describes the project for the library listing.
+// Caller must hold s.Mu.
+func (s *ProjectStore) summary() BookSummary {
+	b := storage.BookInfo{Book: s.book(), Chapters: len(s.Chapters)}
+	for _, ch := range s.Chapters {
+		if len(s.Analysis[ch.ID]) > 0 {
+			b.Analyzed++
+		}
+	}
+	return newBookSummary(b)
+}
+
+// bookFor resolves the book a request works on: the one named by :bookID,
+// or the current book for the unscoped routes. It writes an error response
+// and returns false if the book can't be loaded.
+func bookFor(c *gin.Context) (*ProjectStore, bool) {
+	bookID := c.Param("bookID")
+	if bookID == "" {
+		return Books.Current(), true
+	}
+	p, err := Books.Get(bookID)
+	if errors.Is(err, ErrBookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return p, true
+}
+
+// ListBooks returns every book in the library
+func ListBooks(c *gin.Context) {
+	books, err := Books.List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"books": books})
+}
+
+// OpenBook makes a book the current one and returns its chapters, like an
+// upload of the same file would
+func OpenBook(c *gin.Context) {
+	p, err := Books.Open(c.Param("bookID"))
+	if errors.Is(err, ErrBookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	p.Mu.RLock()
+	defer p.Mu.RUnlock()
+	c.JSON(http.StatusOK, gin.H{
+		"bookId":   p.BookID,
+		"chapters": p.Chapters,
+		"bookPath": p.CurrentBookPath,
+		"metadata": p.Metadata,
+	})
+}
+
+// RenameBook sets the name a book is listed under
+func RenameBook(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := Books.Rename(c.Param("bookID"), strings.TrimSpace(req.Name))
+	if errors.Is(err, ErrBookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Book renamed"})
+}
+
+// DeleteBook removes a book and everything generated for it. Books with
+// unfinished jobs can't be deleted; cancel the jobs first.
+func DeleteBook(c *gin.Context) {
+	bookID := c.Param("bookID")
+	// No job may start on the book while it is deleted
+	unlock, err := Jobs.LockBook(bookID)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "The book has unfinished jobs"})
+		return
+	}
+	defer unlock()
+
+	err = Books.Delete(bookID)
+	if errors.Is(err, ErrBookNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Book not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Book deleted"})
+}
@@ -0,0 +1,134 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLibrary(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	// Two books saved as JSON by an earlier version, whose chapters share an ID
+	books := map[string]string{"mock_library_a": "第一本", "mock_library_b": "第二本"}
+	for bookID, text := range books {
+		data, _ := json.Marshal(&api.ProjectStore{
+			BookID:   bookID,
+			Chapters: []epub.Chapter{{ID: "ch1", Title: text, Content: text}},
+			Metadata: &epub.Metadata{Title: text},
+			Analysis: map[string][]llm.AnalysisResult{"ch1": {{Text: text, Speaker: "Narrator"}}},
+		})
+		os.MkdirAll("data", 0755)
+		if err := os.WriteFile("data/"+bookID+".json", data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove("data/" + bookID + ".json.migrated")
+	}
+	if n, err := api.ImportProjects("data"); err != nil || n != 2 {
+		t.Fatalf("ImportProjects() = %d, %v; want 2 books", n, err)
+	}
+	if _, err := os.Stat("data/mock_library_a.json"); !os.IsNotExist(err) {
+		t.Error("imported JSON project not renamed")
+	}
+
+	do := func(method, url string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, url, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	list := func() map[string]api.BookSummary {
+		w := do("GET", "/api/books", nil)
+		var resp struct {
+			Books []api.BookSummary `json:"books"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		found := map[string]api.BookSummary{}
+		for _, b := range resp.Books {
+			found[b.BookID] = b
+		}
+		return found
+	}
+
+	found := list()
+	for bookID, title := range books {
+		if found[bookID].Name != title || found[bookID].Analyzed != 1 {
+			t.Errorf("listing of %s: %+v", bookID, found[bookID])
+		}
+	}
+
+	// Scoped routes read each book's own analysis
+	for bookID, text := range books {
+		w := do("GET", "/api/books/"+bookID+"/analysis/ch1", nil)
+		var resp struct {
+			Results []llm.AnalysisResult `json:"results"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if w.Code != http.StatusOK || len(resp.Results) != 1 || resp.Results[0].Text != text {
+			t.Errorf("analysis of %s: status %d, body %s", bookID, w.Code, w.Body.String())
+		}
+	}
+	if w := do("GET", "/api/books/mock_library_missing/analysis/ch1", nil); w.Code != http.StatusNotFound {
+		t.Errorf("unknown book: status %d, want 404", w.Code)
+	}
+	if w := do("GET", "/api/books/..%2Fconfig/analysis/ch1", nil); w.Code != http.StatusNotFound {
+		t.Errorf("invalid book ID: status %d, want 404", w.Code)
+	}
+
+	// Rename
+	if w := do("PUT", "/api/books/mock_library_a", gin.H{"name": "改名"}); w.Code != http.StatusOK {
+		t.Fatalf("rename: status %d, body %s", w.Code, w.Body.String())
+	}
+	if name := list()["mock_library_a"].Name; name != "改名" {
+		t.Errorf("renamed book listed as %q", name)
+	}
+
+	// Opening a book makes it the one the unscoped routes use
+	if w := do("POST", "/api/books/mock_library_b/open", nil); w.Code != http.StatusOK {
+		t.Fatalf("open: status %d, body %s", w.Code, w.Body.String())
+	}
+	w := do("GET", "/api/book", nil)
+	var current struct {
+		BookID string `json:"bookId"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &current)
+	if current.BookID != "mock_library_b" {
+		t.Errorf("current book = %q after open", current.BookID)
+	}
+	if !list()["mock_library_b"].Current {
+		t.Error("opened book not marked current")
+	}
+
+	// Delete
+	for bookID := range books {
+		if w := do("DELETE", "/api/books/"+bookID, nil); w.Code != http.StatusOK {
+			t.Errorf("delete %s: status %d, body %s", bookID, w.Code, w.Body.String())
+		}
+	}
+	var deleted api.ProjectStore
+	if err := deleted.Load("mock_library_a"); err != api.ErrBookNotFound {
+		t.Errorf("loading deleted book: err = %v, want ErrBookNotFound", err)
+	}
+	if w := do("GET", "/api/books/mock_library_b", nil); w.Code != http.StatusNotFound {
+		t.Errorf("deleted book: status %d, want 404", w.Code)
+	}
+	if w := do("GET", "/api/book", nil); w.Code != http.StatusNotFound {
+		t.Errorf("current book after deleting it: status %d, want 404", w.Code)
+	}
+}
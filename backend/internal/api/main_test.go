@@ -0,0 +1,23 @@
+package api_test
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tts-book/backend/internal/api"
+)
+
+// TestMain keeps the project database out of the source tree
+func TestMain(m *testing.M) {
+	dir, err := os.MkdirTemp("", "tts-book-api")
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.DatabasePath = filepath.Join(dir, "tts-book.db")
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
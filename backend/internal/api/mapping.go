@@ -1,37 +1,45 @@
 package api
 
 import (
-	"log"
+	"fmt"
 	"net/http"
 	"sort"
 
 	"github.com/gin-gonic/gin"
 )
 
+// CharacterDetail is a character's profile with where it appears
 type CharacterDetail struct {
-	Name     string   `json:"name"`
-	Chapters []string `json:"chapters"`
+	CharacterProfile
+	Chapters        []string `json:"chapters"`                  // Titles of the chapters it speaks in
+	FirstAppearance string   `json:"firstAppearance,omitempty"` // ID of the first of those chapters
+	Lines           int      `json:"lines"`                     // Segments spoken
 }
 
 func GetCharacters(c *gin.Context) {
-	Store.Mu.RLock()
-	defer Store.Mu.RUnlock()
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.RLock()
+	defer book.Mu.RUnlock()
 
 	// Helper to track sorting info
 	type charInfo struct {
 		firstIdx int
 		titles   []string
+		lines    int
 	}
 	infoMap := make(map[string]*charInfo)
 
 	// Initialize for all detected characters
-	for name := range Store.DetectedCharacters {
+	for name := range book.DetectedCharacters {
 		infoMap[name] = &charInfo{firstIdx: -1, titles: []string{}}
 	}
 
 	// Iterate chapters to find appearances (preserves order)
-	for i, ch := range Store.Chapters {
-		segments, ok := Store.Analysis[ch.ID]
+	for i, ch := range book.Chapters {
+		segments, ok := book.Analysis[ch.ID]
 		if !ok {
 			continue
 		}
@@ -41,6 +49,9 @@ func GetCharacters(c *gin.Context) {
 		for _, seg := range segments {
 			if seg.Speaker != "" {
 				speakers[seg.Speaker] = true
+				if info, exists := infoMap[seg.Speaker]; exists {
+					info.lines++
+				}
 			}
 		}
 
@@ -58,10 +69,16 @@ func GetCharacters(c *gin.Context) {
 	// Build result slice
 	details := make([]CharacterDetail, 0, len(infoMap))
 	for name, info := range infoMap {
-		details = append(details, CharacterDetail{
-			Name:     name,
-			Chapters: info.titles,
-		})
+		detail := CharacterDetail{
+			CharacterProfile: book.Profiles[name],
+			Chapters:         info.titles,
+			Lines:            info.lines,
+		}
+		detail.Name = name
+		if info.firstIdx >= 0 {
+			detail.FirstAppearance = book.Chapters[info.firstIdx].ID
+		}
+		details = append(details, detail)
 	}
 
 	// Sort
@@ -88,7 +105,7 @@ func GetCharacters(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"characters": details,
-		"mapping":    Store.VoiceMapping,
+		"mapping":    book.VoiceMapping,
 	})
 }
 
@@ -98,18 +115,34 @@ func ConfirmMapping(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	for name, v := range mapping {
+		params, err := checkParams(v.Params)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %v", name, err)})
+			return
+		}
+		v.Params = params
+		mapping[name] = v
+	}
 
-	Store.Mu.Lock()
-	defer Store.Mu.Unlock()
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
 
+	before := book.snapshot()
 	for name, config := range mapping {
-		Store.VoiceMapping[name] = config
+		book.VoiceMapping[name] = config
 	}
 
 	// Persist
-	if err := Store.Save(); err != nil {
-		log.Printf("Failed to save store: %v", err)
+	if _, err := book.saveEdit(before, "confirm-mapping", fmt.Sprintf("Set voices of %d characters", len(mapping))); err != nil {
+		book.revert(before)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Mapping saved", "count": len(Store.VoiceMapping)})
+	c.JSON(http.StatusOK, gin.H{"message": "Mapping saved", "count": len(book.VoiceMapping)})
 }
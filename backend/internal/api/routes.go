@@ -2,14 +2,52 @@ package api
 
 import (
 	"io/fs"
+	"mime"
 	"net/http"
 	"strings"
-	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/audio"
 
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRoutes(r *gin.Engine, cfg *config.Config, staticFS fs.FS) {
+// bookRoutes registers the endpoints that work on one book
+func bookRoutes(g *gin.RouterGroup) {
+	g.POST("/analyze/:chapterID", AnalyzeChapter)
+	g.POST("/analyze-all", AnalyzeAllChapters)
+
+	g.GET("/characters", GetCharacters)
+	g.POST("/characters/merge", MergeCharacters)
+	g.POST("/characters/update", UpdateCharacter)
+	g.POST("/characters/profile", UpdateProfile)
+	g.POST("/confirm-mapping", ConfirmMapping)
+	g.GET("/casting", GetCasting)
+	g.POST("/casting", CastVoices)
+
+	g.GET("/analysis/:chapterID", GetSegments)
+	g.POST("/analysis/:chapterID/segments", InsertSegment)
+	g.POST("/analysis/:chapterID/segments/reorder", ReorderSegments)
+	g.PUT("/analysis/:chapterID/segments/:index", UpdateSegment)
+	g.DELETE("/analysis/:chapterID/segments/:index", DeleteSegment)
+	g.POST("/analysis/:chapterID/segments/:index/split", SplitSegment)
+	g.POST("/analysis/:chapterID/segments/:index/merge-next", MergeSegments)
+	g.POST("/analysis/:chapterID/restore/:revisionID", RestoreChapter)
+
+	g.GET("/history", GetHistory)
+	g.GET("/history/:revisionID", GetRevision)
+	g.POST("/history/undo", Undo)
+	g.POST("/history/redo", Redo)
+
+	g.POST("/generate/:chapterID", GenerateAudio)
+	g.POST("/generate-all", GenerateAllAudio)
+	g.GET("/audio-status/:chapterID", GetAudioStatus)
+	g.GET("/subtitles/:chapterID", ExportSubtitles)
+	g.POST("/export/m4b", ExportM4B)
+	g.GET("/export/m4b", GetM4BStatus)
+	g.POST("/export/epub", ExportEPUB)
+	g.GET("/export/epub", GetEPUBStatus)
+}
+
+func SetupRoutes(r *gin.Engine, staticFS fs.FS) {
 	// CORS middleware
 	r.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -24,28 +62,52 @@ func SetupRoutes(r *gin.Engine, cfg *config.Config, staticFS fs.FS) {
 
 	api := r.Group("/api")
 	{
-		api.GET("/config", GetConfig(cfg))
-		api.POST("/config", UpdateConfig(cfg))
+		api.GET("/config", GetConfig)
+		api.POST("/config", UpdateConfig)
 		api.POST("/upload", UploadEPUB)
-		api.POST("/analyze/:chapterID", AnalyzeChapter)
-		api.POST("/analyze-all", AnalyzeAllChapters)
+		api.POST("/import", ImportBundle)
+		api.GET("/book", GetBook)
+
+		api.GET("/books", ListBooks)
+		api.GET("/books/:bookID", GetBook)
+		api.PUT("/books/:bookID", RenameBook)
+		api.DELETE("/books/:bookID", DeleteBook)
+		api.POST("/books/:bookID/open", OpenBook)
+		api.GET("/books/:bookID/jobs", ListJobs)
+		api.GET("/books/:bookID/export", ExportBundle)
 
-		api.GET("/characters", GetCharacters)
-		api.POST("/characters/merge", MergeCharacters)
-		api.POST("/characters/update", UpdateCharacter)
-		api.POST("/confirm-mapping", ConfirmMapping)
+		// Book routes exist twice: under /books/:bookID, and unscoped for
+		// the current book (the one last uploaded or opened)
+		bookRoutes(api)
+		bookRoutes(api.Group("/books/:bookID"))
 
-		api.POST("/generate/:chapterID", GenerateAudio)
-		api.POST("/generate-all", GenerateAllAudio)
-		api.GET("/audio-status/:chapterID", GetAudioStatus)
 		api.GET("/browse", BrowseFiles)
-		api.GET("/voices/list", ListConfiguredVoices(cfg))
+		api.GET("/voices/list", ListConfiguredVoices)
+		api.POST("/voices", UploadVoice)
+		api.PUT("/voices", UpdateVoice)
+		api.DELETE("/voices", DeleteVoice)
 		api.GET("/voices/preview", PreviewVoice)
-		api.GET("/llm/models", ListLLMModels(cfg))
+		api.POST("/voices/prepare", PrepareVoice)
+		api.DELETE("/voices/prepare", DiscardPreparedVoice)
+		api.GET("/llm/models", ListLLMModels)
+		api.GET("/tts/engine", GetTTSEngine)
+		api.GET("/tts/uploads", GetUploadCacheStats)
 		api.GET("/ws", WsHandler)
+
+		api.GET("/jobs", ListJobs)
+		api.GET("/jobs/:id", GetJob)
+		api.POST("/jobs/:id/cancel", CancelJob)
+		api.POST("/jobs/:id/pause", PauseJob)
+		api.POST("/jobs/:id/resume", ResumeJob)
 	}
 
-	// Serve generated audio
+	// Serve generated audio. Register MIME types for every output format,
+	// since the system MIME table may not know .opus or .m4a.
+	for _, f := range audio.Formats() {
+		mime.AddExtensionType(f.Ext, f.MIME)
+	}
+	mime.AddExtensionType(".m4b", "audio/mp4")
+	mime.AddExtensionType(".epub", "application/epub+zip")
 	r.Static("/output", "data/out")
 
 	// Serve Frontend Static Files (SPA Support)
@@ -0,0 +1,469 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UpdateSegmentRequest carries the fields to change on a segment.
+// Nil fields are left untouched.
+type UpdateSegmentRequest struct {
+	Text        *string `json:"text"`
+	Typesetting *string `json:"typesetting"`
+	Speaker     *string `json:"speaker"`
+	Emotion     *string `json:"emotion"`
+	// Replaces the segment's generation settings; {} clears them
+	Params *config.GenerationParams `json:"params"`
+}
+
+type SplitSegmentRequest struct {
+	Offset int `json:"offset"` // Rune offset into text where the second segment starts
+	// Rune offset into typesetting. Only needed when typesetting differs from
+	// text (pinyin annotations); otherwise offset is used.
+	TypesettingOffset *int `json:"typesettingOffset"`
+}
+
+type InsertSegmentRequest struct {
+	Index   int                `json:"index"` // Position the new segment will occupy
+	Segment llm.AnalysisResult `json:"segment"`
+}
+
+type ReorderSegmentsRequest struct {
+	Order []int `json:"order"` // New position i holds old segment Order[i]
+}
+
+var validEmotions = map[string]bool{
+	"happy": true, "angry": true, "sad": true, "afraid": true,
+	"disgusted": true, "melancholic": true, "surprised": true, "calm": true,
+}
+
+// GetSegments returns the analysis results of a chapter
+func GetSegments(c *gin.Context) {
+	chapterID := c.Param("chapterID")
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.RLock()
+	defer book.Mu.RUnlock()
+
+	segments, ok := book.Analysis[chapterID]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chapter not analyzed yet"})
+		return
+	}
+
+	diff := compareSource(book, chapterID, segments)
+	c.JSON(http.StatusOK, gin.H{
+		"chapterId":   chapterID,
+		"results":     segments,
+		"sourceMatch": diff == nil,
+		"sourceDiff":  diff,
+	})
+}
+
+// UpdateSegment changes speaker, emotion, text, typesetting or generation
+// settings of one segment
+func UpdateSegment(c *gin.Context) {
+	var req UpdateSegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	editSegments(c, "update-segment", func(segs []llm.AnalysisResult, idx int) ([]llm.AnalysisResult, error) {
+		if err := checkIndex(segs, idx); err != nil {
+			return nil, err
+		}
+		seg := &segs[idx]
+		if req.Text != nil {
+			// Keep typesetting in step with text unless it carries annotations
+			if seg.Typesetting == seg.Text && req.Typesetting == nil {
+				seg.Typesetting = *req.Text
+			}
+			seg.Text = *req.Text
+		}
+		if req.Typesetting != nil {
+			seg.Typesetting = *req.Typesetting
+		}
+		if req.Speaker != nil {
+			if strings.TrimSpace(*req.Speaker) == "" {
+				return nil, fmt.Errorf("speaker cannot be empty")
+			}
+			seg.Speaker = *req.Speaker
+		}
+		if req.Emotion != nil {
+			if !validEmotions[*req.Emotion] {
+				return nil, fmt.Errorf("invalid emotion %q", *req.Emotion)
+			}
+			seg.Emotion = *req.Emotion
+		}
+		if req.Params != nil {
+			params, err := checkParams(req.Params)
+			if err != nil {
+				return nil, err
+			}
+			seg.Params = params
+		}
+		return segs, nil
+	})
+}
+
+// SplitSegment splits one segment into two at a character offset.
+// Both halves keep the original speaker and emotion.
+func SplitSegment(c *gin.Context) {
+	var req SplitSegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	editSegments(c, "split-segment", func(segs []llm.AnalysisResult, idx int) ([]llm.AnalysisResult, error) {
+		if err := checkIndex(segs, idx); err != nil {
+			return nil, err
+		}
+		seg := segs[idx]
+
+		text := []rune(seg.Text)
+		if req.Offset <= 0 || req.Offset >= len(text) {
+			return nil, fmt.Errorf("offset must be between 1 and %d", len(text)-1)
+		}
+
+		first, second := seg, seg
+		first.Text = string(text[:req.Offset])
+		second.Text = string(text[req.Offset:])
+
+		switch {
+		case seg.Typesetting == "":
+			// Nothing to split, both halves fall back to their text
+		case seg.Typesetting == seg.Text:
+			first.Typesetting = first.Text
+			second.Typesetting = second.Text
+		case req.TypesettingOffset != nil:
+			ts := []rune(seg.Typesetting)
+			off := *req.TypesettingOffset
+			if off <= 0 || off >= len(ts) {
+				return nil, fmt.Errorf("typesettingOffset must be between 1 and %d", len(ts)-1)
+			}
+			first.Typesetting = string(ts[:off])
+			second.Typesetting = string(ts[off:])
+		default:
+			return nil, fmt.Errorf("segment has pinyin annotations; typesettingOffset is required")
+		}
+
+		out := make([]llm.AnalysisResult, 0, len(segs)+1)
+		out = append(out, segs[:idx]...)
+		out = append(out, first, second)
+		return append(out, segs[idx+1:]...), nil
+	})
+}
+
+// MergeSegments merges a segment with the one following it.
+// The merged segment keeps the first segment's speaker and emotion.
+func MergeSegments(c *gin.Context) {
+	editSegments(c, "merge-segments", func(segs []llm.AnalysisResult, idx int) ([]llm.AnalysisResult, error) {
+		if err := checkIndex(segs, idx); err != nil {
+			return nil, err
+		}
+		if idx+1 >= len(segs) {
+			return nil, fmt.Errorf("segment %d has no following segment to merge with", idx)
+		}
+
+		first, second := segs[idx], segs[idx+1]
+		merged := first
+		merged.Text = first.Text + second.Text
+		if first.Typesetting != "" || second.Typesetting != "" {
+			merged.Typesetting = typesettingOrText(first) + typesettingOrText(second)
+		}
+
+		out := make([]llm.AnalysisResult, 0, len(segs)-1)
+		out = append(out, segs[:idx]...)
+		out = append(out, merged)
+		return append(out, segs[idx+2:]...), nil
+	})
+}
+
+// InsertSegment inserts a new segment at the given position
+func InsertSegment(c *gin.Context) {
+	var req InsertSegmentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	editSegments(c, "insert-segment", func(segs []llm.AnalysisResult, _ int) ([]llm.AnalysisResult, error) {
+		if req.Index < 0 || req.Index > len(segs) {
+			return nil, fmt.Errorf("index must be between 0 and %d", len(segs))
+		}
+		seg := req.Segment
+		if seg.Text == "" || seg.Speaker == "" {
+			return nil, fmt.Errorf("segment text and speaker are required")
+		}
+		if seg.Emotion == "" {
+			seg.Emotion = "calm"
+		}
+		if !validEmotions[seg.Emotion] {
+			return nil, fmt.Errorf("invalid emotion %q", seg.Emotion)
+		}
+		params, err := checkParams(seg.Params)
+		if err != nil {
+			return nil, err
+		}
+		seg.Params = params
+
+		out := make([]llm.AnalysisResult, 0, len(segs)+1)
+		out = append(out, segs[:req.Index]...)
+		out = append(out, seg)
+		return append(out, segs[req.Index:]...), nil
+	})
+}
+
+// DeleteSegment removes a segment
+func DeleteSegment(c *gin.Context) {
+	editSegments(c, "delete-segment", func(segs []llm.AnalysisResult, idx int) ([]llm.AnalysisResult, error) {
+		if err := checkIndex(segs, idx); err != nil {
+			return nil, err
+		}
+		out := make([]llm.AnalysisResult, 0, len(segs)-1)
+		out = append(out, segs[:idx]...)
+		return append(out, segs[idx+1:]...), nil
+	})
+}
+
+// ReorderSegments reorders the segments of a chapter by a permutation
+func ReorderSegments(c *gin.Context) {
+	var req ReorderSegmentsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	editSegments(c, "reorder-segments", func(segs []llm.AnalysisResult, _ int) ([]llm.AnalysisResult, error) {
+		if len(req.Order) != len(segs) {
+			return nil, fmt.Errorf("order must list all %d segments", len(segs))
+		}
+		seen := make([]bool, len(segs))
+		out := make([]llm.AnalysisResult, len(segs))
+		for i, old := range req.Order {
+			if old < 0 || old >= len(segs) || seen[old] {
+				return nil, fmt.Errorf("order must be a permutation of 0..%d", len(segs)-1)
+			}
+			seen[old] = true
+			out[i] = segs[old]
+		}
+		return out, nil
+	})
+}
+
+// editSegments applies an edit to a copy of a chapter's segments, validates
+// the result against the chapter source and persists it as a revision.
+//
+// If the segments matched the source before the edit, an edit that breaks
+// the match is rejected with 422 unless ?force=true. Analyses that already
+// diverge from the source (LLM rewrites) can still be edited freely.
+func editSegments(c *gin.Context, action string, edit func(segs []llm.AnalysisResult, idx int) ([]llm.AnalysisResult, error)) {
+	chapterID := c.Param("chapterID")
+	force := c.Query("force") == "true"
+
+	idx := -1
+	if raw := c.Param("index"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid segment index"})
+			return
+		}
+		idx = n
+	}
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	book.Mu.Lock()
+	defer book.Mu.Unlock()
+
+	segments, ok := book.Analysis[chapterID]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Chapter not analyzed yet"})
+		return
+	}
+
+	working := make([]llm.AnalysisResult, len(segments))
+	copy(working, segments)
+
+	updated, err := edit(working, idx)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// LLM output doesn't always match its source, so an edit only has to
+	// leave the segments no further from the source than they were
+	diffBefore := compareSource(book, chapterID, segments)
+	diffAfter := compareSource(book, chapterID, updated)
+	if diffAfter.size() > diffBefore.size() && !force {
+		msg := "Edited segments no longer match the chapter text."
+		if diffBefore != nil {
+			msg = "Edit makes the segments differ further from the chapter text."
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":       msg + " Retry with force=true to apply anyway.",
+			"sourceMatch": false,
+			"sourceDiff":  diffAfter,
+		})
+		return
+	}
+
+	before := book.snapshot()
+	book.Analysis[chapterID] = updated
+	for _, seg := range updated {
+		if seg.Speaker != "" {
+			book.DetectedCharacters[seg.Speaker] = true
+		}
+	}
+
+	// Persist
+	summary := fmt.Sprintf("%s in chapter %s", action, chapterID)
+	if idx >= 0 {
+		summary = fmt.Sprintf("%s %d in chapter %s", action, idx, chapterID)
+	}
+	if _, err := book.saveEdit(before, action, summary); err != nil {
+		book.revert(before)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"chapterId":   chapterID,
+		"results":     updated,
+		"sourceMatch": diffAfter == nil,
+		"sourceDiff":  diffAfter,
+	})
+}
+
+func checkIndex(segs []llm.AnalysisResult, idx int) error {
+	if idx < 0 || idx >= len(segs) {
+		return fmt.Errorf("segment index %d out of range (0-%d)", idx, len(segs)-1)
+	}
+	return nil
+}
+
+func typesettingOrText(seg llm.AnalysisResult) string {
+	if seg.Typesetting != "" {
+		return seg.Typesetting
+	}
+	return seg.Text
+}
+
+// maxDiffText caps the text quoted in a sourceDiff
+const maxDiffText = 200
+
+// sourceDiff is the span where the concatenated segment text differs from
+// the chapter source, whitespace ignored: everything between the longest
+// common prefix and the longest common suffix.
+type sourceDiff struct {
+	Offset   int    `json:"offset"`   // Rune offset of the first difference in the source, whitespace removed
+	Expected string `json:"expected"` // Source text in the span, truncated
+	Got      string `json:"got"`      // Segment text in its place, truncated
+	changed  int    // Source runes missing from the segments plus runes added
+}
+
+// size is how far the segments are from the source; zero when they match
+func (d *sourceDiff) size() int {
+	if d == nil {
+		return 0
+	}
+	return d.changed
+}
+
+// compareSource returns where the segments differ from the chapter source,
+// ignoring whitespace (the LLM does not preserve line breaks), or nil when
+// they match. Caller must hold book.Mu.
+func compareSource(book *ProjectStore, chapterID string, segs []llm.AnalysisResult) *sourceDiff {
+	var source string
+	for _, ch := range book.Chapters {
+		if ch.ID == chapterID {
+			source = ch.Content
+			break
+		}
+	}
+
+	var sb strings.Builder
+	for _, seg := range segs {
+		sb.WriteString(seg.Text)
+	}
+	want, got := []rune(stripSpace(source)), []rune(stripSpace(sb.String()))
+
+	prefix := 0
+	for prefix < len(want) && prefix < len(got) && want[prefix] == got[prefix] {
+		prefix++
+	}
+	if prefix == len(want) && prefix == len(got) {
+		return nil
+	}
+	suffix := 0
+	for suffix < len(want)-prefix && suffix < len(got)-prefix && want[len(want)-1-suffix] == got[len(got)-1-suffix] {
+		suffix++
+	}
+	want, got = want[prefix:len(want)-suffix], got[prefix:len(got)-suffix]
+	return &sourceDiff{
+		Offset:   prefix,
+		Expected: truncateRunes(want, maxDiffText),
+		Got:      truncateRunes(got, maxDiffText),
+		changed:  editDistance(want, got),
+	}
+}
+
+// editDistance counts the runes to delete from a and insert into b to turn
+// one into the other, using Myers' diff: O((len(a)+len(b))·d) for distance
+// d, so close texts are cheap however long they are.
+func editDistance(a, b []rune) int {
+	n, m := len(a), len(b)
+	offset := n + m
+	// v[offset+k] is the furthest x reached on diagonal k = x-y
+	v := make([]int, 2*(n+m)+2)
+	for d := 0; d <= n+m; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // Down: insert from b
+			} else {
+				x = v[offset+k-1] + 1 // Right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return d
+			}
+		}
+	}
+	return n + m
+}
+
+func truncateRunes(r []rune, n int) string {
+	if len(r) > n {
+		return string(r[:n]) + "…"
+	}
+	return string(r)
+}
+
+func stripSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
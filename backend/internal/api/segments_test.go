@@ -0,0 +1,198 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSegmentEditing(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	chapterID := "ch_edit"
+	mockBookID := "mock_book_segments"
+
+	api.Store.Mu.Lock()
+	api.Store.BookID = mockBookID
+	api.Store.Chapters = []epub.Chapter{
+		{ID: chapterID, Title: "Edit", Content: "他笑了。\n“你好！”她说。"},
+	}
+	api.Store.Analysis[chapterID] = []llm.AnalysisResult{
+		{Text: "他笑了。", Speaker: "Narrator", Emotion: "calm"},
+		{Text: "“你好！”她说。", Speaker: "Narrator", Emotion: "calm"},
+	}
+	api.Store.Mu.Unlock()
+	defer api.Books.Delete(mockBookID)
+
+	do := func(method, path string, body interface{}) (*httptest.ResponseRecorder, []llm.AnalysisResult) {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+
+		var resp struct {
+			Results []llm.AnalysisResult `json:"results"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp.Results
+	}
+
+	// Split the mis-attributed dialogue from its narration
+	w, segs := do("POST", "/api/analysis/ch_edit/segments/1/split", gin.H{"offset": 5})
+	if w.Code != http.StatusOK {
+		t.Fatalf("split: status %d, body %s", w.Code, w.Body.String())
+	}
+	if len(segs) != 3 || segs[1].Text != "“你好！”" || segs[2].Text != "她说。" {
+		t.Fatalf("split: unexpected segments %+v", segs)
+	}
+
+	// Fix speaker and emotion of the dialogue
+	w, segs = do("PUT", "/api/analysis/ch_edit/segments/1", gin.H{"speaker": "Alice", "emotion": "happy"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("update: status %d, body %s", w.Code, w.Body.String())
+	}
+	if segs[1].Speaker != "Alice" || segs[1].Emotion != "happy" {
+		t.Errorf("update: got %+v", segs[1])
+	}
+
+	// Invalid emotion is rejected
+	w, _ = do("PUT", "/api/analysis/ch_edit/segments/1", gin.H{"emotion": "bored"})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid emotion: status %d, want 400", w.Code)
+	}
+
+	// Generation settings for just this line, checked against their ranges
+	w, segs = do("PUT", "/api/analysis/ch_edit/segments/1", gin.H{"params": gin.H{"temperature": 1.2}})
+	if w.Code != http.StatusOK || segs[1].Params == nil || *segs[1].Params.Temperature != 1.2 {
+		t.Errorf("params: status %d, body %s", w.Code, w.Body.String())
+	}
+	w, _ = do("PUT", "/api/analysis/ch_edit/segments/1", gin.H{"params": gin.H{"top_k": 500}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("invalid params: status %d, want 400", w.Code)
+	}
+
+	// Reordering breaks the match with the source and is rejected without force
+	w, _ = do("POST", "/api/analysis/ch_edit/segments/reorder", gin.H{"order": []int{1, 0, 2}})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reorder: status %d, want 422", w.Code)
+	}
+
+	// Text edits that change content are also rejected
+	w, _ = do("PUT", "/api/analysis/ch_edit/segments/0", gin.H{"text": "他哭了。"})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("text edit: status %d, want 422", w.Code)
+	}
+
+	// Merge back the narration
+	w, segs = do("POST", "/api/analysis/ch_edit/segments/1/merge-next", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("merge: status %d, body %s", w.Code, w.Body.String())
+	}
+	if len(segs) != 2 || segs[1].Text != "“你好！”她说。" || segs[1].Speaker != "Alice" {
+		t.Errorf("merge: unexpected segments %+v", segs)
+	}
+
+	// Deleting with force is allowed even though it breaks the match
+	w, segs = do("DELETE", "/api/analysis/ch_edit/segments/0?force=true", nil)
+	if w.Code != http.StatusOK || len(segs) != 1 {
+		t.Errorf("forced delete: status %d, segments %d", w.Code, len(segs))
+	}
+
+	// Once segments differ from the source, edits that keep the difference
+	// as it is still go through, and the difference is reported
+	w, _ = do("PUT", "/api/analysis/ch_edit/segments/0", gin.H{"emotion": "happy"})
+	var resp struct {
+		SourceMatch bool `json:"sourceMatch"`
+		SourceDiff  struct {
+			Offset   int    `json:"offset"`
+			Expected string `json:"expected"`
+			Got      string `json:"got"`
+		} `json:"sourceDiff"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.SourceMatch || resp.SourceDiff.Expected != "他笑了。" || resp.SourceDiff.Got != "" {
+		t.Errorf("edit of mismatched chapter: status %d, body %s", w.Code, w.Body.String())
+	}
+	// ...but edits that widen it are rejected
+	w, _ = do("PUT", "/api/analysis/ch_edit/segments/0", gin.H{"text": "“你好！”他说。"})
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("widening text edit: status %d, want 422", w.Code)
+	}
+
+	api.Store.Mu.RLock()
+	stored := api.Store.Analysis[chapterID]
+	detected := api.Store.DetectedCharacters["Alice"]
+	api.Store.Mu.RUnlock()
+	if len(stored) != 1 {
+		t.Errorf("store has %d segments, want 1", len(stored))
+	}
+	if !detected {
+		t.Error("new speaker was not registered as a character")
+	}
+}
+
+func TestSegmentEditing_DiffersAtEnds(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	chapterID := "ch_ends"
+	mockBookID := "mock_book_segments_ends"
+
+	// The LLM dropped the punctuation at both ends of the chapter
+	api.Store.Mu.Lock()
+	api.Store.BookID = mockBookID
+	api.Store.Chapters = []epub.Chapter{
+		{ID: chapterID, Title: "Ends", Content: "他笑了。她哭了。我们走吧。他们走了。"},
+	}
+	api.Store.Analysis[chapterID] = []llm.AnalysisResult{
+		{Text: "他笑了", Speaker: "Narrator"},
+		{Text: "她哭了。", Speaker: "Narrator"},
+		{Text: "我们走吧。", Speaker: "Narrator"},
+		{Text: "他们走了", Speaker: "Narrator"},
+	}
+	api.Store.Mu.Unlock()
+	defer api.Books.Delete(mockBookID)
+
+	do := func(method, path string, body interface{}) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// Edits that keep the text as it is go through
+	if w := do("POST", "/api/analysis/ch_ends/segments/1/merge-next", nil); w.Code != http.StatusOK {
+		t.Errorf("merge: status %d, body %s", w.Code, w.Body.String())
+	}
+	// Dropping or rewriting text in the middle is still caught
+	if w := do("DELETE", "/api/analysis/ch_ends/segments/1", nil); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("delete: status %d, want 422", w.Code)
+	}
+	if w := do("PUT", "/api/analysis/ch_ends/segments/1", gin.H{"text": "她笑了。我们走吧。"}); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("text edit: status %d, want 422", w.Code)
+	}
+	// Restoring the missing punctuation brings the segments closer
+	if w := do("PUT", "/api/analysis/ch_ends/segments/0", gin.H{"text": "他笑了。"}); w.Code != http.StatusOK {
+		t.Errorf("fixing edit: status %d, body %s", w.Code, w.Body.String())
+	}
+}
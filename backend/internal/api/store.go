@@ -1,12 +1,15 @@
 package api
 
 import (
-	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"log"
 	"path/filepath"
+	"sort"
 	"sync"
 	"tts-book/backend/internal/epub"
 	"tts-book/backend/internal/llm"
+	"tts-book/backend/internal/storage"
 )
 
 // ProjectStore holds the state of the current loaded book and analysis
@@ -16,9 +19,16 @@ type ProjectStore struct {
 	// Book ID (MD5 Hash)
 	BookID string
 
+	// Name the book is listed under in the library; empty means its title
+	Name string
+
 	CurrentBookPath string
 	Chapters        []epub.Chapter
 
+	// Book details read from the file, and where its cover was saved
+	Metadata  *epub.Metadata
+	CoverPath string
+
 	// ChapterID -> List of Analysis Results (Text segments with generic Speaker)
 	Analysis map[string][]llm.AnalysisResult
 
@@ -27,72 +37,196 @@ type ProjectStore struct {
 
 	// Character Name -> Voice Config
 	VoiceMapping map[string]VoiceConfig
+
+	// Character Name -> aliases and attributes. Characters without a
+	// profile are known by their name only.
+	Profiles map[string]CharacterProfile
 }
 
-type VoiceConfig struct {
-	VoiceID       string `json:"voiceId"`
-	Emotion       string `json:"emotion"`       // Default emotion
-	UseLLMEmotion *bool  `json:"useLLMEmotion"` // If true or nil, use emotion from LLM analysis; if false, use default emotion
+type VoiceConfig = storage.VoiceConfig
+
+type CharacterProfile = storage.CharacterProfile
+
+var Store = newProjectStore()
+
+func newProjectStore() *ProjectStore {
+	return &ProjectStore{
+		Analysis:           make(map[string][]llm.AnalysisResult),
+		DetectedCharacters: make(map[string]bool),
+		VoiceMapping:       make(map[string]VoiceConfig),
+		Profiles:           make(map[string]CharacterProfile),
+	}
 }
 
-var Store = &ProjectStore{
-	Analysis:           make(map[string][]llm.AnalysisResult),
-	DetectedCharacters: make(map[string]bool),
-	VoiceMapping:       make(map[string]VoiceConfig),
+// DatabasePath is the SQLite database every book project is stored in.
+// Projects saved as JSON by earlier versions are imported from its
+// directory. Set it before the first request; the database is opened once.
+var DatabasePath = filepath.Join("data", "tts-book.db")
+
+// Every book project is stored in one SQLite database, opened on first use
+var (
+	projectsOnce sync.Once
+	projectsDB   storage.Store
+	projectsErr  error
+)
+
+func projects() (storage.Store, error) {
+	projectsOnce.Do(func() {
+		db, err := storage.OpenSQLite(DatabasePath)
+		if err != nil {
+			projectsErr = fmt.Errorf("failed to open project database: %v", err)
+			return
+		}
+		projectsDB = db
+
+		// Projects saved as JSON by earlier versions
+		if n, err := storage.ImportJSON(db, filepath.Dir(DatabasePath)); err != nil {
+			log.Printf("[Storage] Warning: Failed to import JSON projects: %v", err)
+		} else if n > 0 {
+			log.Printf("[Storage] Imported %d JSON projects into the database", n)
+		}
+	})
+	return projectsDB, projectsErr
 }
 
-func (s *ProjectStore) getStorePath() string {
+// ImportProjects imports the <bookID>.json projects saved by earlier
+// versions in dir into the project database. The database imports its own
+// directory when it is first opened.
+func ImportProjects(dir string) (int, error) {
+	db, err := projects()
+	if err != nil {
+		return 0, err
+	}
+	return storage.ImportJSON(db, dir)
+}
+
+// id returns the book ID, taking the lock
+func (s *ProjectStore) id() string {
+	s.Mu.RLock()
+	defer s.Mu.RUnlock()
+	return s.BookID
+}
+
+// commit writes part of the project in one transaction.
+// Caller must hold the lock (either RLock or Lock).
+func (s *ProjectStore) commit(fn func(tx storage.Tx) error) error {
 	if s.BookID == "" {
-		return "data/store.json" // Default/Fallback
+		return errors.New("no book loaded")
 	}
-	return filepath.Join("data", s.BookID+".json")
+	db, err := projects()
+	if err != nil {
+		return err
+	}
+	return db.Update(s.BookID, fn)
 }
 
+// Save writes the whole project. Caller must hold the lock.
 func (s *ProjectStore) Save() error {
-	// NOTE: Caller must hold the lock (either RLock or Lock)
-	data, err := json.MarshalIndent(s, "", "  ")
-	if err != nil {
+	return s.commit(s.putAll)
+}
+
+// putAll writes the whole project in tx. Caller must hold the lock.
+func (s *ProjectStore) putAll(tx storage.Tx) error {
+	if err := tx.PutBook(s.book()); err != nil {
 		return err
 	}
+	if err := tx.PutChapters(s.Chapters); err != nil {
+		return err
+	}
+	for chapterID, segments := range s.Analysis {
+		if err := tx.PutSegments(chapterID, segments); err != nil {
+			return err
+		}
+	}
+	return s.putCharacters(tx)
+}
 
-	path := s.getStorePath()
+// saveBook writes the book's own record: name, file and metadata.
+// Caller must hold the lock.
+func (s *ProjectStore) saveBook() error {
+	return s.commit(func(tx storage.Tx) error {
+		return tx.PutBook(s.book())
+	})
+}
 
-	// Ensure dir exists
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+func (s *ProjectStore) putCharacters(tx storage.Tx) error {
+	names := make([]string, 0, len(s.DetectedCharacters))
+	for name, ok := range s.DetectedCharacters {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	characters := make([]CharacterProfile, len(names))
+	for i, name := range names {
+		characters[i] = s.Profiles[name]
+		characters[i].Name = name
+	}
+	if err := tx.PutCharacters(characters); err != nil {
 		return err
 	}
+	return tx.PutVoices(s.VoiceMapping)
+}
 
-	return os.WriteFile(path, data, 0644)
+func (s *ProjectStore) book() storage.Book {
+	return storage.Book{
+		ID:        s.BookID,
+		Name:      s.Name,
+		Path:      s.CurrentBookPath,
+		Metadata:  s.Metadata,
+		CoverPath: s.CoverPath,
+	}
 }
 
+// Load reads a book's project from the database. A book that was never
+// saved leaves an empty project and returns ErrBookNotFound.
 func (s *ProjectStore) Load(bookID string) error {
+	db, err := projects()
+	if err != nil {
+		return err
+	}
+
 	s.Mu.Lock()
 	defer s.Mu.Unlock()
 
 	s.BookID = bookID
-	path := s.getStorePath()
-
-	data, err := os.ReadFile(path)
-	if os.IsNotExist(err) {
-		// Reset state for new book
+	p, err := db.Load(bookID)
+	if errors.Is(err, storage.ErrNotFound) {
+		// Reset state for new book; UploadEPUB fills in the chapters
 		s.Analysis = make(map[string][]llm.AnalysisResult)
 		s.DetectedCharacters = make(map[string]bool)
 		s.VoiceMapping = make(map[string]VoiceConfig)
+		s.Profiles = make(map[string]CharacterProfile)
 		s.CurrentBookPath = ""
-		// Chapters kept? No, Chapters are loaded from memory in UploadEPUB usually.
-		// Actually, Store holds Chapters too? Yes.
-		// But UploadEPUB populates them immediately after this Load call maybe?
-		// If we load existing, we want 'Chapters' from disk too if we saved them?
-		// The current Store has 'Chapters' array.
-		return nil
+		s.Name = ""
+		s.Chapters = nil
+		s.Metadata = nil
+		s.CoverPath = ""
+		return ErrBookNotFound
 	}
 	if err != nil {
 		return err
 	}
 
-	if err := json.Unmarshal(data, s); err != nil {
-		return err
+	s.Name = p.Name
+	s.CurrentBookPath = p.Path
+	s.Chapters = p.Chapters
+	s.Metadata = p.Metadata
+	s.CoverPath = p.CoverPath
+	s.Analysis = p.Analysis
+	s.VoiceMapping = p.Voices
+	s.DetectedCharacters = make(map[string]bool, len(p.Characters))
+	s.Profiles = make(map[string]CharacterProfile)
+	for _, ch := range p.Characters {
+		s.DetectedCharacters[ch.Name] = true
+		if !isBareProfile(ch) {
+			s.Profiles[ch.Name] = ch
+		}
+	}
+
+	// Stores saved before book metadata was kept
+	if s.Metadata == nil && s.CurrentBookPath != "" {
+		s.Metadata, s.CoverPath = readBookInfo(bookID, s.CurrentBookPath)
 	}
 
 	// Migration / Default Policy:
@@ -108,3 +242,16 @@ func (s *ProjectStore) Load(bookID string) error {
 
 	return nil
 }
+
+// recordArtefact notes a file generated for a book in its project
+func recordArtefact(bookID string, a storage.Artefact) {
+	db, err := projects()
+	if err == nil {
+		err = db.Update(bookID, func(tx storage.Tx) error {
+			return tx.PutArtefact(a)
+		})
+	}
+	if err != nil {
+		log.Printf("[Storage] Warning: Failed to record %s of book %s: %v", a.Kind, bookID, err)
+	}
+}
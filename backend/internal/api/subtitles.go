@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"tts-book/backend/internal/audio"
+	"tts-book/backend/internal/llm"
+	"tts-book/backend/internal/subtitle"
+
+	"github.com/gin-gonic/gin"
+)
+
+// narrator is the speaker the analysis assigns to non-dialogue text
+const narrator = "Narrator"
+
+// chapterTiming is written next to a chapter's audio and records where each
+// segment sits in it. Text and speaker are copied from the analysis at
+// generation time so subtitles match the audio even after later edits.
+type chapterTiming struct {
+	Segments []segmentTiming `json:"segments"`
+}
+
+type segmentTiming struct {
+	Index   int    `json:"index"` // Segment index in the analysis
+	StartMs int64  `json:"startMs"`
+	EndMs   int64  `json:"endMs"`
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+}
+
+func timingPath(bookID, chapterID string) string {
+	return fmt.Sprintf("data/out/%s/%s.timing.json", bookID, chapterID)
+}
+
+// writeTimings persists the merge timings of a chapter's segments.
+// timings is indexed like tasks.
+func writeTimings(bookID, chapterID string, segments []llm.AnalysisResult, tasks []segmentTask, timings []audio.Timing) error {
+	ct := chapterTiming{Segments: make([]segmentTiming, len(tasks))}
+	for i, t := range tasks {
+		seg := segments[t.index]
+		ct.Segments[i] = segmentTiming{
+			Index:   t.index,
+			StartMs: timings[i].Start.Milliseconds(),
+			EndMs:   timings[i].End.Milliseconds(),
+			Speaker: seg.Speaker,
+			Text:    seg.Text,
+		}
+	}
+	data, err := json.MarshalIndent(ct, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(timingPath(bookID, chapterID), data, 0644)
+}
+
+func readTimings(bookID, chapterID string) (*chapterTiming, error) {
+	data, err := os.ReadFile(timingPath(bookID, chapterID))
+	if err != nil {
+		return nil, err
+	}
+	var ct chapterTiming
+	if err := json.Unmarshal(data, &ct); err != nil {
+		return nil, err
+	}
+	return &ct, nil
+}
+
+// ExportSubtitles returns a chapter's text timed to its audio.
+//
+// ?format= is srt (default), vtt or lrc. ?speakers= controls labels:
+// "dialogue" (default) labels everyone but the narrator, "all" labels every
+// line and "none" omits labels.
+func ExportSubtitles(c *gin.Context) {
+	chapterID := c.Param("chapterID")
+
+	format, err := subtitle.LookupFormat(c.DefaultQuery("format", "srt"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	speakers := c.DefaultQuery("speakers", "dialogue")
+	if speakers != "dialogue" && speakers != "all" && speakers != "none" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "speakers must be dialogue, all or none"})
+		return
+	}
+
+	book, ok := bookFor(c)
+	if !ok {
+		return
+	}
+	bookID := book.id()
+
+	ct, err := readTimings(bookID, chapterID)
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No timing data for this chapter. Generate its audio first."})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to read timing data: %v", err)})
+		return
+	}
+
+	cues := make([]subtitle.Cue, len(ct.Segments))
+	for i, s := range ct.Segments {
+		cues[i] = subtitle.Cue{
+			Start: time.Duration(s.StartMs) * time.Millisecond,
+			End:   time.Duration(s.EndMs) * time.Millisecond,
+			Text:  s.Text,
+		}
+		if speakers == "all" || (speakers == "dialogue" && s.Speaker != narrator) {
+			cues[i].Speaker = s.Speaker
+		}
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s%s"`, chapterID, format.Ext))
+	c.Header("Content-Type", format.MIME+"; charset=utf-8")
+	c.Status(http.StatusOK)
+	if err := format.Write(c.Writer, cues); err != nil {
+		c.Error(err)
+	}
+}
@@ -5,29 +5,30 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 
+	"tts-book/backend/internal/config"
 	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/importer"
 
 	"github.com/gin-gonic/gin"
 )
 
-// In-memory store for demo purposes. Ideally use a DB or persistent file store.
-var LoadedChapters map[string][]epub.Chapter
-var CurrentBookPath string
-
-func init() {
-	LoadedChapters = make(map[string][]epub.Chapter)
-}
-
+// UploadEPUB imports an uploaded book. Despite the name it accepts every
+// format the importer supports: EPUB, plain text, Markdown and HTML.
 func UploadEPUB(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
 		return
 	}
+	if !importer.Supported(file.Filename) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported file type. Accepted: %v", importer.Extensions)})
+		return
+	}
 
 	// Save uploaded file
 	// Ensure upload dir exists
@@ -36,25 +37,12 @@ func UploadEPUB(c *gin.Context) {
 		os.Mkdir(uploadDir, 0755)
 	}
 
-	dst := filepath.Join(uploadDir, file.Filename)
+	dst := filepath.Join(uploadDir, filepath.Base(file.Filename))
 	if err := c.SaveUploadedFile(file, dst); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
 		return
 	}
 
-	// Parse EPUB
-	reader, err := epub.NewReader(dst)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to parse EPUB: %v", err)})
-		return
-	}
-
-	chapters, err := reader.GetChapters()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to extract chapters: %v", err)})
-		return
-	}
-
 	// Calculate MD5 of the file to use as BookID
 	f, err := os.Open(dst)
 	if err != nil {
@@ -71,32 +59,103 @@ func UploadEPUB(c *gin.Context) {
 
 	bookID := hex.EncodeToString(hasher.Sum(nil))
 
-	// Load existing data for this book if available
-	if err := Store.Load(bookID); err != nil {
-		fmt.Printf("Warning: Failed to load store for book %s: %v\n", bookID, err)
+	// Each book keeps its file in its own folder, so books uploaded under
+	// the same file name don't overwrite each other
+	bookPath := filepath.Join(uploadDir, bookID, filepath.Base(file.Filename))
+	if err := os.MkdirAll(filepath.Dir(bookPath), 0755); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
+	}
+	os.Remove(bookPath) // Rename won't replace an earlier upload on Windows
+	if err := os.Rename(dst, bookPath); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+		return
 	}
 
-	// Update Store
-	Store.Mu.Lock()
-	Store.CurrentBookPath = dst
-	Store.Chapters = chapters // Store chapters in struct too
-	// Note: Analysis, VoiceMapping are preserved if loaded, or empty if new
-	Store.Mu.Unlock()
+	// Parse the book into chapters
+	cfg := config.Get()
+	chapters, err := importer.Import(bookPath, importer.Options{
+		ChapterPatterns: cfg.ChapterPatterns,
+		FootnotesAtEnd:  cfg.FootnotesAtEnd,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to extract chapters: %v", err)})
+		return
+	}
 
-	// Also keep the global singleton for now if legacy code uses it (CurrentBookPath var in upload.go)
-	CurrentBookPath = dst // This local var might be redundant now, but keep for safety
-	LoadedChapters["current"] = chapters
+	meta, coverPath := readBookInfo(bookID, bookPath)
+
+	// Open the book, loading existing data for it if available.
+	// Other books stay in the library, and jobs running on them continue.
+	book, err := Books.Create(bookID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	book.Mu.Lock()
+	chapters, kept := reconcileChapters(book.Chapters, chapters, len(book.Analysis) > 0)
+	if kept {
+		log.Printf("[Upload] Book %s now splits into different chapters; keeping the saved ones so its analysis and audio stay attached", bookID)
+	}
+	book.CurrentBookPath = bookPath
+	book.Chapters = chapters
+	book.Metadata = meta
+	book.CoverPath = coverPath
+	// Note: Analysis, VoiceMapping are preserved if loaded, or empty if new
 
 	// Persist immediately to ensure BookID is saved/file created
-	if err := Store.Save(); err != nil {
+	if err := book.Save(); err != nil {
 		fmt.Printf("Warning: Failed to save initial store: %v\n", err)
 	}
+	book.Mu.Unlock()
 
 	// Return stripped chapters (maybe without full content to save bandwidth?)
 	// For now return full.
 	c.JSON(http.StatusOK, gin.H{
 		"message":  "Upload successful",
+		"bookId":   bookID,
 		"chapters": chapters,
-		"bookPath": dst,
+		"kept":     kept,
+		"bookPath": bookPath,
+		"metadata": meta,
 	})
 }
+
+// reconcileChapters picks the chapters for a re-uploaded book. Analysis,
+// timings and audio are keyed by chapter ID, and the importer hasn't always
+// split books the same way (EPUBs once had a chapter per spine document), so
+// a fresh parse may give a saved ID to different text. When the book has
+// been analysed and the fresh chapters don't match the saved ones ID for ID
+// and text for text, the saved chapters are kept, taking hrefs, matter and
+// readings from any fresh chapter with the same text. kept reports that.
+func reconcileChapters(saved, fresh []epub.Chapter, analysed bool) (chapters []epub.Chapter, kept bool) {
+	if len(saved) == 0 || !analysed {
+		return fresh, false
+	}
+	if len(saved) == len(fresh) {
+		same := true
+		for i := range saved {
+			if saved[i].ID != fresh[i].ID || stripSpace(saved[i].Content) != stripSpace(fresh[i].Content) {
+				same = false
+				break
+			}
+		}
+		if same {
+			return fresh, false
+		}
+	}
+
+	byText := make(map[string]epub.Chapter, len(fresh))
+	for _, ch := range fresh {
+		byText[stripSpace(ch.Content)] = ch
+	}
+	chapters = make([]epub.Chapter, len(saved))
+	for i, ch := range saved {
+		if f, ok := byText[stripSpace(ch.Content)]; ok {
+			ch.Hrefs, ch.Matter, ch.Readings = f.Hrefs, f.Matter, f.Readings
+		}
+		chapters[i] = ch
+	}
+	return chapters, true
+}
@@ -0,0 +1,85 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUpload_KeepsAnalysedChapters(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	type uploaded struct {
+		BookID   string         `json:"bookId"`
+		Chapters []epub.Chapter `json:"chapters"`
+		Kept     bool           `json:"kept"`
+	}
+	upload := func() uploaded {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", "book.txt")
+		fw.Write([]byte("第一章 开始\n他笑了。\n\n第二章 结束\n她哭了。\n"))
+		mw.Close()
+		req, _ := http.NewRequest("POST", "/api/upload", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload: status %d, body %s", w.Code, w.Body.String())
+		}
+		var resp uploaded
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	current := api.Store
+	first := upload()
+	t.Cleanup(func() {
+		api.Books.Delete(first.BookID)
+		api.Store = current
+	})
+	if len(first.Chapters) != 2 || first.Kept {
+		t.Fatalf("first upload = %+v", first)
+	}
+
+	// Imported under an earlier chapter scheme: the same ID for other text
+	old := []epub.Chapter{{ID: first.Chapters[0].ID, Title: "Chapter 1", Content: "他笑了。她哭了。"}}
+	book, _ := api.Books.Get(first.BookID)
+	book.Mu.Lock()
+	book.Chapters = old
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	book.Mu.Unlock()
+
+	// Nothing hangs off the old chapters yet, so the fresh ones replace them
+	if again := upload(); again.Kept || len(again.Chapters) != 2 {
+		t.Errorf("re-upload before analysis = %+v", again)
+	}
+
+	book.Mu.Lock()
+	book.Chapters = old
+	book.Analysis[old[0].ID] = []llm.AnalysisResult{{Text: "他笑了。她哭了。", Speaker: "Narrator"}}
+	if err := book.Save(); err != nil {
+		t.Fatal(err)
+	}
+	book.Mu.Unlock()
+
+	again := upload()
+	if !again.Kept || len(again.Chapters) != 1 || again.Chapters[0].Content != old[0].Content {
+		t.Errorf("re-upload after analysis = %+v, want the saved chapters", again)
+	}
+}
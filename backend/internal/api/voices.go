@@ -1,18 +1,36 @@
 package api
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"time"
+	"tts-book/backend/internal/audio"
 	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/voices"
 
 	"github.com/gin-gonic/gin"
 )
 
-// UploadVoice handles uploading a reference audio file
+// VoiceUsage lists the characters of a book that use a voice
+type VoiceUsage struct {
+	BookID     string   `json:"bookId"`
+	Name       string   `json:"name"`
+	Characters []string `json:"characters"`
+}
+
+func voiceLibrary() voices.Library {
+	return voices.Library{Dirs: config.Get().VoiceLibrary()}
+}
+
+// UploadVoice adds a reference audio file to the voice library. The form
+// may describe it with name, gender, age, language, description, license,
+// tags (comma separated) and dir, the library directory to save it in.
 func UploadVoice(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -20,89 +38,256 @@ func UploadVoice(c *gin.Context) {
 		return
 	}
 
-	// Create voices directory if not exists
-	uploadDir := "data/voices"
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create directory"})
+	meta := voices.Metadata{
+		Name:        c.PostForm("name"),
+		Gender:      c.PostForm("gender"),
+		Age:         c.PostForm("age"),
+		Language:    c.PostForm("language"),
+		Description: c.PostForm("description"),
+		License:     c.PostForm("license"),
+	}
+	if tags := c.PostForm("tags"); tags != "" {
+		meta.Tags = strings.Split(tags, ",")
+	}
+	if err := checkVoiceMetadata(meta); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return
+	}
+	defer src.Close()
+
+	voice, err := voiceLibrary().Add(c.PostForm("dir"), file.Filename, src, meta)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"voice": voice})
+}
+
+// UpdateVoice replaces the name, tags and other details of the voice at
+// ?path
+func UpdateVoice(c *gin.Context) {
+	var meta voices.Metadata
+	if err := c.ShouldBindJSON(&meta); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := checkVoiceMetadata(meta); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Generate unique filename to avoid collision
-	ext := filepath.Ext(file.Filename)
-	filename := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-	dst := filepath.Join(uploadDir, filename)
+	voice, err := voiceLibrary().Update(c.Query("path"), meta)
+	if errors.Is(err, voices.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Voice not found in the library"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"voice": voice})
+}
+
+// DeleteVoice removes the voice at ?path from the library. A voice that
+// characters use is only removed with ?force=true.
+func DeleteVoice(c *gin.Context) {
+	lib := voiceLibrary()
+	voice, err := lib.Get(c.Query("path"))
+	if errors.Is(err, voices.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Voice not found in the library"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	usage, err := voiceUsage(voice.Path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if len(usage) > 0 && c.Query("force") != "true" {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "The voice is in use. Retry with force=true to delete it anyway.",
+			"usage": usage,
+		})
+		return
+	}
+
+	if err := lib.Delete(voice.Path); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Voice deleted", "usage": usage})
+}
+
+// PrepareVoiceRequest picks the part of a voice to prepare. Times are in
+// seconds; zero values use the defaults.
+type PrepareVoiceRequest struct {
+	Start     float64 `json:"start"`
+	End       float64 `json:"end"`       // 0 keeps the rest of the file
+	MaxLength float64 `json:"maxLength"` // Default 15
+}
+
+// PrepareVoice makes a clean reference clip of the voice at ?path: mono,
+// resampled for the engine, trimmed of silence, cut to the requested range
+// and length, and normalised. The clip is stored next to the voice, and
+// characters using the voice are synthesised from it from then on.
+func PrepareVoice(c *gin.Context) {
+	var req PrepareVoiceRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
-	if err := c.SaveUploadedFile(file, dst); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file"})
+	voice, err := voiceLibrary().Get(c.Query("path"))
+	if errors.Is(err, voices.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Voice not found in the library"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Return absolute path so TTS can use it locally
-	absPath, err := filepath.Abs(dst)
+	cfg := config.Get()
+	opts := audio.PrepareOptions{
+		SampleRate: cfg.VoiceSampleRate,
+		Start:      seconds(req.Start),
+		End:        seconds(req.End),
+		MaxLength:  seconds(req.MaxLength),
+	}
+	prepared := voices.PreparedPath(voice.Path)
+	res, err := audio.PrepareVoice(c.Request.Context(), cfg.EncoderPath, voice.Path, prepared, opts)
 	if err != nil {
-		// Fallback to relative
-		absPath = dst
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
+	log.Printf("[Voices] Prepared %s: %.1fs clip from %.1fs of audio", filepath.Base(voice.Path), res.Length.Seconds(), res.SourceLength.Seconds())
 
+	voice.Prepared = prepared
 	c.JSON(http.StatusOK, gin.H{
-		"path":     absPath,
-		"filename": filename,
+		"voice":        voice,
+		"sourceLength": res.SourceLength.Seconds(),
+		"length":       res.Length.Seconds(),
+		"truncated":    res.Truncated,
 	})
 }
 
-// GetVoicesFromDir returns a list of audio files in the directory
-func GetVoicesFromDir(dirPath string) ([]string, error) {
-	var voices []string
-	entries, err := os.ReadDir(dirPath)
+// DiscardPreparedVoice removes the prepared clip of the voice at ?path, so
+// the original file is used again
+func DiscardPreparedVoice(c *gin.Context) {
+	voice, err := voiceLibrary().Get(c.Query("path"))
+	if errors.Is(err, voices.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Voice not found in the library"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if voice.Prepared == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "The voice has no prepared clip"})
+		return
+	}
+	if err := os.Remove(voice.Prepared); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	voice.Prepared = ""
+	c.JSON(http.StatusOK, gin.H{"voice": voice})
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func checkVoiceMetadata(meta voices.Metadata) error {
+	if !validGenders[meta.Gender] {
+		return fmt.Errorf("Invalid gender %q", meta.Gender)
+	}
+	if !validAges[meta.Age] {
+		return fmt.Errorf("Invalid age %q", meta.Age)
+	}
+	return nil
+}
+
+// voiceUsage returns the books whose characters use the voice at path
+func voiceUsage(path string) ([]VoiceUsage, error) {
+	db, err := projects()
+	if err != nil {
+		return nil, err
+	}
+	books, err := db.ListBooks()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, e := range entries {
-		if e.IsDir() {
-			continue
+	usage := []VoiceUsage{}
+	for _, b := range books {
+		p, err := db.Load(b.ID)
+		if err != nil {
+			return nil, err
 		}
-		ext := strings.ToLower(filepath.Ext(e.Name()))
-		if ext == ".wav" || ext == ".mp3" || ext == ".ogg" || ext == ".flac" {
-			voices = append(voices, filepath.Join(dirPath, e.Name()))
+		var characters []string
+		for name, v := range p.Voices {
+			if sameFile(v.VoiceID, path) {
+				characters = append(characters, name)
+			}
+		}
+		if len(characters) > 0 {
+			slices.Sort(characters)
+			usage = append(usage, VoiceUsage{BookID: b.ID, Name: newBookSummary(b).Name, Characters: characters})
 		}
 	}
-	return voices, nil
+	return usage, nil
 }
 
-// ListConfiguredVoices returns the list of voices in the configured directory
-func ListConfiguredVoices(cfg *config.Config) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if cfg.VoiceDir == "" {
-			c.JSON(http.StatusOK, gin.H{"voices": []string{}})
-			return
-		}
+// sameFile reports whether two paths, absolute or relative, name the same file
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
 
-		voices, err := GetVoicesFromDir(cfg.VoiceDir)
-		if err != nil {
-			// Don't fail hard, just return empty and maybe log
-			// For user feedback, maybe we should return error
-			c.JSON(http.StatusOK, gin.H{"voices": []string{}, "error": err.Error()})
-			return
-		}
+// ListConfiguredVoices returns the voices in the library. They can be
+// filtered by ?q (name, description or file name), ?gender, ?age,
+// ?language and ?tag, which may be repeated.
+func ListConfiguredVoices(c *gin.Context) {
+	cfg := config.Get()
+	list, err := voices.Library{Dirs: cfg.VoiceLibrary()}.List()
+	if err != nil {
+		// Don't fail hard; the voice pickers still work with no voices
+		c.JSON(http.StatusOK, gin.H{"voices": []voices.Voice{}, "error": err.Error()})
+		return
+	}
 
-		type VoiceOption struct {
-			Name string `json:"name"`
-			Path string `json:"path"`
-		}
-		var options []VoiceOption
-		for _, v := range voices {
-			absPath, err := filepath.Abs(v)
-			if err != nil {
-				absPath = v // Fallback
-			}
-			options = append(options, VoiceOption{
-				Name: filepath.Base(v),
-				Path: absPath,
-			})
+	q := voices.Query{
+		Text:     c.Query("q"),
+		Gender:   c.Query("gender"),
+		Age:      c.Query("age"),
+		Language: c.Query("language"),
+		Tags:     c.QueryArray("tag"),
+	}
+	matched := []voices.Voice{}
+	for _, v := range list {
+		if q.Match(v) {
+			matched = append(matched, v)
 		}
-		c.JSON(http.StatusOK, gin.H{"voices": options})
 	}
+	c.JSON(http.StatusOK, gin.H{"voices": matched, "dirs": cfg.VoiceLibrary()})
 }
 
 // PreviewVoice serves the audio file for preview
@@ -113,15 +298,21 @@ func PreviewVoice(c *gin.Context) {
 		return
 	}
 
-	// Basic security check: ensure it exists and is a file
-	info, err := os.Stat(path)
-	if os.IsNotExist(err) || info.IsDir() {
-		fmt.Printf("[PreviewVoice] File not found or is dir: %s\n", path)
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+	// Only voices in the library and their prepared clips are served
+	voicePath, prepared := strings.CutSuffix(path, voices.PreparedSuffix)
+	voice, err := voiceLibrary().Get(voicePath)
+	if errors.Is(err, voices.ErrNotFound) || (err == nil && prepared && voice.Prepared == "") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Voice not found in the library"})
 		return
 	}
-
-	fmt.Printf("[PreviewVoice] Serving file: %s\n", path)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	path = voice.Path
+	if prepared {
+		path = voice.Prepared
+	}
 
 	// Set content type
 	ext := strings.ToLower(filepath.Ext(path))
@@ -0,0 +1,194 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"tts-book/backend/internal/api"
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/tts"
+	"tts-book/backend/internal/voices"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestVoiceLibrary(t *testing.T) {
+	cfg := config.Get()
+	oldVoiceDirs := cfg.VoiceDirs
+	cfg.VoiceDirs = []string{t.TempDir(), t.TempDir()}
+	defer func() { cfg.VoiceDirs = oldVoiceDirs }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	upload := func(filename string, fields map[string]string) voices.Voice {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		fw, _ := mw.CreateFormFile("file", filename)
+		fw.Write([]byte("voice"))
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		mw.Close()
+		req, _ := http.NewRequest("POST", "/api/voices", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("upload %s: status %d, body %s", filename, w.Code, w.Body.String())
+		}
+		var resp struct {
+			Voice voices.Voice `json:"voice"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Voice
+	}
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	list := func(query string) []voices.Voice {
+		w := do("GET", "/api/voices/list?"+query, nil)
+		var resp struct {
+			Voices []voices.Voice `json:"voices"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Voices
+	}
+
+	hero := upload("hero.wav", map[string]string{"name": "Hero", "gender": "male", "tags": "deep, villain"})
+	narrator := upload("narrator.mp3", map[string]string{"language": "zh", "dir": cfg.VoiceDirs[1]})
+	if hero.Dir != cfg.VoiceDirs[0] || narrator.Dir != cfg.VoiceDirs[1] || narrator.Name != "narrator" {
+		t.Errorf("uploaded voices = %+v, %+v", hero, narrator)
+	}
+
+	if got := list(""); len(got) != 2 {
+		t.Errorf("list = %+v, want both voices", got)
+	}
+	if got := list("tag=villain&gender=male"); len(got) != 1 || got[0].Path != hero.Path {
+		t.Errorf("list by tag = %+v", got)
+	}
+	if got := list("q=narr"); len(got) != 1 || got[0].Path != narrator.Path {
+		t.Errorf("list by text = %+v", got)
+	}
+
+	// Rename and retag
+	path := url.QueryEscape(hero.Path)
+	if w := do("PUT", "/api/voices?path="+path, gin.H{"name": "Captain", "gender": "male", "tags": []string{"calm"}}); w.Code != http.StatusOK {
+		t.Fatalf("update: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := list("tag=calm"); len(got) != 1 || got[0].Name != "Captain" {
+		t.Errorf("list after update = %+v", got)
+	}
+
+	// A voice a character uses is only deleted when forced
+	mockBookID := "mock_book_voices"
+	api.Store.Mu.Lock()
+	api.Store.BookID = mockBookID
+	api.Store.DetectedCharacters["Captain"] = true
+	api.Store.VoiceMapping["Captain"] = api.VoiceConfig{VoiceID: hero.Path}
+	if err := api.Store.Save(); err != nil {
+		t.Fatal(err)
+	}
+	api.Store.Mu.Unlock()
+	defer api.Books.Delete(mockBookID)
+
+	w := do("DELETE", "/api/voices?path="+path, nil)
+	var conflict struct {
+		Usage []api.VoiceUsage `json:"usage"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &conflict)
+	if w.Code != http.StatusConflict || len(conflict.Usage) != 1 || conflict.Usage[0].BookID != mockBookID {
+		t.Fatalf("delete used voice: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", "/api/voices?path="+path+"&force=true", nil); w.Code != http.StatusOK {
+		t.Fatalf("forced delete: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := list(""); len(got) != 1 {
+		t.Errorf("list after delete = %+v", got)
+	}
+}
+
+func TestPrepareVoice(t *testing.T) {
+	cfg := config.Get()
+	oldVoiceDirs := cfg.VoiceDirs
+	cfg.VoiceDirs = []string{t.TempDir()}
+	defer func() { cfg.VoiceDirs = oldVoiceDirs }()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	api.SetupRoutes(r, nil)
+
+	lib := voices.Library{Dirs: cfg.VoiceDirs}
+	voice, err := lib.Add("", "tone.wav", bytes.NewReader(tts.MockAudio("a reference line to clone", "v", "calm")), voices.Metadata{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := url.QueryEscape(voice.Path)
+
+	do := func(method, path string, body any) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		if body != nil {
+			json.NewEncoder(&buf).Encode(body)
+		}
+		req, _ := http.NewRequest(method, path, &buf)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("POST", "/api/voices/prepare?path="+path, gin.H{"start": 2, "end": 1}); w.Code != http.StatusBadRequest {
+		t.Errorf("end before start: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	w := do("POST", "/api/voices/prepare?path="+path, gin.H{"start": 0.5, "end": 1.5})
+	var resp struct {
+		Voice  voices.Voice `json:"voice"`
+		Length float64      `json:"length"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &resp)
+	if w.Code != http.StatusOK || resp.Voice.Prepared != voices.PreparedPath(voice.Path) || resp.Length > 1.01 {
+		t.Fatalf("prepare: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := voices.Reference(voice.Path); got != resp.Voice.Prepared {
+		t.Errorf("Reference() = %s, want the prepared clip", got)
+	}
+	if list, _ := lib.List(); len(list) != 1 || list[0].Prepared == "" {
+		t.Errorf("list after prepare = %+v", list)
+	}
+
+	// Previews serve the voice and its clip, but nothing outside the library
+	for _, p := range []string{voice.Path, resp.Voice.Prepared} {
+		if w := do("GET", "/api/voices/preview?path="+url.QueryEscape(p), nil); w.Code != http.StatusOK {
+			t.Errorf("preview %s: status %d", p, w.Code)
+		}
+	}
+	outside := filepath.Join(t.TempDir(), "outside.wav")
+	os.WriteFile(outside, []byte("voice"), 0644)
+	if w := do("GET", "/api/voices/preview?path="+url.QueryEscape(outside), nil); w.Code != http.StatusNotFound {
+		t.Errorf("preview outside the library: status %d, want 404", w.Code)
+	}
+
+	if w := do("DELETE", "/api/voices/prepare?path="+path, nil); w.Code != http.StatusOK {
+		t.Fatalf("discard: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := voices.Reference(voice.Path); got != voice.Path {
+		t.Errorf("Reference() after discard = %s", got)
+	}
+}
@@ -20,6 +20,8 @@ type ProgressMessage struct {
 	Percentage int    `json:"percentage"`
 	Message    string `json:"message"`
 	ChapterID  string `json:"chapterId"`
+	JobID      string `json:"jobId,omitempty"`
+	BookID     string `json:"bookId,omitempty"`
 }
 
 type Hub struct {
@@ -78,10 +80,11 @@ func BroadcastProgress(chapterID string, percent int, msg string) {
 }
 
 // Helper to broadcast LLM token
-func BroadcastLLMOutput(chapterID string, token string) {
+func BroadcastLLMOutput(bookID, chapterID string, token string) {
 	GlobalHub.broadcast <- ProgressMessage{
 		Type:      "llm_output",
 		Message:   token,
 		ChapterID: chapterID,
+		BookID:    bookID,
 	}
 }
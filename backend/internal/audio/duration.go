@@ -0,0 +1,103 @@
+package audio
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Duration returns the playing time of an audio file. WAV files are measured
+// from their header; other formats are probed with the ffprobe that sits next
+// to encoderPath.
+func Duration(encoderPath, path string) (time.Duration, error) {
+	if strings.EqualFold(filepath.Ext(path), ".wav") {
+		return WavDuration(path)
+	}
+
+	out, err := exec.Command(probePath(encoderPath),
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		path,
+	).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to probe %s: %w", path, err)
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse duration of %s: %w", path, err)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// maxFmtChunk bounds the fmt chunk WavDuration reads; the largest standard
+// one, WAVE_FORMAT_EXTENSIBLE, is 40 bytes
+const maxFmtChunk = 1024
+
+// WavDuration computes a WAV file's duration from its fmt and data chunks.
+func WavDuration(path string) (time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	riffHeader := make([]byte, 12)
+	if _, err := io.ReadFull(f, riffHeader); err != nil {
+		return 0, fmt.Errorf("failed to read RIFF header: %w", err)
+	}
+	if string(riffHeader[0:4]) != "RIFF" || string(riffHeader[8:12]) != "WAVE" {
+		return 0, fmt.Errorf("invalid WAV file format")
+	}
+
+	var byteRate uint32
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			return 0, fmt.Errorf("data chunk not found: %w", err)
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := binary.LittleEndian.Uint32(chunkHeader[4:8])
+
+		switch chunkID {
+		case "fmt ":
+			if chunkSize < 16 || chunkSize > maxFmtChunk {
+				return 0, fmt.Errorf("invalid fmt chunk")
+			}
+			fmtData := make([]byte, chunkSize)
+			if _, err := io.ReadFull(f, fmtData); err != nil {
+				return 0, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			byteRate = binary.LittleEndian.Uint32(fmtData[8:12])
+		case "data":
+			if byteRate == 0 {
+				return 0, fmt.Errorf("fmt chunk missing or invalid")
+			}
+			return time.Duration(float64(chunkSize) / float64(byteRate) * float64(time.Second)), nil
+		default:
+			if _, err := f.Seek(int64(chunkSize), io.SeekCurrent); err != nil {
+				return 0, err
+			}
+		}
+	}
+}
+
+// probePath derives the ffprobe binary from the configured ffmpeg path,
+// e.g. /opt/ffmpeg/bin/ffmpeg.exe -> /opt/ffmpeg/bin/ffprobe.exe.
+func probePath(encoderPath string) string {
+	if encoderPath == "" {
+		return "ffprobe"
+	}
+	dir, base := filepath.Split(encoderPath)
+	ext := filepath.Ext(base)
+	if !strings.EqualFold(strings.TrimSuffix(base, ext), "ffmpeg") {
+		return "ffprobe"
+	}
+	return dir + "ffprobe" + ext
+}
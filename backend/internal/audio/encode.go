@@ -0,0 +1,113 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Format is a container/codec chapter audio can be written in.
+type Format struct {
+	Name        string // Config value, e.g. "mp3"
+	Ext         string // File extension including the dot
+	MIME        string
+	codec       string // ffmpeg encoder; empty for WAV, which needs no encoding
+	defaultKbps int
+}
+
+var formats = []Format{
+	{Name: "wav", Ext: ".wav", MIME: "audio/wav"},
+	{Name: "mp3", Ext: ".mp3", MIME: "audio/mpeg", codec: "libmp3lame", defaultKbps: 128},
+	{Name: "opus", Ext: ".opus", MIME: "audio/ogg", codec: "libopus", defaultKbps: 48},
+	{Name: "aac", Ext: ".m4a", MIME: "audio/mp4", codec: "aac", defaultKbps: 96},
+}
+
+// Formats lists the supported output formats, WAV first.
+func Formats() []Format {
+	return append([]Format(nil), formats...)
+}
+
+// LookupFormat returns the format with the given name. An empty name is WAV.
+func LookupFormat(name string) (Format, error) {
+	if name == "" {
+		return formats[0], nil
+	}
+	for _, f := range formats {
+		if f.Name == strings.ToLower(name) {
+			return f, nil
+		}
+	}
+	return Format{}, fmt.Errorf("unsupported output format %q", name)
+}
+
+// NeedsEncoder reports whether producing f requires the external encoder.
+func (f Format) NeedsEncoder() bool {
+	return f.codec != ""
+}
+
+// Bitrate returns kbps, or the format's default when kbps is not positive.
+func (f Format) Bitrate(kbps int) int {
+	if kbps <= 0 {
+		return f.defaultKbps
+	}
+	return kbps
+}
+
+// Label describes the format and bitrate, e.g. "mp3@128k" or "wav".
+func (f Format) Label(kbps int) string {
+	if !f.NeedsEncoder() {
+		return f.Name
+	}
+	return fmt.Sprintf("%s@%dk", f.Name, f.Bitrate(kbps))
+}
+
+// CheckEncoder reports whether the encoder binary can be found.
+func CheckEncoder(encoderPath string) error {
+	if encoderPath == "" {
+		encoderPath = "ffmpeg"
+	}
+	if _, err := exec.LookPath(encoderPath); err != nil {
+		return fmt.Errorf("encoder %q not found; install ffmpeg or set encoder_path: %w", encoderPath, err)
+	}
+	return nil
+}
+
+// Encode converts a WAV file to f with an ffmpeg-compatible encoder binary
+// (encoderPath, default "ffmpeg"). The output is written to a temp file and
+// renamed, so a failed encode never leaves a partial file at outputPath.
+func Encode(ctx context.Context, encoderPath, inputPath, outputPath string, f Format, kbps int) error {
+	if !f.NeedsEncoder() {
+		return fmt.Errorf("format %s does not need encoding", f.Name)
+	}
+	if err := CheckEncoder(encoderPath); err != nil {
+		return err
+	}
+	if encoderPath == "" {
+		encoderPath = "ffmpeg"
+	}
+
+	// Keep the extension so the encoder picks the right container
+	tmp := strings.TrimSuffix(outputPath, f.Ext) + ".tmp" + f.Ext
+	defer os.Remove(tmp)
+
+	cmd := exec.CommandContext(ctx, encoderPath,
+		"-y", "-hide_banner", "-loglevel", "error",
+		"-i", inputPath,
+		"-c:a", f.codec,
+		"-b:a", fmt.Sprintf("%dk", f.Bitrate(kbps)),
+		tmp,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("encoding to %s failed: %w: %s", f.Name, err, strings.TrimSpace(stderr.String()))
+	}
+
+	if err := os.Rename(tmp, outputPath); err != nil {
+		return fmt.Errorf("failed to move encoded file: %w", err)
+	}
+	return nil
+}
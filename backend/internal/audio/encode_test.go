@@ -0,0 +1,57 @@
+package audio
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeEncoder writes a script that copies ffmpeg's -i input to its last
+// argument, standing in for ffmpeg.
+func fakeEncoder(t *testing.T) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake encoder needs a POSIX shell")
+	}
+	path := filepath.Join(t.TempDir(), "fake-ffmpeg")
+	script := "#!/bin/sh\nwhile [ $# -gt 1 ]; do [ \"$1\" = -i ] && in=$2; shift; done\ncp \"$in\" \"$1\"\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestEncode(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.wav")
+	if err := os.WriteFile(input, []byte("RIFF-data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	mp3, err := LookupFormat("MP3")
+	if err != nil {
+		t.Fatalf("LookupFormat() error = %v", err)
+	}
+	if mp3.Label(0) != "mp3@128k" || mp3.Label(64) != "mp3@64k" {
+		t.Errorf("Label() = %s, %s", mp3.Label(0), mp3.Label(64))
+	}
+
+	output := filepath.Join(dir, "out.mp3")
+	if err := Encode(context.Background(), fakeEncoder(t), input, output, mp3, 0); err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "RIFF-data" {
+		t.Errorf("encoded output = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.tmp.mp3")); !os.IsNotExist(err) {
+		t.Error("temp file left behind")
+	}
+
+	if err := Encode(context.Background(), filepath.Join(dir, "missing"), input, output, mp3, 0); err == nil {
+		t.Error("Encode() succeeded with a missing encoder")
+	}
+	if _, err := LookupFormat("flac"); err == nil {
+		t.Error("LookupFormat() accepted an unsupported format")
+	}
+}
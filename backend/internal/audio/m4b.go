@@ -0,0 +1,200 @@
+package audio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BookChapter is one chapter file to include in an audiobook
+type BookChapter struct {
+	Title string
+	Path  string
+}
+
+// BookMetadata is written into the audiobook's tags
+type BookMetadata struct {
+	Title       string
+	Authors     []string
+	Narrators   []string
+	Language    string
+	Date        string
+	Description string
+	Series      string // Written as the grouping, which players show as the series
+	SeriesIndex string
+	CoverPath   string // JPEG or PNG; optional
+}
+
+// ExportM4B concatenates chapter files into a single M4B (AAC in MP4) with a
+// chapter marker per input and the given metadata, using the ffmpeg at
+// encoderPath. The inputs may differ in format, rate and channels. onProgress, if not nil, receives the encoded position and the
+// total duration.
+func ExportM4B(ctx context.Context, encoderPath string, chapters []BookChapter, outputPath string, meta BookMetadata, kbps int, onProgress func(done, total time.Duration)) error {
+	if len(chapters) == 0 {
+		return fmt.Errorf("no chapters to export")
+	}
+	if err := CheckEncoder(encoderPath); err != nil {
+		return err
+	}
+	if encoderPath == "" {
+		encoderPath = "ffmpeg"
+	}
+
+	workDir, err := os.MkdirTemp(filepath.Dir(outputPath), ".m4b-")
+	if err != nil {
+		return fmt.Errorf("failed to create work dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	// Chapter boundaries come from each input's duration
+	durations := make([]time.Duration, len(chapters))
+	var total time.Duration
+	for i, ch := range chapters {
+		d, err := Duration(encoderPath, ch.Path)
+		if err != nil {
+			return err
+		}
+		durations[i] = d
+		total += d
+	}
+
+	filterPath := filepath.Join(workDir, "filter.txt")
+	if err := os.WriteFile(filterPath, []byte(concatFilter(len(chapters))), 0644); err != nil {
+		return err
+	}
+	metaPath := filepath.Join(workDir, "metadata.txt")
+	if err := os.WriteFile(metaPath, []byte(ffMetadata(chapters, durations, meta)), 0644); err != nil {
+		return err
+	}
+
+	aac, _ := LookupFormat("aac")
+	tmp := filepath.Join(workDir, "book.m4b")
+	args := []string{"-y", "-hide_banner", "-loglevel", "error", "-nostats", "-progress", "pipe:1"}
+	for _, ch := range chapters {
+		args = append(args, "-i", ch.Path)
+	}
+	metaInput := len(chapters)
+	args = append(args, "-i", metaPath)
+	if meta.CoverPath != "" {
+		args = append(args, "-i", meta.CoverPath)
+	}
+	args = append(args,
+		"-filter_complex_script", filterPath, "-map", "[out]",
+		"-map_metadata", strconv.Itoa(metaInput), "-map_chapters", strconv.Itoa(metaInput),
+	)
+	if meta.CoverPath != "" {
+		args = append(args, "-map", fmt.Sprintf("%d:v", metaInput+1), "-c:v", "copy", "-disposition:v:0", "attached_pic")
+	}
+	args = append(args,
+		"-c:a", aac.codec, "-b:a", fmt.Sprintf("%dk", aac.Bitrate(kbps)),
+		"-movflags", "+faststart",
+		"-f", "mp4", tmp,
+	)
+
+	cmd := exec.CommandContext(ctx, encoderPath, args...)
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start encoder: %w", err)
+	}
+
+	// -progress writes key=value lines; out_time_us is the encoded position
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if v, ok := strings.CutPrefix(line, "out_time_us="); ok && onProgress != nil {
+			if us, err := strconv.ParseInt(v, 10, 64); err == nil {
+				onProgress(time.Duration(us)*time.Microsecond, total)
+			}
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("m4b export failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if err := os.Rename(tmp, outputPath); err != nil {
+		return fmt.Errorf("failed to move exported file: %w", err)
+	}
+	return nil
+}
+
+// m4bSampleRate is the rate every chapter is resampled to before joining
+const m4bSampleRate = 44100
+
+// concatFilter is an ffmpeg filter graph joining the audio of the first n
+// inputs into [out]. Each is converted to one sample format, rate and mono
+// first, as the concat filter needs its inputs to match.
+func concatFilter(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "[%d:a]aresample=%d,aformat=sample_fmts=fltp:channel_layouts=mono[a%d];\n", i, m4bSampleRate, i)
+	}
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "[a%d]", i)
+	}
+	fmt.Fprintf(&sb, "concat=n=%d:v=0:a=1[out]\n", n)
+	return sb.String()
+}
+
+// ffMetadata renders tags and chapters in ffmpeg's FFMETADATA1 format.
+func ffMetadata(chapters []BookChapter, durations []time.Duration, meta BookMetadata) string {
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+
+	tag := func(key, value string) {
+		if value != "" {
+			fmt.Fprintf(&sb, "%s=%s\n", key, escapeMetadata(value))
+		}
+	}
+	authors := strings.Join(meta.Authors, ", ")
+	tag("title", meta.Title)
+	tag("album", meta.Title)
+	tag("artist", authors)
+	tag("album_artist", authors)
+	tag("composer", strings.Join(meta.Narrators, ", ")) // Audiobook players show composer as narrator
+	tag("language", meta.Language)
+	tag("genre", "Audiobook")
+	tag("date", meta.Date)
+	tag("description", meta.Description)
+	tag("comment", meta.Description)
+	if meta.Series != "" {
+		series := meta.Series
+		if meta.SeriesIndex != "" {
+			series += " #" + meta.SeriesIndex
+		}
+		tag("grouping", series)
+	}
+
+	var start time.Duration
+	for i, ch := range chapters {
+		end := start + durations[i]
+		sb.WriteString("\n[CHAPTER]\nTIMEBASE=1/1000\n")
+		fmt.Fprintf(&sb, "START=%d\nEND=%d\n", start.Milliseconds(), end.Milliseconds())
+		tag("title", ch.Title)
+		start = end
+	}
+	return sb.String()
+}
+
+// escapeMetadata escapes the characters FFMETADATA treats specially.
+func escapeMetadata(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '=', ';', '#', '\\', '\n':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
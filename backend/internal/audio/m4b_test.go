@@ -0,0 +1,90 @@
+package audio
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSilence writes a 16-bit mono WAV of the given length
+func writeSilence(t *testing.T, path string, sampleRate int, d time.Duration) {
+	t.Helper()
+	dataSize := int(d.Seconds()*float64(sampleRate)) * 2
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+dataSize))
+	copy(buf[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], 1)
+	binary.LittleEndian.PutUint16(buf[22:], 1)
+	binary.LittleEndian.PutUint32(buf[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(sampleRate*2))
+	binary.LittleEndian.PutUint16(buf[32:], 2)
+	binary.LittleEndian.PutUint16(buf[34:], 16)
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(dataSize))
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWavDuration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.wav")
+	writeSilence(t, path, 22050, 1500*time.Millisecond)
+
+	d, err := WavDuration(path)
+	if err != nil {
+		t.Fatalf("WavDuration() error = %v", err)
+	}
+	if d != 1500*time.Millisecond {
+		t.Errorf("WavDuration() = %v, want 1.5s", d)
+	}
+}
+
+func TestWavDuration_BadFmtChunk(t *testing.T) {
+	for name, size := range map[string]uint32{"short": 8, "huge": 0xFFFFFFF0} {
+		path := filepath.Join(t.TempDir(), name+".wav")
+		writeSilence(t, path, 22050, time.Second)
+		data, _ := os.ReadFile(path)
+		binary.LittleEndian.PutUint32(data[16:], size)
+		os.WriteFile(path, data, 0644)
+
+		if _, err := WavDuration(path); err == nil {
+			t.Errorf("%s fmt chunk: expected an error", name)
+		}
+	}
+}
+
+func TestConcatFilter(t *testing.T) {
+	want := "[0:a]aresample=44100,aformat=sample_fmts=fltp:channel_layouts=mono[a0];\n" +
+		"[1:a]aresample=44100,aformat=sample_fmts=fltp:channel_layouts=mono[a1];\n" +
+		"[a0][a1]concat=n=2:v=0:a=1[out]\n"
+	if got := concatFilter(2); got != want {
+		t.Errorf("concatFilter(2) =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFFMetadata(t *testing.T) {
+	chapters := []BookChapter{{Title: "第一章"}, {Title: "A=B; #2"}}
+	durations := []time.Duration{90 * time.Second, 30 * time.Second}
+	meta := BookMetadata{Title: "活着", Authors: []string{"余华"}, Narrators: []string{"张三"}, Series: "余华作品", SeriesIndex: "2"}
+
+	got := ffMetadata(chapters, durations, meta)
+
+	for _, want := range []string{
+		";FFMETADATA1\n",
+		"title=活着\n",
+		"artist=余华\n",
+		"composer=张三\n",
+		"grouping=余华作品 \\#2\n",
+		"START=0\nEND=90000\ntitle=第一章\n",
+		"START=90000\nEND=120000\ntitle=A\\=B\\; \\#2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metadata missing %q:\n%s", want, got)
+		}
+	}
+}
@@ -5,36 +5,45 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 )
 
-// MergeWavFiles concatenates multiple WAV files into a single output file.
+// Timing is where one merged input sits in the output, excluding the
+// silence inserted around it.
+type Timing struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// MergeWavFiles concatenates multiple WAV files into a single output file
+// and returns the position of each input in the output.
 // It assumes all input files have the same format (sample rate, channels, bit depth).
-func MergeWavFiles(inputs []string, outputPath string, silenceMs int) error {
+func MergeWavFiles(inputs []string, outputPath string, silenceMs int) ([]Timing, error) {
 	if len(inputs) == 0 {
-		return fmt.Errorf("no input files to merge")
+		return nil, fmt.Errorf("no input files to merge")
 	}
 
 	outFile, err := os.Create(outputPath)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return nil, fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer outFile.Close()
 
 	// 1. Read the header from the first file to extract format info
 	firstFile, err := os.Open(inputs[0])
 	if err != nil {
-		return fmt.Errorf("failed to open first input file: %w", err)
+		return nil, fmt.Errorf("failed to open first input file: %w", err)
 	}
 	defer firstFile.Close()
 
 	// Read RIFF header (12 bytes: "RIFF" + size + "WAVE")
 	riffHeader := make([]byte, 12)
 	if _, err := io.ReadFull(firstFile, riffHeader); err != nil {
-		return fmt.Errorf("failed to read RIFF header: %w", err)
+		return nil, fmt.Errorf("failed to read RIFF header: %w", err)
 	}
 
 	if string(riffHeader[0:4]) != "RIFF" || string(riffHeader[8:12]) != "WAVE" {
-		return fmt.Errorf("invalid WAV file format")
+		return nil, fmt.Errorf("invalid WAV file format")
 	}
 
 	// Read chunks until we find "fmt " chunk
@@ -49,7 +58,7 @@ func MergeWavFiles(inputs []string, outputPath string, silenceMs int) error {
 			if err == io.EOF {
 				break
 			}
-			return fmt.Errorf("failed to read chunk header: %w", err)
+			return nil, fmt.Errorf("failed to read chunk header: %w", err)
 		}
 
 		chunkID := string(chunkHeader[0:4])
@@ -59,7 +68,7 @@ func MergeWavFiles(inputs []string, outputPath string, silenceMs int) error {
 			// Read fmt chunk data
 			fmtData := make([]byte, chunkSize)
 			if _, err := io.ReadFull(firstFile, fmtData); err != nil {
-				return fmt.Errorf("failed to read fmt chunk: %w", err)
+				return nil, fmt.Errorf("failed to read fmt chunk: %w", err)
 			}
 			fmtChunk = append(chunkHeader, fmtData...)
 
@@ -74,13 +83,13 @@ func MergeWavFiles(inputs []string, outputPath string, silenceMs int) error {
 		} else {
 			// Skip this chunk
 			if _, err := firstFile.Seek(int64(chunkSize), io.SeekCurrent); err != nil {
-				return fmt.Errorf("failed to skip chunk %s: %w", chunkID, err)
+				return nil, fmt.Errorf("failed to skip chunk %s: %w", chunkID, err)
 			}
 		}
 	}
 
 	if fmtChunk == nil {
-		return fmt.Errorf("fmt chunk not found")
+		return nil, fmt.Errorf("fmt chunk not found")
 	}
 
 	firstFile.Close()
@@ -108,6 +117,10 @@ func MergeWavFiles(inputs []string, outputPath string, silenceMs int) error {
 	binary.Write(outFile, binary.LittleEndian, uint32(0)) // Placeholder
 
 	totalDataSize := uint32(0)
+	timings := make([]Timing, len(inputs))
+	offset := func(bytes uint32) time.Duration {
+		return time.Duration(float64(bytes) / float64(byteRate) * float64(time.Second))
+	}
 
 	// 2. Append audio data from all files
 	for i, inputPath := range inputs {
@@ -115,57 +128,59 @@ func MergeWavFiles(inputs []string, outputPath string, silenceMs int) error {
 		if i > 0 && silenceMs > 0 {
 			n, err := outFile.Write(silenceBuffer)
 			if err != nil {
-				return fmt.Errorf("failed to write silence: %w", err)
+				return nil, fmt.Errorf("failed to write silence: %w", err)
 			}
 			totalDataSize += uint32(n)
 		}
 
 		f, err := os.Open(inputPath)
 		if err != nil {
-			return fmt.Errorf("failed to open %s: %w", inputPath, err)
+			return nil, fmt.Errorf("failed to open %s: %w", inputPath, err)
 		}
 
 		// Skip to data chunk
 		dataOffset, dataSize, err := findDataChunk(f)
 		if err != nil {
 			f.Close()
-			return fmt.Errorf("failed to find data chunk in %s: %w", inputPath, err)
+			return nil, fmt.Errorf("failed to find data chunk in %s: %w", inputPath, err)
 		}
 
 		if _, err := f.Seek(dataOffset, 0); err != nil {
 			f.Close()
-			return fmt.Errorf("failed to seek to data in %s: %w", inputPath, err)
+			return nil, fmt.Errorf("failed to seek to data in %s: %w", inputPath, err)
 		}
 
 		// Copy only the audio data
+		start := totalDataSize
 		n, err := io.CopyN(outFile, f, int64(dataSize))
 		f.Close()
 		if err != nil {
-			return fmt.Errorf("failed to copy data from %s: %w", inputPath, err)
+			return nil, fmt.Errorf("failed to copy data from %s: %w", inputPath, err)
 		}
 		totalDataSize += uint32(n)
+		timings[i] = Timing{Start: offset(start), End: offset(totalDataSize)}
 	}
 
 	// 3. Update size fields
 	// Update RIFF chunk size (fileSize - 8)
 	if _, err := outFile.Seek(4, 0); err != nil {
-		return fmt.Errorf("failed to seek to RIFF size: %w", err)
+		return nil, fmt.Errorf("failed to seek to RIFF size: %w", err)
 	}
 	riffSize := uint32(len(fmtChunk)) + 8 + totalDataSize + 4 // fmt + "data" header + data + "WAVE"
 	if err := binary.Write(outFile, binary.LittleEndian, riffSize); err != nil {
-		return fmt.Errorf("failed to write RIFF size: %w", err)
+		return nil, fmt.Errorf("failed to write RIFF size: %w", err)
 	}
 
 	// Update data chunk size
 	if _, err := outFile.Seek(dataChunkSizePos, 0); err != nil {
-		return fmt.Errorf("failed to seek to data size: %w", err)
+		return nil, fmt.Errorf("failed to seek to data size: %w", err)
 	}
 	if err := binary.Write(outFile, binary.LittleEndian, totalDataSize); err != nil {
-		return fmt.Errorf("failed to write data size: %w", err)
+		return nil, fmt.Errorf("failed to write data size: %w", err)
 	}
 
 	fmt.Printf("[Merger] Successfully merged %d files, total data size: %d bytes\n", len(inputs), totalDataSize)
-	return nil
+	return timings, nil
 }
 
 // findDataChunk finds the data chunk in a WAV file and returns its offset and size
@@ -394,7 +409,7 @@ func NormalizeAudio(inputPath, outputPath string) error {
 }
 
 // MergeAndNormalize works like MergeWavFiles but optionally applies normalization
-func MergeAndNormalize(inputs []string, outputPath string, silenceMs int, normalize bool) error {
+func MergeAndNormalize(inputs []string, outputPath string, silenceMs int, normalize bool) ([]Timing, error) {
 	if !normalize {
 		return MergeWavFiles(inputs, outputPath, silenceMs)
 	}
@@ -404,8 +419,9 @@ func MergeAndNormalize(inputs []string, outputPath string, silenceMs int, normal
 	// Ensure temp file is cleaned up
 	defer os.Remove(tempMerged)
 
-	if err := MergeWavFiles(inputs, tempMerged, silenceMs); err != nil {
-		return err
+	timings, err := MergeWavFiles(inputs, tempMerged, silenceMs)
+	if err != nil {
+		return nil, err
 	}
 
 	// Normalize
@@ -415,8 +431,8 @@ func MergeAndNormalize(inputs []string, outputPath string, silenceMs int, normal
 		os.Rename(tempMerged, outputPath)
 		// Consider returning nil error so process doesn't stop?
 		// But let's log it.
-		return nil
+		return timings, nil
 	}
 
-	return nil
+	return timings, nil
 }
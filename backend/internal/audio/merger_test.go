@@ -0,0 +1,35 @@
+package audio
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMergeWavFiles_Timings(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.wav")
+	b := filepath.Join(dir, "b.wav")
+	writeSilence(t, a, 22050, time.Second)
+	writeSilence(t, b, 22050, 500*time.Millisecond)
+
+	out := filepath.Join(dir, "out.wav")
+	timings, err := MergeWavFiles([]string{a, b}, out, 400)
+	if err != nil {
+		t.Fatalf("MergeWavFiles() error = %v", err)
+	}
+
+	want := []Timing{
+		{Start: 0, End: time.Second},
+		{Start: 1400 * time.Millisecond, End: 1900 * time.Millisecond},
+	}
+	for i := range want {
+		if timings[i] != want[i] {
+			t.Errorf("timing %d = %+v, want %+v", i, timings[i], want[i])
+		}
+	}
+
+	if d, _ := WavDuration(out); d != 1900*time.Millisecond {
+		t.Errorf("merged duration = %v, want 1.9s", d)
+	}
+}
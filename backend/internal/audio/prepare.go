@@ -0,0 +1,490 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PrepareOptions controls how a voice reference clip is prepared. Zero
+// fields other than Start and End take their DefaultPrepareOptions value.
+type PrepareOptions struct {
+	SampleRate       int           // Output rate in Hz; the output is always mono 16-bit PCM
+	Start, End       time.Duration // Part of the source to use; a zero End keeps the rest of it
+	MaxLength        time.Duration // Longer clips are cut, at a pause where there is one
+	SilenceThreshold float64       // dBFS below which leading and trailing audio is trimmed
+	TargetLevel      float64       // RMS loudness of the prepared clip in dBFS
+}
+
+// DefaultPrepareOptions suit Index-TTS2, which works on 22.05 kHz audio and
+// only listens to the first 15 seconds of a reference.
+var DefaultPrepareOptions = PrepareOptions{
+	SampleRate:       22050,
+	MaxLength:        15 * time.Second,
+	SilenceThreshold: -45,
+	TargetLevel:      -20,
+}
+
+// PrepareResult describes a prepared clip
+type PrepareResult struct {
+	SourceLength time.Duration // Length of the whole source file
+	Length       time.Duration // Length of the prepared clip
+	Truncated    bool          // Whether MaxLength cut the clip
+}
+
+const (
+	framesPerSecond = 100                    // Silence is measured over 10 ms frames
+	silencePadding  = 100 * time.Millisecond // Kept around trimmed speech so onsets aren't clipped
+	fadeLength      = 5 * time.Millisecond   // Fades at the clip's edges avoid clicks
+	peakCeiling     = -1.0                   // dBFS the normalised peak may not exceed
+	decodeSlack     = 30 * time.Second       // Decoded past MaxLength, for silence trimmed off the start
+)
+
+var errUnsupportedWav = errors.New("unsupported WAV encoding")
+
+// PrepareVoice turns a reference recording into a clip a TTS engine clones
+// well: it decodes the file (WAV natively, MP3, FLAC and OGG through the
+// encoder binary), downmixes it to mono, keeps the Start-End range, trims
+// silence at both ends, caps the length, resamples and normalises the
+// loudness. The clip is written as a WAV file to outputPath.
+func PrepareVoice(ctx context.Context, encoderPath, inputPath, outputPath string, opts PrepareOptions) (PrepareResult, error) {
+	opts = opts.withDefaults()
+	if err := opts.validate(); err != nil {
+		return PrepareResult{}, err
+	}
+
+	// Only what can end up in the clip is decoded, however long the source
+	length := opts.MaxLength + decodeSlack
+	if opts.End > 0 {
+		length = min(length, opts.End-opts.Start)
+	}
+	samples, channels, rate, total, err := decode(ctx, encoderPath, inputPath, opts.Start, length)
+	if err != nil {
+		return PrepareResult{}, err
+	}
+	samples = downmix(samples, channels)
+	result := PrepareResult{SourceLength: total}
+
+	if opts.Start >= result.SourceLength {
+		return PrepareResult{}, fmt.Errorf("start %v is beyond the end of the audio (%v)", opts.Start, result.SourceLength)
+	}
+
+	samples = trimSilence(samples, rate, opts.SilenceThreshold)
+	if len(samples) == 0 {
+		return PrepareResult{}, fmt.Errorf("no audio above %.0f dBFS in the selected range", opts.SilenceThreshold)
+	}
+	samples, result.Truncated = capLength(samples, rate, opts.MaxLength)
+	samples = resample(samples, rate, opts.SampleRate)
+	fade(samples, opts.SampleRate)
+	normalize(samples, opts.TargetLevel)
+
+	if err := writeWav(outputPath, samples, opts.SampleRate); err != nil {
+		return PrepareResult{}, err
+	}
+	result.Length = samplesDuration(len(samples), opts.SampleRate)
+	return result, nil
+}
+
+func (o PrepareOptions) withDefaults() PrepareOptions {
+	if o.SampleRate == 0 {
+		o.SampleRate = DefaultPrepareOptions.SampleRate
+	}
+	if o.MaxLength == 0 {
+		o.MaxLength = DefaultPrepareOptions.MaxLength
+	}
+	if o.SilenceThreshold == 0 {
+		o.SilenceThreshold = DefaultPrepareOptions.SilenceThreshold
+	}
+	if o.TargetLevel == 0 {
+		o.TargetLevel = DefaultPrepareOptions.TargetLevel
+	}
+	return o
+}
+
+func (o PrepareOptions) validate() error {
+	switch {
+	case o.SampleRate < 8000 || o.SampleRate > 48000:
+		return fmt.Errorf("sample rate %d Hz is outside 8000-48000", o.SampleRate)
+	case o.Start < 0:
+		return fmt.Errorf("start must not be negative")
+	case o.End != 0 && o.End <= o.Start:
+		return fmt.Errorf("end %v must be after start %v", o.End, o.Start)
+	case o.MaxLength < time.Second:
+		return fmt.Errorf("maximum length must be at least a second")
+	case o.SilenceThreshold > 0 || o.TargetLevel > 0:
+		return fmt.Errorf("levels are in dBFS and must not be positive")
+	}
+	return nil
+}
+
+// decode reads length of an audio file from start as interleaved samples
+// in [-1, 1], and returns the length of the whole file. WAV files the
+// decoder can't read, and other formats, are converted by ffmpeg, with the
+// length probed by ffprobe.
+func decode(ctx context.Context, encoderPath, path string, start, length time.Duration) ([]float64, int, int, time.Duration, error) {
+	if strings.EqualFold(filepath.Ext(path), ".wav") {
+		samples, channels, rate, total, err := readWavRange(path, start, length)
+		if !errors.Is(err, errUnsupportedWav) {
+			return samples, channels, rate, total, err
+		}
+	}
+
+	if err := CheckEncoder(encoderPath); err != nil {
+		return nil, 0, 0, 0, err
+	}
+	if encoderPath == "" {
+		encoderPath = "ffmpeg"
+	}
+	total, err := Duration(encoderPath, path)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	cmd := exec.CommandContext(ctx, encoderPath,
+		"-hide_banner", "-loglevel", "error",
+		"-ss", formatSeconds(start), "-i", path, "-t", formatSeconds(length),
+		"-vn", "-f", "wav", "-c:a", "pcm_s16le",
+		"pipe:1",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, 0, 0, 0, fmt.Errorf("decoding %s failed: %w: %s", filepath.Base(path), err, strings.TrimSpace(stderr.String()))
+	}
+	samples, channels, rate, err := parseWav(stdout.Bytes())
+	return samples, channels, rate, total, err
+}
+
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// readWavRange reads length of a WAV file from start, seeking past the rest
+// rather than reading the whole file, and returns the file's length.
+func readWavRange(path string, start, length time.Duration) ([]float64, int, int, time.Duration, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, 0, 0, err
+	}
+	defer f.Close()
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(f, header); err != nil || string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, 0, 0, 0, fmt.Errorf("invalid WAV file format")
+	}
+
+	var format, channels, bits uint16
+	var rate uint32
+	chunkHeader := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, chunkHeader); err != nil {
+			return nil, 0, 0, 0, fmt.Errorf("data chunk not found")
+		}
+		chunkID := string(chunkHeader[0:4])
+		chunkSize := int64(binary.LittleEndian.Uint32(chunkHeader[4:8]))
+
+		switch chunkID {
+		case "fmt ":
+			if chunkSize < 16 || chunkSize > maxFmtChunk {
+				return nil, 0, 0, 0, fmt.Errorf("invalid fmt chunk")
+			}
+			body := make([]byte, chunkSize+chunkSize%2)
+			if _, err := io.ReadFull(f, body); err != nil {
+				return nil, 0, 0, 0, fmt.Errorf("failed to read fmt chunk: %w", err)
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			rate = binary.LittleEndian.Uint32(body[4:8])
+			bits = binary.LittleEndian.Uint16(body[14:16])
+			if format == 0xFFFE && chunkSize >= 26 { // WAVE_FORMAT_EXTENSIBLE
+				format = binary.LittleEndian.Uint16(body[24:26])
+			}
+		case "data":
+			frameSize := int64(channels) * int64(bits/8)
+			if channels == 0 || rate == 0 {
+				return nil, 0, 0, 0, fmt.Errorf("fmt chunk missing or invalid")
+			}
+			if frameSize == 0 {
+				return nil, 0, 0, 0, fmt.Errorf("%w: %d bits", errUnsupportedWav, bits)
+			}
+			// Streamed WAV (e.g. from a pipe) leaves the data size unset
+			pos, err := f.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, 0, 0, 0, err
+			}
+			if info, err := f.Stat(); err == nil && (chunkSize == 0 || chunkSize > info.Size()-pos) {
+				chunkSize = info.Size() - pos
+			}
+			frames := int(chunkSize / frameSize)
+			from := sampleIndex(start, int(rate), frames)
+			n := sampleIndex(length, int(rate), frames-from)
+			if _, err := f.Seek(int64(from)*frameSize, io.SeekCurrent); err != nil {
+				return nil, 0, 0, 0, err
+			}
+			body := make([]byte, int64(n)*frameSize)
+			if _, err := io.ReadFull(f, body); err != nil {
+				return nil, 0, 0, 0, fmt.Errorf("failed to read audio: %w", err)
+			}
+			samples, err := pcmSamples(body, format, bits)
+			return samples, int(channels), int(rate), samplesDuration(frames, int(rate)), err
+		default:
+			if _, err := f.Seek(chunkSize+chunkSize%2, io.SeekCurrent); err != nil {
+				return nil, 0, 0, 0, err
+			}
+		}
+	}
+}
+
+// parseWav decodes integer PCM (8 to 32 bits) and float WAV data
+func parseWav(data []byte) ([]float64, int, int, error) {
+	if len(data) < 12 || string(data[0:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return nil, 0, 0, fmt.Errorf("invalid WAV file format")
+	}
+
+	var format, channels, bits uint16
+	var rate uint32
+	for pos := 12; pos+8 <= len(data); {
+		chunkID := string(data[pos : pos+4])
+		chunkSize := int(binary.LittleEndian.Uint32(data[pos+4 : pos+8]))
+		pos += 8
+		// Streamed WAV (e.g. from a pipe) leaves the data size unset
+		if chunkSize > len(data)-pos || (chunkID == "data" && chunkSize == 0) {
+			chunkSize = len(data) - pos
+		}
+		body := data[pos : pos+chunkSize]
+
+		switch chunkID {
+		case "fmt ":
+			if len(body) < 16 {
+				return nil, 0, 0, fmt.Errorf("invalid fmt chunk")
+			}
+			format = binary.LittleEndian.Uint16(body[0:2])
+			channels = binary.LittleEndian.Uint16(body[2:4])
+			rate = binary.LittleEndian.Uint32(body[4:8])
+			bits = binary.LittleEndian.Uint16(body[14:16])
+			if format == 0xFFFE && len(body) >= 26 { // WAVE_FORMAT_EXTENSIBLE
+				format = binary.LittleEndian.Uint16(body[24:26])
+			}
+		case "data":
+			if channels == 0 || rate == 0 {
+				return nil, 0, 0, fmt.Errorf("fmt chunk missing or invalid")
+			}
+			samples, err := pcmSamples(body, format, bits)
+			return samples, int(channels), int(rate), err
+		}
+		pos += chunkSize + chunkSize%2 // Chunks are word aligned
+	}
+	return nil, 0, 0, fmt.Errorf("data chunk not found")
+}
+
+func pcmSamples(body []byte, format, bits uint16) ([]float64, error) {
+	size := int(bits) / 8
+	if size == 0 || (format != 1 && format != 3) || (format == 3 && size != 4 && size != 8) || size > 8 {
+		return nil, fmt.Errorf("%w: format %d, %d bits", errUnsupportedWav, format, bits)
+	}
+	samples := make([]float64, len(body)/size)
+	for i := range samples {
+		b := body[i*size : (i+1)*size]
+		switch {
+		case format == 3 && size == 4:
+			samples[i] = float64(math.Float32frombits(binary.LittleEndian.Uint32(b)))
+		case format == 3:
+			samples[i] = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		case size == 1:
+			samples[i] = (float64(b[0]) - 128) / 128 // 8-bit PCM is unsigned
+		default:
+			// Sign-extend the little-endian integer from its top byte
+			v := int64(int8(b[size-1]))
+			for j := size - 2; j >= 0; j-- {
+				v = v<<8 | int64(b[j])
+			}
+			samples[i] = float64(v) / float64(int64(1)<<(bits-1))
+		}
+	}
+	return samples, nil
+}
+
+func downmix(samples []float64, channels int) []float64 {
+	if channels <= 1 {
+		return samples
+	}
+	mono := make([]float64, len(samples)/channels)
+	for i := range mono {
+		var sum float64
+		for _, s := range samples[i*channels : (i+1)*channels] {
+			sum += s
+		}
+		mono[i] = sum / float64(channels)
+	}
+	return mono
+}
+
+// trimSilence drops the audio before the first and after the last frame
+// louder than threshold, keeping a little padding
+func trimSilence(samples []float64, rate int, threshold float64) []float64 {
+	frame := max(rate/framesPerSecond, 1)
+	first, last := -1, -1
+	for start := 0; start < len(samples); start += frame {
+		if level(samples[start:min(start+frame, len(samples))]) > threshold {
+			if first < 0 {
+				first = start
+			}
+			last = min(start+frame, len(samples))
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	pad := sampleIndex(silencePadding, rate, len(samples))
+	return samples[max(first-pad, 0):min(last+pad, len(samples))]
+}
+
+// capLength cuts samples to maxLength, at the quietest frame of the last
+// second (or quarter, for short limits) so a word isn't cut in half
+func capLength(samples []float64, rate int, maxLength time.Duration) ([]float64, bool) {
+	limit := sampleIndex(maxLength, rate, math.MaxInt)
+	if len(samples) <= limit {
+		return samples, false
+	}
+	frame := max(rate/framesPerSecond, 1)
+	cut, quietest := limit, math.Inf(1)
+	for end := limit; end-frame >= limit-min(rate, limit/4); end -= frame {
+		if l := level(samples[end-frame : end]); l < quietest {
+			cut, quietest = end-frame/2, l
+		}
+	}
+	return samples[:cut], true
+}
+
+// resample converts between sample rates with a Hann-windowed sinc filter
+// whose cutoff is the lower of the two Nyquist frequencies
+func resample(samples []float64, from, to int) []float64 {
+	if from == to || len(samples) == 0 {
+		return samples
+	}
+	const zeroCrossings = 16
+	ratio := float64(to) / float64(from)
+	cutoff := math.Min(ratio, 1)
+	half := zeroCrossings / cutoff // Filter half-width in input samples
+
+	out := make([]float64, int(float64(len(samples))*ratio))
+	for i := range out {
+		t := float64(i) / ratio
+		lo := max(int(math.Ceil(t-half)), 0)
+		hi := min(int(math.Floor(t+half)), len(samples)-1)
+		var sum float64
+		for j := lo; j <= hi; j++ {
+			x := float64(j) - t
+			window := 0.5 + 0.5*math.Cos(math.Pi*x/half)
+			sum += samples[j] * window * cutoff * sinc(cutoff*x)
+		}
+		out[i] = sum
+	}
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// fade ramps the first and last few milliseconds in and out
+func fade(samples []float64, rate int) {
+	n := min(sampleIndex(fadeLength, rate, len(samples)), len(samples)/2)
+	for i := 0; i < n; i++ {
+		g := float64(i) / float64(n)
+		samples[i] *= g
+		samples[len(samples)-1-i] *= g
+	}
+}
+
+// normalize scales samples to the target RMS level, lowering the gain if
+// that would push the peak above peakCeiling
+func normalize(samples []float64, target float64) {
+	var peak float64
+	for _, s := range samples {
+		peak = math.Max(peak, math.Abs(s))
+	}
+	if peak == 0 {
+		return
+	}
+	gain := math.Pow(10, (target-level(samples))/20)
+	gain = math.Min(gain, math.Pow(10, peakCeiling/20)/peak)
+	for i := range samples {
+		samples[i] *= gain
+	}
+}
+
+// level returns the RMS level of samples in dBFS
+func level(samples []float64) float64 {
+	if len(samples) == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += s * s
+	}
+	return 10 * math.Log10(sum/float64(len(samples)))
+}
+
+// writeWav writes mono 16-bit PCM through a temp file, so a failure never
+// leaves a partial clip at path
+func writeWav(path string, samples []float64, rate int) error {
+	dataSize := len(samples) * 2
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+dataSize))
+	copy(buf[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], 1) // PCM
+	binary.LittleEndian.PutUint16(buf[22:], 1) // Mono
+	binary.LittleEndian.PutUint32(buf[24:], uint32(rate))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(rate*2))
+	binary.LittleEndian.PutUint16(buf[32:], 2)
+	binary.LittleEndian.PutUint16(buf[34:], 16)
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(dataSize))
+	for i, s := range samples {
+		v := math.Round(math.Max(-1, math.Min(1, s)) * 32767)
+		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(int16(v)))
+	}
+
+	// A unique temp file, as the same clip may be prepared twice at once
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write prepared clip: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	tmp.Chmod(0644)
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write prepared clip: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write prepared clip: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to move prepared clip: %w", err)
+	}
+	return nil
+}
+
+func sampleIndex(d time.Duration, rate, limit int) int {
+	return min(int(d.Seconds()*float64(rate)), limit)
+}
+
+func samplesDuration(n, rate int) time.Duration {
+	return time.Duration(float64(n) / float64(rate) * float64(time.Second))
+}
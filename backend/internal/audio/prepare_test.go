@@ -0,0 +1,178 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTone writes a 16-bit stereo WAV of silence, a 440 Hz tone and more
+// silence
+func writeTone(t *testing.T, path string, sampleRate int, silence, tone time.Duration) {
+	t.Helper()
+	var samples []float64
+	pad := make([]float64, int(silence.Seconds()*float64(sampleRate)))
+	samples = append(samples, pad...)
+	for i := 0; i < int(tone.Seconds()*float64(sampleRate)); i++ {
+		samples = append(samples, 0.1*math.Sin(2*math.Pi*440*float64(i)/float64(sampleRate)))
+	}
+	samples = append(samples, pad...)
+
+	dataSize := len(samples) * 4
+	buf := make([]byte, 44+dataSize)
+	copy(buf[0:], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(36+dataSize))
+	copy(buf[8:], "WAVEfmt ")
+	binary.LittleEndian.PutUint32(buf[16:], 16)
+	binary.LittleEndian.PutUint16(buf[20:], 1)
+	binary.LittleEndian.PutUint16(buf[22:], 2)
+	binary.LittleEndian.PutUint32(buf[24:], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(buf[28:], uint32(sampleRate*4))
+	binary.LittleEndian.PutUint16(buf[32:], 4)
+	binary.LittleEndian.PutUint16(buf[34:], 16)
+	copy(buf[36:], "data")
+	binary.LittleEndian.PutUint32(buf[40:], uint32(dataSize))
+	for i, s := range samples {
+		v := uint16(int16(s * 32767))
+		binary.LittleEndian.PutUint16(buf[44+i*4:], v)
+		binary.LittleEndian.PutUint16(buf[46+i*4:], v)
+	}
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPrepareVoice(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "voice.wav")
+	writeTone(t, in, 48000, time.Second, 2*time.Second)
+
+	tests := []struct {
+		name          string
+		opts          PrepareOptions
+		minLength     time.Duration
+		maxLength     time.Duration
+		wantTruncated bool
+	}{
+		{"trims silence", PrepareOptions{}, 2180 * time.Millisecond, 2220 * time.Millisecond, false},
+		{"sub-range", PrepareOptions{Start: 1500 * time.Millisecond, End: 2500 * time.Millisecond}, 980 * time.Millisecond, 1020 * time.Millisecond, false},
+		// Cut at the quietest frame of the last quarter
+		{"capped", PrepareOptions{MaxLength: time.Second}, 750 * time.Millisecond, time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := filepath.Join(dir, "prepared.wav")
+			res, err := PrepareVoice(context.Background(), "", in, out, tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.SourceLength != 4*time.Second || res.Truncated != tt.wantTruncated {
+				t.Errorf("result = %+v", res)
+			}
+			if res.Length < tt.minLength || res.Length > tt.maxLength {
+				t.Errorf("length = %v, want %v-%v", res.Length, tt.minLength, tt.maxLength)
+			}
+
+			data, err := os.ReadFile(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			samples, channels, rate, err := parseWav(data)
+			if err != nil || channels != 1 || rate != 22050 {
+				t.Fatalf("output: %d channels at %d Hz, %v", channels, rate, err)
+			}
+			// Padding lowers the level slightly; the tone itself sits at the target
+			if l := level(samples); l < -21 || l > -19 {
+				t.Errorf("level = %.1f dBFS, want about -20", l)
+			}
+		})
+	}
+
+	if _, err := PrepareVoice(context.Background(), "", in, filepath.Join(dir, "x.wav"), PrepareOptions{Start: 5 * time.Second}); err == nil {
+		t.Error("start beyond the end succeeded")
+	}
+	silent := filepath.Join(dir, "silent.wav")
+	writeSilence(t, silent, 22050, time.Second)
+	if _, err := PrepareVoice(context.Background(), "", silent, filepath.Join(dir, "x.wav"), PrepareOptions{}); err == nil {
+		t.Error("preparing silence succeeded")
+	}
+}
+
+func TestPrepareVoice_Decoder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake decoder needs a POSIX shell")
+	}
+	dir := t.TempDir()
+	// Stand in for ffmpeg decoding to WAV on stdout, logging its arguments,
+	// and for ffprobe measuring the input
+	decoder := filepath.Join(dir, "ffmpeg")
+	script := "#!/bin/sh\necho \"$@\" > \"$0.args\"\nwhile [ $# -gt 1 ]; do [ \"$1\" = -i ] && in=$2; shift; done\ncat \"$in\"\n"
+	if err := os.WriteFile(decoder, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ffprobe"), []byte("#!/bin/sh\necho 1.000000\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(dir, "voice.mp3")
+	writeTone(t, in, 44100, 0, time.Second)
+
+	opts := PrepareOptions{SampleRate: 16000, Start: 100 * time.Millisecond, End: 900 * time.Millisecond}
+	res, err := PrepareVoice(context.Background(), decoder, in, filepath.Join(dir, "voice.prepared.wav"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SourceLength != time.Second {
+		t.Errorf("result = %+v", res)
+	}
+	// Only the selected range is decoded
+	if args, _ := os.ReadFile(decoder + ".args"); !strings.Contains(string(args), "-ss 0.100 -i "+in+" -t 0.800") {
+		t.Errorf("decoder arguments = %s", args)
+	}
+}
+
+func TestReadWavRange(t *testing.T) {
+	in := filepath.Join(t.TempDir(), "voice.wav")
+	writeTone(t, in, 48000, time.Second, 2*time.Second)
+
+	samples, channels, rate, total, err := readWavRange(in, 1500*time.Millisecond, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channels != 2 || rate != 48000 || total != 4*time.Second || len(samples) != 2*48000 {
+		t.Errorf("got %d samples, %d channels at %d Hz of %v", len(samples), channels, rate, total)
+	}
+	// Past the end, only what is there is read
+	if samples, _, _, _, err := readWavRange(in, 3500*time.Millisecond, time.Second); err != nil || len(samples) != 2*24000 {
+		t.Errorf("tail: %d samples, %v", len(samples), err)
+	}
+}
+
+func TestPcmSamples(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   []byte
+		format uint16
+		bits   uint16
+		want   float64
+	}{
+		{"8-bit", []byte{0}, 1, 8, -1},
+		{"24-bit", []byte{0x00, 0x00, 0xC0}, 1, 24, -0.5},
+		{"32-bit", []byte{0x00, 0x00, 0x00, 0x40}, 1, 32, 0.5},
+		{"float", binary.LittleEndian.AppendUint32(nil, math.Float32bits(0.25)), 3, 32, 0.25},
+	}
+	for _, tt := range tests {
+		got, err := pcmSamples(tt.body, tt.format, tt.bits)
+		if err != nil || len(got) != 1 || got[0] != tt.want {
+			t.Errorf("%s: pcmSamples() = %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := pcmSamples([]byte{0, 0}, 2, 4); err == nil {
+		t.Error("ADPCM was accepted")
+	}
+}
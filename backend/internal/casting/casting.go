@@ -0,0 +1,266 @@
+// Package casting picks voices for the characters of a book by matching
+// character attributes to voice attributes.
+package casting
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Voice is a reference voice that can be cast
+type Voice struct {
+	ID     string // Path of the reference audio
+	Gender string // "male", "female" or empty if unknown
+	Age    string // Age bracket as in character profiles, or empty if unknown
+}
+
+// Character is a character to cast
+type Character struct {
+	Name   string
+	Gender string
+	Age    string
+	Lines  int    // Segments spoken in the whole book
+	Voice  string // Current voice; empty if none
+}
+
+// Change is a proposed new voice for a character
+type Change struct {
+	Character string `json:"character"`
+	From      string `json:"from,omitempty"`
+	To        string `json:"to"`
+	Reason    string `json:"reason"` // "new", "shared" or "recast"
+}
+
+// Mode says which characters Cast may give a new voice
+type Mode int
+
+const (
+	// FillMissing casts characters without a voice and keeps every
+	// other assignment, so casting is stable across re-analysis
+	FillMissing Mode = iota
+	// Distinct also recasts frequent speakers that share a voice with a
+	// more frequent one
+	Distinct
+	// Recast casts every character afresh
+	Recast
+)
+
+// ParseMode parses "missing", "distinct" or "all"
+func ParseMode(s string) (Mode, error) {
+	switch s {
+	case "missing":
+		return FillMissing, nil
+	case "distinct", "":
+		return Distinct, nil
+	case "all":
+		return Recast, nil
+	}
+	return 0, fmt.Errorf("unknown casting mode %q", s)
+}
+
+// Ages are the age brackets, youngest first
+var Ages = []string{"child", "teen", "young", "adult", "middle-aged", "elderly"}
+
+// Cast proposes voices for the characters and returns the changes.
+//
+// The most frequent speakers, as many as there are voices, each get a
+// voice of their own; the rest get the best matching voice, preferring
+// voices used less. Ties go to the more frequent character and the earlier
+// voice, so the same input always gives the same cast.
+func Cast(characters []Character, voices []Voice, mode Mode) []Change {
+	if len(voices) == 0 {
+		return nil
+	}
+	chars := make([]Character, len(characters))
+	copy(chars, characters)
+	sort.SliceStable(chars, func(i, j int) bool {
+		if chars[i].Lines != chars[j].Lines {
+			return chars[i].Lines > chars[j].Lines
+		}
+		return chars[i].Name < chars[j].Name
+	})
+
+	inLibrary := make(map[string]bool, len(voices))
+	for _, v := range voices {
+		inLibrary[v.ID] = true
+	}
+	major := min(len(voices), len(chars))
+
+	var changes []Change
+	assign := func(ch Character, to, reason string) {
+		if to != ch.Voice {
+			changes = append(changes, Change{Character: ch.Name, From: ch.Voice, To: to, Reason: reason})
+		}
+	}
+	reasonFor := func(ch Character) string {
+		if ch.Voice == "" {
+			return "new"
+		}
+		return "recast"
+	}
+
+	// Major characters keep their voice unless a more frequent one has it
+	taken := make(map[string]bool)
+	uses := make(map[string]int)
+	var pending []Character
+	reasons := make(map[string]string)
+	for _, ch := range chars[:major] {
+		keep := ch.Voice != "" && mode != Recast
+		if keep && mode == Distinct && taken[ch.Voice] {
+			keep = false
+			reasons[ch.Name] = "shared"
+		}
+		if !keep {
+			pending = append(pending, ch)
+			continue
+		}
+		if inLibrary[ch.Voice] {
+			taken[ch.Voice] = true
+			uses[ch.Voice]++
+		}
+	}
+	// Best matches first, so a character that suits any voice doesn't take
+	// the one voice that suits another; ties go to the more frequent one
+	type pair struct{ c, v, score int }
+	var pairs []pair
+	for c, ch := range pending {
+		for v, voice := range voices {
+			if !taken[voice.ID] {
+				pairs = append(pairs, pair{c, v, Score(ch, voice)})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].score > pairs[j].score })
+	cast := make([]bool, len(pending))
+	for _, p := range pairs {
+		ch, voice := pending[p.c], voices[p.v]
+		if cast[p.c] || taken[voice.ID] {
+			continue
+		}
+		cast[p.c] = true
+		taken[voice.ID] = true
+		uses[voice.ID]++
+		reason := reasons[ch.Name]
+		if reason == "" {
+			reason = reasonFor(ch)
+		}
+		assign(ch, voice.ID, reason)
+	}
+
+	// The rest may share, preferring voices used less
+	for _, ch := range chars[major:] {
+		if ch.Voice != "" && mode != Recast {
+			uses[ch.Voice]++
+			continue
+		}
+		best := bestVoice(ch, voices, uses)
+		uses[best]++
+		assign(ch, best, reasonFor(ch))
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Character < changes[j].Character })
+	return changes
+}
+
+func bestVoice(ch Character, voices []Voice, uses map[string]int) string {
+	best, bestScore := "", 0
+	for i, v := range voices {
+		score := Score(ch, v) - uses[v.ID]
+		if i == 0 || score > bestScore {
+			best, bestScore = v.ID, score
+		}
+	}
+	return best
+}
+
+// Score rates how well a voice suits a character. A voice of the wrong
+// gender scores below any voice of unknown gender.
+func Score(ch Character, v Voice) int {
+	score := 0
+	if ch.Gender != "" && v.Gender != "" {
+		if ch.Gender == v.Gender {
+			score += 4
+		} else {
+			score -= 6
+		}
+	}
+	a, b := ageIndex(ch.Age), ageIndex(v.Age)
+	if a >= 0 && b >= 0 {
+		switch d := max(a-b, b-a); d {
+		case 0:
+			score += 2
+		case 1:
+			score++
+		default:
+			score -= 2 * (d - 1)
+		}
+	}
+	return score
+}
+
+func ageIndex(age string) int {
+	for i, a := range Ages {
+		if a == age {
+			return i
+		}
+	}
+	return -1
+}
+
+// Words in voice file and folder names that give away gender and age.
+// Latin words must be whole words ("old" isn't in "golden"); Chinese ones
+// may be part of a longer word.
+var (
+	genderWords = []struct{ word, gender string }{
+		{"female", "female"}, {"woman", "female"}, {"girl", "female"}, {"lady", "female"}, {"女", "female"},
+		{"male", "male"}, {"man", "male"}, {"boy", "male"}, {"男", "male"},
+	}
+	ageWords = []struct{ word, age string }{
+		{"child", "child"}, {"kid", "child"}, {"童", "child"}, {"小孩", "child"},
+		{"teen", "teen"}, {"少年", "teen"}, {"少女", "teen"},
+		{"young", "young"}, {"青年", "young"},
+		{"middle", "middle-aged"}, {"中年", "middle-aged"},
+		{"elder", "elderly"}, {"old", "elderly"}, {"老", "elderly"},
+		{"adult", "adult"}, {"成年", "adult"},
+	}
+)
+
+// GuessVoice reads the gender and age of a voice from its file and folder
+// name, e.g. "voices/女/少女_温柔.wav" or "old_man.wav".
+func GuessVoice(path string) Voice {
+	v := Voice{ID: path}
+	name := filepath.Base(filepath.Dir(path)) + "/" + strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	name = strings.ToLower(name)
+	words := strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	has := func(word string) bool {
+		if word[0] >= utf8.RuneSelf {
+			return strings.Contains(name, word)
+		}
+		for _, w := range words {
+			if w == word || w == word+"s" {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, w := range genderWords {
+		if has(w.word) {
+			v.Gender = w.gender
+			break
+		}
+	}
+	for _, w := range ageWords {
+		if has(w.word) {
+			v.Age = w.age
+			break
+		}
+	}
+	return v
+}
@@ -0,0 +1,99 @@
+package casting
+
+import (
+	"reflect"
+	"testing"
+)
+
+var testVoices = []Voice{
+	{ID: "deep_man.wav", Gender: "male", Age: "middle-aged"},
+	{ID: "girl.wav", Gender: "female", Age: "child"},
+	{ID: "woman.wav", Gender: "female", Age: "adult"},
+}
+
+func TestCast_MatchesAttributes(t *testing.T) {
+	chars := []Character{
+		{Name: "Narrator", Lines: 100},
+		{Name: "小女孩", Gender: "female", Age: "child", Lines: 10},
+		{Name: "老爷", Gender: "male", Age: "elderly", Lines: 20},
+	}
+	got := Cast(chars, testVoices, FillMissing)
+	// Narrator speaks most, but suits any voice, so it gets the one
+	// nobody else fits
+	want := []Change{
+		{Character: "Narrator", To: "woman.wav", Reason: "new"},
+		{Character: "小女孩", To: "girl.wav", Reason: "new"},
+		{Character: "老爷", To: "deep_man.wav", Reason: "new"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Cast() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCast_Modes(t *testing.T) {
+	// Two major characters share a voice; a minor one has none
+	chars := []Character{
+		{Name: "A", Gender: "female", Lines: 50, Voice: "woman.wav"},
+		{Name: "B", Gender: "female", Lines: 40, Voice: "woman.wav"},
+		{Name: "C", Lines: 30, Voice: "deep_man.wav"},
+		{Name: "D", Gender: "male", Lines: 1},
+	}
+
+	// Re-analysis keeps every assignment and only casts D, sharing the
+	// best matching voice
+	got := Cast(chars, testVoices, FillMissing)
+	want := []Change{{Character: "D", To: "deep_man.wav", Reason: "new"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("FillMissing: Cast() = %+v, want %+v", got, want)
+	}
+
+	// Distinct gives B the voice nobody has
+	got = Cast(chars, testVoices, Distinct)
+	want = []Change{
+		{Character: "B", From: "woman.wav", To: "girl.wav", Reason: "shared"},
+		{Character: "D", To: "deep_man.wav", Reason: "new"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Distinct: Cast() = %+v, want %+v", got, want)
+	}
+
+	// Casting is stable: applying the changes leaves nothing to do
+	applied := make([]Character, len(chars))
+	copy(applied, chars)
+	for _, c := range got {
+		for i := range applied {
+			if applied[i].Name == c.Character {
+				applied[i].Voice = c.To
+			}
+		}
+	}
+	if got := Cast(applied, testVoices, Distinct); len(got) != 0 {
+		t.Errorf("second Cast() = %+v, want no changes", got)
+	}
+}
+
+func TestCast_NoVoices(t *testing.T) {
+	if got := Cast([]Character{{Name: "A"}}, nil, Recast); got != nil {
+		t.Errorf("Cast() = %+v, want nil", got)
+	}
+}
+
+func TestGuessVoice(t *testing.T) {
+	tests := []struct {
+		path   string
+		gender string
+		age    string
+	}{
+		{"voices/女/少女_温柔.wav", "female", "teen"},
+		{"voices/old_man.wav", "male", "elderly"},
+		{"voices/Female-Narrator.mp3", "female", ""},
+		{"voices/golden_romance.wav", "", ""},
+		{"voices/男/中年大叔.flac", "male", "middle-aged"},
+	}
+	for _, tt := range tests {
+		v := GuessVoice(tt.path)
+		if v.ID != tt.path || v.Gender != tt.gender || v.Age != tt.age {
+			t.Errorf("GuessVoice(%q) = %+v, want gender %q, age %q", tt.path, v, tt.gender, tt.age)
+		}
+	}
+}
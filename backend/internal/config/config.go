@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"os"
 	"sync"
+	"sync/atomic"
 )
 
 type Config struct {
@@ -14,24 +15,77 @@ type Config struct {
 	LLMChunkSize   int    `json:"llm_chunk_size"`   // Default 1000
 	LLMMinInterval int    `json:"llm_min_interval"` // Default 3000 ms
 	MockLLM        bool   `json:"mock_llm"`         // Mock LLM responses
+	MockTTS        bool   `json:"mock_tts"`         // Synthesise placeholder tones instead of calling a TTS engine
 	MergeSilence   int    `json:"merge_silence"`    // Silence between audio segments in ms
 	NormalizeAudio bool   `json:"normalize_audio"`  // Whether to normalize audio volume
+	OutputFormat   string `json:"output_format"`    // "wav" (default), "mp3", "opus" or "aac"
+	OutputBitrate  int    `json:"output_bitrate"`   // kbps for compressed formats; 0 uses the format default
+	EncoderPath    string `json:"encoder_path"`     // ffmpeg binary used for compressed formats. Default "ffmpeg"
 	IndexTTSUrl    string `json:"index_tts_url"`
 	VoiceDir       string `json:"voice_dir"`
 	Port           string `json:"port"`
+
+	VoiceDirs       []string `json:"voice_dirs"`        // Voice library directories; the first is where uploads go. Empty uses voice_dir
+	VoiceSampleRate int      `json:"voice_sample_rate"` // Rate prepared voice clips are resampled to. 0 uses 22050, what Index-TTS2 works at
+
+	ChapterPatterns []string `json:"chapter_patterns"` // Heading regexes for text imports; empty uses importer.DefaultChapterPatterns
+	FootnotesAtEnd  bool     `json:"footnotes_at_end"` // Read EPUB footnotes at the end of their chapter rather than where they appear
+
+	TTSConcurrency int              `json:"tts_concurrency"` // Segments synthesised in parallel on the engine's own URL when tts_endpoints is empty. Default 1
+	TTSEndpoints   []TTSEndpoint    `json:"tts_endpoints"`   // Engine replicas; if empty, the engine's own URL is used
+	TTSUploadTTL   int              `json:"tts_upload_ttl"`  // Minutes an uploaded reference voice is reused on an Index-TTS server. Default 60
+	TTSParams      GenerationParams `json:"tts_params"`      // Index-TTS2 sampling settings for every book; characters and segments may override them
+
+	TTSEngine string        `json:"tts_engine"` // "indextts" (default), "openai" or "http"
+	TTSOpenAI OpenAITTS     `json:"tts_openai"` // Settings for the "openai" engine
+	TTSHTTP   HTTPTTSEngine `json:"tts_http"`   // Settings for the "http" engine
+}
+
+// TTSEndpoint is one Index-TTS server and how many requests it may run at once.
+type TTSEndpoint struct {
+	URL         string `json:"url"`
+	Concurrency int    `json:"concurrency"` // Default 1
+}
+
+// OpenAITTS configures an OpenAI-compatible /v1/audio/speech endpoint.
+type OpenAITTS struct {
+	URL    string `json:"url"` // Base URL including /v1, e.g. "https://api.openai.com/v1"
+	APIKey string `json:"api_key"`
+	Model  string `json:"model"` // Default "tts-1"
+	Voice  string `json:"voice"` // Used for characters without a mapped voice. Default "alloy"
 }
 
+// HTTPTTSEngine configures a generic HTTP/JSON TTS server.
+//
+// Template is a Go text/template rendered into the request body with
+// .Text, .Voice (reference path), .VoiceName and .Emotion. The functions
+// "json" (quote as a JSON value) and "base64file" (inline a file) are
+// available. The response is WAV audio, either as the raw body or, when
+// AudioField is set, base64-encoded in that (dot separated) JSON field.
+type HTTPTTSEngine struct {
+	URL          string            `json:"url"`
+	Headers      map[string]string `json:"headers"`
+	Template     string            `json:"template"`
+	AudioField   string            `json:"audio_field"`
+	HealthURL    string            `json:"health_url"`    // Optional; GET must return 2xx
+	VoiceCloning bool              `json:"voice_cloning"` // Template sends the reference audio
+	Emotions     []string          `json:"emotions"`      // Emotions the server understands
+}
+
+// current is the configuration in use. It is replaced whole by Set rather
+// than changed in place, so code holding an earlier one, like a running
+// job, reads it without racing an update.
 var (
-	instance *Config
-	once     sync.Once
-	mu       sync.Mutex
+	current atomic.Pointer[Config]
+	once    sync.Once
+	mu      sync.Mutex
 )
 
 const ConfigFile = "config.json"
 
 func Load() *Config {
 	once.Do(func() {
-		instance = &Config{
+		instance := &Config{
 			LLMBaseURL:     "https://api-inference.modelscope.cn/v1", // DeepSeek on ModelScope
 			LLMModel:       "ZhipuAI/GLM-4.7",
 			LLMProvider:    "openai",
@@ -42,6 +96,9 @@ func Load() *Config {
 			IndexTTSUrl:    "http://127.0.0.1:7860", // Default
 			VoiceDir:       "voices",                // Default local voice directory
 			Port:           "8080",
+			TTSConcurrency: 1,
+			TTSEngine:      "indextts",
+			OutputFormat:   "wav",
 		}
 
 		instance.LLMBaseURL = "https://api-inference.modelscope.cn/v1"
@@ -50,8 +107,9 @@ func Load() *Config {
 		if err == nil {
 			_ = json.Unmarshal(file, instance)
 		}
+		current.Store(instance)
 	})
-	return instance
+	return current.Load()
 }
 
 func (c *Config) Save() error {
@@ -65,9 +123,27 @@ func (c *Config) Save() error {
 	return os.WriteFile(ConfigFile, bytes, 0644)
 }
 
+// VoiceLibrary returns the voice library directories
+func (c *Config) VoiceLibrary() []string {
+	if len(c.VoiceDirs) > 0 {
+		return c.VoiceDirs
+	}
+	if c.VoiceDir != "" {
+		return []string{c.VoiceDir}
+	}
+	return []string{"voices"}
+}
+
 func Get() *Config {
-	if instance == nil {
-		return Load()
+	if c := current.Load(); c != nil {
+		return c
 	}
-	return instance
+	return Load()
+}
+
+// Set makes c the configuration Get returns. c must not be changed
+// afterwards; build a new one for the next update.
+func Set(c *Config) {
+	Load()
+	current.Store(c)
 }
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+)
+
+// GenerationParams are Index-TTS2's sampling settings. Nil fields are unset
+// and inherit from the level above: the built-in defaults, the project's
+// tts_params, the speaking character's voice and finally the segment.
+type GenerationParams struct {
+	EmoWeight         *float64 `json:"emo_weight,omitempty"` // How strongly the emotion vector colours the voice
+	DoSample          *bool    `json:"do_sample,omitempty"`
+	TopP              *float64 `json:"top_p,omitempty"`
+	TopK              *int     `json:"top_k,omitempty"`
+	Temperature       *float64 `json:"temperature,omitempty"`
+	LengthPenalty     *float64 `json:"length_penalty,omitempty"`
+	NumBeams          *int     `json:"num_beams,omitempty"`
+	RepetitionPenalty *float64 `json:"repetition_penalty,omitempty"`
+	MaxMelTokens      *int     `json:"max_mel_tokens,omitempty"`  // Caps the audio length of each sentence
+	MaxTextTokens     *int     `json:"max_text_tokens,omitempty"` // Longer text is split into sentences of at most this many tokens
+}
+
+// DefaultGenerationParams returns the settings used where nothing overrides
+// them, all fields set
+func DefaultGenerationParams() GenerationParams {
+	return GenerationParams{
+		EmoWeight:         ptr(0.3),
+		DoSample:          ptr(true),
+		TopP:              ptr(0.8),
+		TopK:              ptr(30),
+		Temperature:       ptr(0.8),
+		LengthPenalty:     ptr(0.0),
+		NumBeams:          ptr(3),
+		RepetitionPenalty: ptr(10.0),
+		MaxMelTokens:      ptr(1500),
+		MaxTextTokens:     ptr(400),
+	}
+}
+
+// Merge returns p with the fields set in over replacing its own. A nil
+// over changes nothing.
+func (p GenerationParams) Merge(over *GenerationParams) GenerationParams {
+	if over == nil {
+		return p
+	}
+	p.EmoWeight = pick(p.EmoWeight, over.EmoWeight)
+	p.DoSample = pick(p.DoSample, over.DoSample)
+	p.TopP = pick(p.TopP, over.TopP)
+	p.TopK = pick(p.TopK, over.TopK)
+	p.Temperature = pick(p.Temperature, over.Temperature)
+	p.LengthPenalty = pick(p.LengthPenalty, over.LengthPenalty)
+	p.NumBeams = pick(p.NumBeams, over.NumBeams)
+	p.RepetitionPenalty = pick(p.RepetitionPenalty, over.RepetitionPenalty)
+	p.MaxMelTokens = pick(p.MaxMelTokens, over.MaxMelTokens)
+	p.MaxTextTokens = pick(p.MaxTextTokens, over.MaxTextTokens)
+	return p
+}
+
+// IsZero reports whether no field is set
+func (p GenerationParams) IsZero() bool {
+	return p == GenerationParams{}
+}
+
+// Validate checks the set fields against the ranges the Index-TTS2 web UI
+// allows
+func (p GenerationParams) Validate() error {
+	return errors.Join(
+		checkRange("emo_weight", p.EmoWeight, 0, 1),
+		checkRange("top_p", p.TopP, 0, 1),
+		checkRange("top_k", p.TopK, 0, 100),
+		checkRange("temperature", p.Temperature, 0.1, 2),
+		checkRange("length_penalty", p.LengthPenalty, -2, 2),
+		checkRange("num_beams", p.NumBeams, 1, 10),
+		checkRange("repetition_penalty", p.RepetitionPenalty, 0.1, 20),
+		checkRange("max_mel_tokens", p.MaxMelTokens, 50, 1815),
+		checkRange("max_text_tokens", p.MaxTextTokens, 20, 600),
+	)
+}
+
+func checkRange[T int | float64](name string, v *T, min, max T) error {
+	if v != nil && (*v < min || *v > max) {
+		return fmt.Errorf("%s must be between %v and %v, got %v", name, min, max, *v)
+	}
+	return nil
+}
+
+func pick[T any](base, over *T) *T {
+	if over != nil {
+		return over
+	}
+	return base
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,216 @@
+package epub
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// shortPageRunes is the length under which an untitled page ahead of the
+// table of contents (cover, title page, copyright notice) counts as front
+// matter
+const shortPageRunes = 200
+
+// spineDoc is a content document in reading order
+type spineDoc struct {
+	*docText
+	href   string
+	linear bool
+}
+
+// chapterDraft accumulates a chapter while spine documents are walked
+type chapterDraft struct {
+	title    string
+	text     strings.Builder
+	hrefs    []string
+	notes    []string
+	readings []Reading
+	fromTOC  bool // Started by a TOC entry; otherwise a document of its own
+	matter   bool
+	preTOC   bool // Precedes the first TOC entry
+}
+
+// add appends the part of a document between two byte offsets, with the
+// footnotes and readings that fall in it
+func (c *chapterDraft) add(d spineDoc, from, to int) {
+	for _, n := range d.notes {
+		if n.offset >= from && n.offset < to || n.offset == to && to == len(d.text) {
+			c.notes = append(c.notes, n.text)
+		}
+	}
+	for _, r := range d.readings {
+		if r.offset >= from && r.offset < to {
+			c.readings = append(c.readings, r.Reading)
+		}
+	}
+	text := d.text[from:to]
+	if strings.TrimSpace(text) == "" {
+		return
+	}
+	c.text.WriteString(text)
+	if len(c.hrefs) == 0 || c.hrefs[len(c.hrefs)-1] != d.href {
+		c.hrefs = append(c.hrefs, d.href)
+	}
+}
+
+// merge prepends another draft
+func (c *chapterDraft) merge(prev *chapterDraft) {
+	text := prev.text.String() + c.text.String()
+	c.text.Reset()
+	c.text.WriteString(text)
+	c.hrefs = append(append([]string{}, prev.hrefs...), c.hrefs...)
+	c.notes = append(append([]string{}, prev.notes...), c.notes...)
+	c.readings = append(append([]Reading{}, prev.readings...), c.readings...)
+}
+
+// content returns the chapter text with its footnotes at the end
+func (c *chapterDraft) content() string {
+	parts := []string{strings.TrimSpace(c.text.String())}
+	parts = append(parts, c.notes...)
+	return strings.Join(parts, "\n\n") + "\n"
+}
+
+// tocStart is where a TOC entry begins in the spine
+type tocStart struct {
+	title  string
+	doc    int
+	offset int
+}
+
+// buildChapters splits spine documents into chapters by the table of
+// contents. See GetChapters.
+func buildChapters(docs []spineDoc, entries []tocEntry, landmarks map[string]string) []Chapter {
+	// Place TOC entries in the spine. An entry at the same place as the one
+	// before it (a part heading pointing at its first chapter) gives way to
+	// the deeper entry; entries pointing backwards are ignored.
+	docIndex := make(map[string]int)
+	for i, d := range docs {
+		docIndex[d.href] = i
+	}
+	var starts []tocStart
+	for _, e := range entries {
+		i, ok := docIndex[e.href]
+		if !ok {
+			continue
+		}
+		s := tocStart{title: e.title, doc: i, offset: docs[i].anchors[e.anchor]}
+		if n := len(starts); n > 0 {
+			last := starts[n-1]
+			if s.doc == last.doc && s.offset == last.offset {
+				starts[n-1].title = s.title
+				continue
+			}
+			if s.doc < last.doc || (s.doc == last.doc && s.offset < last.offset) {
+				continue
+			}
+		}
+		starts = append(starts, s)
+	}
+
+	var drafts []*chapterDraft
+	var cur *chapterDraft
+	next := 0 // Next TOC start
+	for i, d := range docs {
+		docMatter := !d.linear || isMatter(d.types+" "+landmarks[d.href], d.title)
+		if next >= len(starts) || starts[next].doc != i {
+			// No entry starts here: continue the current chapter, unless
+			// this is a document of its own
+			if cur == nil || !cur.fromTOC || docMatter {
+				cur = &chapterDraft{title: d.title, matter: docMatter, preTOC: next == 0 && len(starts) > 0}
+				drafts = append(drafts, cur)
+			}
+			cur.add(d, 0, len(d.text))
+			continue
+		}
+
+		offset := 0
+		for next < len(starts) && starts[next].doc == i {
+			s := starts[next]
+			if s.offset > offset {
+				if cur == nil {
+					cur = &chapterDraft{title: d.title, preTOC: true}
+					drafts = append(drafts, cur)
+				}
+				cur.add(d, offset, s.offset)
+			}
+			cur = &chapterDraft{title: s.title, fromTOC: true}
+			cur.matter = isMatter(landmarks[d.href], s.title) || (s.offset == 0 && docMatter)
+			drafts = append(drafts, cur)
+			offset = s.offset
+			next++
+		}
+		cur.add(d, offset, len(d.text))
+	}
+
+	// Fold entries that are only a title into the chapter after them
+	var kept []*chapterDraft
+	for i, c := range drafts {
+		text := collapseSpace(c.text.String())
+		if text == "" {
+			continue
+		}
+		if c.fromTOC && text == collapseSpace(c.title) && i+1 < len(drafts) && drafts[i+1].fromTOC {
+			drafts[i+1].merge(c)
+			continue
+		}
+		if c.preTOC && !c.matter && utf8.RuneCountInString(text) < shortPageRunes {
+			c.matter = true
+		}
+		kept = append(kept, c)
+	}
+
+	// Matter before the first story chapter is front matter, the rest back
+	firstStory := len(kept)
+	for i, c := range kept {
+		if !c.matter {
+			firstStory = i
+			break
+		}
+	}
+
+	chapters := make([]Chapter, len(kept))
+	for i, c := range kept {
+		title := c.title
+		if title == "" {
+			title = fmt.Sprintf("Chapter %d", i+1)
+		}
+		chapters[i] = Chapter{
+			ID:       fmt.Sprintf("ch_%03d", i+1),
+			Title:    title,
+			Content:  c.content(),
+			Hrefs:    dedupe(c.hrefs),
+			Readings: dedupeReadings(c.readings),
+		}
+		if c.matter {
+			chapters[i].Matter = "back"
+			if i < firstStory {
+				chapters[i].Matter = "front"
+			}
+		}
+	}
+	return chapters
+}
+
+func dedupe(items []string) []string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, s := range items {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func dedupeReadings(readings []Reading) []Reading {
+	seen := make(map[Reading]bool)
+	var out []Reading
+	for _, r := range readings {
+		if !seen[r] {
+			seen[r] = true
+			out = append(out, r)
+		}
+	}
+	return out
+}
@@ -0,0 +1,122 @@
+package epub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func xhtml(title, body string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">
+<head><title>` + title + `</title></head>
+` + body + `
+</html>`
+}
+
+func TestReader_GetChapters_NCX(t *testing.T) {
+	path := writeEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <manifest>
+    <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml"/>
+    <item id="cover" href="Text/cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="copy" href="Text/copyright.xhtml" media-type="application/xhtml+xml"/>
+    <item id="part1" href="Text/part1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1" href="Text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch1b" href="Text/ch1b.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ch2" href="Text/ch2%20and%203.xhtml" media-type="application/xhtml+xml"/>
+    <item id="ack" href="Text/ack.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine toc="ncx">
+    <itemref idref="cover"/><itemref idref="copy"/><itemref idref="part1"/><itemref idref="ch1"/>
+    <itemref idref="ch1b"/><itemref idref="ch2"/><itemref idref="ack"/>
+  </spine>
+  <guide><reference type="cover" href="Text/cover.xhtml"/></guide>
+</package>`,
+		"OEBPS/toc.ncx": `<?xml version="1.0"?>
+<ncx xmlns="http://www.daisy.org/z3986/2005/ncx/" version="2005-1"><navMap>
+  <navPoint><navLabel><text>第一部</text></navLabel><content src="Text/part1.xhtml"/>
+    <navPoint><navLabel><text>第一章 开始</text></navLabel><content src="Text/ch1.xhtml"/></navPoint>
+    <navPoint><navLabel><text>第二章</text></navLabel><content src="Text/ch2%20and%203.xhtml#s2"/></navPoint>
+    <navPoint><navLabel><text>第三章</text></navLabel><content src="Text/ch2%20and%203.xhtml#s3"/></navPoint>
+  </navPoint>
+  <navPoint><navLabel><text>致谢</text></navLabel><content src="Text/ack.xhtml"/></navPoint>
+</navMap></ncx>`,
+		"OEBPS/Text/cover.xhtml":       xhtml("Cover", `<body><p>书名</p></body>`),
+		"OEBPS/Text/copyright.xhtml":   xhtml("", `<body><p>版权所有，翻印必究。</p></body>`),
+		"OEBPS/Text/part1.xhtml":       xhtml("", `<body><h1>第一部</h1></body>`),
+		"OEBPS/Text/ch1.xhtml":         xhtml("", `<body><h2>第一章</h2><p>正文一。</p></body>`),
+		"OEBPS/Text/ch1b.xhtml":        xhtml("", `<body><p>续篇。</p></body>`),
+		"OEBPS/Text/ch2 and 3.xhtml":   xhtml("", `<body><h2 id="s2">第二章</h2><p>二。</p><h2 id="s3">第三章</h2><p>三。</p></body>`),
+		"OEBPS/Text/ack.xhtml":         xhtml("", `<body><p>感谢所有人。</p></body>`),
+		"OEBPS/Text/unused-file.xhtml": xhtml("", `<body><p>不在书脊里。</p></body>`),
+	})
+
+	chapters, err := (&Reader{path: path}).GetChapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Chapter{
+		{ID: "ch_001", Title: "Cover", Content: "书名\n", Hrefs: []string{"OEBPS/Text/cover.xhtml"}, Matter: "front"},
+		{ID: "ch_002", Title: "Chapter 2", Content: "版权所有，翻印必究。\n", Hrefs: []string{"OEBPS/Text/copyright.xhtml"}, Matter: "front"},
+		{ID: "ch_003", Title: "第一章 开始", Content: "第一部\n\n第一章\n\n正文一。\n\n续篇。\n",
+			Hrefs: []string{"OEBPS/Text/part1.xhtml", "OEBPS/Text/ch1.xhtml", "OEBPS/Text/ch1b.xhtml"}},
+		{ID: "ch_004", Title: "第二章", Content: "第二章\n\n二。\n", Hrefs: []string{"OEBPS/Text/ch2 and 3.xhtml"}},
+		{ID: "ch_005", Title: "第三章", Content: "第三章\n\n三。\n", Hrefs: []string{"OEBPS/Text/ch2 and 3.xhtml"}},
+		{ID: "ch_006", Title: "致谢", Content: "感谢所有人。\n", Hrefs: []string{"OEBPS/Text/ack.xhtml"}, Matter: "back"},
+	}
+	if !reflect.DeepEqual(chapters, want) {
+		t.Errorf("GetChapters() =\n%+v\nwant\n%+v", chapters, want)
+	}
+}
+
+func TestReader_GetChapters_Nav(t *testing.T) {
+	path := writeEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <manifest>
+    <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>
+    <item id="title" href="title.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c1" href="c1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2" href="c2.xhtml" media-type="application/xhtml+xml"/>
+    <item id="notes" href="notes.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine>
+    <itemref idref="title"/><itemref idref="c1"/><itemref idref="c2"/><itemref idref="notes" linear="no"/>
+  </spine>
+</package>`,
+		"OEBPS/nav.xhtml": xhtml("Nav", `<body>
+<nav epub:type="toc"><ol>
+  <li><a href="c1.xhtml">One</a></li>
+  <li><a href="c2.xhtml">Two</a></li>
+  <li><a href="https://example.com/">Elsewhere</a></li>
+</ol></nav>
+<nav epub:type="landmarks"><ol><li><a epub:type="titlepage" href="title.xhtml">Title</a></li></ol></nav>
+</body>`),
+		"OEBPS/title.xhtml": xhtml("", `<body><h1>The Book</h1><p>`+longText+`</p></body>`),
+		"OEBPS/c1.xhtml":    xhtml("", `<body><section epub:type="chapter"><p>First.</p></section></body>`),
+		"OEBPS/c2.xhtml":    xhtml("", `<body><p>Second.</p></body>`),
+		"OEBPS/notes.xhtml": xhtml("", `<body><aside><h2>Notes</h2><p>A note.</p></aside></body>`),
+	})
+
+	chapters, err := (&Reader{path: path}).GetChapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got [][2]string
+	for _, ch := range chapters {
+		got = append(got, [2]string{ch.Title, ch.Matter})
+	}
+	want := [][2]string{{"The Book", "front"}, {"One", ""}, {"Two", ""}, {"Notes", "back"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("titles and matter = %v, want %v", got, want)
+	}
+}
+
+// longText is long enough that a page ahead of the TOC is not taken for
+// front matter by its length alone
+const longText = "It was a bright cold day in April, and the clocks were striking thirteen. " +
+	"Winston Smith, his chin nuzzled into his breast in an effort to escape the vile wind, " +
+	"slipped quickly through the glass doors of Victory Mansions."
@@ -0,0 +1,306 @@
+package epub
+
+import (
+	"archive/zip"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Metadata is the book information declared in the OPF package document
+type Metadata struct {
+	Title       string       `json:"title"`
+	Creators    []Person     `json:"creators,omitempty"` // Creators and contributors, in document order
+	Authors     []string     `json:"authors"`
+	Narrators   []string     `json:"narrators"`
+	Language    string       `json:"language"`
+	Publisher   string       `json:"publisher,omitempty"`
+	Date        string       `json:"date,omitempty"`
+	Description string       `json:"description,omitempty"` // Plain text
+	Subjects    []string     `json:"subjects,omitempty"`
+	Identifiers []Identifier `json:"identifiers,omitempty"`
+	Series      string       `json:"series,omitempty"`
+	SeriesIndex string       `json:"seriesIndex,omitempty"` // As written, e.g. "2" or "2.5"
+}
+
+// Person is a creator or contributor
+type Person struct {
+	Name   string `json:"name"`
+	FileAs string `json:"fileAs,omitempty"` // Sort form, e.g. "Orwell, George"
+	Role   string `json:"role,omitempty"`   // MARC relator code: "aut", "nrt", "trl", "edt"...
+}
+
+// Identifier is a dc:identifier with its scheme, when one is known
+type Identifier struct {
+	Scheme string `json:"scheme,omitempty"` // Lower case: "isbn", "uuid", "asin"...
+	Value  string `json:"value"`
+}
+
+// identifierPrefixes are URN and similar prefixes that name an identifier's
+// scheme inside its value
+var identifierPrefixes = []string{"urn:isbn:", "isbn:", "urn:uuid:", "uuid:", "urn:doi:", "doi:", "asin:", "calibre:"}
+
+// GetMetadata reads the Dublin Core metadata from the OPF. Roles, sort
+// names and identifier schemes may be given as EPUB2 attributes or EPUB3
+// refining metas. The series comes from an EPUB3 belongs-to-collection or
+// from calibre's series metas.
+func (r *Reader) GetMetadata() (*Metadata, error) {
+	z, err := zip.OpenReader(r.path)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	pkg, _, err := openPackage(z)
+	if err != nil {
+		return nil, err
+	}
+	md := &pkg.Metadata
+
+	// EPUB3 refines other elements with <meta refines="#id" property="...">
+	refines := make(map[string]map[string]string)
+	for _, m := range md.Meta {
+		if m.Property == "" || !strings.HasPrefix(m.Refines, "#") {
+			continue
+		}
+		id := strings.TrimPrefix(m.Refines, "#")
+		if refines[id] == nil {
+			refines[id] = make(map[string]string)
+		}
+		refines[id][m.Property] = strings.TrimSpace(m.Value)
+	}
+	refined := func(id, property string) string {
+		if id == "" {
+			return ""
+		}
+		return refines[id][property]
+	}
+
+	meta := &Metadata{
+		Title:       strings.TrimSpace(md.Title),
+		Language:    strings.TrimSpace(md.Language),
+		Publisher:   strings.TrimSpace(md.Publisher),
+		Description: plainText(md.Description),
+	}
+	if len(md.Date) > 0 {
+		meta.Date = strings.TrimSpace(md.Date[0])
+	}
+	for _, s := range md.Subject {
+		if s = strings.TrimSpace(s); s != "" {
+			meta.Subjects = append(meta.Subjects, s)
+		}
+	}
+
+	add := func(c Creator, fallback string) {
+		p := Person{Name: strings.TrimSpace(c.Name), FileAs: c.FileAs, Role: c.Role}
+		if p.Name == "" {
+			return
+		}
+		if p.Role == "" {
+			p.Role = refined(c.ID, "role")
+		}
+		if p.Role == "" {
+			p.Role = fallback
+		}
+		if p.FileAs == "" {
+			p.FileAs = refined(c.ID, "file-as")
+		}
+		meta.Creators = append(meta.Creators, p)
+		switch p.Role {
+		case "aut":
+			meta.Authors = append(meta.Authors, p.Name)
+		case "nrt":
+			meta.Narrators = append(meta.Narrators, p.Name)
+		}
+	}
+	for _, c := range md.Creator {
+		add(c, "aut")
+	}
+	for _, c := range md.Contributor {
+		add(c, "")
+	}
+
+	for _, id := range md.Identifier {
+		ident := Identifier{Scheme: strings.ToLower(id.Scheme), Value: strings.TrimSpace(id.Value)}
+		if ident.Value == "" {
+			continue
+		}
+		lower := strings.ToLower(ident.Value)
+		for _, prefix := range identifierPrefixes {
+			if strings.HasPrefix(lower, prefix) {
+				ident.Value = ident.Value[len(prefix):]
+				if ident.Scheme == "" {
+					ident.Scheme = strings.TrimSuffix(strings.TrimPrefix(prefix, "urn:"), ":")
+				}
+				break
+			}
+		}
+		if ident.Scheme == "" {
+			ident.Scheme = strings.ToLower(refined(id.ID, "identifier-type"))
+		}
+		meta.Identifiers = append(meta.Identifiers, ident)
+	}
+
+	for _, m := range md.Meta {
+		switch {
+		case m.Property == "belongs-to-collection" && meta.Series == "":
+			if t := refined(m.ID, "collection-type"); t != "" && t != "series" {
+				continue
+			}
+			meta.Series = strings.TrimSpace(m.Value)
+			meta.SeriesIndex = refined(m.ID, "group-position")
+		case m.Name == "calibre:series" && meta.Series == "":
+			meta.Series = strings.TrimSpace(m.Content)
+		case m.Name == "calibre:series_index" && meta.SeriesIndex == "":
+			meta.SeriesIndex = strings.TrimSpace(m.Content)
+		}
+	}
+	if meta.Series == "" {
+		meta.SeriesIndex = ""
+	}
+	return meta, nil
+}
+
+// plainText strips markup from a description, which calibre and others
+// store as HTML
+func plainText(s string) string {
+	if !strings.Contains(s, "<") {
+		return strings.TrimSpace(s)
+	}
+	doc, err := html.Parse(strings.NewReader(s))
+	if err != nil {
+		return strings.TrimSpace(s)
+	}
+	e := &textExtractor{anchors: make(map[string]int)}
+	e.walk(doc)
+	e.flush()
+	return strings.TrimSpace(e.out.String())
+}
+
+// GetCover returns the cover image and its media type. It looks for the
+// EPUB3 cover-image property, then the EPUB2 <meta name="cover">, then the
+// first image on the cover page named in the guide, and finally any image
+// named like a cover.
+func (r *Reader) GetCover() ([]byte, string, error) {
+	z, err := zip.OpenReader(r.path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer z.Close()
+
+	pkg, opfPath, err := openPackage(z)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var coverID string
+	for _, m := range pkg.Metadata.Meta {
+		if m.Name == "cover" {
+			coverID = m.Content
+		}
+	}
+
+	images := make(map[string]string) // Zip path -> media type
+	var coverPath, namedPath string
+	for _, item := range pkg.Manifest.Item {
+		if !strings.HasPrefix(item.MediaType, "image/") {
+			continue
+		}
+		itemPath := resolveHref(opfPath, item.Href)
+		images[itemPath] = item.MediaType
+		switch {
+		case hasProperty(item.Properties, "cover-image"):
+			coverPath = itemPath
+		case coverID != "" && item.ID == coverID && coverPath == "":
+			coverPath = itemPath
+		case namedPath == "" && (strings.Contains(strings.ToLower(item.ID), "cover") ||
+			strings.Contains(strings.ToLower(path.Base(item.Href)), "cover")):
+			namedPath = itemPath
+		}
+	}
+	if coverPath == "" {
+		for _, ref := range pkg.Guide.Reference {
+			if strings.EqualFold(ref.Type, "cover") {
+				page, _ := resolveLink(opfPath, ref.Href)
+				coverPath = firstImage(z, page)
+				break
+			}
+		}
+	}
+	if coverPath == "" {
+		coverPath = namedPath
+	}
+	if coverPath == "" {
+		return nil, "", fmt.Errorf("no cover image declared")
+	}
+	mediaType, ok := images[coverPath]
+	if !ok {
+		return nil, "", fmt.Errorf("cover image not in manifest: %s", coverPath)
+	}
+
+	f, err := findFileInZip(z, coverPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("cover image not found: %s", coverPath)
+	}
+	data, err := readZipFile(f)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, mediaType, nil
+}
+
+// firstImage returns the zip path of the first <img> or SVG <image> in an
+// XHTML page
+func firstImage(z *zip.ReadCloser, page string) string {
+	f, err := findFileInZip(z, page)
+	if err != nil {
+		return ""
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	doc, err := html.Parse(rc)
+	if err != nil {
+		return ""
+	}
+
+	var src string
+	var find func(n *html.Node)
+	find = func(n *html.Node) {
+		if src != "" {
+			return
+		}
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "img":
+				src = htmlAttr(n, "src")
+			case "image":
+				if src = htmlAttr(n, "xlink:href"); src == "" {
+					src = htmlAttr(n, "href")
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c)
+		}
+	}
+	find(doc)
+	if src == "" {
+		return ""
+	}
+	target, _ := resolveLink(page, src)
+	return target
+}
+
+func hasProperty(properties, name string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
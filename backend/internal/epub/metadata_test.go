@@ -0,0 +1,187 @@
+package epub
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeEPUB zips files into a temporary .epub and returns its path
+func writeEPUB(t *testing.T, files map[string]string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "book.epub")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testContainer = `<?xml version="1.0"?>
+<container version="1.0" xmlns="urn:oasis:names:tc:opendocument:xmlns:container">
+  <rootfiles><rootfile full-path="OEBPS/content.opf" media-type="application/oebps-package+xml"/></rootfiles>
+</container>`
+
+func TestReader_GetMetadata(t *testing.T) {
+	path := writeEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>活着</dc:title>
+    <dc:language>zh</dc:language>
+    <dc:creator id="c1">余华</dc:creator>
+    <dc:creator opf:role="nrt">张三</dc:creator>
+    <dc:contributor id="c3">李四</dc:contributor>
+    <meta refines="#c3" property="role" scheme="marc:relators">nrt</meta>
+    <meta name="cover" content="cover-img"/>
+  </metadata>
+  <manifest>
+    <item id="cover-img" href="images/cover.jpg" media-type="image/jpeg"/>
+  </manifest>
+  <spine/>
+</package>`,
+		"OEBPS/images/cover.jpg": "JPEGDATA",
+	})
+	r, _ := NewReader(path)
+
+	meta, err := r.GetMetadata()
+	if err != nil {
+		t.Fatalf("GetMetadata() error = %v", err)
+	}
+	if meta.Title != "活着" || meta.Language != "zh" {
+		t.Errorf("title/language = %q/%q", meta.Title, meta.Language)
+	}
+	if len(meta.Authors) != 1 || meta.Authors[0] != "余华" {
+		t.Errorf("authors = %v", meta.Authors)
+	}
+	if len(meta.Narrators) != 2 || meta.Narrators[0] != "张三" || meta.Narrators[1] != "李四" {
+		t.Errorf("narrators = %v", meta.Narrators)
+	}
+
+	cover, mediaType, err := r.GetCover()
+	if err != nil {
+		t.Fatalf("GetCover() error = %v", err)
+	}
+	if string(cover) != "JPEGDATA" || mediaType != "image/jpeg" {
+		t.Errorf("cover = %q (%s)", cover, mediaType)
+	}
+}
+
+func TestReader_GetMetadata_Full(t *testing.T) {
+	path := writeEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">
+    <dc:title>1984</dc:title>
+    <dc:creator opf:role="aut" opf:file-as="Orwell, George">George Orwell</dc:creator>
+    <dc:contributor opf:role="trl">董乐山</dc:contributor>
+    <dc:publisher>上海译文出版社</dc:publisher>
+    <dc:date opf:event="publication">2009-01-01</dc:date>
+    <dc:description>&lt;p&gt;A &lt;b&gt;dystopian&lt;/b&gt; novel.&lt;/p&gt;</dc:description>
+    <dc:subject>Fiction</dc:subject>
+    <dc:subject>Classics</dc:subject>
+    <dc:identifier opf:scheme="ISBN">9787532750870</dc:identifier>
+    <dc:identifier>urn:uuid:1b2c3d</dc:identifier>
+    <meta name="calibre:series" content="Penguin Modern Classics"/>
+    <meta name="calibre:series_index" content="3.0"/>
+  </metadata>
+  <manifest>
+    <item id="cover-page" href="Text/cover.xhtml" media-type="application/xhtml+xml"/>
+    <item id="img1" href="Images/front.png" media-type="image/png"/>
+  </manifest>
+  <spine><itemref idref="cover-page"/></spine>
+  <guide><reference type="cover" href="Text/cover.xhtml"/></guide>
+</package>`,
+		"OEBPS/Text/cover.xhtml": xhtml("Cover", `<body><div><img src="../Images/front.png" alt=""/></div></body>`),
+		"OEBPS/Images/front.png": "PNGDATA",
+	})
+	r, _ := NewReader(path)
+
+	meta, err := r.GetMetadata()
+	if err != nil {
+		t.Fatalf("GetMetadata() error = %v", err)
+	}
+	want := &Metadata{
+		Title: "1984",
+		Creators: []Person{
+			{Name: "George Orwell", FileAs: "Orwell, George", Role: "aut"},
+			{Name: "董乐山", Role: "trl"},
+		},
+		Authors:     []string{"George Orwell"},
+		Publisher:   "上海译文出版社",
+		Date:        "2009-01-01",
+		Description: "A dystopian novel.",
+		Subjects:    []string{"Fiction", "Classics"},
+		Identifiers: []Identifier{{Scheme: "isbn", Value: "9787532750870"}, {Scheme: "uuid", Value: "1b2c3d"}},
+		Series:      "Penguin Modern Classics",
+		SeriesIndex: "3.0",
+	}
+	if !reflect.DeepEqual(meta, want) {
+		t.Errorf("GetMetadata() =\n%+v\nwant\n%+v", meta, want)
+	}
+
+	cover, mediaType, err := r.GetCover()
+	if err != nil {
+		t.Fatalf("GetCover() error = %v", err)
+	}
+	if string(cover) != "PNGDATA" || mediaType != "image/png" {
+		t.Errorf("cover = %q (%s)", cover, mediaType)
+	}
+}
+
+func TestReader_GetMetadata_EPUB3Series(t *testing.T) {
+	path := writeEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>三体</dc:title>
+    <dc:creator id="a1">刘慈欣</dc:creator>
+    <meta refines="#a1" property="file-as">Liu, Cixin</meta>
+    <dc:identifier id="isbn">9787536692930</dc:identifier>
+    <meta refines="#isbn" property="identifier-type" scheme="onix:codelist5">ISBN</meta>
+    <meta property="belongs-to-collection" id="set">科幻世界精品集</meta>
+    <meta refines="#set" property="collection-type">set</meta>
+    <meta property="belongs-to-collection" id="series">地球往事</meta>
+    <meta refines="#series" property="collection-type">series</meta>
+    <meta refines="#series" property="group-position">1</meta>
+  </metadata>
+  <manifest/>
+  <spine/>
+</package>`,
+	})
+	r, _ := NewReader(path)
+	meta, err := r.GetMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Series != "地球往事" || meta.SeriesIndex != "1" {
+		t.Errorf("series = %q #%q", meta.Series, meta.SeriesIndex)
+	}
+	if len(meta.Creators) != 1 || meta.Creators[0].FileAs != "Liu, Cixin" {
+		t.Errorf("creators = %+v", meta.Creators)
+	}
+	if len(meta.Identifiers) != 1 || meta.Identifiers[0] != (Identifier{Scheme: "isbn", Value: "9787536692930"}) {
+		t.Errorf("identifiers = %+v", meta.Identifiers)
+	}
+	if _, _, err := r.GetCover(); err == nil {
+		t.Error("GetCover() should fail without a cover")
+	}
+}
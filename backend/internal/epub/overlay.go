@@ -0,0 +1,659 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// OverlayChapter is the audio and timed text of one chapter to attach as an
+// EPUB 3 media overlay.
+type OverlayChapter struct {
+	ID        string   // Chapter.ID, used to name the audio file
+	Hrefs     []string // Chapter.Hrefs
+	Title     string
+	AudioPath string // Local file to embed
+	AudioType string // MIME type of AudioPath
+	Clips     []Clip
+}
+
+// Clip is a span of chapter text and where it is spoken in the audio
+type Clip struct {
+	Text  string
+	Start time.Duration
+	End   time.Duration
+}
+
+// overlayActiveClass is the CSS class reading systems put on the text being read
+const overlayActiveClass = "-epub-media-overlay-active"
+
+const overlayCSS = "." + overlayActiveClass + " { background-color: #ffe58f; }\n"
+
+// overlayPart is one highlighted span of a clip. A clip is split into parts
+// where its text crosses element boundaries.
+type overlayPart struct {
+	id    string
+	runes int
+}
+
+// overlayDoc is a content document that has been given a media overlay
+type overlayDoc struct {
+	href     string // Zip path of the XHTML
+	id       string // Manifest id of its SMIL
+	smilPath string
+	duration time.Duration
+}
+
+// overlayAudio is a chapter audio file added to the package
+type overlayAudio struct {
+	id        string
+	path      string // Zip path
+	mediaType string
+}
+
+// WriteMediaOverlays copies the EPUB at srcPath to dstPath with each
+// chapter's audio, a SMIL document per content document and the OPF entries
+// that tie them together. Content documents are rewritten so that every
+// clip's text is wrapped in spans that SMIL can reference. A chapter may
+// span several documents and a document may hold several chapters; clips
+// are matched against each document in reading order. Documents that cannot
+// be rewritten are copied unchanged and get no overlay. EPUB 2 packages are
+// upgraded to version 3.0, with a generated navigation document.
+func WriteMediaOverlays(srcPath, dstPath string, chapters []OverlayChapter) error {
+	z, err := zip.OpenReader(srcPath)
+	if err != nil {
+		return err
+	}
+	defer z.Close()
+
+	pkg, opfPath, err := openPackage(z)
+	if err != nil {
+		return err
+	}
+	opfDir := path.Dir(opfPath)
+	inPackage := func(name string) string {
+		if opfDir == "." {
+			return name
+		}
+		return opfDir + "/" + name
+	}
+	cssPath := inPackage("tts-overlay.css")
+
+	// Documents in reading order, with the chapters whose text each holds
+	var docOrder []string
+	docChapters := make(map[string][]int)
+	for i, ch := range chapters {
+		for _, href := range ch.Hrefs {
+			if _, seen := docChapters[href]; !seen {
+				docOrder = append(docOrder, href)
+			}
+			docChapters[href] = append(docChapters[href], i)
+		}
+	}
+	audioPaths := make([]string, len(chapters))
+	for i, ch := range chapters {
+		audioPaths[i] = inPackage("audio/" + ch.ID + filepath.Ext(ch.AudioPath))
+	}
+
+	// Rewrite content documents and build their SMIL
+	replaced := make(map[string][]byte) // Zip path -> new content
+	added := make(map[string]string)    // Zip path -> local file to embed
+	next := make([]int, len(chapters))  // First clip of each chapter not yet placed
+	used := make([]bool, len(chapters))
+	var docs []overlayDoc
+	for _, href := range docOrder {
+		f, err := findFileInZip(z, href)
+		if err != nil {
+			continue
+		}
+
+		// Offer every clip not yet placed of the chapters in this document
+		var clips []Clip
+		var audioHrefs []string
+		var owners [][2]int // Chapter and clip index of each offered clip
+		for _, ci := range docChapters[href] {
+			for k := next[ci]; k < len(chapters[ci].Clips); k++ {
+				clips = append(clips, chapters[ci].Clips[k])
+				audioHrefs = append(audioHrefs, audioPaths[ci])
+				owners = append(owners, [2]int{ci, k})
+			}
+		}
+		if len(clips) == 0 {
+			continue
+		}
+
+		content, err := readZipFile(f)
+		if err != nil {
+			return err
+		}
+		rewritten, parts, err := rewriteDocument(content, clips, relPath(href, cssPath))
+		if err != nil {
+			fmt.Printf("[Overlay] Skipping %s: %v\n", href, err)
+			continue
+		}
+		matched := false
+		for k, p := range parts {
+			if len(p) > 0 {
+				matched = true
+				ci, clip := owners[k][0], owners[k][1]
+				next[ci] = clip + 1
+				used[ci] = true
+			}
+		}
+		if !matched {
+			fmt.Printf("[Overlay] Skipping %s: text does not match its audio\n", href)
+			continue
+		}
+
+		n := len(docs) + 1
+		doc := overlayDoc{
+			href:     href,
+			id:       fmt.Sprintf("mo-%03d", n),
+			smilPath: inPackage(fmt.Sprintf("overlays/%03d.smil", n)),
+		}
+		for i := range audioHrefs {
+			audioHrefs[i] = relPath(doc.smilPath, audioHrefs[i])
+		}
+		var smil []byte
+		smil, doc.duration = buildSMIL(doc, relPath(doc.smilPath, href), clips, audioHrefs, parts)
+		replaced[href] = rewritten
+		replaced[doc.smilPath] = smil
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return fmt.Errorf("no chapter text could be matched to its audio")
+	}
+
+	var audios []overlayAudio
+	for i, ch := range chapters {
+		if used[i] {
+			added[audioPaths[i]] = ch.AudioPath
+			audios = append(audios, overlayAudio{id: "audio-" + ch.ID, path: audioPaths[i], mediaType: ch.AudioType})
+		}
+	}
+
+	hasNav := false
+	for _, item := range pkg.Manifest.Item {
+		if hasProperty(item.Properties, "nav") {
+			hasNav = true
+		}
+	}
+	navPath := inPackage("tts-nav.xhtml")
+	if !hasNav {
+		replaced[navPath] = buildNav(chapters, navPath)
+	}
+
+	opfFile, err := findFileInZip(z, opfPath)
+	if err != nil {
+		return err
+	}
+	opf, err := readZipFile(opfFile)
+	if err != nil {
+		return err
+	}
+	newOPF, err := rewriteOPF(opf, opfDir, docs, audios, !hasNav)
+	if err != nil {
+		return fmt.Errorf("failed to update opf: %v", err)
+	}
+	replaced[opfPath] = newOPF
+	replaced[cssPath] = []byte(overlayCSS)
+
+	return writeZip(z, dstPath, replaced, added)
+}
+
+// rewriteDocument wraps the text of each clip in spans and links the overlay
+// stylesheet. It returns the new document and the spans of each clip.
+func rewriteDocument(content []byte, clips []Clip, cssHref string) ([]byte, [][]overlayPart, error) {
+	stream, err := bodyText(content)
+	if err != nil {
+		return nil, nil, err
+	}
+	ranges := alignClips(stream, clips)
+
+	parts := make([][]overlayPart, len(clips))
+	d := newRawDecoder(bytes.NewReader(content))
+	var w tokenWriter
+	var stack []string
+	pos := 0  // Non-space runes of body text seen so far
+	clip := 0 // First clip that may still own text at pos
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, stackName(t))
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if t.Name.Local == "head" {
+				w.WriteRaw(fmt.Sprintf(`<link rel="stylesheet" type="text/css" href="%s"/>`, escapeAttr(cssHref)))
+			}
+		case xml.CharData:
+			if !spannable(stack, string(t)) {
+				break
+			}
+			owner := func(r rune) int {
+				if unicode.IsSpace(r) {
+					return -2 // Follows the previous rune
+				}
+				for clip < len(ranges) && (ranges[clip][0] < 0 || ranges[clip][1] <= pos) {
+					clip++
+				}
+				k := -1
+				if clip < len(ranges) && ranges[clip][0] <= pos {
+					k = clip
+				}
+				pos++
+				return k
+			}
+			w.WriteRaw(wrapText(string(t), owner, parts))
+			continue
+		}
+		w.Write(tok)
+	}
+	return w.Bytes(), parts, nil
+}
+
+// wrapText splits text into runs by owning clip and wraps owned runs in
+// spans, recording each span in parts. owner returns -2 for whitespace,
+// which stays with the current run.
+func wrapText(text string, owner func(rune) int, parts [][]overlayPart) string {
+	var out, run strings.Builder
+	cur, runes := -1, 0
+	emit := func() {
+		if run.Len() == 0 {
+			return
+		}
+		if cur < 0 {
+			out.WriteString(escapeText(run.String()))
+		} else {
+			id := fmt.Sprintf("ttsb-%d-%d", cur, len(parts[cur]))
+			parts[cur] = append(parts[cur], overlayPart{id: id, runes: runes})
+			fmt.Fprintf(&out, `<span id="%s">%s</span>`, id, escapeText(run.String()))
+		}
+		run.Reset()
+		runes = 0
+	}
+	for _, r := range text {
+		k := owner(r)
+		if k == -2 {
+			k = cur
+		}
+		if k != cur {
+			emit()
+			cur = k
+		}
+		run.WriteRune(r)
+		if !unicode.IsSpace(r) {
+			runes++
+		}
+	}
+	emit()
+	return out.String()
+}
+
+// bodyText returns the non-whitespace runes of the text that rewriteDocument
+// may wrap, in document order.
+func bodyText(content []byte) ([]rune, error) {
+	d := newRawDecoder(bytes.NewReader(content))
+	var stack []string
+	var out []rune
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, stackName(t))
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if spannable(stack, string(t)) {
+				out = append(out, []rune(stripSpaces(string(t)))...)
+			}
+		}
+	}
+}
+
+// stackName is the name an element is tracked under while rewriting. Text
+// that GetChapters leaves out (footnote references and page numbers) is
+// marked so that it is skipped here too.
+func stackName(t xml.StartElement) string {
+	types, _ := attr(t, "type")
+	role, _ := attr(t, "role")
+	types += " " + role
+	for _, skip := range []string{"noteref", "doc-noteref", "pagebreak", "doc-pagebreak"} {
+		if hasProperty(types, skip) {
+			return "#skip"
+		}
+	}
+	if href, _ := attr(t, "href"); t.Name.Local == "a" && strings.Contains(href, "#") {
+		return "#link"
+	}
+	return t.Name.Local
+}
+
+// spannable reports whether text under the given element stack is body text
+// that may be wrapped in a span.
+func spannable(stack []string, text string) bool {
+	inBody := false
+	for _, name := range stack {
+		switch name {
+		case "body":
+			inBody = true
+		case "script", "style", "textarea", "option", "title", "rt", "rp", "#skip":
+			return false
+		case "#link":
+			if noteMarker.MatchString(strings.TrimSpace(text)) {
+				return false
+			}
+		}
+	}
+	return inBody
+}
+
+// alignClips locates each clip's text in a document's body text, ignoring
+// whitespace and searching forward from the previous match. Ranges are in
+// runes; clips that cannot be found get {-1, -1}.
+func alignClips(stream []rune, clips []Clip) [][2]int {
+	s := string(stream)
+	ranges := make([][2]int, len(clips))
+	byteCur, runeCur := 0, 0
+	for i, c := range clips {
+		ranges[i] = [2]int{-1, -1}
+		t := stripSpaces(c.Text)
+		if t == "" {
+			continue
+		}
+		idx := strings.Index(s[byteCur:], t)
+		if idx < 0 {
+			continue
+		}
+		start := runeCur + utf8.RuneCountInString(s[byteCur:byteCur+idx])
+		end := start + utf8.RuneCountInString(t)
+		ranges[i] = [2]int{start, end}
+		byteCur += idx + len(t)
+		runeCur = end
+	}
+	return ranges
+}
+
+func stripSpaces(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// buildSMIL writes the overlay document for a content document and returns
+// its playing time. Each clip's time is divided between its spans in
+// proportion to their length.
+func buildSMIL(doc overlayDoc, textHref string, clips []Clip, audioHrefs []string, parts [][]overlayPart) ([]byte, time.Duration) {
+	var sb strings.Builder
+	var duration time.Duration
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	sb.WriteString(`<smil xmlns="http://www.w3.org/ns/SMIL" xmlns:epub="http://www.idpf.org/2007/ops" version="3.0">` + "\n")
+	fmt.Fprintf(&sb, "  <body>\n    <seq id=\"seq-%s\" epub:textref=\"%s\">\n", doc.id, escapeAttr(textHref))
+	for k, clipParts := range parts {
+		if len(clipParts) == 0 {
+			continue
+		}
+		total := 0
+		for _, p := range clipParts {
+			total += p.runes
+		}
+		start := clips[k].Start
+		span := clips[k].End - start
+		duration += span
+		done := 0
+		for i, p := range clipParts {
+			begin := start + span*time.Duration(done)/time.Duration(max(total, 1))
+			done += p.runes
+			end := start + span*time.Duration(done)/time.Duration(max(total, 1))
+			if i == len(clipParts)-1 {
+				end = clips[k].End
+			}
+			fmt.Fprintf(&sb, "      <par id=\"par-%s\">\n", p.id)
+			fmt.Fprintf(&sb, "        <text src=\"%s#%s\"/>\n", escapeAttr(textHref), p.id)
+			fmt.Fprintf(&sb, "        <audio src=\"%s\" clipBegin=\"%s\" clipEnd=\"%s\"/>\n",
+				escapeAttr(audioHrefs[k]), clockValue(begin), clockValue(end))
+			sb.WriteString("      </par>\n")
+		}
+	}
+	sb.WriteString("    </seq>\n  </body>\n</smil>\n")
+	return []byte(sb.String()), duration
+}
+
+// buildNav writes a minimal EPUB 3 navigation document listing the chapters
+func buildNav(chapters []OverlayChapter, navPath string) []byte {
+	var sb strings.Builder
+	sb.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n<!DOCTYPE html>\n")
+	sb.WriteString(`<html xmlns="http://www.w3.org/1999/xhtml" xmlns:epub="http://www.idpf.org/2007/ops">` + "\n")
+	sb.WriteString("<head><title>Contents</title></head>\n<body>\n<nav epub:type=\"toc\"><ol>\n")
+	for _, ch := range chapters {
+		if len(ch.Hrefs) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "<li><a href=\"%s\">%s</a></li>\n", escapeAttr(relPath(navPath, ch.Hrefs[0])), escapeText(ch.Title))
+	}
+	sb.WriteString("</ol></nav>\n</body>\n</html>\n")
+	return []byte(sb.String())
+}
+
+// rewriteOPF upgrades the package to EPUB 3, points each overlaid document's
+// manifest item at its SMIL and declares the new files and durations.
+func rewriteOPF(opf []byte, opfDir string, docs []overlayDoc, audios []overlayAudio, addNav bool) ([]byte, error) {
+	local := func(p string) string {
+		if opfDir == "." {
+			return p
+		}
+		return strings.TrimPrefix(p, opfDir+"/")
+	}
+	byHref := make(map[string]overlayDoc)
+	var total time.Duration
+	for _, doc := range docs {
+		byHref[local(doc.href)] = doc
+		total += doc.duration
+	}
+
+	d := newRawDecoder(bytes.NewReader(opf))
+	var w tokenWriter
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "package":
+				setAttr(&t, "version", "3.0")
+			case "item":
+				href, _ := attr(t, "href")
+				if doc, ok := byHref[href]; ok {
+					setAttr(&t, "media-overlay", doc.id)
+				}
+			}
+			tok = t
+		case xml.EndElement:
+			prefix := ""
+			if t.Name.Space != "" {
+				prefix = t.Name.Space + ":"
+			}
+			switch t.Name.Local {
+			case "manifest":
+				for _, doc := range docs {
+					w.WriteRaw(fmt.Sprintf(`<%sitem id="%s" href="%s" media-type="application/smil+xml"/>`,
+						prefix, doc.id, escapeAttr(local(doc.smilPath))))
+				}
+				for _, a := range audios {
+					w.WriteRaw(fmt.Sprintf(`<%sitem id="%s" href="%s" media-type="%s"/>`,
+						prefix, escapeAttr(a.id), escapeAttr(local(a.path)), escapeAttr(a.mediaType)))
+				}
+				w.WriteRaw(fmt.Sprintf(`<%sitem id="tts-overlay-css" href="tts-overlay.css" media-type="text/css"/>`, prefix))
+				if addNav {
+					w.WriteRaw(fmt.Sprintf(`<%sitem id="tts-nav" href="tts-nav.xhtml" media-type="application/xhtml+xml" properties="nav"/>`, prefix))
+				}
+			case "metadata":
+				meta := func(property, refines, value string) {
+					if refines != "" {
+						refines = fmt.Sprintf(` refines="#%s"`, refines)
+					}
+					w.WriteRaw(fmt.Sprintf(`<%smeta property="%s"%s>%s</%smeta>`, prefix, property, refines, escapeText(value), prefix))
+				}
+				for _, doc := range docs {
+					meta("media:duration", doc.id, clockValue(doc.duration))
+				}
+				meta("media:duration", "", clockValue(total))
+				meta("media:active-class", "", overlayActiveClass)
+				if !bytes.Contains(opf, []byte("dcterms:modified")) {
+					meta("dcterms:modified", "", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+				}
+			}
+		}
+		w.Write(tok)
+	}
+	return w.Bytes(), nil
+}
+
+// writeZip writes a copy of z to dstPath with replaced entries substituted,
+// new entries added and the mimetype entry first and uncompressed, as EPUB
+// requires.
+func writeZip(z *zip.ReadCloser, dstPath string, replaced map[string][]byte, added map[string]string) error {
+	tmp := dstPath + ".tmp"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	zw := zip.NewWriter(out)
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: "mimetype", Method: zip.Store})
+	if err != nil {
+		out.Close()
+		return err
+	}
+	io.WriteString(mw, "application/epub+zip")
+
+	written := map[string]bool{"mimetype": true}
+	for _, f := range z.File {
+		if written[f.Name] {
+			continue
+		}
+		written[f.Name] = true
+		if data, ok := replaced[f.Name]; ok {
+			err = writeZipEntry(zw, f.Name, data)
+		} else {
+			err = zw.Copy(f)
+		}
+		if err != nil {
+			out.Close()
+			return err
+		}
+	}
+	for name, data := range replaced {
+		if !written[name] {
+			if err := writeZipEntry(zw, name, data); err != nil {
+				out.Close()
+				return err
+			}
+		}
+	}
+	for name, local := range added {
+		// Audio is already compressed; store it as-is
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err == nil {
+			err = copyFile(w, local)
+		}
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("failed to add %s: %v", name, err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dstPath)
+}
+
+func writeZipEntry(zw *zip.Writer, name string, data []byte) error {
+	w, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func copyFile(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// relPath returns the href of target relative to the document at from.
+// Both are zip paths.
+func relPath(from, target string) string {
+	fromDir := strings.Split(path.Dir(from), "/")
+	if path.Dir(from) == "." {
+		fromDir = nil
+	}
+	parts := strings.Split(target, "/")
+	i := 0
+	for i < len(fromDir) && i < len(parts)-1 && fromDir[i] == parts[i] {
+		i++
+	}
+	return strings.Repeat("../", len(fromDir)-i) + strings.Join(parts[i:], "/")
+}
+
+// clockValue formats d as a SMIL clock value
+func clockValue(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
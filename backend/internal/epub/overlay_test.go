@@ -0,0 +1,151 @@
+package epub
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMediaOverlays(t *testing.T) {
+	src := writeEPUB(t, map[string]string{
+		"mimetype":               "application/epub+zip",
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="2.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/"><dc:title>Test</dc:title></metadata>
+  <manifest>
+    <item id="c1" href="Text/ch1.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2a" href="Text/ch2a.xhtml" media-type="application/xhtml+xml"/>
+    <item id="c2b" href="Text/ch2b.xhtml" media-type="application/xhtml+xml"/>
+  </manifest>
+  <spine><itemref idref="c1"/><itemref idref="c2a"/><itemref idref="c2b"/></spine>
+</package>`,
+		"OEBPS/Text/ch1.xhtml": `<?xml version="1.0" encoding="utf-8"?>
+<html xmlns="http://www.w3.org/1999/xhtml">
+<head><title>第一章</title></head>
+<body>
+<h1>第一章</h1>
+<p>他说：<b>“你好&amp;再见。”</b>然后走了。</p>
+<p>没有配音的一段。</p>
+</body>
+</html>`,
+		"OEBPS/Text/ch2a.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>2</title></head><body><p>上半章。</p></body></html>`,
+		"OEBPS/Text/ch2b.xhtml": `<html xmlns="http://www.w3.org/1999/xhtml"><head><title>2</title></head><body><p>下半<ruby>章<rt>zhāng</rt></ruby>。<a epub:type="noteref" href="#n">1</a></p></body></html>`,
+	})
+
+	audioPath := filepath.Join(t.TempDir(), "ch_001.mp3")
+	os.WriteFile(audioPath, []byte("MP3DATA"), 0644)
+
+	dst := filepath.Join(t.TempDir(), "out.epub")
+	err := WriteMediaOverlays(src, dst, []OverlayChapter{{
+		ID:        "ch_001",
+		Hrefs:     []string{"OEBPS/Text/ch1.xhtml"},
+		Title:     "第一章",
+		AudioPath: audioPath,
+		AudioType: "audio/mpeg",
+		Clips: []Clip{
+			{Text: "第一章", Start: 0, End: time.Second},
+			{Text: "他说：", Start: time.Second, End: 2 * time.Second},
+			{Text: "“你好&再见。”然后走了。", Start: 2 * time.Second, End: 4 * time.Second},
+			{Text: "不在书里的文字", Start: 4 * time.Second, End: 5 * time.Second},
+		},
+	}, {
+		// One chapter split across two documents
+		ID:        "ch_002",
+		Hrefs:     []string{"OEBPS/Text/ch2a.xhtml", "OEBPS/Text/ch2b.xhtml"},
+		Title:     "第二章",
+		AudioPath: audioPath,
+		AudioType: "audio/mpeg",
+		Clips: []Clip{
+			{Text: "上半章。", Start: 0, End: time.Second},
+			{Text: "下半章。", Start: time.Second, End: 3 * time.Second},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("WriteMediaOverlays() error = %v", err)
+	}
+
+	z, err := zip.OpenReader(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer z.Close()
+	if z.File[0].Name != "mimetype" || z.File[0].Method != zip.Store {
+		t.Errorf("first entry = %s (method %d), want stored mimetype", z.File[0].Name, z.File[0].Method)
+	}
+	files := make(map[string]string)
+	for _, f := range z.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(data)
+	}
+
+	chapter := files["OEBPS/Text/ch1.xhtml"]
+	for _, want := range []string{
+		`<title>第一章</title><link rel="stylesheet" type="text/css" href="../tts-overlay.css"/></head>`,
+		`<h1><span id="ttsb-0-0">第一章</span></h1>`,
+		`<p><span id="ttsb-1-0">他说：</span><b><span id="ttsb-2-0">“你好&amp;再见。”</span></b><span id="ttsb-2-1">然后走了。</span></p>`,
+		`<p>没有配音的一段。</p>`,
+	} {
+		if !strings.Contains(chapter, want) {
+			t.Errorf("chapter missing %q:\n%s", want, chapter)
+		}
+	}
+
+	smil := files["OEBPS/overlays/001.smil"]
+	for _, want := range []string{
+		`epub:textref="../Text/ch1.xhtml"`,
+		`<text src="../Text/ch1.xhtml#ttsb-2-0"/>`,
+		// 8 of the clip's 13 runes are in the first span
+		`<audio src="../audio/ch_001.mp3" clipBegin="0:00:02.000" clipEnd="0:00:03.230"/>`,
+		`<audio src="../audio/ch_001.mp3" clipBegin="0:00:03.230" clipEnd="0:00:04.000"/>`,
+	} {
+		if !strings.Contains(smil, want) {
+			t.Errorf("smil missing %q:\n%s", want, smil)
+		}
+	}
+	if strings.Contains(smil, "ttsb-3") {
+		t.Errorf("unmatched clip should have no par:\n%s", smil)
+	}
+
+	second := files["OEBPS/overlays/003.smil"]
+	for _, want := range []string{
+		// Ruby annotations and note references are not part of the clip
+		`<text src="../Text/ch2b.xhtml#ttsb-0-2"/>`,
+		`<audio src="../audio/ch_002.mp3" clipBegin="0:00:02.500" clipEnd="0:00:03.000"/>`,
+	} {
+		if !strings.Contains(second, want) {
+			t.Errorf("smil missing %q:\n%s", want, second)
+		}
+	}
+
+	opf := files["OEBPS/content.opf"]
+	for _, want := range []string{
+		`version="3.0"`,
+		`<item id="c1" href="Text/ch1.xhtml" media-type="application/xhtml+xml" media-overlay="mo-001"/>`,
+		`<item id="c2b" href="Text/ch2b.xhtml" media-type="application/xhtml+xml" media-overlay="mo-003"/>`,
+		`<item id="mo-001" href="overlays/001.smil" media-type="application/smil+xml"/>`,
+		`<item id="audio-ch_001" href="audio/ch_001.mp3" media-type="audio/mpeg"/>`,
+		`properties="nav"`,
+		`<meta property="media:duration" refines="#mo-001">0:00:04.000</meta>`,
+		`<meta property="media:duration">0:00:07.000</meta>`,
+		`<meta property="media:active-class">-epub-media-overlay-active</meta>`,
+		`<meta property="dcterms:modified">`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("opf missing %q:\n%s", want, opf)
+		}
+	}
+
+	if files["OEBPS/audio/ch_001.mp3"] != "MP3DATA" {
+		t.Error("audio not embedded")
+	}
+	if !strings.Contains(files["OEBPS/tts-nav.xhtml"], `<a href="Text/ch2a.xhtml">第二章</a>`) {
+		t.Errorf("nav = %s", files["OEBPS/tts-nav.xhtml"])
+	}
+}
@@ -4,20 +4,34 @@ import (
 	"archive/zip"
 	"encoding/xml"
 	"fmt"
-	"path/filepath"
 	"strings"
-
-	"golang.org/x/net/html"
 )
 
 type Chapter struct {
-	ID      string `json:"id"`
-	Title   string `json:"title"`
-	Content string `json:"content"`
+	ID      string   `json:"id"`
+	Title   string   `json:"title"`
+	Content string   `json:"content"`
+	Hrefs   []string `json:"hrefs,omitempty"`  // Zip paths of the XHTML files the text comes from, in order
+	Matter  string   `json:"matter,omitempty"` // "front" or "back" for covers, copyright pages and the like
+
+	Readings []Reading `json:"readings,omitempty"` // Pronunciations the book gives in <ruby>
+}
+
+// Reading is a pronunciation hint: the reading (pinyin, zhuyin, kana...)
+// printed over a word
+type Reading struct {
+	Text    string `json:"text"`
+	Reading string `json:"reading"`
+}
+
+// Options controls how chapter text is extracted
+type Options struct {
+	FootnotesAtEnd bool // Move footnotes to the end of their chapter instead of reading them where they appear
 }
 
 type Reader struct {
 	path string
+	opts Options
 }
 
 // XML Structs for Parsing
@@ -32,26 +46,80 @@ type Container struct {
 
 type Package struct {
 	Metadata struct {
-		Title string `xml:"title"`
+		Title       string    `xml:"title"`
+		Language    string    `xml:"language"`
+		Creator     []Creator `xml:"creator"`
+		Contributor []Creator `xml:"contributor"`
+		Publisher   string    `xml:"publisher"`
+		Date        []string  `xml:"date"` // EPUB2 allows one per opf:event
+		Description string    `xml:"description"`
+		Subject     []string  `xml:"subject"`
+		Identifier  []struct {
+			ID     string `xml:"id,attr"`
+			Scheme string `xml:"scheme,attr"` // EPUB2 opf:scheme
+			Value  string `xml:",chardata"`
+		} `xml:"identifier"`
+		Meta []struct {
+			ID       string `xml:"id,attr"`
+			Name     string `xml:"name,attr"`     // EPUB2 <meta name content>
+			Content  string `xml:"content,attr"`  //
+			Property string `xml:"property,attr"` // EPUB3 <meta property refines>value</meta>
+			Refines  string `xml:"refines,attr"`  //
+			Value    string `xml:",chardata"`
+		} `xml:"meta"`
 	} `xml:"metadata"`
 	Manifest struct {
 		Item []struct {
-			ID        string `xml:"id,attr"`
-			Href      string `xml:"href,attr"`
-			MediaType string `xml:"media-type,attr"`
+			ID         string `xml:"id,attr"`
+			Href       string `xml:"href,attr"`
+			MediaType  string `xml:"media-type,attr"`
+			Properties string `xml:"properties,attr"`
 		} `xml:"item"`
 	} `xml:"manifest"`
 	Spine struct {
+		Toc     string `xml:"toc,attr"` // NCX manifest id (EPUB 2)
 		ItemRef []struct {
-			IDRef string `xml:"idref,attr"`
+			IDRef  string `xml:"idref,attr"`
+			Linear string `xml:"linear,attr"`
 		} `xml:"itemref"`
 	} `xml:"spine"`
+	Guide struct {
+		Reference []struct {
+			Type string `xml:"type,attr"`
+			Href string `xml:"href,attr"`
+		} `xml:"reference"`
+	} `xml:"guide"` // EPUB 2 landmarks
+}
+
+// Creator is a dc:creator or dc:contributor entry
+type Creator struct {
+	ID     string `xml:"id,attr"`
+	Role   string `xml:"role,attr"`    // EPUB2 opf:role; EPUB3 uses a refining meta instead
+	FileAs string `xml:"file-as,attr"` // EPUB2 opf:file-as; likewise
+	Name   string `xml:",chardata"`
 }
 
 func NewReader(path string) (*Reader, error) {
 	return &Reader{path: path}, nil
 }
 
+// NewReaderWithOptions is NewReader with control over text extraction
+func NewReaderWithOptions(path string, opts Options) (*Reader, error) {
+	return &Reader{path: path, opts: opts}, nil
+}
+
+// GetChapters returns the book's chapters in reading order. Chapters follow
+// the table of contents (EPUB 3 nav or EPUB 2 NCX) where there is one:
+// spine files without an entry of their own are merged into the preceding
+// chapter, and a file holding several entries is split at their anchors.
+// Entries whose text is only their title, such as part title pages, are
+// folded into the chapter that follows. Covers, copyright pages, tables of
+// contents and similar are kept but flagged in Chapter.Matter.
+//
+// Each block element becomes a paragraph, and paragraphs are separated by
+// blank lines; <br> becomes a single line break. Ruby annotations are left
+// out of the text and returned in Chapter.Readings, and footnote reference
+// marks and page numbers are dropped.
 func (r *Reader) GetChapters() ([]Chapter, error) {
 	z, err := zip.OpenReader(r.path)
 	if err != nil {
@@ -59,32 +127,10 @@ func (r *Reader) GetChapters() ([]Chapter, error) {
 	}
 	defer z.Close()
 
-	// 1. Find the OPF file via META-INF/container.xml
-	containerFile, err := findFileInZip(z, "META-INF/container.xml")
-	if err != nil {
-		return nil, fmt.Errorf("invalid epub: no container.xml")
-	}
-
-	var container Container
-	if err := decodeXML(containerFile, &container); err != nil {
-		return nil, fmt.Errorf("failed to parse container.xml: %v", err)
-	}
-
-	if len(container.Rootfiles.Rootfile) == 0 {
-		return nil, fmt.Errorf("invalid epub: no rootfile found")
-	}
-
-	opfPath := container.Rootfiles.Rootfile[0].FullPath
-
-	// 2. Parse the OPF file
-	opfFile, err := findFileInZip(z, opfPath)
+	// 1-2. Find and parse the OPF file
+	pkg, opfPath, err := openPackage(z)
 	if err != nil {
-		return nil, fmt.Errorf("opf file not found: %s", opfPath)
-	}
-
-	var pkg Package
-	if err := decodeXML(opfFile, &pkg); err != nil {
-		return nil, fmt.Errorf("failed to parse opf: %v", err)
+		return nil, err
 	}
 
 	// 3. Build lookup map for manifest items (ID -> Href)
@@ -93,50 +139,66 @@ func (r *Reader) GetChapters() ([]Chapter, error) {
 		manifestMap[item.ID] = item.Href
 	}
 
-	// 4. Iterate Spine to get chapters in order
-	var chapters []Chapter
-	opfDir := filepath.Dir(opfPath) // Paths in OPF are relative to OPF location
-
-	count := 1
+	// 4. Read spine documents in order
+	var docs []spineDoc
 	for _, itemRef := range pkg.Spine.ItemRef {
 		href, ok := manifestMap[itemRef.IDRef]
 		if !ok {
 			continue
 		}
 
-		// Resolve path relative to OPF
-		// "OEBPS/content.opf" dir is "OEBPS". Href "Text/chap1.xhtml" -> "OEBPS/Text/chap1.xhtml"
-		fullPath := href
-		if opfDir != "." {
-			// Simple path join, but ensure forward slashes for zip
-			fullPath = filepath.ToSlash(filepath.Join(opfDir, href))
-		}
-
-		// Find file in zip
+		fullPath := resolveHref(opfPath, href)
 		f, err := findFileInZip(z, fullPath)
 		if err != nil {
 			fmt.Printf("Warning: missing file %s\n", fullPath)
 			continue
 		}
-
-		// Extract content
-		content, err := extractText(f)
+		text, err := readDocText(f, r.opts)
 		if err != nil {
 			continue
 		}
+		docs = append(docs, spineDoc{href: fullPath, linear: itemRef.Linear != "no", docText: text})
+	}
 
-		// Use count as ID to ensure order is preserved in frontend
-		if len(strings.TrimSpace(content)) > 10 {
-			chapters = append(chapters, Chapter{
-				ID:      fmt.Sprintf("ch_%03d", count),
-				Title:   fmt.Sprintf("Chapter %d", count),
-				Content: content,
-			})
-			count++
-		}
+	// 5. Group them into chapters by the table of contents
+	entries, landmarks := readTOC(z, pkg, opfPath)
+	return buildChapters(docs, entries, landmarks), nil
+}
+
+// openPackage finds the OPF file via META-INF/container.xml and parses it.
+func openPackage(z *zip.ReadCloser) (*Package, string, error) {
+	containerFile, err := findFileInZip(z, "META-INF/container.xml")
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid epub: no container.xml")
+	}
+
+	var container Container
+	if err := decodeXML(containerFile, &container); err != nil {
+		return nil, "", fmt.Errorf("failed to parse container.xml: %v", err)
+	}
+
+	if len(container.Rootfiles.Rootfile) == 0 {
+		return nil, "", fmt.Errorf("invalid epub: no rootfile found")
 	}
 
-	return chapters, nil
+	opfPath := container.Rootfiles.Rootfile[0].FullPath
+
+	opfFile, err := findFileInZip(z, opfPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("opf file not found: %s", opfPath)
+	}
+
+	var pkg Package
+	if err := decodeXML(opfFile, &pkg); err != nil {
+		return nil, "", fmt.Errorf("failed to parse opf: %v", err)
+	}
+	return &pkg, opfPath, nil
+}
+
+// resolveHref resolves a manifest href against the OPF file's directory
+func resolveHref(opfPath, href string) string {
+	fullPath, _ := resolveLink(opfPath, href)
+	return fullPath
 }
 
 func findFileInZip(z *zip.ReadCloser, name string) (*zip.File, error) {
@@ -158,32 +220,3 @@ func decodeXML(f *zip.File, target interface{}) error {
 	defer rc.Close()
 	return xml.NewDecoder(rc).Decode(target)
 }
-
-func extractText(f *zip.File) (string, error) {
-	rc, err := f.Open()
-	if err != nil {
-		return "", err
-	}
-	defer rc.Close()
-
-	doc, err := html.Parse(rc)
-	if err != nil {
-		return "", err
-	}
-
-	var sb strings.Builder
-	var walker func(*html.Node)
-	walker = func(n *html.Node) {
-		if n.Type == html.TextNode {
-			text := strings.TrimSpace(n.Data)
-			if text != "" {
-				sb.WriteString(text + "\n")
-			}
-		}
-		for c := n.FirstChild; c != nil; c = c.NextSibling {
-			walker(c)
-		}
-	}
-	walker(doc)
-	return sb.String(), nil
-}
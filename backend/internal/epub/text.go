@@ -0,0 +1,264 @@
+package epub
+
+import (
+	"archive/zip"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blockAtoms start and end a paragraph
+var blockAtoms = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Li: true, atom.Blockquote: true, atom.Pre: true,
+	atom.Tr: true, atom.Td: true, atom.Th: true, atom.Section: true, atom.Article: true,
+	atom.Aside: true, atom.Header: true, atom.Footer: true, atom.Hr: true, atom.Figure: true,
+	atom.Figcaption: true, atom.Dt: true, atom.Dd: true, atom.Caption: true, atom.Table: true,
+	atom.Ul: true, atom.Ol: true, atom.Dl: true, atom.Nav: true, atom.Main: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+// noteMarker matches the text of a footnote reference: 1, [2], (3), *, ①
+var noteMarker = regexp.MustCompile(`^[\[(（〔【]?([0-9]{1,3}|[a-z]|[*†‡§]+|[①-⑳])[\])）〕】]?$`)
+
+// lineBreak stands for <br> while a paragraph is being collected, so that
+// it survives the whitespace folding that source line breaks get
+const lineBreak = "\u2028"
+
+// docText is the extracted text of one content document
+type docText struct {
+	text     string
+	anchors  map[string]int // Element id -> byte offset in text
+	notes    []docNote      // Footnotes moved out of text, with Options.FootnotesAtEnd
+	readings []docReading
+	title    string // First h1-h3, or <title>
+	types    string // epub:type of the body and its top-level elements
+}
+
+// docNote is a footnote taken out of the text at offset
+type docNote struct {
+	offset int
+	text   string
+}
+
+// docReading is a ruby reading found in the paragraph starting at offset
+type docReading struct {
+	offset int
+	Reading
+}
+
+// textExtractor collects the paragraphs of a document. Each block element
+// becomes a paragraph, followed by a blank line; inline elements are
+// joined into the paragraph around them.
+type textExtractor struct {
+	opts     Options
+	out      strings.Builder
+	para     strings.Builder
+	anchors  map[string]int
+	notes    []docNote
+	readings []docReading
+}
+
+// readDocText extracts the paragraphs of an XHTML document, recording where
+// each element id falls in the text.
+func readDocText(f *zip.File, opts Options) (*docText, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	doc, err := html.Parse(rc)
+	if err != nil {
+		return nil, err
+	}
+
+	var heading, title string
+	var types []string
+	var find func(n *html.Node, depth int)
+	find = func(n *html.Node, depth int) {
+		if n.Type != html.ElementNode {
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				find(c, depth)
+			}
+			return
+		}
+		switch n.DataAtom {
+		case atom.Title:
+			if title == "" {
+				title = nodeText(n)
+			}
+		case atom.H1, atom.H2, atom.H3:
+			if heading == "" {
+				heading = nodeText(n)
+			}
+		case atom.Body:
+			depth = 0
+		}
+		if depth <= 1 {
+			types = append(types, epubType(n))
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			find(c, depth+1)
+		}
+	}
+	find(doc, 0)
+
+	e := &textExtractor{opts: opts, anchors: make(map[string]int)}
+	e.walk(doc)
+	e.flush()
+
+	d := &docText{
+		text:     e.out.String(),
+		anchors:  e.anchors,
+		notes:    e.notes,
+		readings: e.readings,
+		title:    heading,
+		types:    strings.Join(types, " "),
+	}
+	if d.title == "" {
+		d.title = title
+	}
+	return d, nil
+}
+
+func (e *textExtractor) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		e.para.WriteString(n.Data)
+		return
+	case html.ElementNode:
+		switch n.DataAtom {
+		case atom.Head, atom.Script, atom.Style, atom.Noscript, atom.Template, atom.Rt, atom.Rp:
+			return
+		case atom.Br:
+			e.para.WriteString(lineBreak)
+			return
+		case atom.Ruby:
+			for _, r := range rubyReadings(n) {
+				e.readings = append(e.readings, docReading{offset: e.out.Len(), Reading: r})
+			}
+		}
+		if skippedElement(n) {
+			return
+		}
+		if e.opts.FootnotesAtEnd && isNote(n) {
+			e.flush()
+			note := &textExtractor{opts: e.opts, anchors: make(map[string]int)}
+			for c := n.FirstChild; c != nil; c = c.NextSibling {
+				note.walk(c)
+			}
+			note.flush()
+			if text := strings.TrimSpace(note.out.String()); text != "" {
+				e.notes = append(e.notes, docNote{offset: e.out.Len(), text: text})
+			}
+			for _, r := range note.readings {
+				e.readings = append(e.readings, docReading{offset: e.out.Len(), Reading: r.Reading})
+			}
+			return
+		}
+	}
+
+	block := n.Type == html.ElementNode && blockAtoms[n.DataAtom]
+	if block {
+		e.flush()
+	}
+	if id := htmlAttr(n, "id"); id != "" && n.Type == html.ElementNode {
+		// Inline anchors point at the start of their paragraph
+		e.anchors[id] = e.out.Len()
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		e.walk(c)
+	}
+	if block {
+		e.flush()
+	}
+}
+
+// flush ends the current paragraph
+func (e *textExtractor) flush() {
+	var lines []string
+	for _, l := range strings.Split(e.para.String(), lineBreak) {
+		if t := joinInline(l); t != "" {
+			lines = append(lines, t)
+		}
+	}
+	e.para.Reset()
+	if len(lines) > 0 {
+		e.out.WriteString(strings.Join(lines, "\n") + "\n\n")
+	}
+}
+
+// skippedElement reports whether an element is not part of the text read
+// aloud: footnote references and page break markers.
+func skippedElement(n *html.Node) bool {
+	types := epubType(n) + " " + htmlAttr(n, "role")
+	if hasProperty(types, "noteref") || hasProperty(types, "doc-noteref") ||
+		hasProperty(types, "pagebreak") || hasProperty(types, "doc-pagebreak") {
+		return true
+	}
+	// Untyped links to a note, like <a href="#n1"><sup>[1]</sup></a>
+	return n.DataAtom == atom.A && strings.Contains(htmlAttr(n, "href"), "#") &&
+		noteMarker.MatchString(nodeText(n))
+}
+
+// isNote reports whether an element holds the body of a footnote
+func isNote(n *html.Node) bool {
+	types := epubType(n) + " " + htmlAttr(n, "role")
+	for _, t := range []string{"footnote", "endnote", "rearnote", "note", "doc-footnote", "doc-endnote"} {
+		if hasProperty(types, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// rubyReadings pairs the base text of a <ruby> element with its <rt>
+// annotations. Each <rt> annotates the base text since the previous one.
+func rubyReadings(n *html.Node) []Reading {
+	var readings []Reading
+	var base strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.ElementNode && c.DataAtom == atom.Rp:
+		case c.Type == html.ElementNode && (c.DataAtom == atom.Rt || c.Data == "rtc"):
+			b, r := joinInline(base.String()), nodeText(c)
+			if b != "" && r != "" {
+				readings = append(readings, Reading{Text: b, Reading: r})
+			}
+			base.Reset()
+		case c.Type == html.TextNode:
+			base.WriteString(c.Data)
+		default:
+			base.WriteString(nodeText(c))
+		}
+	}
+	return readings
+}
+
+// joinInline folds whitespace in running text. Spaces between two CJK
+// characters are source formatting and are dropped; other runs become a
+// single space.
+func joinInline(s string) string {
+	fields := strings.Fields(s)
+	var sb strings.Builder
+	for i, f := range fields {
+		if i > 0 {
+			prev, _ := utf8.DecodeLastRuneInString(fields[i-1])
+			next, _ := utf8.DecodeRuneInString(f)
+			if !isCJK(prev) || !isCJK(next) {
+				sb.WriteByte(' ')
+			}
+		}
+		sb.WriteString(f)
+	}
+	return sb.String()
+}
+
+func isCJK(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) ||
+		(r >= 0x3000 && r <= 0x303F) || (r >= 0xFF00 && r <= 0xFFEF)
+}
@@ -0,0 +1,58 @@
+package epub
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReader_GetChapters_Text(t *testing.T) {
+	path := writeEPUB(t, map[string]string{
+		"META-INF/container.xml": testContainer,
+		"OEBPS/content.opf": `<?xml version="1.0"?>
+<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <manifest><item id="c1" href="c1.xhtml" media-type="application/xhtml+xml"/></manifest>
+  <spine><itemref idref="c1"/></spine>
+</package>`,
+		"OEBPS/c1.xhtml": xhtml("第一章", `<body>
+<script>var x = 1;</script>
+<h1>第<ruby>一<rt>yī</rt></ruby>章</h1>
+<p>他<em>重重</em>地
+  摔在地上，<ruby>重<rp>(</rp><rt>chóng</rt><rp>)</rp>新</ruby>站起来<a epub:type="noteref" href="#n1">1</a>。<span epub:type="pagebreak" title="12">12</span></p>
+<p>床前明月光，<br/>疑是地上霜。<a href="#n2"><sup>[2]</sup></a></p>
+<aside epub:type="footnote" id="n1"><p>注一。</p></aside>
+<p>She said <i>hello</i>.</p>
+<aside role="doc-footnote" id="n2"><p>注二。</p></aside>
+</body>`),
+	})
+
+	r, _ := NewReader(path)
+	chapters, err := r.GetChapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 1 {
+		t.Fatalf("got %d chapters", len(chapters))
+	}
+	ch := chapters[0]
+	if ch.Title != "第一章" {
+		t.Errorf("title = %q", ch.Title)
+	}
+	want := "第一章\n\n他重重地摔在地上，重新站起来。\n\n床前明月光，\n疑是地上霜。\n\n注一。\n\nShe said hello.\n\n注二。\n"
+	if ch.Content != want {
+		t.Errorf("content = %q, want %q", ch.Content, want)
+	}
+	readings := []Reading{{Text: "一", Reading: "yī"}, {Text: "重", Reading: "chóng"}}
+	if !reflect.DeepEqual(ch.Readings, readings) {
+		t.Errorf("readings = %+v, want %+v", ch.Readings, readings)
+	}
+
+	r, _ = NewReaderWithOptions(path, Options{FootnotesAtEnd: true})
+	chapters, err = r.GetChapters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "第一章\n\n他重重地摔在地上，重新站起来。\n\n床前明月光，\n疑是地上霜。\n\nShe said hello.\n\n注一。\n\n注二。\n"
+	if chapters[0].Content != want {
+		t.Errorf("content with footnotes at end = %q, want %q", chapters[0].Content, want)
+	}
+}
@@ -0,0 +1,215 @@
+package epub
+
+import (
+	"archive/zip"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// tocEntry is one table of contents entry. Nested entries are flattened in
+// reading order.
+type tocEntry struct {
+	title  string
+	href   string // Zip path of the target document
+	anchor string // Fragment identifier, if any
+}
+
+// matterTypes are the epub:type, landmark and guide types of content that
+// is not part of the story
+var matterTypes = map[string]bool{
+	"cover": true, "titlepage": true, "title-page": true, "halftitlepage": true,
+	"toc": true, "copyright-page": true, "imprint": true, "seriespage": true,
+	"dedication": true, "acknowledgments": true, "acknowledgements": true,
+	"colophon": true, "other-credits": true, "contributors": true,
+	"index": true, "loi": true, "lot": true, "bibliography": true, "glossary": true,
+	"frontmatter": true, "backmatter": true,
+}
+
+// matterTitle matches the titles of front and back matter
+var matterTitle = regexp.MustCompile(`(?i)^(copyright|(table of )?contents|acknowledge?ments?|cover|title ?page|half ?title|also by|about the (author|publisher)|dedication|colophon|index|imprint)\b|^(版权|版權|目录|目錄|致谢|致謝|鸣谢|鳴謝|封面|扉页|扉頁|书名页|書名頁|出版说明|出版說明|作者简介|作者簡介|内容简介|內容簡介)`)
+
+// readTOC returns the book's table of contents, from the EPUB 3 navigation
+// document if it has one and the EPUB 2 NCX otherwise, along with the
+// landmark or guide type of each document that declares one.
+func readTOC(z *zip.ReadCloser, pkg *Package, opfPath string) ([]tocEntry, map[string]string) {
+	landmarks := make(map[string]string)
+	for _, ref := range pkg.Guide.Reference {
+		href, _ := resolveLink(opfPath, ref.Href)
+		landmarks[href] = strings.ToLower(ref.Type)
+	}
+
+	var entries []tocEntry
+	var ncxPath string
+	for _, item := range pkg.Manifest.Item {
+		itemPath := resolveHref(opfPath, item.Href)
+		switch {
+		case hasProperty(item.Properties, "nav"):
+			f, err := findFileInZip(z, itemPath)
+			if err != nil {
+				continue
+			}
+			navEntries, navLandmarks := parseNav(f, itemPath)
+			entries = navEntries
+			for href, t := range navLandmarks {
+				landmarks[href] = t
+			}
+		case item.MediaType == "application/x-dtbncx+xml" && (pkg.Spine.Toc == "" || pkg.Spine.Toc == item.ID):
+			ncxPath = itemPath
+		}
+	}
+
+	if len(entries) == 0 && ncxPath != "" {
+		if f, err := findFileInZip(z, ncxPath); err == nil {
+			entries = parseNCX(f, ncxPath)
+		}
+	}
+	return entries, landmarks
+}
+
+// parseNav reads the toc and landmarks navs of an EPUB 3 navigation document
+func parseNav(f *zip.File, navPath string) ([]tocEntry, map[string]string) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, nil
+	}
+	defer rc.Close()
+	doc, err := html.Parse(rc)
+	if err != nil {
+		return nil, nil
+	}
+
+	var entries []tocEntry
+	landmarks := make(map[string]string)
+	var walk func(n *html.Node, nav string)
+	walk = func(n *html.Node, nav string) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "nav":
+				nav = epubType(n)
+			case "a":
+				href, anchor := resolveLink(navPath, htmlAttr(n, "href"))
+				switch {
+				case href == "":
+				case hasProperty(nav, "toc"):
+					entries = append(entries, tocEntry{title: nodeText(n), href: href, anchor: anchor})
+				case hasProperty(nav, "landmarks"):
+					landmarks[href] = epubType(n)
+				}
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c, nav)
+		}
+	}
+	walk(doc, "")
+	return entries, landmarks
+}
+
+type ncxNavPoint struct {
+	Label   string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Children []ncxNavPoint `xml:"navPoint"`
+}
+
+// parseNCX reads the navMap of an EPUB 2 NCX file
+func parseNCX(f *zip.File, ncxPath string) []tocEntry {
+	var ncx struct {
+		NavMap struct {
+			NavPoint []ncxNavPoint `xml:"navPoint"`
+		} `xml:"navMap"`
+	}
+	if err := decodeXML(f, &ncx); err != nil {
+		return nil
+	}
+
+	var entries []tocEntry
+	var flatten func(points []ncxNavPoint)
+	flatten = func(points []ncxNavPoint) {
+		for _, p := range points {
+			if href, anchor := resolveLink(ncxPath, p.Content.Src); href != "" {
+				entries = append(entries, tocEntry{title: collapseSpace(p.Label), href: href, anchor: anchor})
+			}
+			flatten(p.Children)
+		}
+	}
+	flatten(ncx.NavMap.NavPoint)
+	return entries
+}
+
+// resolveLink resolves a link in the document at base to a zip path and
+// fragment. External links resolve to "".
+func resolveLink(base, link string) (string, string) {
+	if strings.Contains(link, "://") {
+		return "", ""
+	}
+	target, anchor, _ := strings.Cut(link, "#")
+	if unescaped, err := url.PathUnescape(target); err == nil {
+		target = unescaped
+	}
+	if target == "" {
+		return base, anchor
+	}
+	return path.Join(path.Dir(base), target), anchor
+}
+
+// isMatter reports whether a type list (space separated) or title marks
+// front or back matter
+func isMatter(types, title string) bool {
+	for _, t := range strings.Fields(types) {
+		if matterTypes[strings.ToLower(t)] {
+			return true
+		}
+	}
+	return matterTitle.MatchString(strings.TrimSpace(title))
+}
+
+func epubType(n *html.Node) string {
+	for _, a := range n.Attr {
+		if a.Key == "epub:type" || (a.Namespace == "epub" && a.Key == "type") {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func htmlAttr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// nodeText returns the whitespace-collapsed text under n, without ruby
+// annotations
+func nodeText(n *html.Node) string {
+	var sb strings.Builder
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		if n.Type == html.ElementNode && (n.Data == "rt" || n.Data == "rp" || n.Data == "script" || n.Data == "style") {
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		walk(c)
+	}
+	return joinInline(sb.String())
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
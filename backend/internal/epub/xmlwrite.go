@@ -0,0 +1,122 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// tokenWriter re-serialises tokens from xml.Decoder.RawToken. Unlike
+// xml.Encoder it keeps namespace prefixes exactly as written in the source,
+// so rewritten XHTML and OPF files stay as close to the original as possible.
+type tokenWriter struct {
+	buf     bytes.Buffer
+	pending *xml.StartElement // Held back so empty elements can be written as <x/>
+}
+
+func (w *tokenWriter) flush() {
+	if w.pending != nil {
+		w.startTag(*w.pending, false)
+		w.pending = nil
+	}
+}
+
+// Write serialises one token.
+func (w *tokenWriter) Write(tok xml.Token) {
+	if end, ok := tok.(xml.EndElement); ok && w.pending != nil && w.pending.Name == end.Name {
+		w.startTag(*w.pending, true)
+		w.pending = nil
+		return
+	}
+	w.flush()
+
+	switch t := tok.(type) {
+	case xml.StartElement:
+		start := t.Copy()
+		w.pending = &start
+	case xml.EndElement:
+		w.buf.WriteString("</" + qualified(t.Name) + ">")
+	case xml.CharData:
+		w.buf.WriteString(escapeText(string(t)))
+	case xml.Comment:
+		w.buf.WriteString("<!--" + string(t) + "-->")
+	case xml.ProcInst:
+		w.buf.WriteString("<?" + t.Target)
+		if len(t.Inst) > 0 {
+			w.buf.WriteString(" " + string(t.Inst))
+		}
+		w.buf.WriteString("?>")
+	case xml.Directive:
+		w.buf.WriteString("<!" + string(t) + ">")
+	}
+}
+
+// WriteRaw writes markup as-is.
+func (w *tokenWriter) WriteRaw(s string) {
+	w.flush()
+	w.buf.WriteString(s)
+}
+
+// Bytes returns everything written so far.
+func (w *tokenWriter) Bytes() []byte {
+	w.flush()
+	return w.buf.Bytes()
+}
+
+func (w *tokenWriter) startTag(t xml.StartElement, empty bool) {
+	w.buf.WriteString("<" + qualified(t.Name))
+	for _, a := range t.Attr {
+		w.buf.WriteString(" " + qualified(a.Name) + `="` + escapeAttr(a.Value) + `"`)
+	}
+	if empty {
+		w.buf.WriteString("/>")
+	} else {
+		w.buf.WriteString(">")
+	}
+}
+
+// qualified returns prefix:local for names from RawToken, which leaves the
+// prefix in Space.
+func qualified(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;")
+)
+
+func escapeText(s string) string { return textEscaper.Replace(s) }
+func escapeAttr(s string) string { return attrEscaper.Replace(s) }
+
+// newRawDecoder returns a decoder for XHTML/OPF that understands HTML entities.
+func newRawDecoder(r io.Reader) *xml.Decoder {
+	d := xml.NewDecoder(r)
+	d.Entity = xml.HTMLEntity
+	return d
+}
+
+// attr returns the value of the attribute with the given local name.
+func attr(t xml.StartElement, local string) (string, bool) {
+	for _, a := range t.Attr {
+		if a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+// setAttr sets or replaces an unprefixed attribute.
+func setAttr(t *xml.StartElement, local, value string) {
+	for i, a := range t.Attr {
+		if a.Name.Space == "" && a.Name.Local == local {
+			t.Attr[i].Value = value
+			return
+		}
+	}
+	t.Attr = append(t.Attr, xml.Attr{Name: xml.Name{Local: local}, Value: value})
+}
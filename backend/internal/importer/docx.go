@@ -0,0 +1,175 @@
+package importer
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// docxStyle is the part of a Word paragraph style that marks headings
+type docxStyle struct {
+	name    string
+	outline int // 1-based outline level; 0 for body text
+}
+
+// docxLines extracts the paragraphs of a Word document. Heading levels come
+// from each paragraph's outline level or from a "heading N"/"Title" style,
+// resolved through styles.xml since style IDs are localised.
+func docxLines(path string) ([]line, error) {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	var document, styles *zip.File
+	for _, f := range z.File {
+		switch f.Name {
+		case "word/document.xml":
+			document = f
+		case "word/styles.xml":
+			styles = f
+		}
+	}
+	if document == nil {
+		return nil, fmt.Errorf("invalid docx: no word/document.xml")
+	}
+
+	styleMap := map[string]docxStyle{}
+	if styles != nil {
+		if styleMap, err = readDocxStyles(styles); err != nil {
+			return nil, fmt.Errorf("failed to parse styles.xml: %v", err)
+		}
+	}
+
+	rc, err := document.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var lines []line
+	var levels []int
+	var sb strings.Builder
+	level := 0
+	inText := false
+	skip := 0 // Depth inside deleted text, footnote references and the like
+	d := xml.NewDecoder(rc)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse document.xml: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if skip > 0 {
+				skip++
+				continue
+			}
+			switch t.Name.Local {
+			case "del", "instrText", "delText", "footnoteReference", "endnoteReference":
+				skip = 1
+			case "p":
+				sb.Reset()
+				level = 0
+			case "pStyle":
+				id, _ := attrValue(t, "val")
+				level = max(level, docxHeadingLevel(styleMap[id]))
+			case "outlineLvl":
+				if v, _ := attrValue(t, "val"); v != "" {
+					if n, err := strconv.Atoi(v); err == nil && n < 9 {
+						level = n + 1
+					}
+				}
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteString(" ")
+			case "br", "cr":
+				sb.WriteString("\n")
+			}
+		case xml.EndElement:
+			if skip > 0 {
+				skip--
+				continue
+			}
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				// Line breaks within a paragraph become separate paragraphs
+				for _, part := range strings.Split(sb.String(), "\n") {
+					if text := strings.Join(strings.Fields(part), " "); text != "" {
+						lines = append(lines, line{text: text})
+						levels = append(levels, level)
+					}
+				}
+				sb.Reset()
+			}
+		case xml.CharData:
+			if inText && skip == 0 {
+				sb.Write(t)
+			}
+		}
+	}
+
+	markHeadings(lines, levels)
+	return lines, nil
+}
+
+func readDocxStyles(f *zip.File) (map[string]docxStyle, error) {
+	var doc struct {
+		Style []struct {
+			ID   string `xml:"styleId,attr"`
+			Name struct {
+				Val string `xml:"val,attr"`
+			} `xml:"name"`
+			PPr struct {
+				OutlineLvl *struct {
+					Val int `xml:"val,attr"`
+				} `xml:"outlineLvl"`
+			} `xml:"pPr"`
+		} `xml:"style"`
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	styles := make(map[string]docxStyle)
+	for _, s := range doc.Style {
+		style := docxStyle{name: strings.ToLower(s.Name.Val)}
+		if s.PPr.OutlineLvl != nil && s.PPr.OutlineLvl.Val < 9 {
+			style.outline = s.PPr.OutlineLvl.Val + 1
+		}
+		styles[s.ID] = style
+	}
+	return styles, nil
+}
+
+// docxHeadingLevel returns the heading level a paragraph style implies
+func docxHeadingLevel(s docxStyle) int {
+	if s.outline > 0 {
+		return s.outline
+	}
+	if s.name == "title" {
+		return 1
+	}
+	if n, ok := strings.CutPrefix(s.name, "heading "); ok {
+		if lv, err := strconv.Atoi(n); err == nil && lv > 0 {
+			return lv
+		}
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package importer
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+// commonHanzi are frequent characters in both simplified and traditional
+// text. Decoding with the wrong legacy encoding turns them into rare ones.
+var commonHanzi = func() map[rune]bool {
+	m := make(map[rune]bool)
+	for _, r := range "的一是了不在人有我他她你们們这這个個中来來上大为為和国國地到以说說时時要就出会會可也对對生能而子那得于於着著下自之年过過发發后後作里裡么麼没沒看" {
+		m[r] = true
+	}
+	return m
+}()
+
+// DecodeText converts a text file to UTF-8 and returns the name of the
+// encoding it was read as. UTF-8 and UTF-16 are recognised by BOM or by
+// their byte patterns; anything else is decoded as whichever of GB18030 and
+// Big5 yields more common Chinese characters.
+func DecodeText(data []byte) (string, string, error) {
+	var enc encoding.Encoding
+	name := ""
+	switch {
+	case bytes.HasPrefix(data, []byte{0xEF, 0xBB, 0xBF}):
+		return normalizeText(string(data[3:])), "utf-8", nil
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		enc, name = unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM), "utf-16le"
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		enc, name = unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM), "utf-16be"
+	default:
+		if order, ok := guessUTF16(data); ok {
+			enc, name = unicode.UTF16(order, unicode.IgnoreBOM), "utf-16le"
+			if order == unicode.BigEndian {
+				name = "utf-16be"
+			}
+			break
+		}
+		if utf8.Valid(data) {
+			return normalizeText(string(data)), "utf-8", nil
+		}
+
+		best := -1 << 31
+		for _, cand := range []struct {
+			name string
+			enc  encoding.Encoding
+		}{{"gb18030", simplifiedchinese.GB18030}, {"big5", traditionalchinese.Big5}} {
+			text, err := cand.enc.NewDecoder().Bytes(data)
+			if err != nil {
+				continue
+			}
+			if s := scoreChinese(string(text)); s > best {
+				best, enc, name = s, cand.enc, cand.name
+			}
+		}
+		if enc == nil {
+			return "", "", errUnknownEncoding
+		}
+	}
+
+	text, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", "", err
+	}
+	return normalizeText(string(text)), name, nil
+}
+
+// guessUTF16 spots BOM-less UTF-16 by the zero high bytes of ASCII text.
+func guessUTF16(data []byte) (unicode.Endianness, bool) {
+	n := min(len(data), 4096) &^ 1
+	if n < 2 {
+		return unicode.BigEndian, false
+	}
+	var even, odd int
+	for i := 0; i < n; i += 2 {
+		if data[i] == 0 {
+			even++
+		}
+		if data[i+1] == 0 {
+			odd++
+		}
+	}
+	pairs := n / 2
+	switch {
+	case odd*5 > pairs && even*20 < pairs:
+		return unicode.LittleEndian, true
+	case even*5 > pairs && odd*20 < pairs:
+		return unicode.BigEndian, true
+	}
+	return unicode.BigEndian, false
+}
+
+// scoreChinese rates how plausible text is as Chinese
+func scoreChinese(text string) int {
+	score := 0
+	for _, r := range text {
+		switch {
+		case r == utf8.RuneError:
+			score -= 5
+		case commonHanzi[r]:
+			score++
+		}
+	}
+	return score
+}
+
+// normalizeText drops a leading BOM and normalises line endings to \n
+func normalizeText(s string) string {
+	s = strings.TrimPrefix(s, "\uFEFF")
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	return strings.ReplaceAll(s, "\r", "\n")
+}
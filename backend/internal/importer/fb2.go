@@ -0,0 +1,136 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// fb2Paragraphs are the FictionBook elements that hold a paragraph of text
+var fb2Paragraphs = map[string]bool{
+	"p": true, "v": true, "subtitle": true, "text-author": true,
+}
+
+// fb2Lines extracts the paragraphs of a FictionBook 2 file, or of the .fb2
+// inside a zipped .fbz. Every <section> title is a heading; sections that
+// hold nothing but nested sections fold their titles into the first
+// chapter beneath them. Bodies other than the main one (notes, comments)
+// are skipped.
+func fb2Lines(path string) ([]line, error) {
+	d, err := openFB2(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []line
+	var sb strings.Builder
+	inBody, skipBody := false, false
+	titleDepth := 0 // Nesting of <title> elements
+	var title []string
+	paraDepth := 0
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse fb2: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch name := t.Name.Local; {
+			case name == "body":
+				inBody = true
+				bodyName, _ := attrValue(t, "name")
+				skipBody = bodyName != ""
+			case !inBody || skipBody:
+			case name == "title":
+				titleDepth++
+			case fb2Paragraphs[name]:
+				paraDepth++
+				sb.Reset()
+			}
+		case xml.EndElement:
+			switch name := t.Name.Local; {
+			case name == "body":
+				inBody, skipBody = false, false
+			case !inBody || skipBody:
+			case name == "title":
+				titleDepth--
+				if titleDepth == 0 && len(title) > 0 {
+					lines = append(lines, line{text: strings.Join(title, " "), heading: true})
+					title = nil
+				}
+			case fb2Paragraphs[name]:
+				paraDepth--
+				text := strings.Join(strings.Fields(sb.String()), " ")
+				if text == "" {
+					break
+				}
+				if titleDepth > 0 {
+					title = append(title, text)
+				} else {
+					lines = append(lines, line{text: text})
+				}
+			}
+		case xml.CharData:
+			if inBody && !skipBody && paraDepth > 0 {
+				sb.Write(t)
+			}
+		}
+	}
+	return lines, nil
+}
+
+// openFB2 returns a decoder for a .fb2 file or the .fb2 inside a .fbz
+func openFB2(path string) (*xml.Decoder, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, []byte("PK")) {
+		if data, err = unzipFB2(data); err != nil {
+			return nil, err
+		}
+	}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.CharsetReader = charset.NewReaderLabel
+	d.Strict = false
+	return d, nil
+}
+
+// unzipFB2 returns the first .fb2 file in a zip archive
+func unzipFB2(data []byte) ([]byte, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range z.File {
+		if strings.HasSuffix(strings.ToLower(f.Name), ".fb2") {
+			rc, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return io.ReadAll(rc)
+		}
+	}
+	return nil, fmt.Errorf("no .fb2 file in archive")
+}
+
+// attrValue returns the value of the attribute with the given local name
+func attrValue(t xml.StartElement, local string) (string, bool) {
+	for _, a := range t.Attr {
+		if a.Name.Local == local {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
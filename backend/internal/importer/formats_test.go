@@ -0,0 +1,205 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestImport_FB2(t *testing.T) {
+	body := `<?xml version="1.0" encoding="windows-1251"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0">
+<description><title-info><book-title>Книга</book-title></title-info></description>
+<body>
+  <title><p>Книга</p></title>
+  <section>
+    <title><p>Часть первая</p></title>
+    <section>
+      <title><p>Глава 1</p><p>Начало</p></title>
+      <p>Первый абзац главы.</p>
+      <empty-line/>
+      <p>Второй <emphasis>абзац</emphasis>.</p>
+    </section>
+    <section>
+      <title><p>Глава 2</p></title>
+      <poem><stanza><v>Строка стиха</v></stanza></poem>
+      <p>Конец книги<a l:href="#n1" type="note">1</a>.</p>
+    </section>
+  </section>
+</body>
+<body name="notes"><section id="n1"><title><p>1</p></title><p>Сноска, которую не читают.</p></section></body>
+</FictionBook>`
+	data, _ := charmap.Windows1251.NewEncoder().String(body)
+
+	chapters, err := Import(writeFile(t, "book.fb2", data), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 2 || chapters[0].Title != "Глава 1 Начало" || chapters[1].Title != "Глава 2" {
+		t.Fatalf("chapters = %+v", chapters)
+	}
+	want := "Книга\n\nЧасть первая\n\nГлава 1 Начало\n\nПервый абзац главы.\n\nВторой абзац.\n"
+	if chapters[0].Content != want {
+		t.Errorf("content = %q, want %q", chapters[0].Content, want)
+	}
+	if chapters[1].Content != "Глава 2\n\nСтрока стиха\n\nКонец книги1.\n" {
+		t.Errorf("content = %q", chapters[1].Content)
+	}
+}
+
+func TestImport_DOCX(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "book.docx")
+	f, _ := os.Create(path)
+	zw := zip.NewWriter(f)
+	files := map[string]string{
+		// Style IDs are localised; only the names identify headings
+		"word/styles.xml": `<w:styles xmlns:w="w">
+  <w:style w:styleId="1"><w:name w:val="heading 1"/></w:style>
+  <w:style w:styleId="a"><w:name w:val="Title"/></w:style>
+</w:styles>`,
+		"word/document.xml": `<w:document xmlns:w="w"><w:body>
+<w:p><w:pPr><w:pStyle w:val="a"/></w:pPr><w:r><w:t>书名</w:t></w:r></w:p>
+<w:p><w:pPr><w:pStyle w:val="1"/></w:pPr><w:r><w:t>第一章</w:t></w:r></w:p>
+<w:p><w:r><w:t xml:space="preserve">他说：</w:t></w:r><w:r><w:t>“你好。”</w:t></w:r><w:r><w:footnoteReference w:id="1"/></w:r></w:p>
+<w:p><w:r><w:t>第一行</w:t><w:br/><w:t>第二行</w:t></w:r><w:del><w:r><w:delText>删除</w:delText></w:r></w:del></w:p>
+<w:p><w:pPr><w:outlineLvl w:val="0"/></w:pPr><w:r><w:t>尾声</w:t></w:r></w:p>
+<w:p><w:r><w:t>全书完，谢谢阅读。</w:t></w:r></w:p>
+</w:body></w:document>`,
+	}
+	for name, content := range files {
+		w, _ := zw.Create(name)
+		w.Write([]byte(content))
+	}
+	zw.Close()
+	f.Close()
+
+	chapters, err := Import(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 2 || chapters[0].Title != "第一章" || chapters[1].Title != "尾声" {
+		t.Fatalf("chapters = %+v", chapters)
+	}
+	if want := "书名\n\n第一章\n\n他说：“你好。”\n\n第一行\n\n第二行\n"; chapters[0].Content != want {
+		t.Errorf("content = %q, want %q", chapters[0].Content, want)
+	}
+}
+
+// buildMOBI assembles a Palm database with a MOBI header and the given
+// text records
+func buildMOBI(compression uint16, extraFlags uint16, records ...[]byte) []byte {
+	rec0 := make([]byte, 0xF4)
+	binary.BigEndian.PutUint16(rec0[0:], compression)
+	binary.BigEndian.PutUint16(rec0[8:], uint16(len(records)))
+	copy(rec0[16:], "MOBI")
+	binary.BigEndian.PutUint32(rec0[20:], 0xE8)
+	binary.BigEndian.PutUint32(rec0[28:], 65001)
+	binary.BigEndian.PutUint16(rec0[0xF2:], extraFlags)
+	all := append([][]byte{rec0}, records...)
+
+	var buf bytes.Buffer
+	header := make([]byte, 78)
+	copy(header, "test")
+	copy(header[60:], "BOOKMOBI")
+	binary.BigEndian.PutUint16(header[76:], uint16(len(all)))
+	buf.Write(header)
+	offset := 78 + len(all)*8 + 2
+	for _, r := range all {
+		entry := make([]byte, 8)
+		binary.BigEndian.PutUint32(entry, uint32(offset))
+		buf.Write(entry)
+		offset += len(r)
+	}
+	buf.Write([]byte{0, 0})
+	for _, r := range all {
+		buf.Write(r)
+	}
+	return buf.Bytes()
+}
+
+func TestImport_MOBI(t *testing.T) {
+	part1 := []byte("<html><body><h2>第一章</h2><p>他说：“你好。”</p><mbp:pagebreak/>")
+	// Trailing entry flag 0x2: one entry whose size (3, including the size
+	// byte itself) is stored backwards at the end of the record
+	part1 = append(part1, 'x', 'y', 0x83)
+	part2 := []byte("<h2>第二章</h2><p>再见。</p></body></html>")
+	part2 = append(part2, 'z', 'z', 0x83)
+
+	data := buildMOBI(mobiUncompressed, 0x2, part1, part2)
+	chapters, err := Import(writeFile(t, "book.mobi", string(data)), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 2 || chapters[0].Title != "第一章" || chapters[1].Title != "第二章" {
+		t.Fatalf("chapters = %+v", chapters)
+	}
+	if chapters[1].Content != "第二章\n\n再见。\n" {
+		t.Errorf("content = %q", chapters[1].Content)
+	}
+
+	drm := buildMOBI(mobiPalmDOC, 0, []byte("x"))
+	binary.BigEndian.PutUint16(drm[78+2*8+2+12:], 2)
+	if _, err := Import(writeFile(t, "drm.azw3", string(drm)), Options{}); err == nil {
+		t.Error("expected an error for an encrypted book")
+	}
+}
+
+func TestPalmDOCDecompress(t *testing.T) {
+	src := []byte{'a', 'b', 'c', 0x80, 0x18, 0xF8, 0x02, 0x80, 0x81, 'd'}
+	want := []byte{'a', 'b', 'c', 'a', 'b', 'c', ' ', 'x', 0x80, 0x81, 'd'}
+	if got := palmDOCDecompress(src); !bytes.Equal(got, want) {
+		t.Errorf("palmDOCDecompress() = %q, want %q", got, want)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	fb2 := writeFile(t, "book.fb2", `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">
+<description>
+  <title-info>
+    <genre>sf</genre>
+    <author><first-name>Аркадий</first-name><middle-name>Натанович</middle-name><last-name>Стругацкий</last-name></author>
+    <book-title>Пикник на обочине</book-title>
+    <annotation><p>Повесть о <emphasis>Зоне</emphasis>.</p></annotation>
+    <lang>ru</lang>
+    <coverpage><image l:href="#cover.png"/></coverpage>
+    <sequence name="Мир Полудня" number="7"/>
+  </title-info>
+  <publish-info><publisher>АСТ</publisher><year>2005</year><isbn>5-17-012345-6</isbn></publish-info>
+</description>
+<body><section><p>Текст.</p></section></body>
+<binary id="cover.png" content-type="image/png">UE5H
+REFUQQ==</binary>
+</FictionBook>`)
+
+	meta, err := Metadata(fb2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Title != "Пикник на обочине" || meta.Language != "ru" || meta.Publisher != "АСТ" || meta.Date != "2005" {
+		t.Errorf("metadata = %+v", meta)
+	}
+	if len(meta.Creators) != 1 || meta.Creators[0].Name != "Аркадий Натанович Стругацкий" || meta.Creators[0].FileAs != "Стругацкий, Аркадий Натанович" {
+		t.Errorf("creators = %+v", meta.Creators)
+	}
+	if meta.Series != "Мир Полудня" || meta.SeriesIndex != "7" || meta.Description != "Повесть о Зоне." {
+		t.Errorf("series = %q #%s, description = %q", meta.Series, meta.SeriesIndex, meta.Description)
+	}
+	cover, mediaType, err := Cover(fb2)
+	if err != nil || string(cover) != "PNGDATA" || mediaType != "image/png" {
+		t.Errorf("Cover() = %q, %q, %v", cover, mediaType, err)
+	}
+
+	meta, err = Metadata(writeFile(t, "活着.txt", "正文"))
+	if err != nil || meta.Title != "活着" {
+		t.Errorf("Metadata(txt) = %+v, %v", meta, err)
+	}
+	if _, _, err := Cover(writeFile(t, "book.md", "# Title")); err == nil {
+		t.Error("expected no cover for markdown")
+	}
+}
@@ -0,0 +1,71 @@
+package importer
+
+import (
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blockAtoms end a paragraph
+var blockAtoms = map[atom.Atom]bool{
+	atom.P: true, atom.Div: true, atom.Br: true, atom.Li: true, atom.Blockquote: true,
+	atom.Pre: true, atom.Tr: true, atom.Section: true, atom.Article: true, atom.Hr: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+// htmlLines extracts the paragraphs of a standalone HTML book, marking
+// headings as markHeadings describes.
+func htmlLines(text string) ([]line, error) {
+	doc, err := html.Parse(strings.NewReader(text))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []line
+	var levels []int // Heading level of each line, 0 for text
+	var sb strings.Builder
+	level := 0
+	flush := func() {
+		if t := strings.Join(strings.Fields(sb.String()), " "); t != "" {
+			lines = append(lines, line{text: t})
+			levels = append(levels, level)
+		}
+		sb.Reset()
+	}
+
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			switch n.DataAtom {
+			case atom.Head, atom.Script, atom.Style, atom.Noscript, atom.Template:
+				return
+			}
+		}
+
+		block := blockAtoms[n.DataAtom]
+		if block {
+			flush()
+			level = headingLevels[n.DataAtom]
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if block {
+			flush()
+			level = 0
+		}
+	}
+	walk(doc)
+
+	markHeadings(lines, levels)
+	return lines, nil
+}
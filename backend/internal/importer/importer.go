@@ -0,0 +1,282 @@
+// Package importer turns book files into chapters. EPUBs are read with
+// epub.Reader; every other format is reduced to paragraphs and split at
+// chapter headings, taken from the document structure where the format has
+// one and matched by pattern otherwise.
+//
+// Paragraphs in imported chapters are separated by blank lines, so
+// analysis can split long chapters at paragraph boundaries.
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"tts-book/backend/internal/epub"
+)
+
+// DefaultChapterPatterns match common chapter headings: 第X章/回/节/卷,
+// "Chapter N", named front and back matter, and level 1-2 Markdown headings.
+var DefaultChapterPatterns = []string{
+	`^第[0-9０-９零〇一二三四五六七八九十百千万两]+[章回节節卷集部篇]`,
+	`^(?i)chapter\s+([0-9]+|[ivxlcdm]+)\b`,
+	`^(序章|序言|楔子|引子|前言|尾声|尾聲|后记|後記|终章|終章|番外)`,
+	`^#{1,2}\s+\S`,
+}
+
+// Extensions lists the file types Import accepts
+var Extensions = []string{
+	".epub", ".txt", ".md", ".markdown", ".html", ".htm", ".xhtml",
+	".fb2", ".fbz", ".docx", ".mobi", ".azw", ".azw3",
+}
+
+const (
+	// maxHeadingRunes keeps sentences that happen to start like a heading
+	// ("第一章就写得……") from splitting a chapter
+	maxHeadingRunes = 50
+
+	// maxChunkRunes is the size of the parts a book without recognisable
+	// headings is cut into
+	maxChunkRunes = 20000
+)
+
+var errUnknownEncoding = errors.New("unable to detect text encoding")
+
+// Options controls how books are split into chapters
+type Options struct {
+	ChapterPatterns []string // Regexes matched against each trimmed line; empty uses DefaultChapterPatterns
+	FootnotesAtEnd  bool     // EPUB only: see epub.Options
+}
+
+// Supported reports whether Import accepts the file's extension
+func Supported(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// CompilePatterns compiles chapter heading patterns, falling back to
+// DefaultChapterPatterns when none are given.
+func CompilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		patterns = DefaultChapterPatterns
+	}
+	res := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chapter pattern %q: %v", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// Import reads the book at path and splits it into chapters, choosing the
+// format by file extension.
+func Import(path string, opts Options) ([]epub.Chapter, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".epub" {
+		reader, err := epub.NewReaderWithOptions(path, epub.Options{FootnotesAtEnd: opts.FootnotesAtEnd})
+		if err != nil {
+			return nil, err
+		}
+		return reader.GetChapters()
+	}
+	if !Supported(path) {
+		return nil, fmt.Errorf("unsupported file type %q", ext)
+	}
+
+	patterns, err := CompilePatterns(opts.ChapterPatterns)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readLines(path, ext)
+	if err != nil {
+		return nil, err
+	}
+	return splitChapters(lines, patterns), nil
+}
+
+// readLines extracts the paragraphs of a non-EPUB book
+func readLines(path, ext string) ([]line, error) {
+	switch ext {
+	case ".fb2", ".fbz":
+		return fb2Lines(path)
+	case ".docx":
+		return docxLines(path)
+	case ".mobi", ".azw", ".azw3":
+		return mobiLines(path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text, _, err := DecodeText(data)
+	if err != nil {
+		return nil, err
+	}
+	switch ext {
+	case ".md", ".markdown":
+		return markdownLines(text), nil
+	case ".html", ".htm", ".xhtml":
+		return htmlLines(text)
+	}
+	return textLines(text), nil
+}
+
+// line is one paragraph of an imported book. Formats with structural
+// headings mark them; for the rest, headings are found by pattern.
+type line struct {
+	text    string
+	heading bool
+}
+
+func textLines(text string) []line {
+	var lines []line
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, line{text: l})
+		}
+	}
+	return lines
+}
+
+// markHeadings flags the lines that start chapters, given the heading level
+// of each line (0 for text). Chapters start at the highest level that occurs
+// more than once, so a lone book title does not hide the chapters beneath
+// it; higher headings are folded into the chapter that follows. Without such
+// headings every line is left for pattern matching.
+func markHeadings(lines []line, levels []int) {
+	count := make(map[int]int)
+	for _, l := range levels {
+		count[l]++
+	}
+	for lv := 1; lv <= 9; lv++ {
+		if count[lv] > 1 {
+			for i := range lines {
+				lines[i].heading = levels[i] > 0 && levels[i] <= lv
+			}
+			return
+		}
+	}
+}
+
+// splitChapters groups lines into chapters, starting one at every heading.
+// A heading with no text of its own (a volume title followed directly by
+// its first chapter) is folded into the next chapter.
+func splitChapters(lines []line, patterns []*regexp.Regexp) []epub.Chapter {
+	structural := false
+	for _, l := range lines {
+		structural = structural || l.heading
+	}
+
+	type section struct {
+		title string
+		lines []string
+		body  int // Non-heading lines
+	}
+	var sections []*section
+	cur := &section{}
+	for _, l := range lines {
+		isHeading := l.heading
+		if !structural {
+			isHeading = matchesHeading(l.text, patterns)
+		}
+		if isHeading {
+			if cur.body > 0 {
+				sections = append(sections, cur)
+				cur = &section{}
+			}
+			cur.title = cleanHeading(l.text)
+			cur.lines = append(cur.lines, cur.title)
+			continue
+		}
+		cur.lines = append(cur.lines, l.text)
+		cur.body++
+	}
+	if len(cur.lines) > 0 {
+		sections = append(sections, cur)
+	}
+
+	if len(sections) == 1 && sections[0].title == "" {
+		return chunkChapters(sections[0].lines)
+	}
+
+	var chapters []epub.Chapter
+	for _, s := range sections {
+		content := strings.Join(s.lines, "\n\n") + "\n"
+		if len(strings.TrimSpace(content)) <= 10 {
+			continue
+		}
+		chapters = append(chapters, newChapter(len(chapters)+1, s.title, content))
+	}
+	return chapters
+}
+
+// chunkChapters cuts a book with no headings into parts at paragraph
+// boundaries, so no chapter is too long to analyse.
+func chunkChapters(lines []string) []epub.Chapter {
+	var chapters []epub.Chapter
+	var sb strings.Builder
+	runes := 0
+	flush := func() {
+		if strings.TrimSpace(sb.String()) != "" {
+			chapters = append(chapters, newChapter(len(chapters)+1, "", sb.String()))
+		}
+		sb.Reset()
+		runes = 0
+	}
+	for _, l := range lines {
+		if runes > 0 && runes+utf8.RuneCountInString(l) > maxChunkRunes {
+			flush()
+		}
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(l + "\n")
+		runes += utf8.RuneCountInString(l)
+	}
+	flush()
+	return chapters
+}
+
+func newChapter(n int, title, content string) epub.Chapter {
+	if title == "" {
+		title = fmt.Sprintf("Chapter %d", n)
+	}
+	return epub.Chapter{
+		ID:      fmt.Sprintf("ch_%03d", n),
+		Title:   title,
+		Content: content,
+	}
+}
+
+func matchesHeading(text string, patterns []*regexp.Regexp) bool {
+	if utf8.RuneCountInString(text) > maxHeadingRunes {
+		return false
+	}
+	for _, re := range patterns {
+		if re.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanHeading strips Markdown heading markers
+func cleanHeading(text string) string {
+	if strings.HasPrefix(text, "#") {
+		text = strings.TrimRight(strings.TrimLeft(text, "#"), "# ")
+	}
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,169 @@
+package importer
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const testNovel = `书名：测试小说
+作者：某人
+
+第一卷 风起
+第一章 少年
+他说：“我们走吧，这里不是久留之地。”
+她看了他一眼，没有说话。
+第二章：出发
+第二天一早，他们就出发了。路上的人很多。
+第三章就写得这么长了，这一行虽然以第三章开头，但它是正文而不是标题，所以不应该被切开，否则整本书的章节就全乱了。
+`
+
+func TestDecodeText(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String(testNovel)
+	big5, _ := traditionalchinese.Big5.NewEncoder().String("他說：“我們走吧，這裡不是久留之地。”她看了他一眼，沒有說話。")
+	utf16, _ := unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewEncoder().String("Chapter 1\r\nHello")
+	utf16be, _ := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewEncoder().String("Chapter 1\nHello there")
+
+	tests := []struct {
+		name     string
+		data     string
+		wantEnc  string
+		wantText string
+	}{
+		{"utf8", "\xEF\xBB\xBF第一章\r\n正文", "utf-8", "第一章\n正文"},
+		{"gbk", gbk, "gb18030", testNovel},
+		{"big5", big5, "big5", "他說：“我們走吧，這裡不是久留之地。”她看了他一眼，沒有說話。"},
+		{"utf16 bom", utf16, "utf-16le", "Chapter 1\nHello"},
+		{"utf16 no bom", utf16be, "utf-16be", "Chapter 1\nHello there"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, enc, err := DecodeText([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if enc != tt.wantEnc || text != tt.wantText {
+				t.Errorf("DecodeText() = %q, %q; want %q, %q", text, enc, tt.wantText, tt.wantEnc)
+			}
+		})
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func chapterTitles(t *testing.T, path string, opts Options) []string {
+	t.Helper()
+	chapters, err := Import(path, opts)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	var titles []string
+	for i, ch := range chapters {
+		if want := "ch_00" + string(rune('1'+i)); ch.ID != want {
+			t.Errorf("chapter %d ID = %s, want %s", i, ch.ID, want)
+		}
+		titles = append(titles, ch.Title)
+	}
+	return titles
+}
+
+func TestImport_Text(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String(testNovel)
+	path := writeFile(t, "novel.txt", gbk)
+
+	chapters, err := Import(path, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]string, len(chapters))
+	for i, ch := range chapters {
+		got[i] = ch.Title
+	}
+	// The front matter becomes its own chapter, and the volume heading is
+	// folded into the first chapter
+	want := []string{"Chapter 1", "第一章 少年", "第二章：出发"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("titles = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(chapters[1].Content, "第一卷 风起\n\n第一章 少年\n\n他说") {
+		t.Errorf("chapter 2 content = %q", chapters[1].Content)
+	}
+	if !strings.Contains(chapters[2].Content, "第三章就写得") {
+		t.Errorf("long line starting with a heading was split off: %q", chapters[2].Content)
+	}
+
+	// Custom patterns replace the defaults
+	titles := chapterTitles(t, writeFile(t, "b.txt", "== One ==\nsome text here\n== Two ==\nmore text here\n"),
+		Options{ChapterPatterns: []string{`^== .* ==$`}})
+	if strings.Join(titles, "|") != "== One ==|== Two ==" {
+		t.Errorf("custom pattern titles = %q", titles)
+	}
+}
+
+func TestImport_Markdown(t *testing.T) {
+	md := `---
+title: Test
+---
+# The Book
+
+## Chapter 1 {#c1}
+
+She said **hello** to [Bob](http://example.com).
+
+- a list item that is long enough
+
+## Chapter 2
+
+` + "```" + `
+fenced text
+` + "```" + `
+Another *paragraph* of text.
+`
+	chapters, err := Import(writeFile(t, "book.md", md), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 2 || chapters[0].Title != "Chapter 1" || chapters[1].Title != "Chapter 2" {
+		t.Fatalf("chapters = %+v", chapters)
+	}
+	want := "The Book\n\nChapter 1\n\nShe said hello to Bob.\n\na list item that is long enough\n"
+	if chapters[0].Content != want {
+		t.Errorf("content = %q, want %q", chapters[0].Content, want)
+	}
+	if chapters[1].Content != "Chapter 2\n\nfenced text\n\nAnother paragraph of text.\n" {
+		t.Errorf("content = %q", chapters[1].Content)
+	}
+}
+
+func TestImport_HTML(t *testing.T) {
+	page := `<html><head><title>x</title><style>p{}</style></head><body>
+<h1>The Book</h1>
+<h2>Opening</h2><p>First   paragraph<br>second line.</p>
+<h2>Ending</h2><p>Last <b>words</b> of the book.</p><script>var x;</script>
+</body></html>`
+	chapters, err := Import(writeFile(t, "book.html", page), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chapters) != 2 || chapters[0].Title != "Opening" || chapters[1].Title != "Ending" {
+		t.Fatalf("chapters = %+v", chapters)
+	}
+	if chapters[0].Content != "The Book\n\nOpening\n\nFirst paragraph\n\nsecond line.\n" {
+		t.Errorf("content = %q", chapters[0].Content)
+	}
+	if chapters[1].Content != "Ending\n\nLast words of the book.\n" {
+		t.Errorf("content = %q", chapters[1].Content)
+	}
+}
@@ -0,0 +1,54 @@
+package importer
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	mdImage     = regexp.MustCompile(`!\[[^\]]*\]\([^)]*\)`)
+	mdLink      = regexp.MustCompile(`\[([^\]]*)\]\([^)]*\)`)
+	mdEmphasis  = regexp.MustCompile(`(\*\*|__|\*|~~|` + "`" + `)`)
+	mdListItem  = regexp.MustCompile(`^([-*+]|\d+[.)])\s+`)
+	mdRule      = regexp.MustCompile(`^([-*_]\s*){3,}$`)
+	mdComment   = regexp.MustCompile(`<!--.*?-->`)
+	mdHeadingID = regexp.MustCompile(`\s*\{#[^}]*\}$`)
+)
+
+// markdownLines reduces Markdown to plain paragraphs. Heading markers are
+// kept so the default patterns can split on them; other markup, front
+// matter and code fence markers are dropped.
+func markdownLines(text string) []line {
+	raw := strings.Split(text, "\n")
+
+	// YAML front matter
+	if len(raw) > 0 && strings.TrimSpace(raw[0]) == "---" {
+		for i := 1; i < len(raw); i++ {
+			if strings.TrimSpace(raw[i]) == "---" {
+				raw = raw[i+1:]
+				break
+			}
+		}
+	}
+
+	var lines []line
+	for _, l := range raw {
+		l = strings.TrimSpace(mdComment.ReplaceAllString(l, ""))
+		if l == "" || strings.HasPrefix(l, "```") || strings.HasPrefix(l, "~~~") || mdRule.MatchString(l) {
+			continue
+		}
+		if strings.HasPrefix(l, "#") {
+			lines = append(lines, line{text: mdHeadingID.ReplaceAllString(l, "")})
+			continue
+		}
+		l = strings.TrimSpace(strings.TrimLeft(l, ">"))
+		l = mdListItem.ReplaceAllString(l, "")
+		l = mdImage.ReplaceAllString(l, "")
+		l = mdLink.ReplaceAllString(l, "$1")
+		l = mdEmphasis.ReplaceAllString(l, "")
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, line{text: l})
+		}
+	}
+	return lines
+}
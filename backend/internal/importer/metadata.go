@@ -0,0 +1,191 @@
+package importer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"tts-book/backend/internal/epub"
+
+	"golang.org/x/net/html"
+)
+
+// Paragraph-level tags in an annotation become spaces; inline ones vanish
+var (
+	markupBlockTag = regexp.MustCompile(`</?(p|v|subtitle|empty-line|stanza|poem|cite)\b[^>]*>`)
+	markupTag      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// fb2Person is a FictionBook author or translator
+type fb2Person struct {
+	First    string `xml:"first-name"`
+	Middle   string `xml:"middle-name"`
+	Last     string `xml:"last-name"`
+	Nickname string `xml:"nickname"`
+}
+
+// fb2Description is the part of a FictionBook file that describes the book
+type fb2Description struct {
+	TitleInfo struct {
+		Genre      []string    `xml:"genre"`
+		Author     []fb2Person `xml:"author"`
+		Translator []fb2Person `xml:"translator"`
+		BookTitle  string      `xml:"book-title"`
+		Annotation struct {
+			Inner string `xml:",innerxml"`
+		} `xml:"annotation"`
+		Date      string `xml:"date"`
+		Lang      string `xml:"lang"`
+		Coverpage struct {
+			Image []struct {
+				Href string `xml:"href,attr"`
+			} `xml:"image"`
+		} `xml:"coverpage"`
+		Sequence []struct {
+			Name   string `xml:"name,attr"`
+			Number string `xml:"number,attr"`
+		} `xml:"sequence"`
+	} `xml:"description>title-info"`
+	PublishInfo struct {
+		Publisher string `xml:"publisher"`
+		Year      string `xml:"year"`
+		ISBN      string `xml:"isbn"`
+	} `xml:"description>publish-info"`
+	Binary []struct {
+		ID          string `xml:"id,attr"`
+		ContentType string `xml:"content-type,attr"`
+		Data        string `xml:",chardata"`
+	} `xml:"binary"`
+}
+
+// Metadata returns the title, creators and other details of a book. EPUB
+// and FB2 files declare them; for other formats the title is taken from
+// the file name.
+func Metadata(path string) (*epub.Metadata, error) {
+	var meta *epub.Metadata
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".epub":
+		reader, err := epub.NewReader(path)
+		if err != nil {
+			return nil, err
+		}
+		if meta, err = reader.GetMetadata(); err != nil {
+			return nil, err
+		}
+	case ".fb2", ".fbz":
+		desc, err := readFB2Description(path)
+		if err != nil {
+			return nil, err
+		}
+		meta = fb2Metadata(desc)
+	default:
+		meta = &epub.Metadata{}
+	}
+	if meta.Title == "" {
+		meta.Title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return meta, nil
+}
+
+// Cover returns the cover image of an EPUB or FB2 book and its media type
+func Cover(path string) ([]byte, string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".epub":
+		reader, err := epub.NewReader(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return reader.GetCover()
+	case ".fb2", ".fbz":
+		desc, err := readFB2Description(path)
+		if err != nil {
+			return nil, "", err
+		}
+		return fb2Cover(desc)
+	}
+	return nil, "", fmt.Errorf("no cover image declared")
+}
+
+func readFB2Description(path string) (*fb2Description, error) {
+	d, err := openFB2(path)
+	if err != nil {
+		return nil, err
+	}
+	var desc fb2Description
+	if err := d.Decode(&desc); err != nil {
+		return nil, fmt.Errorf("failed to parse fb2: %v", err)
+	}
+	return &desc, nil
+}
+
+func fb2Metadata(desc *fb2Description) *epub.Metadata {
+	info := &desc.TitleInfo
+	meta := &epub.Metadata{
+		Title:     strings.TrimSpace(info.BookTitle),
+		Language:  strings.TrimSpace(info.Lang),
+		Publisher: strings.TrimSpace(desc.PublishInfo.Publisher),
+		Date:      strings.TrimSpace(info.Date),
+		Subjects:  info.Genre,
+	}
+	if meta.Date == "" {
+		meta.Date = strings.TrimSpace(desc.PublishInfo.Year)
+	}
+	annotation := markupBlockTag.ReplaceAllString(info.Annotation.Inner, " ")
+	meta.Description = strings.Join(strings.Fields(html.UnescapeString(markupTag.ReplaceAllString(annotation, ""))), " ")
+
+	add := func(people []fb2Person, role string) {
+		for _, p := range people {
+			person := p.toPerson(role)
+			if person.Name == "" {
+				continue
+			}
+			meta.Creators = append(meta.Creators, person)
+			if role == "aut" {
+				meta.Authors = append(meta.Authors, person.Name)
+			}
+		}
+	}
+	add(info.Author, "aut")
+	add(info.Translator, "trl")
+
+	if isbn := strings.TrimSpace(desc.PublishInfo.ISBN); isbn != "" {
+		meta.Identifiers = append(meta.Identifiers, epub.Identifier{Scheme: "isbn", Value: isbn})
+	}
+	if len(info.Sequence) > 0 {
+		meta.Series = strings.TrimSpace(info.Sequence[0].Name)
+		meta.SeriesIndex = strings.TrimSpace(info.Sequence[0].Number)
+	}
+	return meta
+}
+
+func (p fb2Person) toPerson(role string) epub.Person {
+	given := strings.Join(strings.Fields(p.First+" "+p.Middle), " ")
+	last := strings.TrimSpace(p.Last)
+	person := epub.Person{Name: strings.TrimSpace(given + " " + last), Role: role}
+	if given != "" && last != "" {
+		person.FileAs = last + ", " + given
+	}
+	if person.Name == "" {
+		person.Name = strings.TrimSpace(p.Nickname)
+	}
+	return person
+}
+
+func fb2Cover(desc *fb2Description) ([]byte, string, error) {
+	for _, img := range desc.TitleInfo.Coverpage.Image {
+		id := strings.TrimPrefix(img.Href, "#")
+		for _, b := range desc.Binary {
+			if b.ID != id {
+				continue
+			}
+			data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(b.Data), ""))
+			if err != nil {
+				return nil, "", fmt.Errorf("invalid cover image: %v", err)
+			}
+			return data, b.ContentType, nil
+		}
+	}
+	return nil, "", fmt.Errorf("no cover image declared")
+}
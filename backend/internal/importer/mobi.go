@@ -0,0 +1,185 @@
+package importer
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"regexp"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// PalmDOC compression types
+const (
+	mobiUncompressed = 1
+	mobiPalmDOC      = 2
+	mobiHuffCDIC     = 17480
+)
+
+// mobiPageBreak separates chapters in MOBI markup; turning it into a
+// paragraph break keeps the text on either side apart.
+var mobiPageBreak = regexp.MustCompile(`(?i)<mbp:pagebreak\s*/?>`)
+
+// mobiLines extracts the paragraphs of an unencrypted MOBI, AZW or AZW3
+// book. The text records hold HTML, which is read like a standalone HTML
+// book. Combined MOBI/KF8 files are read from their MOBI part.
+func mobiLines(path string) ([]line, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	markup, err := mobiText(data)
+	if err != nil {
+		return nil, err
+	}
+	return htmlLines(mobiPageBreak.ReplaceAllString(markup, "<p></p>"))
+}
+
+// mobiText returns the decompressed, decoded text of a MOBI file
+func mobiText(data []byte) (string, error) {
+	records, err := palmRecords(data)
+	if err != nil {
+		return "", err
+	}
+	if len(records) < 2 {
+		return "", fmt.Errorf("invalid mobi: no text records")
+	}
+	rec0 := records[0]
+	if len(rec0) < 16 {
+		return "", fmt.Errorf("invalid mobi: short header")
+	}
+
+	compression := binary.BigEndian.Uint16(rec0[0:])
+	textRecords := int(binary.BigEndian.Uint16(rec0[8:]))
+	if enc := binary.BigEndian.Uint16(rec0[12:]); enc != 0 {
+		return "", fmt.Errorf("mobi is DRM-protected")
+	}
+
+	// The MOBI header that follows the PalmDOC header is optional (plain
+	// PalmDOC .prc files lack it)
+	encoding := uint32(1252)
+	extraFlags := uint16(0)
+	if len(rec0) >= 32 && string(rec0[16:20]) == "MOBI" {
+		headerLen := binary.BigEndian.Uint32(rec0[20:])
+		encoding = binary.BigEndian.Uint32(rec0[28:])
+		if headerLen >= 0xE4 && len(rec0) >= 0xF4 {
+			extraFlags = binary.BigEndian.Uint16(rec0[0xF2:0xF4])
+		}
+	}
+
+	var text bytes.Buffer
+	for i := 1; i <= textRecords && i < len(records); i++ {
+		rec := records[i]
+		rec = rec[:len(rec)-trailingEntriesSize(rec, extraFlags)]
+		switch compression {
+		case mobiUncompressed:
+			text.Write(rec)
+		case mobiPalmDOC:
+			text.Write(palmDOCDecompress(rec))
+		case mobiHuffCDIC:
+			return "", fmt.Errorf("HUFF/CDIC compressed mobi is not supported")
+		default:
+			return "", fmt.Errorf("unknown mobi compression %d", compression)
+		}
+	}
+
+	if encoding == 65001 {
+		return string(bytes.ToValidUTF8(text.Bytes(), []byte("\uFFFD"))), nil
+	}
+	decoded, err := charmap.Windows1252.NewDecoder().Bytes(text.Bytes())
+	if err != nil {
+		return "", err
+	}
+	return string(decoded), nil
+}
+
+// palmRecords splits a Palm database into its records
+func palmRecords(data []byte) ([][]byte, error) {
+	if len(data) < 78 {
+		return nil, fmt.Errorf("invalid mobi: too short")
+	}
+	n := int(binary.BigEndian.Uint16(data[76:]))
+	if len(data) < 78+n*8 {
+		return nil, fmt.Errorf("invalid mobi: truncated record list")
+	}
+	offsets := make([]int, n+1)
+	for i := 0; i < n; i++ {
+		offsets[i] = int(binary.BigEndian.Uint32(data[78+i*8:]))
+	}
+	offsets[n] = len(data)
+
+	records := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		if offsets[i] > offsets[i+1] || offsets[i+1] > len(data) {
+			return nil, fmt.Errorf("invalid mobi: bad offset for record %d", i)
+		}
+		records[i] = data[offsets[i]:offsets[i+1]]
+	}
+	return records, nil
+}
+
+// trailingEntriesSize returns how many bytes at the end of a text record
+// are trailing entries rather than text, as described by the MOBI header's
+// extra data flags.
+func trailingEntriesSize(rec []byte, flags uint16) int {
+	size := 0
+	for f := flags >> 1; f != 0; f >>= 1 {
+		if f&1 == 0 {
+			continue
+		}
+		// Entry sizes are stored at the end as a backwards varint
+		end := len(rec) - size
+		value, shift := 0, 0
+		for i := end - 1; i >= 0 && shift < 28; i-- {
+			b := rec[i]
+			value |= int(b&0x7F) << shift
+			shift += 7
+			if b&0x80 != 0 {
+				break
+			}
+		}
+		size += value
+	}
+	if flags&1 != 0 && len(rec) > size {
+		size += int(rec[len(rec)-size-1]&0x3) + 1
+	}
+	return min(size, len(rec))
+}
+
+// palmDOCDecompress expands PalmDOC's LZ77 variant
+func palmDOCDecompress(src []byte) []byte {
+	out := make([]byte, 0, len(src)*2)
+	for i := 0; i < len(src); {
+		c := src[i]
+		i++
+		switch {
+		case c >= 1 && c <= 8:
+			// Literal run
+			end := min(i+int(c), len(src))
+			out = append(out, src[i:end]...)
+			i = end
+		case c < 0x80:
+			out = append(out, c)
+		case c >= 0xC0:
+			// Space followed by a character
+			out = append(out, ' ', c^0x80)
+		default:
+			// Back reference: 11-bit distance, 3-bit length
+			if i >= len(src) {
+				return out
+			}
+			pair := int(c)<<8 | int(src[i])
+			i++
+			dist := (pair >> 3) & 0x7FF
+			n := pair&7 + 3
+			if dist == 0 || dist > len(out) {
+				continue
+			}
+			for j := 0; j < n; j++ {
+				out = append(out, out[len(out)-dist])
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,464 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle state of a job.
+type State string
+
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StatePaused    State = "paused"
+	StateFailed    State = "failed"
+	StateDone      State = "done"
+	StateCancelled State = "cancelled"
+)
+
+// Finished reports whether the job has reached a terminal state.
+func (s State) Finished() bool {
+	return s == StateFailed || s == StateDone || s == StateCancelled
+}
+
+var (
+	ErrNotFound     = errors.New("job not found")
+	ErrDuplicate    = errors.New("a job for this target is already active")
+	ErrUnknownKind  = errors.New("unknown job kind")
+	ErrInvalidState = errors.New("operation not allowed in current job state")
+	ErrBookBusy     = errors.New("the book has unfinished jobs")
+	ErrBookLocked   = errors.New("the book is being changed")
+)
+
+// keepFinished is how many finished jobs are remembered; older ones are
+// dropped so jobs.json doesn't grow with every job ever run.
+const keepFinished = 100
+
+// Runner executes a job. It must return promptly once ctx is cancelled and
+// should call job.Checkpoint between units of work so pausing takes effect.
+type Runner func(ctx context.Context, job *Job) error
+
+// Job is a unit of long-running work (analysis, generation, export...).
+type Job struct {
+	ID        string            `json:"id"`
+	Kind      string            `json:"kind"`
+	Key       string            `json:"key"` // Deduplication key, e.g. "generate:<bookID>:<chapterID>"
+	BookID    string            `json:"bookId"`
+	Params    map[string]string `json:"params,omitempty"`
+	State     State             `json:"state"`
+	Progress  int               `json:"progress"`
+	Message   string            `json:"message"`
+	Error     string            `json:"error,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+
+	m       *Manager
+	cancel  context.CancelFunc
+	resumed chan struct{} // Closed when a paused job is resumed
+}
+
+// Manager tracks jobs, runs them with bounded concurrency and persists their
+// state to disk so unfinished jobs survive a server restart.
+type Manager struct {
+	mu         sync.Mutex
+	path       string
+	maxRunning int
+	runners    map[string]Runner
+	jobs       map[string]*Job
+	running    int
+	seq        int
+	keep       int             // Finished jobs to keep
+	locked     map[string]bool // Books no job may be submitted for, see LockBook
+}
+
+// NewManager creates a manager that persists to path and runs at most
+// maxRunning jobs at a time.
+func NewManager(path string, maxRunning int) *Manager {
+	if maxRunning <= 0 {
+		maxRunning = 1
+	}
+	return &Manager{
+		path:       path,
+		maxRunning: maxRunning,
+		runners:    make(map[string]Runner),
+		jobs:       make(map[string]*Job),
+		keep:       keepFinished,
+		locked:     make(map[string]bool),
+	}
+}
+
+// Register associates a runner with a job kind. Runners must be registered
+// before Load so resumed jobs can find them.
+func (m *Manager) Register(kind string, r Runner) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.runners[kind] = r
+}
+
+// Submit queues a new job. If an unfinished job with the same key exists,
+// ErrDuplicate is returned together with that job.
+func (m *Manager) Submit(kind, key, bookID string, params map[string]string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.runners[kind]; !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKind, kind)
+	}
+	if m.locked[bookID] {
+		return nil, ErrBookLocked
+	}
+	if key != "" {
+		for _, j := range m.jobs {
+			if j.Key == key && !j.State.Finished() {
+				return j.snapshot(), ErrDuplicate
+			}
+		}
+	}
+
+	m.seq++
+	now := time.Now()
+	job := &Job{
+		ID:        fmt.Sprintf("%d-%d", now.UnixNano(), m.seq),
+		Kind:      kind,
+		Key:       key,
+		BookID:    bookID,
+		Params:    params,
+		State:     StateQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+		m:         m,
+	}
+	m.jobs[job.ID] = job
+	m.saveLocked()
+	m.dispatchLocked()
+	return job.snapshot(), nil
+}
+
+// LockBook reserves a book for a change no job may run alongside, like
+// deleting it. It fails with ErrBookBusy while the book has unfinished jobs
+// and with ErrBookLocked if it is already locked. Until unlock is called,
+// submitting a job for the book fails with ErrBookLocked.
+func (m *Manager) LockBook(bookID string) (unlock func(), err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.locked[bookID] {
+		return nil, ErrBookLocked
+	}
+	for _, j := range m.jobs {
+		if j.BookID == bookID && !j.State.Finished() {
+			return nil, ErrBookBusy
+		}
+	}
+	m.locked[bookID] = true
+	return func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.locked, bookID)
+	}, nil
+}
+
+// List returns copies of all known jobs, newest first.
+func (m *Manager) List() []*Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	list := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, j.snapshot())
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// Get returns a copy of a single job.
+func (m *Manager) Get(id string) (*Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// Cancel stops a job. Running jobs have their context cancelled; queued or
+// paused jobs are finalised immediately.
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if j.State.Finished() {
+		return ErrInvalidState
+	}
+
+	if j.cancel != nil {
+		// The run goroutine records the final state once the runner returns.
+		j.cancel()
+		if j.resumed != nil {
+			close(j.resumed)
+			j.resumed = nil
+		}
+		return nil
+	}
+
+	j.setStateLocked(StateCancelled, "Cancelled")
+	m.pruneLocked()
+	m.saveLocked()
+	return nil
+}
+
+// Pause asks a job to stop at its next checkpoint. Queued jobs are held back
+// from dispatch until resumed.
+func (m *Manager) Pause(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if j.State != StateRunning && j.State != StateQueued {
+		return ErrInvalidState
+	}
+
+	if j.resumed == nil {
+		j.resumed = make(chan struct{})
+	}
+	j.setStateLocked(StatePaused, "Paused")
+	m.saveLocked()
+	return nil
+}
+
+// Resume continues a paused job.
+func (m *Manager) Resume(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	j, ok := m.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if j.State != StatePaused {
+		return ErrInvalidState
+	}
+
+	if j.resumed != nil {
+		close(j.resumed)
+		j.resumed = nil
+	}
+
+	if j.cancel != nil {
+		j.setStateLocked(StateRunning, "Resumed")
+	} else {
+		j.setStateLocked(StateQueued, "Resumed")
+		m.dispatchLocked()
+	}
+	m.saveLocked()
+	return nil
+}
+
+// Load reads persisted jobs from disk. Jobs that were queued or running when
+// the server stopped are queued again; paused jobs stay paused.
+func (m *Manager) Load() error {
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved []*Job
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, j := range saved {
+		j.m = m
+		switch j.State {
+		case StateQueued, StateRunning:
+			if _, ok := m.runners[j.Kind]; !ok {
+				j.State = StateFailed
+				j.Error = ErrUnknownKind.Error()
+				break
+			}
+			log.Printf("[Jobs] Resuming %s job %s", j.Kind, j.ID)
+			j.State = StateQueued
+			j.Message = "Resumed after restart"
+		case StatePaused:
+			j.resumed = make(chan struct{})
+		}
+		m.jobs[j.ID] = j
+	}
+	m.pruneLocked()
+	m.saveLocked()
+	m.dispatchLocked()
+	return nil
+}
+
+// dispatchLocked starts queued jobs (oldest first) while capacity remains.
+func (m *Manager) dispatchLocked() {
+	if m.running >= m.maxRunning {
+		return
+	}
+
+	var queued []*Job
+	for _, j := range m.jobs {
+		if j.State == StateQueued {
+			queued = append(queued, j)
+		}
+	}
+	sort.Slice(queued, func(a, b int) bool {
+		return queued[a].CreatedAt.Before(queued[b].CreatedAt)
+	})
+
+	for _, j := range queued {
+		if m.running >= m.maxRunning {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		j.cancel = cancel
+		j.setStateLocked(StateRunning, "Started")
+		m.running++
+		go m.run(ctx, j, m.runners[j.Kind])
+	}
+	m.saveLocked()
+}
+
+func (m *Manager) run(ctx context.Context, j *Job, r Runner) {
+	err := r(ctx, j)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.running--
+	switch {
+	case ctx.Err() != nil:
+		j.setStateLocked(StateCancelled, "Cancelled")
+	case err != nil:
+		j.Error = err.Error()
+		j.setStateLocked(StateFailed, err.Error())
+	default:
+		j.Progress = 100
+		j.setStateLocked(StateDone, "Done")
+	}
+	j.cancel() // Release the context now that the runner is done with it
+	j.cancel = nil
+	log.Printf("[Jobs] %s job %s finished: %s", j.Kind, j.ID, j.State)
+
+	m.pruneLocked()
+	m.saveLocked()
+	m.dispatchLocked()
+}
+
+// pruneLocked forgets the oldest finished jobs beyond the retention limit.
+// Unfinished jobs are always kept.
+func (m *Manager) pruneLocked() {
+	var finished []*Job
+	for _, j := range m.jobs {
+		if j.State.Finished() {
+			finished = append(finished, j)
+		}
+	}
+	if len(finished) <= m.keep {
+		return
+	}
+	sort.Slice(finished, func(a, b int) bool {
+		return finished[a].UpdatedAt.After(finished[b].UpdatedAt)
+	})
+	for _, j := range finished[m.keep:] {
+		delete(m.jobs, j.ID)
+	}
+}
+
+func (m *Manager) saveLocked() {
+	list := make([]*Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, j)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		return list[a].CreatedAt.Before(list[b].CreatedAt)
+	})
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		log.Printf("[Jobs] Failed to marshal jobs: %v", err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		log.Printf("[Jobs] Failed to create jobs dir: %v", err)
+		return
+	}
+	if err := os.WriteFile(m.path, data, 0644); err != nil {
+		log.Printf("[Jobs] Failed to save jobs: %v", err)
+	}
+}
+
+// Checkpoint blocks while the job is paused and returns ctx.Err() once the
+// job has been cancelled. Runners call it between units of work.
+func (j *Job) Checkpoint(ctx context.Context) error {
+	for {
+		j.m.mu.Lock()
+		wait := j.resumed
+		j.m.mu.Unlock()
+
+		if wait == nil {
+			return ctx.Err()
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Report updates the job's progress and status message.
+func (j *Job) Report(percent int, msg string) {
+	j.m.mu.Lock()
+	defer j.m.mu.Unlock()
+
+	j.Progress = percent
+	j.Message = msg
+	j.UpdatedAt = time.Now()
+}
+
+// Param returns a job parameter or "" if unset.
+func (j *Job) Param(name string) string {
+	return j.Params[name]
+}
+
+func (j *Job) setStateLocked(s State, msg string) {
+	j.State = s
+	j.Message = msg
+	j.UpdatedAt = time.Now()
+}
+
+func (j *Job) snapshot() *Job {
+	cp := *j
+	cp.m = nil
+	cp.cancel = nil
+	cp.resumed = nil
+	return &cp
+}
@@ -0,0 +1,214 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitFor polls until the job reaches the wanted state or the test times out.
+func waitFor(t *testing.T, m *Manager, id string, want State) *Job {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		j, err := m.Get(id)
+		if err != nil {
+			t.Fatalf("Get(%s) error = %v", id, err)
+		}
+		if j.State == want {
+			return j
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	j, _ := m.Get(id)
+	t.Fatalf("job %s state = %s, want %s", id, j.State, want)
+	return nil
+}
+
+func TestManager_RunAndDuplicate(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "jobs.json"), 2)
+	release := make(chan struct{})
+	m.Register("work", func(ctx context.Context, job *Job) error {
+		<-release
+		return nil
+	})
+
+	job, err := m.Submit("work", "work:a", "book", nil)
+	if err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+	waitFor(t, m, job.ID, StateRunning)
+
+	dup, err := m.Submit("work", "work:a", "book", nil)
+	if !errors.Is(err, ErrDuplicate) {
+		t.Fatalf("duplicate Submit() error = %v, want ErrDuplicate", err)
+	}
+	if dup.ID != job.ID {
+		t.Errorf("duplicate Submit() returned job %s, want %s", dup.ID, job.ID)
+	}
+
+	close(release)
+	waitFor(t, m, job.ID, StateDone)
+
+	// The key is free again once the first job has finished
+	again, err := m.Submit("work", "work:a", "book", nil)
+	if err != nil {
+		t.Fatalf("Submit() after completion error = %v", err)
+	}
+	// Let it finish so nothing writes to the temp dir after the test
+	waitFor(t, m, again.ID, StateDone)
+}
+
+func TestManager_PauseResumeCancel(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "jobs.json"), 1)
+	steps := make(chan int, 100)
+	m.Register("loop", func(ctx context.Context, job *Job) error {
+		for i := 0; ; i++ {
+			if err := job.Checkpoint(ctx); err != nil {
+				return err
+			}
+			steps <- i
+			time.Sleep(time.Millisecond)
+		}
+	})
+
+	job, _ := m.Submit("loop", "", "book", nil)
+	<-steps
+
+	if err := m.Pause(job.ID); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	waitFor(t, m, job.ID, StatePaused)
+
+	// Drain anything produced before the pause took effect, then make sure
+	// the runner is blocked.
+	time.Sleep(20 * time.Millisecond)
+	for len(steps) > 0 {
+		<-steps
+	}
+	select {
+	case <-steps:
+		t.Fatal("runner made progress while paused")
+	case <-time.After(30 * time.Millisecond):
+	}
+
+	if err := m.Resume(job.ID); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	<-steps
+
+	if err := m.Cancel(job.ID); err != nil {
+		t.Fatalf("Cancel() error = %v", err)
+	}
+	waitFor(t, m, job.ID, StateCancelled)
+}
+
+func TestManager_LoadResumesUnfinished(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.json")
+
+	first := NewManager(path, 1)
+	first.Register("work", func(ctx context.Context, job *Job) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	job, _ := first.Submit("work", "k", "book", map[string]string{"chapterId": "ch_001"})
+	waitFor(t, first, job.ID, StateRunning)
+
+	// Simulate a restart: a fresh manager reads the persisted state.
+	ran := make(chan string, 1)
+	second := NewManager(path, 1)
+	second.Register("work", func(ctx context.Context, job *Job) error {
+		ran <- job.Param("chapterId")
+		return nil
+	})
+	if err := second.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	select {
+	case got := <-ran:
+		if got != "ch_001" {
+			t.Errorf("resumed job param = %q, want ch_001", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("persisted job was not resumed")
+	}
+	waitFor(t, second, job.ID, StateDone)
+}
+
+func TestManager_PrunesFinished(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "jobs.json"), 1)
+	m.keep = 2
+	release := make(chan struct{})
+	m.Register("work", func(ctx context.Context, job *Job) error {
+		if job.Param("block") != "" {
+			<-release
+		}
+		return nil
+	})
+
+	blocked, _ := m.Submit("work", "", "book", map[string]string{"block": "yes"})
+	waitFor(t, m, blocked.ID, StateRunning)
+	var ids []string
+	for i := 0; i < 4; i++ {
+		job, _ := m.Submit("work", "", "book", nil)
+		ids = append(ids, job.ID)
+	}
+	// Queued jobs are never pruned, however many there are
+	if got := len(m.List()); got != 5 {
+		t.Fatalf("List() has %d jobs, want 5", got)
+	}
+
+	close(release)
+	waitFor(t, m, ids[3], StateDone)
+	if got := len(m.List()); got != 2 {
+		t.Errorf("List() has %d jobs after pruning, want 2", got)
+	}
+	if _, err := m.Get(blocked.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("oldest finished job was kept")
+	}
+}
+
+func TestManager_LockBook(t *testing.T) {
+	m := NewManager(filepath.Join(t.TempDir(), "jobs.json"), 1)
+	release := make(chan struct{})
+	m.Register("work", func(ctx context.Context, job *Job) error {
+		<-release
+		return nil
+	})
+
+	job, err := m.Submit("work", "work:a", "a", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.LockBook("a"); !errors.Is(err, ErrBookBusy) {
+		t.Errorf("LockBook() with a job running = %v, want ErrBookBusy", err)
+	}
+	close(release)
+	waitFor(t, m, job.ID, StateDone)
+
+	unlock, err := m.LockBook("a")
+	if err != nil {
+		t.Fatalf("LockBook() error = %v", err)
+	}
+	if _, err := m.Submit("work", "work:a", "a", nil); !errors.Is(err, ErrBookLocked) {
+		t.Errorf("Submit() on a locked book = %v, want ErrBookLocked", err)
+	}
+	if _, err := m.LockBook("a"); !errors.Is(err, ErrBookLocked) {
+		t.Errorf("second LockBook() = %v, want ErrBookLocked", err)
+	}
+	other, err := m.Submit("work", "work:b", "b", nil)
+	if err != nil {
+		t.Fatalf("Submit() on another book = %v", err)
+	}
+	waitFor(t, m, other.ID, StateDone)
+
+	unlock()
+	job, err = m.Submit("work", "work:a", "a", nil)
+	if err != nil {
+		t.Fatalf("Submit() after unlock = %v", err)
+	}
+	waitFor(t, m, job.ID, StateDone)
+}
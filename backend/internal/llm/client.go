@@ -124,6 +124,8 @@ type AnalysisResult struct {
 	Typesetting string `json:"typesetting,omitempty"` // Text with Pinyin annotations for TTS
 	Speaker     string `json:"speaker"`
 	Emotion     string `json:"emotion"`
+
+	Params *config.GenerationParams `json:"params,omitempty"` // Overrides the speaker's generation settings
 }
 
 type Client struct {
@@ -167,10 +169,14 @@ func NewClient(cfg *config.Config) *Client {
 	return client
 }
 
-func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]AnalysisResult, error) {
+// AnalyzeTextStream sends text to the LLM and parses the streamed segments.
+// Cancelling ctx aborts the in-flight request and any pending retries.
+func (c *Client) AnalyzeTextStream(ctx context.Context, text string, onToken func(string)) ([]AnalysisResult, error) {
 	if c.isMock {
 		log.Println("[LLM] Mock Mode Enabled. Returning simulated result.")
-		time.Sleep(1 * time.Second)
+		if err := sleepCtx(ctx, 1*time.Second); err != nil {
+			return nil, err
+		}
 
 		// Simulate tokens
 		mockTokens := []string{"{", "\"segments\": ", "[", "{", "\"text\": ", "\"Mock segment 1\"", ", \"speaker\": ", "\"Narrator\"", ", \"emotion\": ", "\"calm\"", "},", "{", "\"text\": ", "\"Mock dialogue\"", ", \"speaker\": ", "\"Hero\"", ", \"emotion\": ", "\"happy\"", "}]", "}"}
@@ -178,7 +184,9 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 			if onToken != nil {
 				onToken(t)
 			}
-			time.Sleep(50 * time.Millisecond)
+			if err := sleepCtx(ctx, 50*time.Millisecond); err != nil {
+				return nil, err
+			}
 		}
 
 		return []AnalysisResult{
@@ -189,7 +197,7 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 	}
 
 	if c.provider == "gemini" {
-		return c.streamGemini(text, onToken)
+		return c.streamGemini(ctx, text, onToken)
 	}
 
 	// Rate Limiting: Cooldown
@@ -198,7 +206,10 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 	if elapsed < c.minInterval {
 		wait := c.minInterval - elapsed
 		log.Printf("[LLM] Rate Limit Cooldown: Waiting %v...\n", wait)
-		time.Sleep(wait)
+		if err := sleepCtx(ctx, wait); err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
 	}
 	c.mu.Unlock()
 
@@ -226,7 +237,7 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 			Stream:   true,
 		}
 
-		stream, err := c.api.CreateChatCompletionStream(context.Background(), req)
+		stream, err := c.api.CreateChatCompletionStream(ctx, req)
 		if err != nil {
 			var apiErr *openai.APIError
 			if errors.As(err, &apiErr) {
@@ -236,7 +247,9 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 			}
 
 			lastErr = err
-			time.Sleep(1 * time.Second) // Basic backoff
+			if err := sleepCtx(ctx, 1*time.Second); err != nil { // Basic backoff
+				return nil, err
+			}
 			continue
 		}
 
@@ -283,7 +296,9 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 
 		if streamFailed {
 			lastErr = fmt.Errorf("stream interrupted")
-			time.Sleep(1 * time.Second)
+			if err := sleepCtx(ctx, 1*time.Second); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -295,7 +310,9 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 		if err != nil {
 			log.Printf("[LLM] JSON Extraction Error (Attempt %d): %v. Content: %s\n", attempt, err, content)
 			lastErr = fmt.Errorf("failed to extract JSON: %v", err)
-			time.Sleep(1 * time.Second)
+			if err := sleepCtx(ctx, 1*time.Second); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -338,7 +355,9 @@ func (c *Client) AnalyzeTextStream(text string, onToken func(string)) ([]Analysi
 		if parseErr != nil {
 			log.Printf("[LLM] JSON Parse Error (Attempt %d): %v. Content End: ...%s\n", attempt, parseErr, getLastNChars(jsonContent, 100))
 			lastErr = fmt.Errorf("failed to parse LLM JSON: %v. Check log for content", parseErr)
-			time.Sleep(1 * time.Second)
+			if err := sleepCtx(ctx, 1*time.Second); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -382,7 +401,7 @@ func (c *Client) ListModels() ([]string, error) {
 }
 
 // streamGemini handles the native Google Gemini API streaming using GenAI SDK
-func (c *Client) streamGemini(text string, onToken func(string)) ([]AnalysisResult, error) {
+func (c *Client) streamGemini(ctx context.Context, text string, onToken func(string)) ([]AnalysisResult, error) {
 	if c.genaiClient == nil {
 		return nil, fmt.Errorf("gemini client not initialized")
 	}
@@ -391,7 +410,10 @@ func (c *Client) streamGemini(text string, onToken func(string)) ([]AnalysisResu
 	c.mu.Lock()
 	elapsed := time.Since(c.lastRequestTime)
 	if elapsed < c.minInterval {
-		time.Sleep(c.minInterval - elapsed)
+		if err := sleepCtx(ctx, c.minInterval-elapsed); err != nil {
+			c.mu.Unlock()
+			return nil, err
+		}
 	}
 	c.mu.Unlock()
 
@@ -413,8 +435,6 @@ func (c *Client) streamGemini(text string, onToken func(string)) ([]AnalysisResu
 	for attempt := 1; attempt <= maxRetries; attempt++ {
 		log.Printf("[LLM] Sending Gemini SDK Streaming request (Length: %d, Attempt: %d/%d)\n", len(text), attempt, maxRetries)
 
-		ctx := context.Background()
-
 		// Use genai.Text helper to create contents
 		contents := genai.Text(systemPrompt + "\n\n" + text)
 
@@ -445,7 +465,9 @@ func (c *Client) streamGemini(text string, onToken func(string)) ([]AnalysisResu
 
 		if streamFailed {
 			lastErr = fmt.Errorf("stream interrupted")
-			time.Sleep(1 * time.Second)
+			if err := sleepCtx(ctx, 1*time.Second); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -459,7 +481,9 @@ func (c *Client) streamGemini(text string, onToken func(string)) ([]AnalysisResu
 		if err != nil {
 			log.Printf("[LLM] JSON Extraction Error (Attempt %d): %v\n", attempt, err)
 			lastErr = fmt.Errorf("failed to extract JSON: %v", err)
-			time.Sleep(1 * time.Second)
+			if err := sleepCtx(ctx, 1*time.Second); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -481,7 +505,9 @@ func (c *Client) streamGemini(text string, onToken func(string)) ([]AnalysisResu
 			}
 
 			lastErr = fmt.Errorf("failed to parse LLM JSON: %v", parseErr)
-			time.Sleep(1 * time.Second)
+			if err := sleepCtx(ctx, 1*time.Second); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -491,6 +517,18 @@ func (c *Client) streamGemini(text string, onToken func(string)) ([]AnalysisResu
 	return nil, fmt.Errorf("analysis failed after %d attempts. Last error: %v", maxRetries, lastErr)
 }
 
+// sleepCtx sleeps for d or until ctx is cancelled, whichever comes first.
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // extractJSON attempts to find the first '{' and last '}' to extract the valid JSON object
 func extractJSON(s string) (string, error) {
 	start := strings.Index(s, "{")
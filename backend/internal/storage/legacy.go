@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+)
+
+// legacyProject is a project as earlier versions saved it to data/<bookID>.json
+type legacyProject struct {
+	BookID             string
+	Name               string
+	CurrentBookPath    string
+	Chapters           []epub.Chapter
+	Metadata           *epub.Metadata
+	CoverPath          string
+	Analysis           map[string][]llm.AnalysisResult
+	DetectedCharacters map[string]bool
+	VoiceMapping       map[string]VoiceConfig
+}
+
+// ImportJSON imports the projects earlier versions saved as <bookID>.json in
+// dir. Each file is imported in one transaction and then renamed to
+// <bookID>.json.migrated, so it is imported once and kept as a backup. Books
+// already in the store are left as they are. Other JSON files (jobs.json...)
+// are skipped. It returns the number of books imported.
+func ImportJSON(s Store, dir string) (int, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return imported, err
+		}
+		var p legacyProject
+		bookID := strings.TrimSuffix(filepath.Base(file), ".json")
+		if json.Unmarshal(data, &p) != nil || p.BookID != bookID {
+			continue
+		}
+
+		if _, err := s.Load(bookID); err == nil {
+			log.Printf("[Storage] Book %s is already in the database, skipping %s", bookID, file)
+			continue
+		} else if !errors.Is(err, ErrNotFound) {
+			return imported, err
+		}
+
+		if err := s.Update(bookID, p.write); err != nil {
+			return imported, fmt.Errorf("failed to import %s: %v", file, err)
+		}
+		if err := os.Rename(file, file+".migrated"); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func (p *legacyProject) write(tx Tx) error {
+	err := tx.PutBook(Book{
+		Name:      p.Name,
+		Path:      p.CurrentBookPath,
+		Metadata:  p.Metadata,
+		CoverPath: p.CoverPath,
+	})
+	if err != nil {
+		return err
+	}
+	if err := tx.PutChapters(p.Chapters); err != nil {
+		return err
+	}
+	for chapterID, segments := range p.Analysis {
+		if err := tx.PutSegments(chapterID, segments); err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(p.DetectedCharacters))
+	for name, ok := range p.DetectedCharacters {
+		if ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	characters := make([]CharacterProfile, len(names))
+	for i, name := range names {
+		characters[i] = CharacterProfile{Name: name}
+	}
+	if err := tx.PutCharacters(characters); err != nil {
+		return err
+	}
+	return tx.PutVoices(p.VoiceMapping)
+}
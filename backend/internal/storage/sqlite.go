@@ -0,0 +1,591 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+
+	_ "modernc.org/sqlite" // Pure Go driver, no cgo needed for release builds
+)
+
+// migrations upgrade the schema one version at a time. The database's
+// user_version is the number of migrations applied; append new ones, never
+// edit old ones.
+var migrations = []string{
+	`CREATE TABLE books (
+		id         TEXT PRIMARY KEY,
+		name       TEXT NOT NULL DEFAULT '',
+		path       TEXT NOT NULL DEFAULT '',
+		metadata   TEXT,
+		cover_path TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE chapters (
+		book_id  TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		id       TEXT NOT NULL,
+		title    TEXT NOT NULL DEFAULT '',
+		content  TEXT NOT NULL DEFAULT '',
+		matter   TEXT NOT NULL DEFAULT '',
+		hrefs    TEXT,
+		readings TEXT,
+		PRIMARY KEY (book_id, position)
+	);
+	CREATE TABLE segments (
+		book_id     TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+		chapter_id  TEXT NOT NULL,
+		position    INTEGER NOT NULL,
+		text        TEXT NOT NULL DEFAULT '',
+		typesetting TEXT NOT NULL DEFAULT '',
+		speaker     TEXT NOT NULL DEFAULT '',
+		emotion     TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (book_id, chapter_id, position)
+	);
+	CREATE TABLE characters (
+		book_id TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+		name    TEXT NOT NULL,
+		PRIMARY KEY (book_id, name)
+	);
+	CREATE TABLE voice_mappings (
+		book_id         TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+		character       TEXT NOT NULL,
+		voice_id        TEXT NOT NULL DEFAULT '',
+		emotion         TEXT NOT NULL DEFAULT '',
+		use_llm_emotion INTEGER,
+		PRIMARY KEY (book_id, character)
+	);
+	CREATE TABLE artefacts (
+		book_id    TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+		chapter_id TEXT NOT NULL DEFAULT '',
+		kind       TEXT NOT NULL,
+		path       TEXT NOT NULL,
+		info       TEXT NOT NULL DEFAULT '',
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY (book_id, chapter_id, kind)
+	);`,
+	`CREATE TABLE revisions (
+		id         INTEGER PRIMARY KEY AUTOINCREMENT,
+		book_id    TEXT NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+		action     TEXT NOT NULL,
+		summary    TEXT NOT NULL DEFAULT '',
+		chapters   TEXT,
+		before     TEXT NOT NULL,
+		after      TEXT NOT NULL,
+		undone     INTEGER NOT NULL DEFAULT 0,
+		created_at INTEGER NOT NULL
+	);
+	CREATE INDEX revisions_book ON revisions (book_id, id);`,
+	`ALTER TABLE characters ADD COLUMN aliases TEXT;
+	ALTER TABLE characters ADD COLUMN gender TEXT NOT NULL DEFAULT '';
+	ALTER TABLE characters ADD COLUMN age TEXT NOT NULL DEFAULT '';
+	ALTER TABLE characters ADD COLUMN description TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE voice_mappings ADD COLUMN params TEXT;
+	ALTER TABLE segments ADD COLUMN params TEXT;`,
+}
+
+// SQLite stores projects in a single SQLite database file
+type SQLite struct {
+	db *sql.DB
+}
+
+// OpenSQLite opens the database at path, creating it and upgrading its
+// schema as needed
+func OpenSQLite(path string) (*SQLite, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	dsn := "file:" + filepath.ToSlash(path) + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// One connection serialises writers, which SQLite does anyway
+	db.SetMaxOpenConns(1)
+
+	s := &SQLite{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate %s: %v", path, err)
+	}
+	return s, nil
+}
+
+func (s *SQLite) migrate() error {
+	var version int
+	if err := s.db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	for i := version; i < len(migrations); i++ {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		// PRAGMA doesn't take parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQLite) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLite) ListBooks() ([]BookInfo, error) {
+	rows, err := s.db.Query(`
+		SELECT b.id, b.name, b.path, b.metadata, b.cover_path, b.created_at, b.updated_at,
+			(SELECT COUNT(*) FROM chapters c WHERE c.book_id = b.id),
+			(SELECT COUNT(DISTINCT s.chapter_id) FROM segments s
+				JOIN chapters c ON c.book_id = s.book_id AND c.id = s.chapter_id
+				WHERE s.book_id = b.id)
+		FROM books b
+		ORDER BY b.updated_at DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := []BookInfo{}
+	for rows.Next() {
+		var b BookInfo
+		var meta sql.NullString
+		var created, updated int64
+		if err := rows.Scan(&b.ID, &b.Name, &b.Path, &meta, &b.CoverPath, &created, &updated, &b.Chapters, &b.Analyzed); err != nil {
+			return nil, err
+		}
+		if b.Metadata, err = decodeMetadata(meta); err != nil {
+			return nil, fmt.Errorf("book %s: %v", b.ID, err)
+		}
+		b.CreatedAt, b.UpdatedAt = time.UnixMilli(created), time.UnixMilli(updated)
+		books = append(books, b)
+	}
+	return books, rows.Err()
+}
+
+func (s *SQLite) Load(bookID string) (*Project, error) {
+	p := &Project{
+		Analysis: make(map[string][]llm.AnalysisResult),
+		Voices:   make(map[string]VoiceConfig),
+	}
+
+	var meta sql.NullString
+	var created, updated int64
+	err := s.db.QueryRow(`SELECT id, name, path, metadata, cover_path, created_at, updated_at FROM books WHERE id = ?`, bookID).
+		Scan(&p.ID, &p.Name, &p.Path, &meta, &p.CoverPath, &created, &updated)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Metadata, err = decodeMetadata(meta); err != nil {
+		return nil, err
+	}
+	p.CreatedAt, p.UpdatedAt = time.UnixMilli(created), time.UnixMilli(updated)
+
+	if err := s.loadChapters(p); err != nil {
+		return nil, fmt.Errorf("failed to load chapters: %v", err)
+	}
+	if err := s.loadSegments(p); err != nil {
+		return nil, fmt.Errorf("failed to load segments: %v", err)
+	}
+	if err := s.loadCharacters(p); err != nil {
+		return nil, fmt.Errorf("failed to load characters: %v", err)
+	}
+	return p, nil
+}
+
+func (s *SQLite) loadChapters(p *Project) error {
+	rows, err := s.db.Query(`SELECT id, title, content, matter, hrefs, readings FROM chapters WHERE book_id = ? ORDER BY position`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ch epub.Chapter
+		var hrefs, readings sql.NullString
+		if err := rows.Scan(&ch.ID, &ch.Title, &ch.Content, &ch.Matter, &hrefs, &readings); err != nil {
+			return err
+		}
+		if err := decodeJSON(hrefs, &ch.Hrefs); err != nil {
+			return err
+		}
+		if err := decodeJSON(readings, &ch.Readings); err != nil {
+			return err
+		}
+		p.Chapters = append(p.Chapters, ch)
+	}
+	return rows.Err()
+}
+
+func (s *SQLite) loadSegments(p *Project) error {
+	rows, err := s.db.Query(`SELECT chapter_id, text, typesetting, speaker, emotion, params FROM segments WHERE book_id = ? ORDER BY chapter_id, position`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var chapterID string
+		var seg llm.AnalysisResult
+		var params sql.NullString
+		if err := rows.Scan(&chapterID, &seg.Text, &seg.Typesetting, &seg.Speaker, &seg.Emotion, &params); err != nil {
+			return err
+		}
+		if err := decodeJSON(params, &seg.Params); err != nil {
+			return err
+		}
+		p.Analysis[chapterID] = append(p.Analysis[chapterID], seg)
+	}
+	return rows.Err()
+}
+
+func (s *SQLite) loadCharacters(p *Project) error {
+	rows, err := s.db.Query(`SELECT name, aliases, gender, age, description FROM characters WHERE book_id = ? ORDER BY name`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var ch CharacterProfile
+		var aliases sql.NullString
+		if err := rows.Scan(&ch.Name, &aliases, &ch.Gender, &ch.Age, &ch.Description); err != nil {
+			return err
+		}
+		if err := decodeJSON(aliases, &ch.Aliases); err != nil {
+			return err
+		}
+		p.Characters = append(p.Characters, ch)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = s.db.Query(`SELECT character, voice_id, emotion, use_llm_emotion, params FROM voice_mappings WHERE book_id = ?`, p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var v VoiceConfig
+		var useLLM sql.NullBool
+		var params sql.NullString
+		if err := rows.Scan(&name, &v.VoiceID, &v.Emotion, &useLLM, &params); err != nil {
+			return err
+		}
+		if err := decodeJSON(params, &v.Params); err != nil {
+			return err
+		}
+		if useLLM.Valid {
+			v.UseLLMEmotion = &useLLM.Bool
+		}
+		p.Voices[name] = v
+	}
+	return rows.Err()
+}
+
+func (s *SQLite) Update(bookID string, fn func(tx Tx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UnixMilli()
+	if _, err := tx.Exec(`INSERT INTO books (id, created_at, updated_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET updated_at = excluded.updated_at`, bookID, now, now); err != nil {
+		return err
+	}
+	if err := fn(&sqliteTx{tx: tx, bookID: bookID}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLite) Delete(bookID string) error {
+	res, err := s.db.Exec(`DELETE FROM books WHERE id = ?`, bookID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLite) Artefacts(bookID string) ([]Artefact, error) {
+	rows, err := s.db.Query(`SELECT chapter_id, kind, path, info, created_at FROM artefacts WHERE book_id = ? ORDER BY chapter_id, kind`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artefacts := []Artefact{}
+	for rows.Next() {
+		var a Artefact
+		var created int64
+		if err := rows.Scan(&a.ChapterID, &a.Kind, &a.Path, &a.Info, &created); err != nil {
+			return nil, err
+		}
+		a.CreatedAt = time.UnixMilli(created)
+		artefacts = append(artefacts, a)
+	}
+	return artefacts, rows.Err()
+}
+
+func (s *SQLite) Revisions(bookID string) ([]Revision, error) {
+	rows, err := s.db.Query(`SELECT id, action, summary, chapters, undone, created_at FROM revisions WHERE book_id = ? ORDER BY id`, bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []Revision{}
+	for rows.Next() {
+		var r Revision
+		var chapters sql.NullString
+		var created int64
+		if err := rows.Scan(&r.ID, &r.Action, &r.Summary, &chapters, &r.Undone, &created); err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(chapters, &r.Chapters); err != nil {
+			return nil, err
+		}
+		r.CreatedAt = time.UnixMilli(created)
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+func (s *SQLite) Revision(bookID string, id int64) (*Revision, error) {
+	var r Revision
+	var chapters, before, after sql.NullString
+	var created int64
+	err := s.db.QueryRow(`SELECT id, action, summary, chapters, before, after, undone, created_at FROM revisions WHERE book_id = ? AND id = ?`, bookID, id).
+		Scan(&r.ID, &r.Action, &r.Summary, &chapters, &before, &after, &r.Undone, &created)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.Before, r.After = &Snapshot{}, &Snapshot{}
+	for _, f := range []struct {
+		s sql.NullString
+		v any
+	}{{chapters, &r.Chapters}, {before, r.Before}, {after, r.After}} {
+		if err := decodeJSON(f.s, f.v); err != nil {
+			return nil, err
+		}
+	}
+	r.CreatedAt = time.UnixMilli(created)
+	return &r, nil
+}
+
+type sqliteTx struct {
+	tx     *sql.Tx
+	bookID string
+}
+
+func (t *sqliteTx) PutBook(b Book) error {
+	meta, err := encodeJSON(b.Metadata)
+	if err != nil {
+		return err
+	}
+	_, err = t.tx.Exec(`UPDATE books SET name = ?, path = ?, metadata = ?, cover_path = ? WHERE id = ?`,
+		b.Name, b.Path, meta, b.CoverPath, t.bookID)
+	return err
+}
+
+func (t *sqliteTx) PutChapters(chapters []epub.Chapter) error {
+	if _, err := t.tx.Exec(`DELETE FROM chapters WHERE book_id = ?`, t.bookID); err != nil {
+		return err
+	}
+	stmt, err := t.tx.Prepare(`INSERT INTO chapters (book_id, position, id, title, content, matter, hrefs, readings) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, ch := range chapters {
+		hrefs, err := encodeJSON(ch.Hrefs)
+		if err != nil {
+			return err
+		}
+		readings, err := encodeJSON(ch.Readings)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(t.bookID, i, ch.ID, ch.Title, ch.Content, ch.Matter, hrefs, readings); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqliteTx) PutSegments(chapterID string, segments []llm.AnalysisResult) error {
+	if _, err := t.tx.Exec(`DELETE FROM segments WHERE book_id = ? AND chapter_id = ?`, t.bookID, chapterID); err != nil {
+		return err
+	}
+	stmt, err := t.tx.Prepare(`INSERT INTO segments (book_id, chapter_id, position, text, typesetting, speaker, emotion, params) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for i, seg := range segments {
+		params, err := encodeJSON(seg.Params)
+		if err != nil {
+			return err
+		}
+		if _, err := stmt.Exec(t.bookID, chapterID, i, seg.Text, seg.Typesetting, seg.Speaker, seg.Emotion, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqliteTx) PutCharacters(characters []CharacterProfile) error {
+	if _, err := t.tx.Exec(`DELETE FROM characters WHERE book_id = ?`, t.bookID); err != nil {
+		return err
+	}
+	for _, ch := range characters {
+		aliases, err := encodeJSON(ch.Aliases)
+		if err != nil {
+			return err
+		}
+		if _, err := t.tx.Exec(`INSERT OR IGNORE INTO characters (book_id, name, aliases, gender, age, description) VALUES (?, ?, ?, ?, ?, ?)`,
+			t.bookID, ch.Name, aliases, ch.Gender, ch.Age, ch.Description); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqliteTx) PutVoices(voices map[string]VoiceConfig) error {
+	if _, err := t.tx.Exec(`DELETE FROM voice_mappings WHERE book_id = ?`, t.bookID); err != nil {
+		return err
+	}
+	for name, v := range voices {
+		var useLLM sql.NullBool
+		if v.UseLLMEmotion != nil {
+			useLLM = sql.NullBool{Bool: *v.UseLLMEmotion, Valid: true}
+		}
+		params, err := encodeJSON(v.Params)
+		if err != nil {
+			return err
+		}
+		if _, err := t.tx.Exec(`INSERT INTO voice_mappings (book_id, character, voice_id, emotion, use_llm_emotion, params) VALUES (?, ?, ?, ?, ?, ?)`,
+			t.bookID, name, v.VoiceID, v.Emotion, useLLM, params); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *sqliteTx) PutArtefact(a Artefact) error {
+	if a.CreatedAt.IsZero() {
+		a.CreatedAt = time.Now()
+	}
+	_, err := t.tx.Exec(`INSERT INTO artefacts (book_id, chapter_id, kind, path, info, created_at) VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (book_id, chapter_id, kind) DO UPDATE SET path = excluded.path, info = excluded.info, created_at = excluded.created_at`,
+		t.bookID, a.ChapterID, a.Kind, a.Path, a.Info, a.CreatedAt.UnixMilli())
+	return err
+}
+
+func (t *sqliteTx) AddRevision(r *Revision) error {
+	if _, err := t.tx.Exec(`DELETE FROM revisions WHERE book_id = ? AND undone = 1`, t.bookID); err != nil {
+		return err
+	}
+	if r.CreatedAt.IsZero() {
+		r.CreatedAt = time.Now()
+	}
+	chapters, err := encodeJSON(r.Chapters)
+	if err != nil {
+		return err
+	}
+	before, err := json.Marshal(r.Before)
+	if err != nil {
+		return err
+	}
+	after, err := json.Marshal(r.After)
+	if err != nil {
+		return err
+	}
+	res, err := t.tx.Exec(`INSERT INTO revisions (book_id, action, summary, chapters, before, after, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		t.bookID, r.Action, r.Summary, chapters, string(before), string(after), r.CreatedAt.UnixMilli())
+	if err != nil {
+		return err
+	}
+	r.ID, err = res.LastInsertId()
+	return err
+}
+
+func (t *sqliteTx) SetUndone(id int64, undone bool) error {
+	res, err := t.tx.Exec(`UPDATE revisions SET undone = ? WHERE book_id = ? AND id = ?`, undone, t.bookID, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (t *sqliteTx) Clear() error {
+	// Deleting the book cascades to everything stored for it
+	now := time.Now().UnixMilli()
+	if _, err := t.tx.Exec(`DELETE FROM books WHERE id = ?`, t.bookID); err != nil {
+		return err
+	}
+	_, err := t.tx.Exec(`INSERT INTO books (id, created_at, updated_at) VALUES (?, ?, ?)`, t.bookID, now, now)
+	return err
+}
+
+// encodeJSON stores nested values as JSON text; nil and empty slices as NULL
+func encodeJSON(v any) (sql.NullString, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	if s := string(data); s == "null" || s == "[]" || s == "{}" {
+		return sql.NullString{}, nil
+	}
+	return sql.NullString{String: string(data), Valid: true}, nil
+}
+
+func decodeJSON(s sql.NullString, v any) error {
+	if !s.Valid {
+		return nil
+	}
+	return json.Unmarshal([]byte(s.String), v)
+}
+
+func decodeMetadata(s sql.NullString) (*epub.Metadata, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	meta := &epub.Metadata{}
+	if err := json.Unmarshal([]byte(s.String), meta); err != nil {
+		return nil, err
+	}
+	return meta, nil
+}
@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+)
+
+func openTestDB(t *testing.T) *SQLite {
+	t.Helper()
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSQLite() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSQLite_RoundTrip(t *testing.T) {
+	s := openTestDB(t)
+	yes := true
+	temperature, beams := 0.6, 1
+
+	chapters := []epub.Chapter{
+		{ID: "ch2", Title: "第二章", Content: "你好。", Hrefs: []string{"b.xhtml"}},
+		{ID: "ch1", Title: "第一章", Content: "他笑了。", Readings: []epub.Reading{{Text: "笑", Reading: "xiào"}}},
+	}
+	segments := []llm.AnalysisResult{
+		{Text: "他笑了。", Typesetting: "他笑(xiao4)了。", Speaker: "Narrator", Emotion: "calm"},
+		{Text: "你好。", Speaker: "Alice", Emotion: "happy", Params: &config.GenerationParams{NumBeams: &beams}},
+	}
+	characters := []CharacterProfile{
+		{Name: "Narrator"},
+		{Name: "Alice", Aliases: []string{"她(Alice)", "Alicia"}, Gender: "female", Age: "young", Description: "主角"},
+	}
+	voices := map[string]VoiceConfig{"Alice": {VoiceID: "alice.wav", Emotion: "calm", UseLLMEmotion: &yes, Params: &config.GenerationParams{Temperature: &temperature}}}
+
+	err := s.Update("book1", func(tx Tx) error {
+		if err := tx.PutBook(Book{Name: "书", Path: "uploads/book1/a.epub", Metadata: &epub.Metadata{Title: "标题"}}); err != nil {
+			return err
+		}
+		if err := tx.PutChapters(chapters); err != nil {
+			return err
+		}
+		if err := tx.PutSegments("ch1", segments); err != nil {
+			return err
+		}
+		if err := tx.PutCharacters(characters); err != nil {
+			return err
+		}
+		return tx.PutVoices(voices)
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	p, err := s.Load("book1")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if p.Name != "书" || p.Path != "uploads/book1/a.epub" || p.Metadata == nil || p.Metadata.Title != "标题" {
+		t.Errorf("book = %+v", p.Book)
+	}
+	if !reflect.DeepEqual(p.Chapters, chapters) {
+		t.Errorf("chapters = %+v, want %+v", p.Chapters, chapters)
+	}
+	if !reflect.DeepEqual(p.Analysis["ch1"], segments) {
+		t.Errorf("segments = %+v, want %+v", p.Analysis["ch1"], segments)
+	}
+	if !reflect.DeepEqual(p.Characters, []CharacterProfile{characters[1], characters[0]}) {
+		t.Errorf("characters = %+v", p.Characters)
+	}
+	if !reflect.DeepEqual(p.Voices, voices) {
+		t.Errorf("voices = %+v, want %+v", p.Voices, voices)
+	}
+
+	books, err := s.ListBooks()
+	if err != nil {
+		t.Fatalf("ListBooks() error = %v", err)
+	}
+	if len(books) != 1 || books[0].Chapters != 2 || books[0].Analyzed != 1 {
+		t.Errorf("ListBooks() = %+v", books)
+	}
+
+	if _, err := s.Load("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Load(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSQLite_UpdateIsAtomic(t *testing.T) {
+	s := openTestDB(t)
+	seg := []llm.AnalysisResult{{Text: "原文", Speaker: "Narrator"}}
+	if err := s.Update("book1", func(tx Tx) error { return tx.PutSegments("ch1", seg) }); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	// A failing update leaves the previous segments in place
+	failed := errors.New("failed")
+	err := s.Update("book1", func(tx Tx) error {
+		if err := tx.PutSegments("ch1", []llm.AnalysisResult{{Text: "改了"}}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Update() error = %v, want %v", err, failed)
+	}
+
+	p, err := s.Load("book1")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !reflect.DeepEqual(p.Analysis["ch1"], seg) {
+		t.Errorf("segments after failed update = %+v, want %+v", p.Analysis["ch1"], seg)
+	}
+}
+
+func TestSQLite_DeleteAndArtefacts(t *testing.T) {
+	s := openTestDB(t)
+	err := s.Update("book1", func(tx Tx) error {
+		if err := tx.PutArtefact(Artefact{ChapterID: "ch1", Kind: ArtefactAudio, Path: "a.wav", Info: "wav"}); err != nil {
+			return err
+		}
+		// Same chapter and kind replaces the earlier file
+		return tx.PutArtefact(Artefact{ChapterID: "ch1", Kind: ArtefactAudio, Path: "a.mp3", Info: "mp3@128k"})
+	})
+	if err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	artefacts, err := s.Artefacts("book1")
+	if err != nil {
+		t.Fatalf("Artefacts() error = %v", err)
+	}
+	if len(artefacts) != 1 || artefacts[0].Path != "a.mp3" {
+		t.Errorf("Artefacts() = %+v", artefacts)
+	}
+
+	// Clearing drops what was stored, unless the transaction fails
+	s.Update("book1", func(tx Tx) error {
+		if err := tx.Clear(); err != nil {
+			return err
+		}
+		return errors.New("abort")
+	})
+	if artefacts, _ := s.Artefacts("book1"); len(artefacts) != 1 {
+		t.Errorf("artefacts after aborted Clear() = %+v", artefacts)
+	}
+	if err := s.Update("book1", func(tx Tx) error { return tx.Clear() }); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if artefacts, _ := s.Artefacts("book1"); len(artefacts) != 0 {
+		t.Errorf("artefacts left after Clear(): %+v", artefacts)
+	}
+
+	if err := s.Delete("book1"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if artefacts, _ := s.Artefacts("book1"); len(artefacts) != 0 {
+		t.Errorf("artefacts left after Delete: %+v", artefacts)
+	}
+	if err := s.Delete("book1"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("second Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestImportJSON(t *testing.T) {
+	s := openTestDB(t)
+	dir := t.TempDir()
+
+	legacy := legacyProject{
+		BookID:             "abc123",
+		CurrentBookPath:    "uploads/a.epub",
+		Chapters:           []epub.Chapter{{ID: "ch1", Title: "一", Content: "你好。"}},
+		Analysis:           map[string][]llm.AnalysisResult{"ch1": {{Text: "你好。", Speaker: "Alice"}}},
+		DetectedCharacters: map[string]bool{"Alice": true},
+		VoiceMapping:       map[string]VoiceConfig{"Alice": {VoiceID: "alice.wav"}},
+	}
+	data, _ := json.Marshal(legacy)
+	os.WriteFile(filepath.Join(dir, "abc123.json"), data, 0644)
+	os.WriteFile(filepath.Join(dir, "jobs.json"), []byte(`{"jobs":[]}`), 0644)
+
+	n, err := ImportJSON(s, dir)
+	if err != nil || n != 1 {
+		t.Fatalf("ImportJSON() = %d, %v; want 1", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abc123.json.migrated")); err != nil {
+		t.Errorf("imported file not renamed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "jobs.json")); err != nil {
+		t.Errorf("non-project file touched: %v", err)
+	}
+
+	p, err := s.Load("abc123")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if p.Path != "uploads/a.epub" || len(p.Chapters) != 1 || len(p.Analysis["ch1"]) != 1 ||
+		!reflect.DeepEqual(p.Characters, []CharacterProfile{{Name: "Alice"}}) || p.Voices["Alice"].VoiceID != "alice.wav" {
+		t.Errorf("imported project = %+v", p)
+	}
+
+	// Importing again finds nothing left to import
+	if n, err := ImportJSON(s, dir); err != nil || n != 0 {
+		t.Errorf("second ImportJSON() = %d, %v; want 0", n, err)
+	}
+}
@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/epub"
+	"tts-book/backend/internal/llm"
+)
+
+var ErrNotFound = errors.New("book not found")
+
+// Store persists book projects. Every change goes through Update, which
+// applies it in one transaction: a crash either keeps the change or the
+// previous state, never half of it.
+type Store interface {
+	// ListBooks returns every stored book, most recently changed first
+	ListBooks() ([]BookInfo, error)
+	// Load returns a whole project, or ErrNotFound
+	Load(bookID string) (*Project, error)
+	// Update runs fn in a transaction on the book, creating the book if it
+	// doesn't exist yet. If fn returns an error nothing is written.
+	Update(bookID string, fn func(tx Tx) error) error
+	// Delete removes a book and everything stored for it
+	Delete(bookID string) error
+	// Artefacts returns the files generated for a book
+	Artefacts(bookID string) ([]Artefact, error)
+	// Revisions returns a book's edit history, oldest first, without the
+	// Before and After snapshots
+	Revisions(bookID string) ([]Revision, error)
+	// Revision returns one revision with its snapshots, or ErrNotFound
+	Revision(bookID string, id int64) (*Revision, error)
+	Close() error
+}
+
+// Tx writes parts of a project inside Store.Update. Each Put replaces what
+// was stored before, so callers only write the parts they changed.
+type Tx interface {
+	PutBook(b Book) error
+	PutChapters(chapters []epub.Chapter) error
+	PutSegments(chapterID string, segments []llm.AnalysisResult) error
+	PutCharacters(characters []CharacterProfile) error
+	PutVoices(voices map[string]VoiceConfig) error
+	PutArtefact(a Artefact) error
+	// AddRevision records an edit, setting r.ID. Undone revisions are
+	// dropped: once something new is edited they can't be redone.
+	AddRevision(r *Revision) error
+	// SetUndone marks a revision as undone or redone
+	SetUndone(id int64, undone bool) error
+	// Clear removes everything stored for the book, history included, so
+	// the rest of the transaction replaces it whole
+	Clear() error
+}
+
+// Book is the book's own record: the file it was imported from and the
+// details read from it
+type Book struct {
+	ID        string         `json:"bookId"`
+	Name      string         `json:"name"` // Name the book is listed under; empty means its title
+	Path      string         `json:"bookPath"`
+	Metadata  *epub.Metadata `json:"metadata"`
+	CoverPath string         `json:"coverPath"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// BookInfo is a book with counts for the library listing
+type BookInfo struct {
+	Book
+	Chapters int `json:"chapters"`
+	Analyzed int `json:"analyzed"` // Chapters with at least one segment
+}
+
+// Project is everything stored for a book
+type Project struct {
+	Book
+	Chapters   []epub.Chapter
+	Analysis   map[string][]llm.AnalysisResult // ChapterID -> segments
+	Characters []CharacterProfile
+	Voices     map[string]VoiceConfig // Character name -> voice
+}
+
+// CharacterProfile describes a character. Aliases are other names the
+// analysis used for the same character; new results naming an alias are
+// credited to Name.
+type CharacterProfile struct {
+	Name        string   `json:"name"`
+	Aliases     []string `json:"aliases,omitempty"`
+	Gender      string   `json:"gender,omitempty"` // "male", "female" or empty for unknown
+	Age         string   `json:"age,omitempty"`    // "child", "teen", "young", "adult", "middle-aged", "elderly" or empty
+	Description string   `json:"description,omitempty"`
+}
+
+type VoiceConfig struct {
+	VoiceID       string `json:"voiceId"`
+	Emotion       string `json:"emotion"`       // Default emotion
+	UseLLMEmotion *bool  `json:"useLLMEmotion"` // If true or nil, use emotion from LLM analysis; if false, use default emotion
+
+	Params *config.GenerationParams `json:"params,omitempty"` // Overrides the project's generation settings for the character
+}
+
+// Artefact is a file generated for a book: chapter audio and timings, or a
+// whole-book export. There is one of each kind per chapter.
+type Artefact struct {
+	ChapterID string    `json:"chapterId,omitempty"` // Empty for whole-book exports
+	Kind      string    `json:"kind"`
+	Path      string    `json:"path"`
+	Info      string    `json:"info,omitempty"` // E.g. the encoding of chapter audio
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Artefact kinds
+const (
+	ArtefactAudio   = "audio"
+	ArtefactTimings = "timings"
+	ArtefactM4B     = "m4b"
+	ArtefactEPUB    = "epub"
+)
+
+// Revision records one edit of a project. Before and After hold the parts
+// the edit changed, as they were before and after it.
+type Revision struct {
+	ID        int64     `json:"id"`
+	Action    string    `json:"action"` // E.g. "merge-characters", "edit-segments", "analyze"
+	Summary   string    `json:"summary"`
+	Chapters  []string  `json:"chapters,omitempty"` // Chapters whose segments changed
+	Before    *Snapshot `json:"before,omitempty"`
+	After     *Snapshot `json:"after,omitempty"`
+	Undone    bool      `json:"undone"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Snapshot holds some of the editable parts of a project
+type Snapshot struct {
+	Segments   map[string][]llm.AnalysisResult `json:"segments,omitempty"`   // ChapterID -> segments; nil means not analyzed
+	Characters map[string]bool                 `json:"characters,omitempty"` // Name -> detected
+	Voices     map[string]*VoiceConfig         `json:"voices,omitempty"`     // Name -> voice; nil means none
+	Profiles   map[string]*CharacterProfile    `json:"profiles,omitempty"`   // Name -> profile; nil means none
+}
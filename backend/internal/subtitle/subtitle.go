@@ -0,0 +1,118 @@
+// Package subtitle renders timed text as SRT, WebVTT or LRC.
+package subtitle
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// Cue is one timed line of text
+type Cue struct {
+	Start   time.Duration
+	End     time.Duration
+	Speaker string // Optional label
+	Text    string
+}
+
+// Format describes an output format
+type Format struct {
+	Name  string
+	Ext   string
+	MIME  string
+	write func(w io.Writer, cues []Cue) error
+}
+
+var formats = map[string]Format{
+	"srt": {Name: "srt", Ext: ".srt", MIME: "application/x-subrip", write: WriteSRT},
+	"vtt": {Name: "vtt", Ext: ".vtt", MIME: "text/vtt", write: WriteVTT},
+	"lrc": {Name: "lrc", Ext: ".lrc", MIME: "text/plain", write: WriteLRC},
+}
+
+// LookupFormat returns the format with the given name ("srt", "vtt" or "lrc")
+func LookupFormat(name string) (Format, error) {
+	f, ok := formats[strings.ToLower(name)]
+	if !ok {
+		return Format{}, fmt.Errorf("unsupported subtitle format %q", name)
+	}
+	return f, nil
+}
+
+// Write renders cues in format f
+func (f Format) Write(w io.Writer, cues []Cue) error {
+	return f.write(w, cues)
+}
+
+// WriteSRT renders cues as SubRip, prefixing speaker labels as "Speaker: text".
+func WriteSRT(w io.Writer, cues []Cue) error {
+	for i, c := range cues {
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n", i+1,
+			clock(c.Start, ","), clock(c.End, ","), labelled(c))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteVTT renders cues as WebVTT, using voice spans for speaker labels.
+func WriteVTT(w io.Writer, cues []Cue) error {
+	if _, err := io.WriteString(w, "WEBVTT\n\n"); err != nil {
+		return err
+	}
+	for _, c := range cues {
+		text := escapeVTT(oneLine(c.Text))
+		if c.Speaker != "" {
+			text = fmt.Sprintf("<v %s>%s", escapeVTT(c.Speaker), text)
+		}
+		if _, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n", clock(c.Start, "."), clock(c.End, "."), text); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLRC renders cues as LRC lyrics. LRC has no end times, so a blank line
+// is emitted wherever a gap follows a cue.
+func WriteLRC(w io.Writer, cues []Cue) error {
+	for i, c := range cues {
+		if _, err := fmt.Fprintf(w, "[%s]%s\n", lrcTime(c.Start), labelled(c)); err != nil {
+			return err
+		}
+		if i+1 == len(cues) || cues[i+1].Start > c.End {
+			if _, err := fmt.Fprintf(w, "[%s]\n", lrcTime(c.End)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func labelled(c Cue) string {
+	if c.Speaker == "" {
+		return oneLine(c.Text)
+	}
+	return c.Speaker + ": " + oneLine(c.Text)
+}
+
+// oneLine joins the lines of a segment so each cue is a single line
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func escapeVTT(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// clock formats d as HH:MM:SS<sep>mmm
+func clock(d time.Duration, sep string) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// lrcTime formats d as mm:ss.xx; minutes keep counting past an hour
+func lrcTime(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%02d:%02d.%02d", cs/6000, cs/100%60, cs%100)
+}
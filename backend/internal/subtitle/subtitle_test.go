@@ -0,0 +1,47 @@
+package subtitle
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+var testCues = []Cue{
+	{Start: 0, End: 1500 * time.Millisecond, Text: "他笑了。"},
+	{Start: 1900 * time.Millisecond, End: 61*time.Minute + 2*time.Second, Speaker: "Alice", Text: "“你好\n<朋友>！”"},
+}
+
+func TestWriteSRT(t *testing.T) {
+	var sb strings.Builder
+	if err := WriteSRT(&sb, testCues); err != nil {
+		t.Fatal(err)
+	}
+	want := "1\n00:00:00,000 --> 00:00:01,500\n他笑了。\n\n" +
+		"2\n00:00:01,900 --> 01:01:02,000\nAlice: “你好 <朋友>！”\n\n"
+	if sb.String() != want {
+		t.Errorf("WriteSRT() =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestWriteVTT(t *testing.T) {
+	var sb strings.Builder
+	if err := WriteVTT(&sb, testCues); err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n\n00:00:00.000 --> 00:00:01.500\n他笑了。\n\n" +
+		"00:00:01.900 --> 01:01:02.000\n<v Alice>“你好 &lt;朋友&gt;！”\n\n"
+	if sb.String() != want {
+		t.Errorf("WriteVTT() =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestWriteLRC(t *testing.T) {
+	var sb strings.Builder
+	if err := WriteLRC(&sb, testCues); err != nil {
+		t.Fatal(err)
+	}
+	want := "[00:00.00]他笑了。\n[00:01.50]\n[00:01.90]Alice: “你好 <朋友>！”\n[61:02.00]\n"
+	if sb.String() != want {
+		t.Errorf("WriteLRC() =\n%s\nwant\n%s", sb.String(), want)
+	}
+}
@@ -0,0 +1,108 @@
+package tts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// SegmentCache stores generated segment audio on disk under a content key,
+// so re-generating a chapter only synthesises segments whose inputs changed.
+type SegmentCache struct {
+	dir string
+}
+
+func NewSegmentCache(dir string) *SegmentCache {
+	return &SegmentCache{dir: dir}
+}
+
+// Path returns where the audio for key is (or would be) stored.
+func (s *SegmentCache) Path(key string) string {
+	return filepath.Join(s.dir, key[:2], key+".wav")
+}
+
+// Has reports whether audio for key is cached.
+func (s *SegmentCache) Has(key string) bool {
+	info, err := os.Stat(s.Path(key))
+	return err == nil && info.Size() > 0
+}
+
+// Put stores audio for key. The file is written to a temp name and renamed
+// so a crash never leaves a truncated entry behind.
+func (s *SegmentCache) Put(key string, data []byte) (string, error) {
+	path := s.Path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return path, nil
+}
+
+// CacheKey returns the cache key for a segment: a hash of the sanitised
+// text, the reference voice's content, the emotion vector and every
+// generation parameter sent to Index-TTS.
+func (c *Client) CacheKey(req Request) (string, error) {
+	ref, err := voiceRef(c.resolveVoice(req.Voice))
+	if err != nil {
+		return "", err
+	}
+
+	args, err := json.Marshal(c.buildArgs(c.sanitizeText(req.Text), ref, req.Emotion, req.Params))
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(args)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type fileHashEntry struct {
+	size    int64
+	modTime int64
+	hash    string
+}
+
+// fileHashes memoises file hashes by path, invalidated on size/mtime change,
+// so voice files are not re-read for every segment.
+var fileHashes sync.Map // path -> fileHashEntry
+
+// hashFile returns the hex SHA-256 of a file's content.
+func hashFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if v, ok := fileHashes.Load(path); ok {
+		e := v.(fileHashEntry)
+		if e.size == info.Size() && e.modTime == info.ModTime().UnixNano() {
+			return e.hash, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	fileHashes.Store(path, fileHashEntry{size: info.Size(), modTime: info.ModTime().UnixNano(), hash: sum})
+	return sum, nil
+}
@@ -0,0 +1,76 @@
+package tts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"tts-book/backend/internal/config"
+)
+
+func TestClient_CacheKey(t *testing.T) {
+	dir := t.TempDir()
+	voice := filepath.Join(dir, "voice.wav")
+	if err := os.WriteFile(voice, []byte("voice-a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := NewClient(&config.Config{})
+
+	base, err := client.CacheKey(Request{Text: "今晩好", Voice: voice, Emotion: "happy"})
+	if err != nil {
+		t.Fatalf("CacheKey() error = %v", err)
+	}
+
+	// Sanitisation happens before hashing, so variant forms share a key
+	same, _ := client.CacheKey(Request{Text: "今晚好", Voice: voice, Emotion: "happy"})
+	if same != base {
+		t.Errorf("sanitised text produced a different key")
+	}
+
+	otherEmotion, _ := client.CacheKey(Request{Text: "今晩好", Voice: voice, Emotion: "sad"})
+	if otherEmotion == base {
+		t.Errorf("changing emotion did not change the key")
+	}
+
+	// Spelling out the defaults changes nothing; other settings do
+	defaults, _ := client.CacheKey(Request{Text: "今晩好", Voice: voice, Emotion: "happy", Params: config.DefaultGenerationParams()})
+	if defaults != base {
+		t.Errorf("explicit default params produced a different key")
+	}
+	beams := 1
+	otherParams, _ := client.CacheKey(Request{Text: "今晩好", Voice: voice, Emotion: "happy", Params: config.GenerationParams{NumBeams: &beams}})
+	if otherParams == base {
+		t.Errorf("changing num_beams did not change the key")
+	}
+
+	// Replacing the voice file content must invalidate the key
+	time.Sleep(10 * time.Millisecond)
+	if err := os.WriteFile(voice, []byte("voice-b-longer"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	otherVoice, _ := client.CacheKey(Request{Text: "今晩好", Voice: voice, Emotion: "happy"})
+	if otherVoice == base {
+		t.Errorf("changing voice content did not change the key")
+	}
+}
+
+func TestSegmentCache_PutHas(t *testing.T) {
+	cache := NewSegmentCache(t.TempDir())
+	key := "abcdef0123456789"
+
+	if cache.Has(key) {
+		t.Fatal("empty cache reports a hit")
+	}
+
+	path, err := cache.Put(key, []byte("RIFF"))
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if path != cache.Path(key) {
+		t.Errorf("Put() path = %s, want %s", path, cache.Path(key))
+	}
+	if !cache.Has(key) {
+		t.Error("cache miss after Put()")
+	}
+}
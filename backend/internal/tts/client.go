@@ -1,11 +1,15 @@
 package tts
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"strings"
+	"time"
 
 	"tts-book/backend/internal/config"
 
@@ -21,6 +25,7 @@ var variantMap = map[rune]rune{
 	'晩': '晚', // U+6669 -> U+665A
 }
 
+// Client is the Index-TTS2 engine, driven through its Gradio gen_single API.
 type Client struct {
 	client *resty.Client
 	url    string
@@ -28,9 +33,13 @@ type Client struct {
 }
 
 func NewClient(cfg *config.Config) *Client {
+	return newClientForURL(cfg, cfg.IndexTTSUrl)
+}
+
+func newClientForURL(cfg *config.Config, url string) *Client {
 	return &Client{
 		client: resty.New(),
-		url:    cfg.IndexTTSUrl,
+		url:    strings.TrimRight(url, "/"),
 		cfg:    cfg,
 	}
 }
@@ -59,85 +68,115 @@ func (c *Client) sanitizeText(text string) string {
 	return b.String()
 }
 
+// Capabilities reports Index-TTS2's support for reference audio and emotion vectors.
+func (c *Client) Capabilities() Capabilities {
+	return Capabilities{Engine: EngineIndexTTS, VoiceCloning: true, Emotions: Emotions}
+}
+
+// Health checks that the Gradio app is reachable.
+func (c *Client) Health(ctx context.Context) error {
+	resp, err := c.client.R().SetContext(ctx).Get(c.url + "/gradio_api/info")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("health check failed: %s", resp.Status())
+	}
+	return nil
+}
+
 // Generate calls the Index-TTS API using the Gradio Event Protocol.
-func (c *Client) Generate(text, voice, emotion string) ([]byte, error) {
+// Cancelling ctx aborts any in-flight upload, event stream or download.
+func (c *Client) Generate(ctx context.Context, req Request) ([]byte, error) {
 	// 0. Sanitize Text
-	text = c.sanitizeText(text)
+	text := c.sanitizeText(req.Text)
+	emotion := req.Emotion
 
 	// 1. Prepare Arguments
-	if voice == "" {
-		// Try to find a default voice in the configured voice directory
-		voiceDir := c.cfg.VoiceDir
-		if voiceDir == "" {
-			voiceDir = "voices"
-		}
-		// Construct the path to a default voice, e.g., voice_06.wav if it exists, or just any wav
-		defaultVoice := "voice_06.wav" // Or pick the first available
-		voice = fmt.Sprintf("%s/%s", voiceDir, defaultVoice)
-		// Check if it exists? We let the upload logic handle it or fail gracefully.
-	}
-
-	// 1.5 Upload voice if it's a local file
-	if _, err := os.Stat(voice); err == nil {
-		fmt.Printf("[TTS] Voice file found locally: %s. Uploading...\n", voice)
-		remotePath, err := c.uploadVoice(voice)
-		if err != nil {
-			return nil, fmt.Errorf("failed to upload voice file: %v", err)
-		}
-		fmt.Printf("[TTS] Uploaded voice. Remote path: %s\n", remotePath)
-		voice = remotePath
-	} else {
+	voice := c.resolveVoice(req.Voice)
+
+	// 1.5 Upload voice if it's a local file, or reuse an earlier upload
+	info, err := os.Stat(voice)
+	if err != nil {
 		fmt.Printf("[TTS] Voice file not found locally or error: %v. Using as is: %s\n", err, voice)
+		return c.synthesize(ctx, text, voice, emotion, req.Params)
 	}
-
-	// Emotion mapping to vectors
-	emotions := map[string]int{
-		"happy": 0, "angry": 1, "sad": 2, "afraid": 3,
-		"disgusted": 4, "melancholic": 5, "surprised": 6, "calm": 7,
+	hash, err := hashFile(voice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash voice file: %v", err)
 	}
-	vecs := make([]float64, 8)
-	if idx, ok := emotions[emotion]; ok {
-		vecs[idx] = 1.0
-	} else {
-		vecs[7] = 1.0 // Default Calm
+	key := uploadKey{server: c.url, hash: hash}
+	remotePath, cached, err := c.remoteVoice(ctx, key, voice, info.Size())
+	if err != nil {
+		return nil, err
 	}
 
-	// Determine control method
-	controlMethod := "Same as the voice reference"
-	if emotion != "" {
-		controlMethod = "Use emotion vectors"
+	audio, err := c.synthesize(ctx, text, remotePath, emotion, req.Params)
+	var serr *serverError
+	if cached && errors.As(err, &serr) && serr.mayBeMissingFile() {
+		// Gradio may have cleaned up the temp file; upload it again and retry once
+		log.Printf("[TTS] Server rejected cached voice %s (%v). Uploading again...", remotePath, err)
+		uploads.reject(key, remotePath)
+		if remotePath, _, err = c.remoteVoice(ctx, key, voice, info.Size()); err != nil {
+			return nil, err
+		}
+		return c.synthesize(ctx, text, remotePath, emotion, req.Params)
 	}
+	return audio, err
+}
 
-	fmt.Printf("Emo control mode:%s,vec:%v\n", controlMethod, vecs)
-
-	// Create FileData object for voice and emo_ref
-	fileObj := map[string]interface{}{
-		"path": voice,
-		"meta": map[string]string{"_type": "gradio.FileData"},
+// remoteVoice returns the server path of a local voice file, uploading it
+// unless the upload cache has it.
+func (c *Client) remoteVoice(ctx context.Context, key uploadKey, voice string, size int64) (string, bool, error) {
+	ttl := time.Duration(c.cfg.TTSUploadTTL) * time.Minute
+	if ttl <= 0 {
+		ttl = DefaultUploadTTL
 	}
+	remotePath, cached, err := uploads.get(ctx, key, size, ttl, func() (string, error) {
+		fmt.Printf("[TTS] Uploading voice file %s...\n", voice)
+		return c.uploadVoice(ctx, voice)
+	})
+	if err != nil {
+		return "", false, fmt.Errorf("failed to upload voice file: %v", err)
+	}
+	if !cached {
+		fmt.Printf("[TTS] Uploaded voice. Remote path: %s\n", remotePath)
+	}
+	return remotePath, cached, nil
+}
 
-	// Construct data array (24 arguments)
-	data := []interface{}{
-		controlMethod,                      // [0]
-		fileObj,                            // [1] prompt (FileData object)
-		text,                               // [2] text
-		fileObj,                            // [3] emo_ref_path (Using same as voice)
-		0.3,                                // [4] emo_weight
-		vecs[0], vecs[1], vecs[2], vecs[3], // [5-8]
-		vecs[4], vecs[5], vecs[6], vecs[7], // [9-12]
-		"",    // [13] emo_text
-		false, // [14] emo_random
-		400,   // [15] max_text_tokens
-		true,  // [16] do_sample
-		0.8,   // [17] top_p
-		30,    // [18] top_k
-		0.8,   // [19] temperature
-		0,     // [20] length_penalty
-		3,     // [21] num_beams
-		10,    // [22] repetition_penalty
-		1500,  // [23] max_mel_tokens
+// serverError is a failure Index-TTS reported, as opposed to one reaching it
+type serverError struct {
+	msg    string
+	reason string // What the server said, if anything
+}
+
+func (e *serverError) Error() string {
+	return e.msg
+}
+
+// mayBeMissingFile reports whether the server may have failed because a
+// file it was given isn't there, as when Gradio has cleaned up an uploaded
+// voice. Gradio only says why when the app shows errors, which the
+// Index-TTS WebUI doesn't, so a failure with no reason may be one too.
+func (e *serverError) mayBeMissingFile() bool {
+	reason := strings.ToLower(strings.TrimSpace(e.reason))
+	if reason == "" || reason == "null" {
+		return true
 	}
+	for _, s := range []string{"no such file", "filenotfounderror", "file not found"} {
+		if strings.Contains(reason, s) {
+			return true
+		}
+	}
+	return false
+}
 
+// synthesize runs gen_single with voice, a path on the server or a name
+// the server knows, and downloads the result.
+func (c *Client) synthesize(ctx context.Context, text, voice, emotion string, params config.GenerationParams) ([]byte, error) {
+	data := c.buildArgs(text, voice, emotion, params)
+	fmt.Printf("Emo control mode:%s,vec:%v\n", data[0], emotionVector(emotion))
 	payload := map[string]interface{}{"data": data}
 
 	// Step 1: POST to get Event ID
@@ -147,6 +186,7 @@ func (c *Client) Generate(text, voice, emotion string) ([]byte, error) {
 	}
 
 	resp, err := c.client.R().
+		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
 		SetBody(payload).
 		SetResult(&initResult).
@@ -156,7 +196,7 @@ func (c *Client) Generate(text, voice, emotion string) ([]byte, error) {
 		return nil, fmt.Errorf("POST failed: %v", err)
 	}
 	if resp.IsError() {
-		return nil, fmt.Errorf("POST API error: %s - Body: %s", resp.Status(), resp.String())
+		return nil, &serverError{fmt.Sprintf("POST API error: %s - Body: %s", resp.Status(), resp.String()), resp.String()}
 	}
 	if initResult.EventID == "" {
 		return nil, fmt.Errorf("no event_id returned")
@@ -164,25 +204,36 @@ func (c *Client) Generate(text, voice, emotion string) ([]byte, error) {
 
 	// Step 2: GET to read stream
 	eventURL := fmt.Sprintf("%s/gradio_api/call/gen_single/%s", c.url, initResult.EventID)
-	streamResp, err := c.client.R().SetDoNotParseResponse(true).Get(eventURL)
+	streamResp, err := c.client.R().SetContext(ctx).SetDoNotParseResponse(true).Get(eventURL)
 	if err != nil {
 		return nil, fmt.Errorf("GET stream failed: %v", err)
 	}
 	defer streamResp.RawBody().Close()
 
-	bodyBytes, _ := io.ReadAll(streamResp.RawBody())
+	bodyBytes, err := io.ReadAll(streamResp.RawBody())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read event stream: %v", err)
+	}
 	bodyString := string(bodyBytes)
 
 	// Gradio Event Protocol sends several "event: ..." and "data: ..." blocks.
 	// We are looking for "event: complete" or the last "data: " line.
 	lines := strings.Split(bodyString, "\n")
-	var lastDataLine string
+	var lastEvent, lastDataLine string
 	for _, line := range lines {
+		if strings.HasPrefix(line, "event: ") {
+			lastEvent = strings.TrimPrefix(line, "event: ")
+		}
 		if strings.HasPrefix(line, "data: ") {
 			lastDataLine = strings.TrimPrefix(line, "data: ")
 		}
 	}
 
+	// The message is null unless the app shows errors
+	if lastEvent == "error" {
+		return nil, &serverError{fmt.Sprintf("generation failed on the server: %s", lastDataLine), lastDataLine}
+	}
+
 	if lastDataLine == "" {
 		return nil, fmt.Errorf("no data found in stream response")
 	}
@@ -225,7 +276,7 @@ func (c *Client) Generate(text, voice, emotion string) ([]byte, error) {
 		fileURL = fmt.Sprintf("%s/file=%s", c.url, resultFile)
 	}
 
-	audioResp, err := c.client.R().Get(fileURL)
+	audioResp, err := c.client.R().SetContext(ctx).Get(fileURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to download audio from %s: %v", fileURL, err)
 	}
@@ -238,10 +289,84 @@ func (c *Client) Generate(text, voice, emotion string) ([]byte, error) {
 	return audioData, nil
 }
 
-func (c *Client) uploadVoice(filePath string) (string, error) {
+// resolveVoice falls back to a default voice in the configured voice
+// directory when no voice is mapped.
+func (c *Client) resolveVoice(voice string) string {
+	if voice != "" {
+		return voice
+	}
+	// Try to find a default voice in the configured voice directory
+	voiceDir := c.cfg.VoiceDir
+	if voiceDir == "" {
+		voiceDir = "voices"
+	}
+	// Construct the path to a default voice, e.g., voice_06.wav if it exists, or just any wav
+	defaultVoice := "voice_06.wav" // Or pick the first available
+	// Check if it exists? We let the upload logic handle it or fail gracefully.
+	return fmt.Sprintf("%s/%s", voiceDir, defaultVoice)
+}
+
+// emotionVector maps an emotion name to Index-TTS2's 8-dimensional emotion vector.
+func emotionVector(emotion string) []float64 {
+	emotions := map[string]int{
+		"happy": 0, "angry": 1, "sad": 2, "afraid": 3,
+		"disgusted": 4, "melancholic": 5, "surprised": 6, "calm": 7,
+	}
+	vecs := make([]float64, 8)
+	if idx, ok := emotions[emotion]; ok {
+		vecs[idx] = 1.0
+	} else {
+		vecs[7] = 1.0 // Default Calm
+	}
+	return vecs
+}
+
+// buildArgs constructs the positional argument array for gen_single.
+// voice is the server-side path of the reference audio.
+func (c *Client) buildArgs(text, voice, emotion string, params config.GenerationParams) []interface{} {
+	vecs := emotionVector(emotion)
+	p := config.DefaultGenerationParams().Merge(&params)
+
+	// Determine control method
+	controlMethod := "Same as the voice reference"
+	if emotion != "" {
+		controlMethod = "Use emotion vectors"
+	}
+
+	// Create FileData object for voice and emo_ref
+	fileObj := map[string]interface{}{
+		"path": voice,
+		"meta": map[string]string{"_type": "gradio.FileData"},
+	}
+
+	// Construct data array (24 arguments)
+	return []interface{}{
+		controlMethod,                      // [0]
+		fileObj,                            // [1] prompt (FileData object)
+		text,                               // [2] text
+		fileObj,                            // [3] emo_ref_path (Using same as voice)
+		*p.EmoWeight,                       // [4] emo_weight
+		vecs[0], vecs[1], vecs[2], vecs[3], // [5-8]
+		vecs[4], vecs[5], vecs[6], vecs[7], // [9-12]
+		"",                   // [13] emo_text
+		false,                // [14] emo_random
+		*p.MaxTextTokens,     // [15] max_text_tokens
+		*p.DoSample,          // [16] do_sample
+		*p.TopP,              // [17] top_p
+		*p.TopK,              // [18] top_k
+		*p.Temperature,       // [19] temperature
+		*p.LengthPenalty,     // [20] length_penalty
+		*p.NumBeams,          // [21] num_beams
+		*p.RepetitionPenalty, // [22] repetition_penalty
+		*p.MaxMelTokens,      // [23] max_mel_tokens
+	}
+}
+
+func (c *Client) uploadVoice(ctx context.Context, filePath string) (string, error) {
 	uploadURL := fmt.Sprintf("%s/gradio_api/upload", c.url)
 
 	resp, err := c.client.R().
+		SetContext(ctx).
 		SetFile("files", filePath).
 		Post(uploadURL)
 
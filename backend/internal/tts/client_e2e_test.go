@@ -0,0 +1,133 @@
+package tts_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/tts"
+	"tts-book/backend/internal/tts/ttstest"
+)
+
+func TestClient_GenerateEndToEnd(t *testing.T) {
+	srv := ttstest.NewServer()
+	defer srv.Close()
+
+	voice := filepath.Join(t.TempDir(), "narrator.wav")
+	if err := os.WriteFile(voice, tts.MockAudio("ref", "ref", "calm"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := tts.NewClient(&config.Config{IndexTTSUrl: srv.URL})
+	if err := client.Health(context.Background()); err != nil {
+		t.Fatalf("Health() error = %v", err)
+	}
+
+	topK := 50
+	audio, err := client.Generate(context.Background(), tts.Request{Text: "今晩は", Voice: voice, Emotion: "sad", Params: config.GenerationParams{TopK: &topK}})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	reqs := srv.Requests()
+	if len(reqs) != 1 || srv.Uploads() != 1 {
+		t.Fatalf("server saw %d requests, %d uploads; want 1, 1", len(reqs), srv.Uploads())
+	}
+	got := reqs[0]
+	if got.Text != "今晚は" {
+		t.Errorf("text was not sanitised before sending: %q", got.Text)
+	}
+	if got.Emotion != "sad" {
+		t.Errorf("emotion vector decoded as %q, want sad", got.Emotion)
+	}
+	if *got.Params.TopK != 50 || *got.Params.NumBeams != 3 {
+		t.Errorf("sent top_k %d, num_beams %d; want the override and the default", *got.Params.TopK, *got.Params.NumBeams)
+	}
+	if want := tts.MockAudio(got.Text, got.Voice, got.Emotion); !bytes.Equal(audio, want) {
+		t.Errorf("downloaded %d bytes, want the server's %d byte result", len(audio), len(want))
+	}
+}
+
+func TestClient_ReusesUploads(t *testing.T) {
+	srv := ttstest.NewServer()
+	defer srv.Close()
+
+	voice := filepath.Join(t.TempDir(), "narrator.wav")
+	ref := tts.MockAudio("ref", "ref", "calm")
+	if err := os.WriteFile(voice, ref, 0644); err != nil {
+		t.Fatal(err)
+	}
+	client := tts.NewClient(&config.Config{IndexTTSUrl: srv.URL})
+	generate := func() {
+		t.Helper()
+		if _, err := client.Generate(context.Background(), tts.Request{Text: "你好", Voice: voice}); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+	}
+
+	before := tts.UploadCacheStats()
+	for i := 0; i < 3; i++ {
+		generate()
+	}
+	if srv.Uploads() != 1 {
+		t.Errorf("3 segments uploaded the voice %d times, want 1", srv.Uploads())
+	}
+	if stats := tts.UploadCacheStats(); stats.Hits-before.Hits != 2 || stats.BytesSaved-before.BytesSaved != 2*int64(len(ref)) {
+		t.Errorf("stats = %+v, before %+v", stats, before)
+	}
+
+	// The server lost its temp files and, like the Index-TTS WebUI, doesn't
+	// say why: the voice is uploaded again
+	srv.ForgetUploads()
+	generate()
+	if srv.Uploads() != 2 {
+		t.Errorf("uploads after the server forgot them = %d, want 2", srv.Uploads())
+	}
+	if stats := tts.UploadCacheStats(); stats.Rejected-before.Rejected != 1 {
+		t.Errorf("rejected = %d, want 1", stats.Rejected-before.Rejected)
+	}
+	generate()
+	if reqs := srv.Requests(); srv.Uploads() != 2 || len(reqs) != 6 {
+		t.Errorf("%d uploads, %d requests; want 2, 6", srv.Uploads(), len(reqs))
+	}
+
+	// A server showing errors says the file is missing
+	srv.ShowErrors(true)
+	srv.ForgetUploads()
+	generate()
+	if reqs := srv.Requests(); srv.Uploads() != 3 || len(reqs) != 8 {
+		t.Errorf("after a reported missing file: %d uploads, %d requests; want 3, 8", srv.Uploads(), len(reqs))
+	}
+
+	// Other failures it explains are returned as they are, keeping the upload
+	srv.FailNext("CUDA out of memory")
+	if _, err := client.Generate(context.Background(), tts.Request{Text: "你好", Voice: voice}); err == nil || !strings.Contains(err.Error(), "CUDA out of memory") {
+		t.Errorf("Generate() error = %v, want the server's", err)
+	}
+	if reqs := srv.Requests(); srv.Uploads() != 3 || len(reqs) != 9 {
+		t.Errorf("after a failure: %d uploads, %d requests; want 3, 9", srv.Uploads(), len(reqs))
+	}
+	if stats := tts.UploadCacheStats(); stats.Rejected-before.Rejected != 2 {
+		t.Errorf("rejected after a failure = %d, want 2", stats.Rejected-before.Rejected)
+	}
+}
+
+func TestMockAudio_Deterministic(t *testing.T) {
+	a := tts.MockAudio("你好世界", "alice.wav", "happy")
+	if !bytes.Equal(a, tts.MockAudio("你好世界", "alice.wav", "happy")) {
+		t.Error("same inputs produced different audio")
+	}
+	if bytes.Equal(a, tts.MockAudio("你好世界", "alice.wav", "sad")) {
+		t.Error("emotion does not affect the tone")
+	}
+	if long := tts.MockAudio("你好世界你好世界", "alice.wav", "happy"); len(long) <= len(a) {
+		t.Error("longer text did not produce longer audio")
+	}
+	if string(a[:4]) != "RIFF" || string(a[8:12]) != "WAVE" {
+		t.Error("mock audio is not a WAV file")
+	}
+}
@@ -0,0 +1,134 @@
+package tts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"tts-book/backend/internal/config"
+)
+
+// Engine names accepted in config.Config.TTSEngine
+const (
+	EngineIndexTTS = "indextts"
+	EngineOpenAI   = "openai"
+	EngineHTTP     = "http"
+)
+
+// Emotions understood by the analysis step and passed to engines.
+var Emotions = []string{"happy", "angry", "sad", "afraid", "disgusted", "melancholic", "surprised", "calm"}
+
+// Request describes one piece of audio to synthesise.
+type Request struct {
+	Text    string
+	Voice   string // Reference audio path, or a voice name for engines without cloning
+	Emotion string
+	Params  config.GenerationParams // Index-TTS2 sampling settings; unset fields use the defaults
+}
+
+// Capabilities describes what an engine supports.
+type Capabilities struct {
+	Engine       string   `json:"engine"`
+	VoiceCloning bool     `json:"voiceCloning"` // Voice is a reference audio file
+	Emotions     []string `json:"emotions"`     // Emotions the engine can express; empty if none
+}
+
+// Engine is a text-to-speech backend. Implementations return WAV audio so
+// output can be merged by the audio package.
+type Engine interface {
+	Generate(ctx context.Context, req Request) ([]byte, error)
+	// CacheKey identifies the audio Generate would produce for req,
+	// covering the text, voice content and every engine setting.
+	CacheKey(req Request) (string, error)
+	Capabilities() Capabilities
+	Health(ctx context.Context) error
+}
+
+// NewEngine creates the engine selected by cfg.TTSEngine at its default URL.
+func NewEngine(cfg *config.Config) Engine {
+	return newEngineForURL(cfg, "")
+}
+
+// CheckConfig validates the engine selection and its settings.
+func CheckConfig(cfg *config.Config) error {
+	switch cfg.TTSEngine {
+	case "", EngineIndexTTS, EngineOpenAI:
+		return nil
+	case EngineHTTP:
+		if cfg.TTSHTTP.Template == "" {
+			return nil
+		}
+		_, err := parseHTTPTemplate(cfg.TTSHTTP.Template, requestFuncs)
+		return err
+	default:
+		return fmt.Errorf("unknown tts engine %q", cfg.TTSEngine)
+	}
+}
+
+// newEngineForURL creates the configured engine pointed at url, or at the
+// engine's own configured URL when url is empty.
+func newEngineForURL(cfg *config.Config, url string) Engine {
+	if cfg.MockTTS {
+		return MockEngine{}
+	}
+	if url == "" {
+		url = engineURL(cfg)
+	}
+	switch cfg.TTSEngine {
+	case EngineOpenAI:
+		return newOpenAIEngine(cfg, url)
+	case EngineHTTP:
+		return newHTTPEngine(cfg, url)
+	default:
+		return newClientForURL(cfg, url)
+	}
+}
+
+// engineURL is the configured engine's own URL
+func engineURL(cfg *config.Config) string {
+	switch cfg.TTSEngine {
+	case EngineOpenAI:
+		return cfg.TTSOpenAI.URL
+	case EngineHTTP:
+		return cfg.TTSHTTP.URL
+	default:
+		return cfg.IndexTTSUrl
+	}
+}
+
+// voiceRef identifies a voice for cache keys. Local reference files are
+// identified by content hash, so replacing a file under the same name
+// invalidates its segments; anything else is used as-is.
+func voiceRef(voice string) (string, error) {
+	if _, err := os.Stat(voice); err != nil {
+		return voice, nil
+	}
+	h, err := hashFile(voice)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash voice file: %v", err)
+	}
+	return "sha256:" + h, nil
+}
+
+// hashKey joins parts with a separator and returns their hex SHA-256.
+func hashKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// voiceName derives a voice name for engines without voice cloning:
+// a reference file path maps to its file name without extension.
+func voiceName(voice, fallback string) string {
+	if voice == "" {
+		return fallback
+	}
+	if strings.ContainsAny(voice, `/\`) || filepath.Ext(voice) != "" {
+		base := filepath.Base(voice)
+		return strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	return voice
+}
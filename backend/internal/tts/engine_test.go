@@ -0,0 +1,108 @@
+package tts
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"tts-book/backend/internal/config"
+)
+
+var fakeWAV = []byte("RIFF\x24\x00\x00\x00WAVEfmt ")
+
+func TestOpenAIEngine_Generate(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/speech" || r.Header.Get("Authorization") != "Bearer sk-test" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&got)
+		w.Write(fakeWAV)
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		TTSEngine: EngineOpenAI,
+		TTSOpenAI: config.OpenAITTS{URL: srv.URL + "/v1/", APIKey: "sk-test", Model: "gpt-4o-mini-tts"},
+	}
+	engine := NewEngine(cfg)
+
+	audio, err := engine.Generate(context.Background(), Request{Text: "你好", Voice: "voices/narrator.wav", Emotion: "sad"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if string(audio) != string(fakeWAV) {
+		t.Errorf("Generate() returned %q", audio)
+	}
+	if got["voice"] != "narrator" || got["response_format"] != "wav" || got["instructions"] == nil {
+		t.Errorf("unexpected request body %v", got)
+	}
+	if len(engine.Capabilities().Emotions) == 0 {
+		t.Error("instruction-capable model reports no emotions")
+	}
+
+	// The same model on another host shares the cache
+	cfg.TTSOpenAI.URL = "http://elsewhere/v1/"
+	moved := NewEngine(cfg)
+	a, _ := engine.CacheKey(Request{Text: "你好", Voice: "voices/narrator.wav"})
+	b, _ := moved.CacheKey(Request{Text: "你好", Voice: "voices/narrator.wav"})
+	if a != b {
+		t.Errorf("CacheKey() differs between hosts: %q, %q", a, b)
+	}
+}
+
+func TestMockEngine_CacheKey(t *testing.T) {
+	var engine MockEngine
+	base, _ := engine.CacheKey(Request{Text: "a", Voice: "alice"})
+	defaults, _ := engine.CacheKey(Request{Text: "a", Voice: "alice", Params: config.DefaultGenerationParams()})
+	temperature := 1.2
+	other, _ := engine.CacheKey(Request{Text: "a", Voice: "alice", Params: config.GenerationParams{Temperature: &temperature}})
+	if base != defaults || base == other {
+		t.Errorf("CacheKey() = %q, %q with defaults, %q with temperature 1.2", base, defaults, other)
+	}
+}
+
+func TestHTTPEngine_Template(t *testing.T) {
+	var got map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": map[string]string{"audio": base64.StdEncoding.EncodeToString(fakeWAV)},
+		})
+	}))
+	defer srv.Close()
+
+	cfg := &config.Config{
+		TTSEngine: EngineHTTP,
+		TTSHTTP: config.HTTPTTSEngine{
+			URL:        srv.URL,
+			Template:   `{"input": {{json .Text}}, "speaker": {{json .VoiceName}}, "style": {{json .Emotion}}}`,
+			AudioField: "result.audio",
+		},
+	}
+	engine := NewEngine(cfg)
+
+	audio, err := engine.Generate(context.Background(), Request{Text: `他说"走"`, Voice: "alice", Emotion: "angry"})
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if string(audio) != string(fakeWAV) {
+		t.Errorf("Generate() returned %q", audio)
+	}
+	if got["input"] != `他说"走"` || got["speaker"] != "alice" || got["style"] != "angry" {
+		t.Errorf("unexpected request body %v", got)
+	}
+
+	a, _ := engine.CacheKey(Request{Text: "a", Voice: "alice"})
+	b, _ := engine.CacheKey(Request{Text: "b", Voice: "alice"})
+	if a == "" || a == b {
+		t.Errorf("CacheKey() = %q, %q; want distinct keys", a, b)
+	}
+
+	if err := CheckConfig(&config.Config{TTSEngine: EngineHTTP, TTSHTTP: config.HTTPTTSEngine{Template: "{{"}}); err == nil {
+		t.Error("CheckConfig() accepted an invalid template")
+	}
+}
@@ -0,0 +1,199 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"tts-book/backend/internal/config"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// defaultHTTPTemplate is used when the http engine has no template configured.
+const defaultHTTPTemplate = `{"text": {{json .Text}}, "voice": {{json .VoiceName}}, "emotion": {{json .Emotion}}}`
+
+// HTTPEngine drives any TTS server with a JSON-over-HTTP API. The request
+// body comes from a configurable template; see config.HTTPTTSEngine.
+type HTTPEngine struct {
+	client *resty.Client
+	url    string
+	cfg    config.HTTPTTSEngine
+	tmpl   *template.Template
+	keys   *template.Template // tmpl rendered with keyFuncs
+	err    error              // Template parse error, reported on use
+}
+
+// templateData is what request templates are rendered with.
+type templateData struct {
+	Text      string
+	Voice     string
+	VoiceName string
+	Emotion   string
+}
+
+func jsonValue(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// requestFuncs are available to request templates.
+var requestFuncs = template.FuncMap{
+	"json": jsonValue,
+	"base64file": func(path string) (string, error) {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return base64.StdEncoding.EncodeToString(b), nil
+	},
+}
+
+// keyFuncs render the template for cache keys, inlining a file's content
+// hash instead of its bytes.
+var keyFuncs = template.FuncMap{
+	"json":       jsonValue,
+	"base64file": voiceRef,
+}
+
+func newHTTPEngine(cfg *config.Config, url string) *HTTPEngine {
+	hc := cfg.TTSHTTP
+	if hc.Template == "" {
+		hc.Template = defaultHTTPTemplate
+	}
+	e := &HTTPEngine{
+		client: resty.New(),
+		url:    url,
+		cfg:    hc,
+	}
+	e.tmpl, e.err = parseHTTPTemplate(hc.Template, requestFuncs)
+	if e.err == nil {
+		e.keys, e.err = parseHTTPTemplate(hc.Template, keyFuncs)
+	}
+	return e
+}
+
+func parseHTTPTemplate(text string, funcs template.FuncMap) (*template.Template, error) {
+	tmpl, err := template.New("request").Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid http engine template: %v", err)
+	}
+	return tmpl, nil
+}
+
+// Capabilities reports what the configuration declares the server supports.
+func (e *HTTPEngine) Capabilities() Capabilities {
+	return Capabilities{Engine: EngineHTTP, VoiceCloning: e.cfg.VoiceCloning, Emotions: e.cfg.Emotions}
+}
+
+// Health requests the configured health URL. Without one the engine is
+// assumed healthy as long as its template is valid.
+func (e *HTTPEngine) Health(ctx context.Context) error {
+	if e.err != nil {
+		return e.err
+	}
+	if e.cfg.HealthURL == "" {
+		return nil
+	}
+	resp, err := e.client.R().SetContext(ctx).SetHeaders(e.cfg.Headers).Get(e.cfg.HealthURL)
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("health check failed: %s", resp.Status())
+	}
+	return nil
+}
+
+// Generate renders the request template for req, posts it and extracts the
+// WAV audio from the response.
+func (e *HTTPEngine) Generate(ctx context.Context, req Request) ([]byte, error) {
+	body, err := e.render(e.tmpl, req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := e.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetHeaders(e.cfg.Headers).
+		SetBody(body).
+		Post(e.url)
+	if err != nil {
+		return nil, fmt.Errorf("POST failed: %v", err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("POST API error: %s - Body: %s", resp.Status(), resp.String())
+	}
+
+	if e.cfg.AudioField == "" {
+		return checkWAV(resp.Body())
+	}
+	encoded, err := lookupField(resp.Body(), e.cfg.AudioField)
+	if err != nil {
+		return nil, err
+	}
+	audio, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", e.cfg.AudioField, err)
+	}
+	return checkWAV(audio)
+}
+
+// CacheKey hashes the request the template renders for req, with inlined
+// voice files replaced by their content hash. The URL is left out so every
+// endpoint serving the model shares the cache.
+func (e *HTTPEngine) CacheKey(req Request) (string, error) {
+	ref, err := voiceRef(req.Voice)
+	if err != nil {
+		return "", err
+	}
+	body, err := e.render(e.keys, req)
+	if err != nil {
+		return "", err
+	}
+	return hashKey(EngineHTTP, e.cfg.AudioField, ref, string(body)), nil
+}
+
+// render executes tmpl for req.
+func (e *HTTPEngine) render(tmpl *template.Template, req Request) ([]byte, error) {
+	if e.err != nil {
+		return nil, e.err
+	}
+	var buf bytes.Buffer
+	data := templateData{
+		Text:      req.Text,
+		Voice:     req.Voice,
+		VoiceName: voiceName(req.Voice, ""),
+		Emotion:   req.Emotion,
+	}
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, fmt.Errorf("failed to render request: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// lookupField returns the string at a dot separated path in a JSON document.
+func lookupField(body []byte, path string) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return "", fmt.Errorf("failed to parse response: %v", err)
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("response has no field %q", path)
+		}
+		v = obj[key]
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("response field %q is not a string", path)
+	}
+	return s, nil
+}
@@ -0,0 +1,115 @@
+package tts
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/fnv"
+	"math"
+
+	"tts-book/backend/internal/config"
+)
+
+// EngineMock is reported by MockEngine. It is selected with config.Config.MockTTS
+// rather than TTSEngine, so a real engine's settings survive mock runs.
+const EngineMock = "mock"
+
+// Mock audio format, matching what Index-TTS2 returns.
+const (
+	MockSampleRate    = 22050
+	mockMsPerRune     = 120
+	mockMinDurationMs = 300
+)
+
+// MockEngine synthesises deterministic tones instead of speech so the
+// generation pipeline can run offline. Duration grows with the text, pitch
+// depends on the voice and emotion.
+type MockEngine struct{}
+
+// Capabilities reports every emotion, since the tone pitch reflects it.
+func (MockEngine) Capabilities() Capabilities {
+	return Capabilities{Engine: EngineMock, VoiceCloning: true, Emotions: Emotions}
+}
+
+// Health always succeeds.
+func (MockEngine) Health(ctx context.Context) error {
+	return nil
+}
+
+// Generate returns the mock WAV for req.
+func (MockEngine) Generate(ctx context.Context, req Request) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return MockAudio(req.Text, req.Voice, req.Emotion), nil
+}
+
+// CacheKey hashes the request fields. Params are merged onto the defaults
+// first, as the Index-TTS client does, so spelling out a default keeps the key.
+func (MockEngine) CacheKey(req Request) (string, error) {
+	params, err := json.Marshal(config.DefaultGenerationParams().Merge(&req.Params))
+	if err != nil {
+		return "", err
+	}
+	return hashKey(EngineMock, req.Text, req.Voice, req.Emotion, string(params)), nil
+}
+
+// MockAudio renders a 16-bit mono WAV tone for text. The same inputs always
+// produce the same bytes.
+func MockAudio(text, voice, emotion string) []byte {
+	durationMs := len([]rune(text)) * mockMsPerRune
+	if durationMs < mockMinDurationMs {
+		durationMs = mockMinDurationMs
+	}
+	n := MockSampleRate * durationMs / 1000
+
+	// Voice picks a base pitch between 120 and 360 Hz, emotion shifts it
+	h := fnv.New32a()
+	h.Write([]byte(voice))
+	freq := 120 + float64(h.Sum32()%240)
+	for i, e := range Emotions {
+		if e == emotion {
+			freq *= 1 + float64(i)*0.05
+		}
+	}
+
+	// Short fades avoid clicks at segment boundaries
+	fade := MockSampleRate / 100
+	samples := make([]int16, n)
+	for i := range samples {
+		amp := 0.3
+		if i < fade {
+			amp *= float64(i) / float64(fade)
+		} else if n-i < fade {
+			amp *= float64(n-i) / float64(fade)
+		}
+		samples[i] = int16(amp * math.MaxInt16 * math.Sin(2*math.Pi*freq*float64(i)/MockSampleRate))
+	}
+	return encodeWAV(samples, MockSampleRate)
+}
+
+// encodeWAV wraps 16-bit mono PCM samples in a canonical WAV header.
+func encodeWAV(samples []int16, sampleRate int) []byte {
+	dataSize := len(samples) * 2
+	buf := make([]byte, 44+dataSize)
+
+	copy(buf[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(36+dataSize))
+	copy(buf[8:12], "WAVE")
+
+	copy(buf[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(buf[16:20], 16)                   // fmt chunk size
+	binary.LittleEndian.PutUint16(buf[20:22], 1)                    // PCM
+	binary.LittleEndian.PutUint16(buf[22:24], 1)                    // Mono
+	binary.LittleEndian.PutUint32(buf[24:28], uint32(sampleRate))   // Sample rate
+	binary.LittleEndian.PutUint32(buf[28:32], uint32(sampleRate*2)) // Byte rate
+	binary.LittleEndian.PutUint16(buf[32:34], 2)                    // Block align
+	binary.LittleEndian.PutUint16(buf[34:36], 16)                   // Bits per sample
+
+	copy(buf[36:40], "data")
+	binary.LittleEndian.PutUint32(buf[40:44], uint32(dataSize))
+	for i, s := range samples {
+		binary.LittleEndian.PutUint16(buf[44+i*2:], uint16(s))
+	}
+	return buf
+}
@@ -0,0 +1,114 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"tts-book/backend/internal/config"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// OpenAIEngine speaks the OpenAI-compatible /v1/audio/speech API. Voices are
+// names rather than reference audio; a mapped voice file is sent by its file
+// name without extension, which suits local servers that name voices after
+// their reference files.
+type OpenAIEngine struct {
+	client *resty.Client
+	url    string
+	cfg    config.OpenAITTS
+}
+
+func newOpenAIEngine(cfg *config.Config, url string) *OpenAIEngine {
+	oc := cfg.TTSOpenAI
+	if oc.Model == "" {
+		oc.Model = "tts-1"
+	}
+	if oc.Voice == "" {
+		oc.Voice = "alloy"
+	}
+	return &OpenAIEngine{
+		client: resty.New(),
+		url:    strings.TrimRight(url, "/"),
+		cfg:    oc,
+	}
+}
+
+// Capabilities reports emotion support through speaking instructions, which
+// the tts-1 models do not accept.
+func (e *OpenAIEngine) Capabilities() Capabilities {
+	caps := Capabilities{Engine: EngineOpenAI}
+	if e.supportsInstructions() {
+		caps.Emotions = Emotions
+	}
+	return caps
+}
+
+// Health lists the server's models, which requires a reachable server and a
+// valid API key.
+func (e *OpenAIEngine) Health(ctx context.Context) error {
+	resp, err := e.client.R().
+		SetContext(ctx).
+		SetAuthToken(e.cfg.APIKey).
+		Get(e.url + "/models")
+	if err != nil {
+		return err
+	}
+	if resp.IsError() {
+		return fmt.Errorf("health check failed: %s", resp.Status())
+	}
+	return nil
+}
+
+// Generate requests WAV audio for req.
+func (e *OpenAIEngine) Generate(ctx context.Context, req Request) ([]byte, error) {
+	resp, err := e.client.R().
+		SetContext(ctx).
+		SetAuthToken(e.cfg.APIKey).
+		SetHeader("Content-Type", "application/json").
+		SetBody(e.buildBody(req)).
+		Post(e.url + "/audio/speech")
+	if err != nil {
+		return nil, fmt.Errorf("speech request failed: %v", err)
+	}
+	if resp.IsError() {
+		return nil, fmt.Errorf("speech API error: %s - Body: %s", resp.Status(), resp.String())
+	}
+	return checkWAV(resp.Body())
+}
+
+// CacheKey hashes the request body, which covers model, voice and text. The
+// URL is left out so moving the model to another host keeps the cache.
+func (e *OpenAIEngine) CacheKey(req Request) (string, error) {
+	body := e.buildBody(req)
+	return hashKey(EngineOpenAI, fmt.Sprint(body["model"]), fmt.Sprint(body["voice"]),
+		fmt.Sprint(body["input"]), fmt.Sprint(body["instructions"])), nil
+}
+
+func (e *OpenAIEngine) buildBody(req Request) map[string]interface{} {
+	body := map[string]interface{}{
+		"model":           e.cfg.Model,
+		"input":           req.Text,
+		"voice":           voiceName(req.Voice, e.cfg.Voice),
+		"response_format": "wav",
+	}
+	if req.Emotion != "" && req.Emotion != "calm" && e.supportsInstructions() {
+		body["instructions"] = fmt.Sprintf("Speak in a %s tone.", req.Emotion)
+	}
+	return body
+}
+
+func (e *OpenAIEngine) supportsInstructions() bool {
+	return e.cfg.Model != "tts-1" && e.cfg.Model != "tts-1-hd"
+}
+
+// checkWAV makes sure an engine returned WAV audio, which is all the merger
+// understands.
+func checkWAV(data []byte) ([]byte, error) {
+	if len(data) < 12 || !bytes.Equal(data[:4], []byte("RIFF")) || !bytes.Equal(data[8:12], []byte("WAVE")) {
+		return nil, fmt.Errorf("engine returned %d bytes that are not WAV audio", len(data))
+	}
+	return data, nil
+}
@@ -0,0 +1,239 @@
+package tts
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+
+	"tts-book/backend/internal/config"
+)
+
+// Pool spreads generation requests across one or more endpoints of the
+// configured engine, never running more than an endpoint's configured
+// concurrency against it.
+type Pool struct {
+	engines  []Engine
+	slots    []*slots // Limit of each engine's endpoint, shared by every pool using it
+	capacity int
+	next     int // Engine tried first by the next request; guarded by endpoints.mu
+}
+
+// slots counts the requests running on one endpoint against its limit
+type slots struct {
+	limit int
+	busy  int
+}
+
+// endpoints holds the slots of every endpoint by engine and URL. They
+// outlive pools, so a pool rebuilt after a settings change and the jobs
+// still running on the old one share an endpoint's limit.
+var endpoints = struct {
+	mu    sync.Mutex
+	freed *sync.Cond // Broadcast when a slot is freed or a waiter cancelled
+	byKey map[string]*slots
+}{byKey: make(map[string]*slots)}
+
+func init() {
+	endpoints.freed = sync.NewCond(&endpoints.mu)
+}
+
+// shared is the pool every job generates through, so concurrent jobs take
+// turns on an endpoint's slots instead of each bringing their own
+var shared struct {
+	mu       sync.Mutex
+	pool     *Pool
+	settings []byte // The engine settings the pool was built from
+}
+
+// poolSettings are the settings engines and pools are built from
+type poolSettings struct {
+	MockTTS        bool
+	TTSEngine      string
+	TTSConcurrency int
+	TTSEndpoints   []config.TTSEndpoint
+	TTSUploadTTL   int
+	IndexTTSUrl    string
+	VoiceDir       string
+	TTSOpenAI      config.OpenAITTS
+	TTSHTTP        config.HTTPTTSEngine
+}
+
+// SharedPool returns the process-wide pool, rebuilding it when the engine
+// settings in cfg have changed since it was built. Jobs still holding the
+// old pool finish on it, within the same endpoint limits.
+func SharedPool(cfg *config.Config) *Pool {
+	settings, _ := json.Marshal(poolSettings{
+		MockTTS:        cfg.MockTTS,
+		TTSEngine:      cfg.TTSEngine,
+		TTSConcurrency: cfg.TTSConcurrency,
+		TTSEndpoints:   cfg.TTSEndpoints,
+		TTSUploadTTL:   cfg.TTSUploadTTL,
+		IndexTTSUrl:    cfg.IndexTTSUrl,
+		VoiceDir:       cfg.VoiceDir,
+		TTSOpenAI:      cfg.TTSOpenAI,
+		TTSHTTP:        cfg.TTSHTTP,
+	})
+
+	shared.mu.Lock()
+	defer shared.mu.Unlock()
+	if shared.pool == nil || string(settings) != string(shared.settings) {
+		shared.pool = NewPool(cfg)
+		shared.settings = settings
+	}
+	return shared.pool
+}
+
+// NewPool builds a pool from cfg.TTSEndpoints, falling back to the engine's
+// own URL with cfg.TTSConcurrency slots when no endpoints are configured.
+// An endpoint's limit is shared with every other pool using it and set to
+// the one in cfg. Callers generating audio should use SharedPool.
+func NewPool(cfg *config.Config) *Pool {
+	eps := cfg.TTSEndpoints
+	if len(eps) == 0 {
+		eps = []config.TTSEndpoint{{URL: "", Concurrency: cfg.TTSConcurrency}}
+	}
+
+	p := &Pool{}
+	limits := make(map[string]int)
+	var keys []string
+	for _, ep := range eps {
+		if ep.URL == "" && len(cfg.TTSEndpoints) > 0 {
+			continue
+		}
+		if ep.Concurrency <= 0 {
+			ep.Concurrency = 1
+		}
+		p.engines = append(p.engines, newEngineForURL(cfg, ep.URL))
+		keys = append(keys, endpointKey(cfg, ep.URL))
+		limits[keys[len(keys)-1]] += ep.Concurrency
+	}
+	if len(p.engines) == 0 {
+		p.engines = append(p.engines, NewEngine(cfg))
+		keys = append(keys, endpointKey(cfg, ""))
+		limits[keys[0]] = 1
+	}
+
+	endpoints.mu.Lock()
+	for _, key := range keys {
+		s := endpoints.byKey[key]
+		if s == nil {
+			s = &slots{}
+			endpoints.byKey[key] = s
+		}
+		s.limit = limits[key]
+		p.slots = append(p.slots, s)
+	}
+	endpoints.freed.Broadcast() // A limit may have grown
+	endpoints.mu.Unlock()
+	for _, n := range limits {
+		p.capacity += n
+	}
+
+	log.Printf("[TTS] Pool ready: %s engine, %d endpoint(s), %d concurrent slot(s)",
+		p.engines[0].Capabilities().Engine, len(p.engines), p.capacity)
+	return p
+}
+
+// endpointKey identifies the endpoint an engine built for url talks to
+func endpointKey(cfg *config.Config, url string) string {
+	if cfg.MockTTS {
+		return EngineMock
+	}
+	if url == "" {
+		url = engineURL(cfg)
+	}
+	engine := cfg.TTSEngine
+	if engine == "" {
+		engine = EngineIndexTTS
+	}
+	return engine + " " + strings.TrimRight(url, "/")
+}
+
+// Capacity is the total number of requests the pool runs at once.
+func (p *Pool) Capacity() int {
+	return p.capacity
+}
+
+// Generate waits for a free slot on any endpoint and generates audio there.
+func (p *Pool) Generate(ctx context.Context, req Request) ([]byte, error) {
+	i, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer p.release(i)
+
+	return p.engines[i].Generate(ctx, req)
+}
+
+// acquire takes a slot, trying the endpoints in turn so consecutive
+// requests go to different ones, and returns the engine it is for.
+func (p *Pool) acquire(ctx context.Context) (int, error) {
+	stop := context.AfterFunc(ctx, func() {
+		endpoints.mu.Lock()
+		endpoints.freed.Broadcast()
+		endpoints.mu.Unlock()
+	})
+	defer stop()
+
+	endpoints.mu.Lock()
+	defer endpoints.mu.Unlock()
+	for {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		for n := range p.engines {
+			i := (p.next + n) % len(p.engines)
+			if s := p.slots[i]; s.busy < s.limit {
+				s.busy++
+				p.next = i + 1
+				return i, nil
+			}
+		}
+		endpoints.freed.Wait()
+	}
+}
+
+func (p *Pool) release(i int) {
+	endpoints.mu.Lock()
+	p.slots[i].busy--
+	endpoints.freed.Broadcast()
+	endpoints.mu.Unlock()
+}
+
+// CacheKey returns the cache key for a segment. Keys do not depend on
+// which endpoint ends up generating the audio.
+func (p *Pool) CacheKey(req Request) (string, error) {
+	return p.engines[0].CacheKey(req)
+}
+
+// Capabilities reports what the pool's engine supports.
+func (p *Pool) Capabilities() Capabilities {
+	return p.engines[0].Capabilities()
+}
+
+// EndpointHealth is the result of checking one endpoint.
+type EndpointHealth struct {
+	Index int    `json:"index"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// Health checks every endpoint in the pool concurrently.
+func (p *Pool) Health(ctx context.Context) []EndpointHealth {
+	results := make([]EndpointHealth, len(p.engines))
+	var wg sync.WaitGroup
+	for i, e := range p.engines {
+		wg.Add(1)
+		go func(i int, e Engine) {
+			defer wg.Done()
+			results[i] = EndpointHealth{Index: i, OK: true}
+			if err := e.Health(ctx); err != nil {
+				results[i] = EndpointHealth{Index: i, Error: err.Error()}
+			}
+		}(i, e)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,78 @@
+package tts
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"tts-book/backend/internal/config"
+)
+
+func TestNewPool_Slots(t *testing.T) {
+	cfg := &config.Config{
+		IndexTTSUrl: "http://unused:7860",
+		TTSEndpoints: []config.TTSEndpoint{
+			{URL: "http://a:7860", Concurrency: 2},
+			{URL: "http://b:7860/"},
+			{URL: ""}, // Ignored
+		},
+	}
+	p := NewPool(cfg)
+
+	if p.Capacity() != 3 {
+		t.Fatalf("Capacity() = %d, want 3", p.Capacity())
+	}
+
+	// Requests alternate between endpoints until each is at its limit
+	var got []string
+	for i := 0; i < p.Capacity(); i++ {
+		n, err := p.acquire(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, p.engines[n].(*Client).url)
+	}
+	want := []string{"http://a:7860", "http://b:7860", "http://a:7860"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("slot %d = %s, want %s", i, got[i], want[i])
+		}
+	}
+
+	// A pool for the same endpoints shares their limits
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := NewPool(cfg).acquire(ctx); err == nil {
+		t.Error("a second pool got a slot on full endpoints")
+	}
+	for i := range p.engines {
+		p.release(i)
+	}
+}
+
+func TestNewPool_FallbackToIndexTTSUrl(t *testing.T) {
+	p := NewPool(&config.Config{IndexTTSUrl: "http://127.0.0.1:7860", TTSConcurrency: 2})
+	if p.Capacity() != 2 || p.engines[0].(*Client).url != "http://127.0.0.1:7860" {
+		t.Errorf("fallback pool = %d slots at %s", p.Capacity(), p.engines[0].(*Client).url)
+	}
+}
+
+func TestSharedPool(t *testing.T) {
+	cfg := &config.Config{IndexTTSUrl: "http://127.0.0.1:7861", TTSConcurrency: 2}
+	p := SharedPool(cfg)
+	if SharedPool(cfg) != p {
+		t.Error("unchanged config built a new pool")
+	}
+	cfg.OutputFormat = "mp3"
+	if SharedPool(cfg) != p {
+		t.Error("a setting the engine doesn't use built a new pool")
+	}
+	cfg.TTSConcurrency = 3
+	q := SharedPool(cfg)
+	if q == p || q.Capacity() != 3 {
+		t.Errorf("pool not rebuilt after a config change")
+	}
+	if p.slots[0] != q.slots[0] || p.slots[0].limit != 3 {
+		t.Error("rebuilt pool doesn't share the endpoint's limit with the old one")
+	}
+}
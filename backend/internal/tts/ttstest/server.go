@@ -0,0 +1,248 @@
+// Package ttstest provides an in-process stand-in for the Index-TTS2 Gradio
+// app, so tts.Client can be tested end to end without a GPU.
+package ttstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+
+	"tts-book/backend/internal/config"
+	"tts-book/backend/internal/tts"
+)
+
+const uploadDir = "/tmp/gradio/"
+
+// Server speaks enough of the Gradio protocol for tts.Client: voice upload,
+// gen_single call and event stream, and result download. Audio is rendered
+// with tts.MockAudio.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	uploads  map[string][]byte      // Remote path -> uploaded voice
+	uploaded int                    // Uploads ever received
+	pending  map[string]tts.Request // Event ID -> request awaiting its stream
+	files    map[string][]byte      // Remote path -> generated audio
+	requests []tts.Request          // Every gen_single call, in order
+	fail     string                 // Error the next stream reports
+	show     bool                   // Report why generation failed
+}
+
+// NewServer starts a server. Call Close when done.
+func NewServer() *Server {
+	s := &Server{
+		uploads: make(map[string][]byte),
+		pending: make(map[string]tts.Request),
+		files:   make(map[string][]byte),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/gradio_api/info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"named_endpoints": map[string]interface{}{"/gen_single": struct{}{}}})
+	})
+	mux.HandleFunc("/gradio_api/upload", s.handleUpload)
+	mux.HandleFunc("/gradio_api/call/gen_single", s.handleCall)
+	mux.HandleFunc("/gradio_api/call/gen_single/", s.handleStream)
+	mux.HandleFunc("/", s.handleFile)
+
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Requests returns the gen_single calls received so far. Voice is the
+// remote path of the uploaded reference audio; Params are all set.
+func (s *Server) Requests() []tts.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]tts.Request(nil), s.requests...)
+}
+
+// Uploads returns how many voice files were uploaded.
+func (s *Server) Uploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploaded
+}
+
+// ForgetUploads drops the uploaded voices, as Gradio does when its temp
+// directory is cleaned. Generating with one of them fails afterwards.
+func (s *Server) ForgetUploads() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clear(s.uploads)
+}
+
+// ShowErrors makes failed generations report why, as a Gradio app started
+// with show_error does. By default the reason is null, as with the
+// Index-TTS WebUI.
+func (s *Server) ShowErrors(show bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.show = show
+}
+
+// FailNext makes the next generation fail with msg.
+func (s *Server) FailNext(msg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fail = msg
+}
+
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	file, header, err := r.FormFile("files")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	remote := fmt.Sprintf("%s%d/%s", uploadDir, s.nextID, path.Base(header.Filename))
+	s.uploads[remote] = data
+	s.uploaded++
+	s.mu.Unlock()
+
+	writeJSON(w, []string{remote})
+}
+
+func (s *Server) handleCall(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Data []interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(body.Data) != 24 {
+		http.Error(w, fmt.Sprintf("gen_single takes 24 arguments, got %d", len(body.Data)), http.StatusUnprocessableEntity)
+		return
+	}
+
+	req := tts.Request{Emotion: emotionName(body.Data[5:13]), Params: generationParams(body.Data)}
+	req.Text, _ = body.Data[2].(string)
+	if prompt, ok := body.Data[1].(map[string]interface{}); ok {
+		req.Voice, _ = prompt["path"].(string)
+	}
+
+	s.mu.Lock()
+	s.nextID++
+	id := fmt.Sprintf("evt%d", s.nextID)
+	s.pending[id] = req
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	writeJSON(w, map[string]string{"event_id": id})
+}
+
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/gradio_api/call/gen_single/")
+
+	s.mu.Lock()
+	req, ok := s.pending[id]
+	delete(s.pending, id)
+	fail, show := s.fail, s.show
+	s.fail = ""
+	if _, uploaded := s.uploads[req.Voice]; strings.HasPrefix(req.Voice, uploadDir) && !uploaded {
+		// Like Gradio, fail on a temp file it doesn't have
+		fail = fmt.Sprintf("[Errno 2] No such file or directory: '%s'", req.Voice)
+	}
+	var remote string
+	if ok && fail == "" {
+		remote = fmt.Sprintf("/tmp/gradio/%s/output.wav", id)
+		s.files[remote] = tts.MockAudio(req.Text, req.Voice, req.Emotion)
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	if !ok || (fail != "" && !show) {
+		fmt.Fprint(w, "event: error\ndata: null\n\n")
+		return
+	}
+	if fail != "" {
+		msg, _ := json.Marshal(fail)
+		fmt.Fprintf(w, "event: error\ndata: %s\n\n", msg)
+		return
+	}
+
+	result, _ := json.Marshal([]interface{}{map[string]interface{}{
+		"__type__": "update",
+		"value": map[string]interface{}{
+			"path": remote,
+			"url":  s.URL + "/gradio_api/file=" + remote,
+		},
+	}})
+	fmt.Fprint(w, "event: generating\ndata: null\n\n")
+	fmt.Fprintf(w, "event: complete\ndata: %s\n\n", result)
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	remote := r.URL.Path
+	for _, prefix := range []string{"/gradio_api/file=", "/file="} {
+		remote = strings.TrimPrefix(remote, prefix)
+	}
+
+	s.mu.Lock()
+	data, ok := s.files[remote]
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/wav")
+	w.Write(data)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// generationParams reads the sampling settings out of gen_single's arguments.
+func generationParams(data []interface{}) config.GenerationParams {
+	number := func(i int) *float64 {
+		v, _ := data[i].(float64)
+		return &v
+	}
+	integer := func(i int) *int {
+		v, _ := data[i].(float64)
+		n := int(v)
+		return &n
+	}
+	doSample, _ := data[16].(bool)
+	return config.GenerationParams{
+		EmoWeight:         number(4),
+		MaxTextTokens:     integer(15),
+		DoSample:          &doSample,
+		TopP:              number(17),
+		TopK:              integer(18),
+		Temperature:       number(19),
+		LengthPenalty:     number(20),
+		NumBeams:          integer(21),
+		RepetitionPenalty: number(22),
+		MaxMelTokens:      integer(23),
+	}
+}
+
+// emotionName maps an emotion vector back to the emotion with the largest weight.
+func emotionName(vec []interface{}) string {
+	best, bestWeight := "calm", 0.0
+	for i, v := range vec {
+		if w, ok := v.(float64); ok && w > bestWeight && i < len(tts.Emotions) {
+			best, bestWeight = tts.Emotions[i], w
+		}
+	}
+	return best
+}
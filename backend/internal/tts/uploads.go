@@ -0,0 +1,134 @@
+package tts
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultUploadTTL is how long an uploaded reference voice is reused when
+// tts_upload_ttl is unset. Gradio keeps uploads in a temp directory that a
+// restart or cache cleanup may empty, so they aren't trusted forever.
+const DefaultUploadTTL = time.Hour
+
+// UploadStats counts how the upload cache has served reference voices
+type UploadStats struct {
+	Entries       int   `json:"entries"`       // Uploads currently cached
+	Hits          int   `json:"hits"`          // Generations that reused an upload
+	Misses        int   `json:"misses"`        // Uploads made, including re-uploads
+	Expired       int   `json:"expired"`       // Uploads dropped for being older than the TTL
+	Rejected      int   `json:"rejected"`      // Cached uploads the server no longer had
+	BytesUploaded int64 `json:"bytesUploaded"` // Reference audio sent to servers
+	BytesSaved    int64 `json:"bytesSaved"`    // Reference audio not sent thanks to the cache
+}
+
+// uploadCache remembers where reference voices were uploaded on each
+// server, keyed by server URL and file content, so a book's thousands of
+// segments don't upload the same voice thousands of times.
+type uploadCache struct {
+	mu      sync.Mutex
+	entries map[uploadKey]*upload
+	stats   UploadStats
+	now     func() time.Time
+}
+
+type uploadKey struct {
+	server string
+	hash   string // SHA-256 of the voice file
+}
+
+type upload struct {
+	path     string // Remote path; empty until the upload finishes
+	size     int64
+	uploaded time.Time
+	done     chan struct{} // Closed when the upload finished or failed
+}
+
+// uploads is shared by every client, so uploads outlive the pool of the
+// job that made them
+var uploads = newUploadCache()
+
+func newUploadCache() *uploadCache {
+	return &uploadCache{entries: make(map[uploadKey]*upload), now: time.Now}
+}
+
+// UploadCacheStats returns the upload cache's counters
+func UploadCacheStats() UploadStats {
+	return uploads.snapshot()
+}
+
+// get returns the remote path of a voice, calling send to upload it unless
+// a fresh upload is cached. Concurrent callers for the same voice wait for
+// one upload rather than each making their own. cached reports whether
+// the path came from the cache.
+func (u *uploadCache) get(ctx context.Context, key uploadKey, size int64, ttl time.Duration, send func() (string, error)) (path string, cached bool, err error) {
+	for {
+		u.mu.Lock()
+		e, ok := u.entries[key]
+		if !ok {
+			break // Still holding the lock
+		}
+		select {
+		case <-e.done:
+			if u.now().Sub(e.uploaded) < ttl {
+				u.stats.Hits++
+				u.stats.BytesSaved += e.size
+				u.mu.Unlock()
+				return e.path, true, nil
+			}
+			u.stats.Expired++
+			delete(u.entries, key)
+			u.mu.Unlock()
+		default:
+			// Another segment is uploading this voice; wait and look again
+			u.mu.Unlock()
+			select {
+			case <-e.done:
+			case <-ctx.Done():
+				return "", false, ctx.Err()
+			}
+		}
+	}
+
+	e := &upload{size: size, done: make(chan struct{})}
+	u.entries[key] = e
+	u.stats.Misses++
+	u.mu.Unlock()
+
+	path, err = send()
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if err != nil {
+		delete(u.entries, key)
+	} else {
+		e.path, e.uploaded = path, u.now()
+		u.stats.BytesUploaded += size
+	}
+	close(e.done)
+	return path, false, err
+}
+
+// reject forgets an upload the server no longer has. An upload that has
+// already replaced it is kept.
+func (u *uploadCache) reject(key uploadKey, path string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if e, ok := u.entries[key]; ok && e.path == path {
+		delete(u.entries, key)
+		u.stats.Rejected++
+	}
+}
+
+func (u *uploadCache) snapshot() UploadStats {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	stats := u.stats
+	stats.Entries = 0
+	for _, e := range u.entries {
+		if e.path != "" {
+			stats.Entries++
+		}
+	}
+	return stats
+}
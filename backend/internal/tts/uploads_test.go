@@ -0,0 +1,91 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUploadCache(t *testing.T) {
+	u := newUploadCache()
+	now := time.Unix(0, 0)
+	u.now = func() time.Time { return now }
+
+	sent := 0
+	send := func() (string, error) {
+		sent++
+		return "/tmp/gradio/" + string(rune('0'+sent)) + "/voice.wav", nil
+	}
+	key := uploadKey{server: "http://gpu:7860", hash: "abc"}
+	ctx := context.Background()
+
+	path, cached, err := u.get(ctx, key, 100, time.Hour, send)
+	if err != nil || cached || path != "/tmp/gradio/1/voice.wav" {
+		t.Fatalf("first get() = %q, %v, %v", path, cached, err)
+	}
+	if path, cached, _ := u.get(ctx, key, 100, time.Hour, send); !cached || path != "/tmp/gradio/1/voice.wav" {
+		t.Errorf("second get() = %q, %v; want the cached upload", path, cached)
+	}
+	// Another server needs its own upload
+	if _, cached, _ := u.get(ctx, uploadKey{server: "http://other:7860", hash: "abc"}, 100, time.Hour, send); cached || sent != 2 {
+		t.Errorf("get() on another server: cached %v, %d uploads", cached, sent)
+	}
+
+	now = now.Add(time.Hour)
+	if path, cached, _ := u.get(ctx, key, 100, time.Hour, send); cached || path != "/tmp/gradio/3/voice.wav" {
+		t.Errorf("get() after the TTL = %q, %v; want a new upload", path, cached)
+	}
+
+	// Rejecting a path that was already replaced keeps the new upload
+	u.reject(key, "/tmp/gradio/1/voice.wav")
+	if _, cached, _ := u.get(ctx, key, 100, time.Hour, send); !cached {
+		t.Error("stale reject() dropped the current upload")
+	}
+	u.reject(key, "/tmp/gradio/3/voice.wav")
+	if _, cached, _ := u.get(ctx, key, 100, time.Hour, send); cached {
+		t.Error("get() after reject() used the cache")
+	}
+
+	// Failed uploads aren't cached
+	failed := errors.New("connection refused")
+	if _, _, err := u.get(ctx, uploadKey{hash: "bad"}, 1, time.Hour, func() (string, error) { return "", failed }); !errors.Is(err, failed) {
+		t.Errorf("get() with a failing upload = %v", err)
+	}
+
+	want := UploadStats{Entries: 2, Hits: 2, Misses: 5, Expired: 1, Rejected: 1, BytesUploaded: 400, BytesSaved: 200}
+	if got := u.snapshot(); got != want {
+		t.Errorf("stats = %+v, want %+v", got, want)
+	}
+}
+
+func TestUploadCache_Concurrent(t *testing.T) {
+	u := newUploadCache()
+	key := uploadKey{server: "http://gpu:7860", hash: "abc"}
+	release := make(chan struct{})
+	var mu sync.Mutex
+	sent := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u.get(context.Background(), key, 1, time.Hour, func() (string, error) {
+				mu.Lock()
+				sent++
+				mu.Unlock()
+				<-release
+				return "/tmp/gradio/1/voice.wav", nil
+			})
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if sent != 1 {
+		t.Errorf("8 concurrent segments uploaded %d times, want 1", sent)
+	}
+}
@@ -0,0 +1,365 @@
+// Package voices manages the voice library: reference audio files in one or
+// more directories, each described by a sidecar file next to it.
+//
+// The sidecar of "alice.wav" is "alice.wav.json", or "alice.wav.yaml" for
+// those who prefer writing YAML by hand. Voices without a sidecar are
+// listed with their file name and no other details. A voice may also have
+// a prepared clip, "alice.wav.prepared.wav", which is sent to TTS engines
+// in its place.
+package voices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+)
+
+var ErrNotFound = errors.New("voice not found")
+
+// Extensions lists the audio file types the library holds
+var Extensions = []string{".wav", ".mp3", ".ogg", ".flac"}
+
+var sidecarExtensions = []string{".json", ".yaml", ".yml"}
+
+// PreparedSuffix is appended to a voice's path to name its prepared clip
+const PreparedSuffix = ".prepared.wav"
+
+// Metadata is what a sidecar file holds
+type Metadata struct {
+	Name        string   `json:"name,omitempty" yaml:"name,omitempty"`
+	Gender      string   `json:"gender,omitempty" yaml:"gender,omitempty"` // "male", "female" or empty
+	Age         string   `json:"age,omitempty" yaml:"age,omitempty"`       // Age bracket as in character profiles
+	Language    string   `json:"language,omitempty" yaml:"language,omitempty"`
+	Description string   `json:"description,omitempty" yaml:"description,omitempty"` // Timbre, e.g. "low and husky"
+	License     string   `json:"license,omitempty" yaml:"license,omitempty"`
+	Tags        []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+}
+
+// Voice is a voice in the library
+type Voice struct {
+	Metadata
+	Path     string `json:"path"`               // Absolute path of the audio file; voice mappings refer to it
+	Dir      string `json:"dir"`                // Library directory the voice is in
+	Prepared string `json:"prepared,omitempty"` // Prepared clip, if the voice has one
+}
+
+// PreparedPath returns where the prepared clip of the voice at path goes
+func PreparedPath(path string) string {
+	return path + PreparedSuffix
+}
+
+// Reference returns the file to send a TTS engine for the voice at path:
+// its prepared clip if it has one, otherwise the file itself
+func Reference(path string) string {
+	if path == "" {
+		return ""
+	}
+	if info, err := os.Stat(PreparedPath(path)); err == nil && !info.IsDir() {
+		return PreparedPath(path)
+	}
+	return path
+}
+
+// Query filters voices. Empty fields match every voice.
+type Query struct {
+	Text     string // Matched against name, description and file name
+	Gender   string
+	Age      string
+	Language string
+	Tags     []string // Voices must have all of them
+}
+
+// Match reports whether a voice matches the query
+func (q Query) Match(v Voice) bool {
+	if q.Gender != "" && v.Gender != q.Gender {
+		return false
+	}
+	if q.Age != "" && v.Age != q.Age {
+		return false
+	}
+	if q.Language != "" && !strings.EqualFold(v.Language, q.Language) {
+		return false
+	}
+	for _, tag := range q.Tags {
+		if !slices.Contains(v.Tags, tag) {
+			return false
+		}
+	}
+	if q.Text != "" {
+		text := strings.ToLower(q.Text)
+		fields := []string{v.Name, v.Description, filepath.Base(v.Path)}
+		for _, f := range fields {
+			if strings.Contains(strings.ToLower(f), text) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Library is the set of directories voices are kept in. The first one is
+// where new voices go.
+type Library struct {
+	Dirs []string
+}
+
+// List returns the voices in every library directory, including folders
+// within them, ordered by directory and path. Directories that don't
+// exist are skipped.
+func (l Library) List() ([]Voice, error) {
+	var voices []Voice
+	seen := make(map[string]bool)
+	for _, dir := range l.Dirs {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			return nil, err
+		}
+		if seen[root] {
+			continue
+		}
+		seen[root] = true
+
+		err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if path == root && errors.Is(err, fs.ErrNotExist) {
+					return fs.SkipDir
+				}
+				return err
+			}
+			if d.IsDir() || !isAudio(path) {
+				return nil
+			}
+			v, err := read(root, path)
+			if err != nil {
+				// One broken sidecar shouldn't hide the whole library
+				log.Printf("[Voices] Warning: %v", err)
+				v = Voice{Metadata: Metadata{Name: defaultName(path)}, Path: path, Dir: root}
+			}
+			voices = append(voices, v)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return voices, nil
+}
+
+// Get returns the voice at path, which must be in a library directory
+func (l Library) Get(path string) (Voice, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return Voice{}, err
+	}
+	root, ok := l.dirOf(path)
+	if !ok || !isAudio(path) {
+		return Voice{}, ErrNotFound
+	}
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return Voice{}, ErrNotFound
+	}
+	return read(root, path)
+}
+
+// Add saves a new voice in dir, which must be one of the library's
+// directories, under its file name or, if that is taken, a numbered one.
+func (l Library) Add(dir, filename string, r io.Reader, meta Metadata) (Voice, error) {
+	if !isAudio(filename) {
+		return Voice{}, fmt.Errorf("unsupported audio type; accepted: %v", Extensions)
+	}
+	if dir == "" && len(l.Dirs) > 0 {
+		dir = l.Dirs[0]
+	}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return Voice{}, err
+	}
+	if !slices.ContainsFunc(l.Dirs, func(d string) bool {
+		abs, err := filepath.Abs(d)
+		return err == nil && abs == root
+	}) {
+		return Voice{}, fmt.Errorf("%s is not a voice library directory", dir)
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return Voice{}, err
+	}
+
+	name := filepath.Base(filename)
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	path := filepath.Join(root, name)
+	var f *os.File
+	for i := 2; ; i++ {
+		f, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+		path = filepath.Join(root, fmt.Sprintf("%s-%d%s", base, i, ext))
+	}
+	if err != nil {
+		return Voice{}, err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(path)
+		return Voice{}, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return Voice{}, err
+	}
+
+	meta = clean(meta)
+	if meta.Name == "" {
+		meta.Name = defaultName(path)
+	}
+	v := Voice{Metadata: meta, Path: path, Dir: root}
+	if err := writeSidecar(path, meta); err != nil {
+		os.Remove(path)
+		return Voice{}, err
+	}
+	return v, nil
+}
+
+// Update replaces the metadata of the voice at path
+func (l Library) Update(path string, meta Metadata) (Voice, error) {
+	v, err := l.Get(path)
+	if err != nil {
+		return Voice{}, err
+	}
+	meta = clean(meta)
+	if meta.Name == "" {
+		meta.Name = defaultName(v.Path)
+	}
+	if err := writeSidecar(v.Path, meta); err != nil {
+		return Voice{}, err
+	}
+	v.Metadata = meta
+	return v, nil
+}
+
+// Delete removes the voice at path, its sidecar and its prepared clip
+func (l Library) Delete(path string) error {
+	v, err := l.Get(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(v.Path); err != nil {
+		return err
+	}
+	for _, ext := range sidecarExtensions {
+		os.Remove(v.Path + ext)
+	}
+	os.Remove(PreparedPath(v.Path))
+	return nil
+}
+
+// dirOf returns the library directory an absolute path is in
+func (l Library) dirOf(path string) (string, bool) {
+	for _, dir := range l.Dirs {
+		root, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err == nil && rel != "." && !strings.HasPrefix(rel, "..") && !filepath.IsAbs(rel) {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+func read(root, path string) (Voice, error) {
+	v := Voice{Path: path, Dir: root}
+	meta, err := readSidecar(path)
+	if err != nil {
+		return Voice{}, fmt.Errorf("failed to read sidecar of %s: %v", path, err)
+	}
+	v.Metadata = meta
+	if v.Name == "" {
+		v.Name = defaultName(path)
+	}
+	if ref := Reference(path); ref != path {
+		v.Prepared = ref
+	}
+	return v, nil
+}
+
+// readSidecar reads the first sidecar found; no sidecar is no metadata
+func readSidecar(path string) (Metadata, error) {
+	var meta Metadata
+	for _, ext := range sidecarExtensions {
+		data, err := os.ReadFile(path + ext)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return meta, err
+		}
+		if ext == ".json" {
+			err = json.Unmarshal(data, &meta)
+		} else {
+			err = yaml.Unmarshal(data, &meta)
+		}
+		return meta, err
+	}
+	return meta, nil
+}
+
+// writeSidecar writes metadata in the format of the voice's existing
+// sidecar, JSON if it has none
+func writeSidecar(path string, meta Metadata) error {
+	for _, ext := range sidecarExtensions[1:] {
+		if _, err := os.Stat(path + ext); err == nil {
+			data, err := yaml.Marshal(meta)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(path+ext, data, 0644)
+		}
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path+".json", data, 0644)
+}
+
+// clean trims the metadata and sorts its tags, dropping duplicates
+func clean(meta Metadata) Metadata {
+	meta.Name = strings.TrimSpace(meta.Name)
+	var tags []string
+	for _, tag := range meta.Tags {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	meta.Tags = slices.Compact(tags)
+	return meta
+}
+
+func defaultName(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// isAudio reports whether path is a voice. Prepared clips belong to their
+// voice and aren't voices themselves.
+func isAudio(path string) bool {
+	if strings.HasSuffix(strings.ToLower(path), PreparedSuffix) {
+		return false
+	}
+	return slices.Contains(Extensions, strings.ToLower(filepath.Ext(path)))
+}
@@ -0,0 +1,108 @@
+package voices
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLibrary(t *testing.T) {
+	main, extra := t.TempDir(), t.TempDir()
+	lib := Library{Dirs: []string{main, extra, filepath.Join(main, "missing")}}
+
+	// A voice described in YAML, in a folder of the second directory
+	os.MkdirAll(filepath.Join(extra, "女"), 0755)
+	yamlVoice := filepath.Join(extra, "女", "少女.wav")
+	os.WriteFile(yamlVoice, []byte("voice"), 0644)
+	os.WriteFile(yamlVoice+".yaml", []byte("name: 小青\ngender: female\nage: teen\ntags: [bright]\n"), 0644)
+	os.WriteFile(filepath.Join(extra, "notes.txt"), []byte("not a voice"), 0644)
+	os.WriteFile(PreparedPath(yamlVoice), []byte("prepared"), 0644)
+
+	alice, err := lib.Add("", "alice.wav", strings.NewReader("alice"), Metadata{Gender: "female", Tags: []string{"warm", " narrator", "warm"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alice.Path != filepath.Join(main, "alice.wav") || alice.Name != "alice" || !reflect.DeepEqual(alice.Tags, []string{"narrator", "warm"}) {
+		t.Errorf("Add() = %+v", alice)
+	}
+	// The same file name again gets a number
+	second, err := lib.Add(main, "alice.wav", strings.NewReader("other"), Metadata{})
+	if err != nil || second.Path != filepath.Join(main, "alice-2.wav") {
+		t.Errorf("second Add() = %+v, %v", second, err)
+	}
+	if _, err := lib.Add(t.TempDir(), "x.wav", strings.NewReader("x"), Metadata{}); err == nil {
+		t.Error("Add() outside the library succeeded")
+	}
+
+	list, err := lib.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("List() = %+v, want 3 voices", list)
+	}
+	if v := list[2]; v.Path != yamlVoice || v.Name != "小青" || v.Age != "teen" || v.Dir != extra || v.Prepared != PreparedPath(yamlVoice) {
+		t.Errorf("YAML voice = %+v", v)
+	}
+
+	// Updating keeps the sidecar's format
+	updated, err := lib.Update(yamlVoice, Metadata{Name: "小青", Gender: "female", License: "CC-BY"})
+	if err != nil || updated.License != "CC-BY" {
+		t.Fatalf("Update() = %+v, %v", updated, err)
+	}
+	if got, err := lib.Get(yamlVoice); err != nil || got.License != "CC-BY" || got.Age != "" {
+		t.Errorf("Get() after update = %+v, %v", got, err)
+	}
+	if _, err := os.Stat(yamlVoice + ".json"); !os.IsNotExist(err) {
+		t.Error("Update() wrote a JSON sidecar next to the YAML one")
+	}
+
+	if _, err := lib.Get(filepath.Join(main, "..", "outside.wav")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() outside the library = %v, want ErrNotFound", err)
+	}
+
+	if got := Reference(alice.Path); got != alice.Path {
+		t.Errorf("Reference() of an unprepared voice = %s", got)
+	}
+
+	if err := lib.Delete(alice.Path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(alice.Path + ".json"); !os.IsNotExist(err) {
+		t.Error("Delete() left the sidecar")
+	}
+	if err := lib.Delete(yamlVoice); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(PreparedPath(yamlVoice)); !os.IsNotExist(err) {
+		t.Error("Delete() left the prepared clip")
+	}
+}
+
+func TestQuery(t *testing.T) {
+	v := Voice{
+		Metadata: Metadata{Name: "Deep", Gender: "male", Language: "zh", Description: "low, husky", Tags: []string{"villain", "calm"}},
+		Path:     "/voices/deep.wav",
+	}
+	tests := []struct {
+		q    Query
+		want bool
+	}{
+		{Query{}, true},
+		{Query{Gender: "male", Language: "ZH"}, true},
+		{Query{Gender: "female"}, false},
+		{Query{Tags: []string{"villain", "calm"}}, true},
+		{Query{Tags: []string{"villain", "bright"}}, false},
+		{Query{Text: "HUSKY"}, true},
+		{Query{Text: "deep.wav"}, true},
+		{Query{Text: "soft"}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.q.Match(v); got != tt.want {
+			t.Errorf("%+v.Match() = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+}
@@ -96,11 +96,11 @@ export default function ChapterList({ chapters, setChapters, onSelectChapter, ba
         <div className="space-y-6">
             <div className="glass-panel p-8 text-center border-dashed border-2 border-gray-600 hover:border-violet-500 transition-colors">
                 <Upload className="mx-auto h-12 w-12 text-gray-400 mb-4" />
-                <h3 className="text-lg font-medium text-white">上传电子书 (.epub)</h3>
+                <h3 className="text-lg font-medium text-white">上传电子书 (.epub / .txt / .md / .html / .fb2 / .docx / .mobi)</h3>
                 <p className="text-gray-400 mt-2 mb-6">拖拽文件至此 或 点击选择文件</p>
                 <input
                     type="file"
-                    accept=".epub"
+                    accept=".epub,.txt,.md,.markdown,.html,.htm,.xhtml,.fb2,.fbz,.docx,.mobi,.azw,.azw3"
                     onChange={handleUpload}
                     className="hidden"
                     id="epub-upload"
@@ -212,6 +212,11 @@ export default function ChapterList({ chapters, setChapters, onSelectChapter, ba
                                 className="w-full flex justify-between items-center p-4 bg-gray-800/50 rounded-lg hover:bg-gray-800 border border-transparent hover:border-violet-500/50 transition-all group text-left"
                             >
                                 <span className="truncate flex-1 font-medium text-lg">{ch.title || `第 ${ch.id} 章`}</span>
+                                {ch.matter && (
+                                    <span className="mr-2 text-xs px-2 py-0.5 rounded bg-gray-700 text-gray-400">
+                                        {ch.matter === 'front' ? '前言页' : '附录页'}
+                                    </span>
+                                )}
                                 <ChevronRight className="text-gray-500 group-hover:text-violet-400" />
                             </button>
                         ))}
@@ -45,11 +45,11 @@ export default function Import({ chapters, setChapters, analysisData, setAnalysi
         <div className="space-y-6">
             <div className="glass-panel p-8 text-center border-dashed border-2 border-gray-600 hover:border-violet-500 transition-colors">
                 <Upload className="mx-auto h-12 w-12 text-gray-400 mb-4" />
-                <h3 className="text-lg font-medium text-white">上传电子书 (.epub)</h3>
+                <h3 className="text-lg font-medium text-white">上传电子书 (.epub / .txt / .md / .html / .fb2 / .docx / .mobi)</h3>
                 <p className="text-gray-400 mt-2 mb-6">拖拽文件至此 或 点击选择文件</p>
                 <input
                     type="file"
-                    accept=".epub"
+                    accept=".epub,.txt,.md,.markdown,.html,.htm,.xhtml,.fb2,.fbz,.docx,.mobi,.azw,.azw3"
                     onChange={handleUpload}
                     className="hidden"
                     id="epub-upload"
@@ -196,6 +196,19 @@ export default function Settings() {
                     </label>
                 </div>
 
+                <div className="flex items-center gap-2">
+                    <input
+                        type="checkbox"
+                        id="footnotes_at_end"
+                        checked={!!config.footnotes_at_end}
+                        onChange={e => setConfig({ ...config, footnotes_at_end: e.target.checked })}
+                        className="w-4 h-4 rounded border-gray-600 bg-slate-700 text-violet-500 focus:ring-violet-500"
+                    />
+                    <label htmlFor="footnotes_at_end" className="text-sm text-gray-300 cursor-pointer select-none">
+                        EPUB 脚注移至章节末尾朗读 (重新导入后生效)
+                    </label>
+                </div>
+
                 <div className="flex items-center gap-2">
                     <input
                         type="checkbox"
@@ -13,19 +13,56 @@ export const api = {
             headers: { 'Content-Type': 'multipart/form-data' }
         });
     },
+    getBook: () => axios.get(`${API_BASE}/book`),
+
+    listBooks: () => axios.get(`${API_BASE}/books`),
+    openBook: (bookId) => axios.post(`${API_BASE}/books/${bookId}/open`),
+    renameBook: (bookId, name) => axios.put(`${API_BASE}/books/${bookId}`, { name }),
+    deleteBook: (bookId) => axios.delete(`${API_BASE}/books/${bookId}`),
+    exportBundle: (bookId, withAudio = false) => axios.get(`${API_BASE}/books/${bookId}/export?audio=${withAudio}`, {
+        responseType: 'blob'
+    }),
+    importBundle: (file, overwrite = false) => {
+        const formData = new FormData();
+        formData.append('file', file);
+        return axios.post(`${API_BASE}/import?overwrite=${overwrite}`, formData, {
+            headers: { 'Content-Type': 'multipart/form-data' }
+        });
+    },
 
     analyzeChapter: (chapterId, force = false) => axios.post(`${API_BASE}/analyze/${chapterId}?force=${force}`),
     analyzeAllChapters: (force = false) => axios.post(`${API_BASE}/analyze-all?force=${force}`),
 
     getCharacters: () => axios.get(`${API_BASE}/characters`),
+    updateProfile: (profile) => axios.post(`${API_BASE}/characters/profile`, profile),
+
+    getHistory: () => axios.get(`${API_BASE}/history`),
+    undo: () => axios.post(`${API_BASE}/history/undo`),
+    redo: () => axios.post(`${API_BASE}/history/redo`),
+    restoreChapter: (chapterId, revisionId) => axios.post(`${API_BASE}/analysis/${chapterId}/restore/${revisionId}`),
     confirmMapping: (mapping) => axios.post(`${API_BASE}/confirm-mapping`, mapping),
+    previewCasting: (mode = 'distinct') => axios.get(`${API_BASE}/casting?mode=${mode}`),
+    castVoices: (mode = 'distinct', characters = []) => axios.post(`${API_BASE}/casting?mode=${mode}`, { characters }),
 
     // Start generation
     generateAudio: (chapterId) => axios.post(`${API_BASE}/generate/${chapterId}`),
-    generateAllAudio: () => axios.post(`${API_BASE}/generate-all`),
+    generateAllAudio: (keepLegacy = false) => axios.post(`${API_BASE}/generate-all?keepLegacy=${keepLegacy}`),
     checkAudioStatus: (chapterId) => axios.get(`${API_BASE}/audio-status/${chapterId}`),
 
-    getVoiceList: () => axios.get(`${API_BASE}/voices/list`),
+    getVoiceList: (filters = {}) => axios.get(`${API_BASE}/voices/list`, { params: filters }),
+    uploadVoice: (file, metadata = {}) => {
+        const formData = new FormData();
+        formData.append('file', file);
+        Object.entries(metadata).forEach(([key, value]) => formData.append(key, value));
+        return axios.post(`${API_BASE}/voices`, formData, {
+            headers: { 'Content-Type': 'multipart/form-data' }
+        });
+    },
+    updateVoice: (path, metadata) => axios.put(`${API_BASE}/voices`, metadata, { params: { path } }),
+    deleteVoice: (path, force = false) => axios.delete(`${API_BASE}/voices`, { params: { path, force } }),
+    prepareVoice: (path, options = {}) => axios.post(`${API_BASE}/voices/prepare`, options, { params: { path } }),
+    discardPreparedVoice: (path) => axios.delete(`${API_BASE}/voices/prepare`, { params: { path } }),
     getVoicePreviewUrl: (path) => `${API_BASE}/voices/preview?path=${encodeURIComponent(path)}`,
     getLLMModels: () => axios.get(`${API_BASE}/llm/models`),
+    getUploadCacheStats: () => axios.get(`${API_BASE}/tts/uploads`),
 };